@@ -0,0 +1,55 @@
+package gantry // import "github.com/ad-freiburg/gantry"
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+)
+
+// ArtifactsPath stores the location inside containers where the artifacts of
+// all dependencies are mounted. Artifacts of dependency d declared as path p
+// are available as ArtifactsPath/d/p.
+const ArtifactsPath string = "/artifacts"
+
+// artifactTempDirPrefix returns the prefix of the temporary directory used to
+// stage the artifact with the given index of step name.
+func artifactTempDirPrefix(name string, index int) string {
+	return fmt.Sprintf("artifact_%s_%d_", name, index)
+}
+
+// mountArtifacts adds volumes for declared artifacts to all steps of p. Each
+// artifact is staged into a temporary directory of env, which is mounted at
+// the declared path into the producing step and read-only below
+// ArtifactsPath into all steps depending on it.
+func (p *PipelineDefinition) mountArtifacts(env *PipelineEnvironment) error {
+	dirs := make(map[string][]string)
+	for name, step := range p.Steps {
+		for i, path := range step.Artifacts {
+			dir, err := env.GetOrCreateTempDir(artifactTempDirPrefix(step.RawContainerName(), i))
+			if err != nil {
+				return fmt.Errorf("could not create artifact directory for step '%s': %s", step.ColoredName(), err)
+			}
+			dirs[name] = append(dirs[name], dir)
+			step.Volumes = append(step.Volumes, fmt.Sprintf("%s:%s", dir, path))
+		}
+		p.Steps[name] = step
+	}
+	for name, step := range p.Steps {
+		deps := make([]string, 0)
+		for dep := range step.Dependencies() {
+			if _, ok := dirs[dep]; ok {
+				deps = append(deps, dep)
+			}
+		}
+		sort.Strings(deps)
+		for _, dep := range deps {
+			producer := p.Steps[dep]
+			for i, path := range producer.Artifacts {
+				target := filepath.Join(ArtifactsPath, producer.RawContainerName(), path)
+				step.Volumes = append(step.Volumes, fmt.Sprintf("%s:%s:ro", dirs[dep][i], target))
+			}
+		}
+		p.Steps[name] = step
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package gantry // import "github.com/ad-freiburg/gantry"
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/ad-freiburg/gantry/types"
+)
+
+// failureTracker counts the failed steps of a run and decides when no further
+// steps are started. It is safe for concurrent use.
+type failureTracker struct {
+	limit int
+	count int
+	// failed stores the failed steps and the steps skipped because one of
+	// their dependencies failed.
+	failed types.StringSet
+	// skipped stores the steps not started after bailing.
+	skipped []string
+	m       sync.Mutex
+}
+
+// newFailureTracker returns a failureTracker which bails once limit steps
+// failed, a limit less than 1 never bails.
+func newFailureTracker(limit int) *failureTracker {
+	return &failureTracker{
+		limit:   limit,
+		failed:  types.StringSet{},
+		skipped: []string{},
+	}
+}
+
+// fail records the failure of the step name.
+func (f *failureTracker) fail(name string) {
+	f.m.Lock()
+	defer f.m.Unlock()
+	f.count++
+	f.failed[name] = true
+}
+
+// block records that the step name did not run as a dependency failed, its
+// dependents are blocked too.
+func (f *failureTracker) block(name string) {
+	f.m.Lock()
+	defer f.m.Unlock()
+	f.failed[name] = true
+}
+
+// bail returns whether enough steps failed to not start the step name. If
+// so, the step is recorded as skipped.
+func (f *failureTracker) bail(name string) bool {
+	f.m.Lock()
+	defer f.m.Unlock()
+	if f.limit < 1 || f.count < f.limit {
+		return false
+	}
+	f.skipped = append(f.skipped, name)
+	return true
+}
+
+// failedDependency returns the name of a dependency of step which failed or
+// was blocked, an empty string if there is none.
+func (f *failureTracker) failedDependency(step Step) string {
+	f.m.Lock()
+	defer f.m.Unlock()
+	for _, dep := range sortedKeys(step.Dependencies()) {
+		if f.failed[dep] {
+			return dep
+		}
+	}
+	return ""
+}
+
+// Count returns the number of failed steps.
+func (f *failureTracker) Count() int {
+	f.m.Lock()
+	defer f.m.Unlock()
+	return f.count
+}
+
+// Skipped returns the sorted names of all steps not started after bailing.
+func (f *failureTracker) Skipped() []string {
+	f.m.Lock()
+	defer f.m.Unlock()
+	result := append([]string{}, f.skipped...)
+	sort.Strings(result)
+	return result
+}
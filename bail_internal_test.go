@@ -0,0 +1,112 @@
+package gantry
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/ad-freiburg/gantry/types"
+)
+
+func TestFailureTracker(t *testing.T) {
+	f := newFailureTracker(2)
+	if f.bail("a") {
+		t.Errorf("Bailed without failures")
+	}
+	f.fail("a")
+	if f.bail("b") {
+		t.Errorf("Bailed after 1 of 2 failures")
+	}
+	c := Step{Service: Service{Name: "c"}, After: types.StringSet{"a": true}}
+	if dep := f.failedDependency(c); dep != "a" {
+		t.Errorf("Incorrect failed dependency, got: '%s', wanted: 'a'", dep)
+	}
+	f.block("c")
+	d := Step{Service: Service{Name: "d", DependsOn: DependsOn{"c": DependencyConditionStarted}}}
+	if dep := f.failedDependency(d); dep != "c" {
+		t.Errorf("Incorrect failed dependency, got: '%s', wanted: 'c'", dep)
+	}
+	// Blocked steps do not count as failures
+	if f.bail("d") {
+		t.Errorf("Bailed after blocking a step")
+	}
+	f.fail("b")
+	if !f.bail("e") || !f.bail("d") {
+		t.Errorf("Did not bail after 2 of 2 failures")
+	}
+	if skipped := f.Skipped(); !reflect.DeepEqual(skipped, []string{"d", "e"}) {
+		t.Errorf("Incorrect skipped steps, got: '%v', wanted: '[d e]'", skipped)
+	}
+
+	// A limit less than 1 never bails
+	f = newFailureTracker(0)
+	f.fail("a")
+	if f.bail("b") {
+		t.Errorf("Bailed without limit")
+	}
+}
+
+func TestPipelineRunCommandBailAfter(t *testing.T) {
+	tmpDef, tmpEnv := setupDefAndEnv(`version: "2.0"
+steps:
+  a:
+    image: alpine
+  b:
+    image: alpine
+  c:
+    image: alpine
+    after:
+    - a
+    - b
+  d:
+    image: alpine
+`, "")
+	defer os.Remove(tmpDef)
+	defer os.Remove(tmpEnv)
+	defer func(limit int) { BailAfter = limit }(BailAfter)
+
+	cases := []struct {
+		limit  int
+		result map[string]StepStatus
+	}{
+		// c is skipped either due to bailing or the failed dependencies
+		{0, map[string]StepStatus{"a": StepStatusFailed, "b": StepStatusFailed, "c": StepStatusSkipped, "d": StepStatusSucceeded}},
+		{2, map[string]StepStatus{"a": StepStatusFailed, "b": StepStatusFailed, "c": StepStatusSkipped}},
+		{3, map[string]StepStatus{"a": StepStatusFailed, "b": StepStatusFailed, "c": StepStatusSkipped, "d": StepStatusSucceeded}},
+	}
+	for _, c := range cases {
+		BailAfter = c.limit
+		p, err := NewPipeline(tmpDef, tmpEnv, types.StringMap{}, types.StringSet{}, types.StringSet{})
+		if err != nil {
+			t.Fatalf("unexpected error creating pipeline: '%#v'", err)
+		}
+		p.localRunner = NewNoopRunner(true)
+		p.noopRunner = NewNoopRunner(true)
+		result, err := p.runCommand(runConfig{
+			usePreconditions: true,
+			run: func(runner Runner, step Step) func() error {
+				return func() error {
+					if step.Name == "a" || step.Name == "b" {
+						return fmt.Errorf("%s failed", step.Name)
+					}
+					return nil
+				}
+			},
+		})
+		if err == nil {
+			t.Errorf("Missing error for limit %d", c.limit)
+		}
+		statuses := map[string]StepStatus{}
+		for _, step := range result.Steps() {
+			statuses[step.Name] = step.Status
+		}
+		// d may start before or after bailing
+		if c.limit == 2 {
+			delete(statuses, "d")
+		}
+		if !reflect.DeepEqual(statuses, c.result) {
+			t.Errorf("Incorrect result for limit %d, got: '%v', wanted: '%v'", c.limit, statuses, c.result)
+		}
+	}
+}
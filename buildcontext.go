@@ -0,0 +1,207 @@
+package gantry // import "github.com/ad-freiburg/gantry"
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// DockerIgnore stores the name of the file excluding files from a build
+// context.
+const DockerIgnore string = ".dockerignore"
+
+// ignorePattern is a single line of a .dockerignore file.
+type ignorePattern struct {
+	regexp    *regexp.Regexp
+	exclusion bool
+}
+
+// dockerIgnore matches paths relative to the build context against the
+// patterns of a .dockerignore file, the last matching pattern wins.
+type dockerIgnore []ignorePattern
+
+// readDockerIgnore reads the .dockerignore file of the context dir, no path is
+// ignored if it does not exist.
+func readDockerIgnore(dir string) (dockerIgnore, error) {
+	f, err := os.Open(filepath.Join(dir, DockerIgnore))
+	if os.IsNotExist(err) {
+		return dockerIgnore{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseDockerIgnore(f)
+}
+
+// parseDockerIgnore parses patterns like docker does: empty lines and lines
+// starting with # are skipped, ! marks exceptions, * and ? do not match /
+// and ** matches any number of directories.
+func parseDockerIgnore(r io.Reader) (dockerIgnore, error) {
+	result := dockerIgnore{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		exclusion := false
+		if strings.HasPrefix(line, "!") {
+			exclusion = true
+			line = strings.TrimSpace(line[1:])
+		}
+		line = filepath.ToSlash(filepath.Clean(line))
+		line = strings.TrimPrefix(line, "/")
+		if line == "" || line == "." {
+			continue
+		}
+		re, err := ignorePatternRegexp(line)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s pattern '%s': %s", DockerIgnore, line, err)
+		}
+		result = append(result, ignorePattern{regexp: re, exclusion: exclusion})
+	}
+	return result, scanner.Err()
+}
+
+func ignorePatternRegexp(pattern string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch c {
+		case '*':
+			if i+1 < len(pattern) && pattern[i+1] == '*' {
+				i++
+				// **/ also matches no directory at all
+				if i+1 < len(pattern) && pattern[i+1] == '/' {
+					i++
+					b.WriteString("(.*/)?")
+				} else {
+					b.WriteString(".*")
+				}
+			} else {
+				b.WriteString("[^/]*")
+			}
+		case '?':
+			b.WriteString("[^/]")
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+				b.WriteString(regexp.QuoteMeta(string(pattern[i])))
+			}
+		case '[':
+			end := strings.IndexByte(pattern[i:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated character class")
+			}
+			class := pattern[i+1 : i+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + class + "]")
+			i += end
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
+
+// Ignored returns whether path, relative to the context, is excluded. A path
+// is also excluded if one of its parent directories matches a pattern.
+func (d dockerIgnore) Ignored(path string) bool {
+	path = filepath.ToSlash(path)
+	ignored := false
+	for _, p := range d {
+		match := false
+		for candidate := path; candidate != "."; candidate = filepath.ToSlash(filepath.Dir(candidate)) {
+			if p.regexp.MatchString(candidate) {
+				match = true
+				break
+			}
+		}
+		if match {
+			ignored = !p.exclusion
+		}
+	}
+	return ignored
+}
+
+// ContextHash returns a stable hash of all files of the build context which
+// are not excluded by its .dockerignore, and of the Dockerfile. Names, modes
+// and contents of files are hashed, timestamps are not.
+func (b BuildInfo) ContextHash() (string, error) {
+	dir := b.Context
+	if dir == "" {
+		dir = "."
+	}
+	ignore, err := readDockerIgnore(dir)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	if err := hashTree(h, dir, ignore); err != nil {
+		return "", err
+	}
+	// The Dockerfile is always sent to the daemon, even if it is excluded or
+	// outside of the context.
+	dockerfile := b.Dockerfile
+	if dockerfile == "" {
+		dockerfile = "Dockerfile"
+	}
+	fmt.Fprintf(h, "\x00%s\x00", filepath.ToSlash(dockerfile))
+	if err := hashFile(h, filepath.Join(dir, dockerfile)); err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// hashTree writes the names, modes and contents of all files below root which
+// are not ignored to w. If root is a file, only its mode and content are
+// written.
+func hashTree(w io.Writer, root string, ignore dockerIgnore) error {
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		// Excluded directories can contain exceptions, so they are walked
+		if info.IsDir() || ignore.Ignored(rel) {
+			return nil
+		}
+		fmt.Fprintf(w, "%s\x00%o\x00", filepath.ToSlash(rel), info.Mode())
+		if info.Mode()&os.ModeSymlink != 0 {
+			target, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(w, "%s\x00", target)
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		return hashFile(w, path)
+	})
+}
+
+func hashFile(w io.Writer, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
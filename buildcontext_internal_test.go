@@ -0,0 +1,101 @@
+package gantry // import "github.com/ad-freiburg/gantry"
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDockerIgnoreIgnored(t *testing.T) {
+	ignore, err := parseDockerIgnore(strings.NewReader(`# comment
+*.log
+/build
+**/node_modules
+docs/*.md
+!docs/README.md
+tmp?
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		path    string
+		ignored bool
+	}{
+		{"main.go", false},
+		{"error.log", true},
+		{"sub/error.log", false},
+		{"build", true},
+		{"build/out", true},
+		{"src/build", false},
+		{"node_modules/a.js", true},
+		{"web/app/node_modules/a.js", true},
+		{"docs/guide.md", true},
+		{"docs/README.md", false},
+		{"docs/sub/guide.md", false},
+		{"tmp1", true},
+		{"tmp12", false},
+	}
+	for _, c := range cases {
+		if r := ignore.Ignored(c.path); r != c.ignored {
+			t.Errorf("Incorrect result for '%s', got: %t, wanted: %t", c.path, r, c.ignored)
+		}
+	}
+}
+
+func TestBuildInfoContextHash(t *testing.T) {
+	dir, err := ioutil.TempDir("", "context")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	write := func(name string, content string) {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b := BuildInfo{Context: dir}
+	hash := func() string {
+		h, err := b.ContextHash()
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		return h
+	}
+
+	// Without .dockerignore everything is hashed
+	write("Dockerfile", "FROM alpine")
+	write("app/main.go", "package main")
+	write("debug.log", "a")
+	initial := hash()
+	if initial != hash() {
+		t.Errorf("Incorrect hash, not stable")
+	}
+	write("debug.log", "b")
+	withLog := hash()
+	if withLog == initial {
+		t.Errorf("Incorrect hash, change of debug.log not detected")
+	}
+
+	write(DockerIgnore, "*.log\n")
+	ignored := hash()
+	write("debug.log", "c")
+	if hash() != ignored {
+		t.Errorf("Incorrect hash, ignored file changed the hash")
+	}
+	write("app/main.go", "package app")
+	if hash() == ignored {
+		t.Errorf("Incorrect hash, change of app/main.go not detected")
+	}
+	changed := hash()
+	write("Dockerfile", "FROM debian")
+	if hash() == changed {
+		t.Errorf("Incorrect hash, change of Dockerfile not detected")
+	}
+}
@@ -1,12 +1,116 @@
 package gantry // import "github.com/ad-freiburg/gantry"
 
 import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+
 	"github.com/ad-freiburg/gantry/types"
 )
 
 // BuildInfo represents the build-keyword in a docker-compse.yml.
 type BuildInfo struct {
-	Context    string          `json:"context"`
-	Dockerfile string          `json:"dockerfile"`
-	Args       types.StringMap `json:"args"`
+	Context    string                    `json:"context"`
+	Dockerfile string                    `json:"dockerfile"`
+	Args       types.StringMap           `json:"args"`
+	PullBase   bool                      `json:"pull"`
+	CacheFrom  []string                  `json:"cache_from"`
+	Secrets    []BuildSecret             `json:"secrets"` // Requires BuildKit.
+	SSH        types.StringOrStringSlice `json:"ssh"`     // Requires BuildKit.
+	// ExtraArgs are passed verbatim to docker build just before the context.
+	// They are not validated, options gantry models should be preferred.
+	ExtraArgs []string `json:"extra_args"`
+}
+
+// BuildSecret represents a file exposed to a build using --secret.
+type BuildSecret struct {
+	ID     string `json:"id"`
+	Source string `json:"src"`
+}
+
+// UnmarshalJSON sets *s from a "id=...,src=..." string or an object.
+func (s *BuildSecret) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err == nil {
+		result := BuildSecret{}
+		for _, part := range strings.Split(value, ",") {
+			kv := strings.SplitN(part, "=", 2)
+			if len(kv) < 2 {
+				return fmt.Errorf("invalid build secret '%s'", value)
+			}
+			switch kv[0] {
+			case "id":
+				result.ID = kv[1]
+			case "src", "source":
+				result.Source = kv[1]
+			default:
+				return fmt.Errorf("invalid build secret '%s'", value)
+			}
+		}
+		*s = result
+		return nil
+	}
+	parsedJSON := struct {
+		ID     string `json:"id"`
+		Source string `json:"src"`
+	}{}
+	if err := json.Unmarshal(data, &parsedJSON); err != nil {
+		return err
+	}
+	*s = BuildSecret(parsedJSON)
+	return nil
+}
+
+// String returns s in the format used by --secret.
+func (s BuildSecret) String() string {
+	return fmt.Sprintf("id=%s,src=%s", s.ID, s.Source)
+}
+
+// Check validates s, returns nil if ok, otherwise returns found error.
+func (s BuildSecret) Check() error {
+	if s.ID == "" || s.Source == "" {
+		return fmt.Errorf("build secret needs id and src")
+	}
+	return nil
+}
+
+// resolveSecrets makes relative sources of all secrets of b relative to dir.
+func (b *BuildInfo) resolveSecrets(dir string) {
+	for i, secret := range b.Secrets {
+		if secret.Source != "" && !filepath.IsAbs(secret.Source) {
+			b.Secrets[i].Source = filepath.Join(dir, secret.Source)
+		}
+	}
+}
+
+// resolveContext makes a relative context of b relative to dir. Remote
+// contexts like git repositories or URLs are kept. An empty context is only
+// set if a Dockerfile is given, as steps without build information use an
+// image.
+func (b *BuildInfo) resolveContext(dir string) {
+	if b.Context == "" {
+		if b.Dockerfile != "" {
+			b.Context = dir
+		}
+		return
+	}
+	if filepath.IsAbs(b.Context) || isRemoteContext(b.Context) {
+		return
+	}
+	b.Context = filepath.Join(dir, b.Context)
+}
+
+// isRemoteContext returns whether context is not a local directory but a
+// repository, URL or stdin as accepted by docker build.
+func isRemoteContext(context string) bool {
+	if context == "-" {
+		return true
+	}
+	for _, prefix := range []string{"http://", "https://", "git://", "git@", "github.com/"} {
+		if strings.HasPrefix(context, prefix) {
+			return true
+		}
+	}
+	return false
 }
@@ -0,0 +1,34 @@
+package gantry_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ad-freiburg/gantry"
+)
+
+func TestBuildSecretUnmarshalJSON(t *testing.T) {
+	var cases = []struct {
+		json   string
+		err    string
+		result gantry.BuildSecret
+	}{
+		{"", "unexpected end of JSON input", gantry.BuildSecret{}},
+		{"\"id=token,src=./token.txt\"", "", gantry.BuildSecret{ID: "token", Source: "./token.txt"}},
+		{"\"id=token,source=/token.txt\"", "", gantry.BuildSecret{ID: "token", Source: "/token.txt"}},
+		{"\"token\"", "invalid build secret 'token'", gantry.BuildSecret{}},
+		{"\"id=token,mode=0400\"", "invalid build secret 'id=token,mode=0400'", gantry.BuildSecret{}},
+		{"{\"id\": \"token\", \"src\": \"token.txt\"}", "", gantry.BuildSecret{ID: "token", Source: "token.txt"}},
+	}
+
+	for _, c := range cases {
+		s := gantry.BuildSecret{}
+		err := s.UnmarshalJSON([]byte(c.json))
+		if (err != nil && c.err == "") || (err == nil && c.err != "") {
+			t.Errorf("Incorrect error for '%s', got '%s', wanted '%s'", c.json, err, c.err)
+		}
+		if !reflect.DeepEqual(s, c.result) {
+			t.Errorf("Incorrect result for '%s', got: '%#v', wanted '%#v'", c.json, s, c.result)
+		}
+	}
+}
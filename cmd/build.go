@@ -1,12 +1,14 @@
 package cmd // import "github.com/ad-freiburg/gantry/cmd"
 
 import (
+	"github.com/ad-freiburg/gantry"
 	"github.com/spf13/cobra"
 )
 
 func init() {
 	rootCmd.AddCommand(buildCmd)
 	buildCmd.Flags().BoolVar(&forcePull, "pull", false, "Always attempt to pull a newer version of the image.")
+	rootCmd.PersistentFlags().BoolVar(&gantry.ForceRebuild, "force-rebuild", false, "Rebuild all images without using the build cache")
 }
 
 var (
@@ -0,0 +1,53 @@
+package cmd // import "github.com/ad-freiburg/gantry/cmd"
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(doctorCmd)
+	doctorCmd.Flags().BoolVar(&doctorJSON, "json", false, "Print the results as json")
+}
+
+var doctorJSON bool
+
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Checks whether the container runtime is set up and all referenced files exist",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		diagnostics, err := pipeline.Diagnose()
+		if err != nil {
+			return err
+		}
+		failed := 0
+		for _, d := range diagnostics {
+			if !d.OK {
+				failed++
+			}
+		}
+		if doctorJSON {
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			if err := encoder.Encode(diagnostics); err != nil {
+				return err
+			}
+		} else {
+			for _, d := range diagnostics {
+				mark := "✓"
+				if !d.OK {
+					mark = "✗"
+				}
+				fmt.Printf("%s %s: %s\n", mark, d.Check, d.Message)
+			}
+		}
+		if failed > 0 {
+			return fmt.Errorf("%d checks failed", failed)
+		}
+		return nil
+	},
+	PersistentPostRun: func(cmd *cobra.Command, args []string) {},
+}
@@ -65,11 +65,15 @@ var dotCmd = &cobra.Command{
 				continue
 			}
 			sName := strings.ReplaceAll(step.Name, "-", "_")
-			// Display services as ellipse, and steps as rectangle
+			// Display services as ellipse, external services as octagon
+			// and steps as rectangle
 			shape := "rectangle"
 			style := "solid"
-			if step.Meta.Type == gantry.ServiceTypeService {
+			switch step.Meta.Type {
+			case gantry.ServiceTypeService:
 				shape = "ellipse"
+			case gantry.ServiceTypeExternal:
+				shape = "octagon"
 			}
 			if step.Meta.Ignore {
 				style = "dashed"
@@ -0,0 +1,35 @@
+package cmd // import "github.com/ad-freiburg/gantry/cmd"
+
+import (
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(graphCmd)
+	graphCmd.Flags().StringVar(&graphOutput, "output", "-", "File to store the json output, - for stdout")
+}
+
+var graphOutput string
+
+var graphCmd = &cobra.Command{
+	Use:   "graph [flags] [Service/Step...]",
+	Short: "Prints the resolved graph of steps and services as json",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		pipelines, err := pipeline.Definition.Pipelines()
+		if err != nil {
+			return err
+		}
+		if graphOutput == "-" {
+			return pipelines.WriteJSON(os.Stdout)
+		}
+		f, err := os.Create(graphOutput)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return pipelines.WriteJSON(f)
+	},
+	PersistentPostRun: func(cmd *cobra.Command, args []string) {},
+}
@@ -8,25 +8,29 @@ import (
 	"syscall"
 
 	"github.com/ad-freiburg/gantry"
-	"github.com/ad-freiburg/gantry/preprocessor"
 	"github.com/ad-freiburg/gantry/types"
 	"github.com/spf13/cobra"
 )
 
 func init() {
 	preprocessorCmd.AddCommand(preprocessorApplyCmd)
+	preprocessorApplyCmd.Flags().BoolVar(&printSubstitutions, "substitutions", false, "Print the resolved substitutions and their source instead of the result")
 }
 
+var printSubstitutions bool
+
 var preprocessorApplyCmd = &cobra.Command{
-	Use:   "apply file",
-	Short: "Prints the result of pre-processing the given file without executing or altering it",
-	Args:  cobra.ExactArgs(1),
+	Use:   "apply [file]",
+	Short: "Prints the result of pre-processing the given file, or the definition, without executing or altering it",
+	Args:  cobra.MaximumNArgs(1),
 	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
 		return nil
 	},
 	RunE: func(cmd *cobra.Command, args []string) error {
 		var err error
-		defFile = args[0]
+		if len(args) > 0 {
+			defFile = args[0]
+		}
 		ignoredSteps := types.StringSet{}
 		for _, step := range stepsToIgnore {
 			ignoredSteps[step] = true
@@ -44,38 +48,28 @@ var preprocessorApplyCmd = &cobra.Command{
 				env[parts[0]] = &parts[1]
 			}
 		}
-		environment, err := gantry.NewPipelineEnvironment(envFile, env, ignoredSteps, selectedSteps)
+		environment, err := gantry.NewPipelineEnvironmentFromFiles(envFiles, env, ignoredSteps, selectedSteps)
 		if err != nil {
 			if e, ok := err.(*os.PathError); ok && e.Err != syscall.ENOENT {
 				return err
 			}
 		}
 
-		// Read file
-		file, err := os.Open(defFile)
-		if err != nil {
-			return err
-		}
-		defer file.Close()
-		data, err := ioutil.ReadAll(file)
-		if err != nil {
-			return err
-		}
-
-		// Run preprocessor
-		preproc, err := preprocessor.NewPreprocessor()
-		preproc.DryRun = true
-		if err != nil {
-			return err
-		}
-		data, err = preproc.Process(data, environment)
-		if err != nil {
-			return err
+		if printSubstitutions {
+			// Apply the statements of the definition to the substitutions
+			if err := gantry.RenderPipelineDefinition(ioutil.Discard, defFile, environment); err != nil {
+				return err
+			}
+			for _, s := range environment.ResolvedSubstitutions() {
+				value := "<unset>"
+				if s.Value != nil {
+					value = *s.Value
+				}
+				fmt.Printf("%s=%s\t(%s)\n", s.Name, value, s.Source)
+			}
+			return nil
 		}
-
-		// Print result
-		fmt.Printf("%s\n", data)
-		return nil
+		return gantry.RenderPipelineDefinition(os.Stdout, defFile, environment)
 	},
 	PersistentPostRun: func(cmd *cobra.Command, args []string) {},
 }
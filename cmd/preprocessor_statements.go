@@ -26,6 +26,7 @@ var preprocessorStatementsCmd = &cobra.Command{
 		for _, f := range p.Functions() {
 			fmt.Printf("\n%s\n", f.Usage())
 		}
+		fmt.Printf("\n%s\n", preprocessor.IncludeUsage)
 		return nil
 	},
 	PersistentPostRun: func(cmd *cobra.Command, args []string) {},
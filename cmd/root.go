@@ -1,13 +1,16 @@
 package cmd // import "github.com/ad-freiburg/gantry/cmd"
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
 	"os/signal"
 	"path/filepath"
 	"strings"
+	"sync"
 	"syscall"
+	"time"
 
 	"github.com/ad-freiburg/gantry"
 	"github.com/ad-freiburg/gantry/types"
@@ -23,6 +26,12 @@ var rootCmd = &cobra.Command{
 		if pipeline != nil {
 			return nil
 		}
+		if err := gantry.SetPrefixFormat(logFormat); err != nil {
+			return err
+		}
+		if err := gantry.CheckProgress(gantry.Progress); err != nil {
+			return err
+		}
 		var err error
 		ignoredSteps := types.StringSet{}
 		for _, step := range stepsToIgnore {
@@ -41,10 +50,42 @@ var rootCmd = &cobra.Command{
 				env[parts[0]] = &parts[1]
 			}
 		}
-		pipeline, err = gantry.NewPipeline(defFile, envFile, env, ignoredSteps, selectedSteps)
+		pipeline, err = gantry.NewPipelineFromFiles(defFile, envFiles, env, ignoredSteps, selectedSteps)
 		if err != nil {
 			return err
 		}
+		if cmd.Flags().Changed("tempdir-no-autoclean") {
+			pipeline.SetTempDirNoAutoClean(tempDirNoAutoClean)
+		}
+		pipeline.Environment.TempDirPurge = tempDirPurge
+		if len(runnerEnv) > 0 {
+			env := map[string]string{}
+			for _, v := range runnerEnv {
+				parts := strings.SplitN(v, "=", 2)
+				if len(parts) == 1 {
+					return fmt.Errorf("invalid runner-env '%s', use KEY=VALUE", v)
+				}
+				env[parts[0]] = parts[1]
+			}
+			pipeline.SetRunnerEnvironment(env)
+		}
+		if runnerDir != "" {
+			if info, err := os.Stat(runnerDir); err != nil || !info.IsDir() {
+				return fmt.Errorf("invalid runner-dir '%s', not a directory", runnerDir)
+			}
+			pipeline.SetRunnerWorkingDirectory(runnerDir)
+		}
+		if serviceLogs != "" {
+			serviceLogsFile, err = os.Create(serviceLogs)
+			if err != nil {
+				return err
+			}
+			pipeline.ServiceLogs = serviceLogsFile
+			gantry.FollowServiceLogs = true
+		}
+		if waitForInterrupt {
+			gantry.FollowServiceLogs = true
+		}
 		// Check for obvious errors
 		if gantry.Verbose {
 			log.Print("Check pipeline\n")
@@ -52,6 +93,19 @@ var rootCmd = &cobra.Command{
 		if err = pipeline.Check(); err != nil {
 			log.Fatal(err)
 		}
+		unreachable, err := pipeline.Definition.UnreachableSteps()
+		if err != nil {
+			return err
+		}
+		if len(unreachable) > 0 {
+			log.Printf("Warning: not selected and not required by a selected step: %s", strings.Join(unreachable, ", "))
+		}
+		if gantry.DockerHost == "" {
+			gantry.DockerHost = pipeline.Environment.DockerHost
+		}
+		if gantry.DockerContext == "" {
+			gantry.DockerContext = pipeline.Environment.DockerContext
+		}
 		if gantry.ProjectName == "" && pipeline.Environment.ProjectName != "" {
 			gantry.ProjectName = pipeline.Environment.ProjectName
 		}
@@ -66,8 +120,22 @@ var rootCmd = &cobra.Command{
 			}
 			gantry.ProjectName = filepath.Base(cwd)
 		}
-		gantry.ProjectName = strings.ReplaceAll(strings.ReplaceAll(strings.ToLower(gantry.ProjectName), " ", "_"), ".", "")
+		gantry.ProjectName = gantry.SanitizeName(strings.ReplaceAll(gantry.ProjectName, ".", ""))
 		pipeline.Network = gantry.Network(fmt.Sprintf("%s_gantry", gantry.ProjectName))
+		if combinedLogPath == "" && combinedLog {
+			combinedLogPath = gantry.CombinedLogPath(pipeline.Environment.TempDirPath, time.Now())
+		}
+		if combinedLogPath != "" {
+			if err := os.MkdirAll(filepath.Dir(combinedLogPath), 0755); err != nil {
+				return err
+			}
+			combinedLogFile, err = os.Create(combinedLogPath)
+			if err != nil {
+				return err
+			}
+			gantry.SetCombinedLog(combinedLogFile)
+			log.Printf("Writing combined log to %s", combinedLogPath)
+		}
 		// We have valid data, silence generic usage information now.
 		cmd.SilenceUsage = true
 		// Print used container executable
@@ -91,6 +159,23 @@ var rootCmd = &cobra.Command{
 		if err := pipeline.CleanUp(syscall.Signal(0)); err != nil {
 			log.Fatal(err)
 		}
+		// All followers are stopped, nothing writes to the file anymore.
+		if serviceLogsFile != nil {
+			if err := serviceLogsFile.Close(); err != nil {
+				log.Printf("Error closing %s: %s", serviceLogs, err)
+			}
+		}
+		if pruneImages {
+			if err := pipeline.PruneImages(); err != nil {
+				log.Printf("Error pruning images: %s", err)
+			}
+		}
+		if combinedLogFile != nil {
+			gantry.SetCombinedLog(nil)
+			if err := combinedLogFile.Close(); err != nil {
+				log.Printf("Error closing %s: %s", combinedLogPath, err)
+			}
+		}
 	},
 	Version:                gantry.Version,
 	BashCompletionFunction: bashCompletionFunc,
@@ -110,21 +195,67 @@ const (
 
 var (
 	defFile       string
-	envFile       string
+	envFiles      []string
 	pipeline      *gantry.Pipeline
 	stepsToIgnore []string
 	environment   []string
+	pruneImages   bool
+	logFormat     string
+	// runnerEnv is passed to the container executable, e.g. DOCKER_BUILDKIT=1.
+	runnerEnv []string
+	// runnerDir is the working directory of the container executable.
+	runnerDir string
+	// tempDirNoAutoClean overrides tempdir_no_autoclean if set.
+	tempDirNoAutoClean bool
+	// tempDirPurge removes persisted temporary directories after the run.
+	tempDirPurge bool
+	// serviceLogs is the file the merged logs of services are written to.
+	serviceLogs     string
+	serviceLogsFile *os.File
+	// combinedLog writes a combined log of the run to the default location.
+	combinedLog bool
+	// combinedLogPath is the file all prefixed output is copied to.
+	combinedLogPath string
+	combinedLogFile *os.File
+	// waitCancel stops waiting for services on interrupt if set.
+	waitCancel context.CancelFunc
+	waitMutex  sync.Mutex
 )
 
 func init() {
 	rootCmd.PersistentFlags().StringVarP(&defFile, "file", "f", "", fmt.Sprintf("Explicit %s to use", gantry.GantryDef))
-	rootCmd.PersistentFlags().StringVarP(&envFile, "global-environment", "g", "", fmt.Sprintf("Explicit %s to use", gantry.GantryEnv))
+	rootCmd.PersistentFlags().StringArrayVarP(&envFiles, "global-environment", "g", []string{}, fmt.Sprintf("Explicit %s to use, later files override earlier ones", gantry.GantryEnv))
 	rootCmd.PersistentFlags().StringVarP(&gantry.ProjectName, "project-name", "p", "", "Spefify an alternate project name")
+	rootCmd.PersistentFlags().StringVar(&gantry.DockerHost, "docker-host", "", "Daemon to run all commands against, passed as DOCKER_HOST, overrides docker_host of the environment")
+	rootCmd.PersistentFlags().StringVar(&gantry.DockerContext, "context", "", "Docker context to run all commands in, overrides docker_context of the environment")
+	rootCmd.PersistentFlags().StringVar(&gantry.TempDirPath, "tempdir", "", fmt.Sprintf("Root of all temporary directories, overrides %s and tempdir of the environment", gantry.TempDirEnv))
+	rootCmd.PersistentFlags().StringVar(&gantry.SecretsDir, "secrets-dir", "", fmt.Sprintf("Load each file in this directory, e.g. %s, as sensitive substitution named like the file, overrides secrets_dir of the environment", gantry.DefaultSecretsDir))
 	rootCmd.PersistentFlags().BoolVar(&gantry.Verbose, "verbose", false, "Verbose output")
 	rootCmd.PersistentFlags().BoolVar(&gantry.ShowContainerCommands, "show-container-commands", false, "Print commands used to interact with containers")
 	rootCmd.PersistentFlags().BoolVar(&gantry.ForceWharfer, "force-wharfer", false, "Force usage of wharfer")
+	rootCmd.PersistentFlags().BoolVar(&gantry.FollowServiceLogs, "follow-service-logs", false, "Print logs of detached services while running")
+	rootCmd.PersistentFlags().StringVar(&serviceLogs, "service-logs", "", "Merge the logs of detached services into this file, implies --follow-service-logs")
+	rootCmd.PersistentFlags().BoolVar(&combinedLog, "combined-log", false, fmt.Sprintf("Copy all output with timestamps to a log file of this run in %s of the temporary directory", gantry.RunLogDir))
+	rootCmd.PersistentFlags().StringVar(&combinedLogPath, "combined-log-file", "", "Copy all output with timestamps to this file, implies --combined-log")
+	rootCmd.PersistentFlags().DurationVar(&gantry.StatsInterval, "stats-interval", 0, "Sample cpu and memory usage of detached services in this interval and print a summary, 0 to disable")
+	rootCmd.PersistentFlags().IntVar(&gantry.MaxParallel, "parallel", 0, "Maximum number of steps running at the same time, 0 for no limit")
+	rootCmd.PersistentFlags().IntVar(&gantry.BailAfter, "bail-after", 1, "Stop starting steps once this many steps failed, 0 to run all steps whose dependencies succeeded")
+	rootCmd.PersistentFlags().BoolVar(&gantry.NoDeps, "no-deps", false, "Do not run dependencies of selected steps, required services have to be running")
+	rootCmd.PersistentFlags().BoolVar(&gantry.Resume, "resume", false, "Skip steps which succeeded in the previous run and did not change since")
+	rootCmd.PersistentFlags().BoolVar(&gantry.ForceRerun, "force-rerun", false, "Run all steps when resuming, record their outcome again")
+	rootCmd.PersistentFlags().BoolVar(&gantry.ReuseServices, "reuse-services", false, "Keep running and healthy containers of services from a previous run instead of replacing them")
+	rootCmd.PersistentFlags().BoolVarP(&gantry.Quiet, "quiet", "q", false, "Only print output of steps which fail")
+	rootCmd.PersistentFlags().BoolVar(&gantry.GroupOutput, "group-output", false, "Print the output of each step as one block once it finished instead of live")
+	rootCmd.PersistentFlags().BoolVar(&gantry.StrictVolumes, "strict-volumes", false, "Fail instead of warn if bind-mount sources do not exist")
+	rootCmd.PersistentFlags().BoolVar(&pruneImages, "prune-images", false, "Remove dangling images built for this project after running")
+	rootCmd.PersistentFlags().BoolVar(&tempDirNoAutoClean, "tempdir-no-autoclean", false, "Do not clean temporary directories, overrides tempdir_no_autoclean of the environment")
+	rootCmd.PersistentFlags().BoolVar(&tempDirPurge, "tempdir-purge", false, "Remove temporary directories persisted by tempdir_persist after the run")
+	rootCmd.PersistentFlags().StringVar(&gantry.Progress, "progress", gantry.ProgressAuto, fmt.Sprintf("Progress output of builds and pulls: %s keeps the output of docker, %s passes --progress=plain to builds, %s prints one line per finished build step or layer and requires BuildKit", gantry.ProgressAuto, gantry.ProgressPlain, gantry.ProgressSummary))
+	rootCmd.PersistentFlags().StringVar(&logFormat, "log-format", "", "Go template for prefixed output lines, e.g. '[{{plain .Prefix}}] {{.Line}}'")
 	rootCmd.PersistentFlags().StringArrayVarP(&stepsToIgnore, "ignore", "i", []string{}, "Ignore step/service with this name")
 	rootCmd.PersistentFlags().StringArrayVarP(&environment, "env", "e", []string{}, "Set environment variables")
+	rootCmd.PersistentFlags().StringVar(&runnerDir, "runner-dir", "", "Run the container executable in this directory instead of the current one")
+	rootCmd.PersistentFlags().StringArrayVar(&runnerEnv, "runner-env", []string{}, "Set KEY=VALUE in the environment of the container executable, e.g. DOCKER_BUILDKIT=1")
 	if err := rootCmd.PersistentFlags().SetAnnotation("file", cobra.BashCompFilenameExt, []string{".yaml", ".yml"}); err != nil {
 		log.Printf("Error setting file annotation: %s", err)
 	}
@@ -143,6 +274,10 @@ func signalHandler() {
 	for s := range c {
 		switch s {
 		case syscall.SIGINT:
+			// Waiting for services is the regular way to end
+			if stopWaiting() {
+				continue
+			}
 			if err := pipeline.CleanUp(s); err != nil {
 				log.Fatal(err)
 			}
@@ -159,6 +294,26 @@ func signalHandler() {
 	}
 }
 
+// setWaitCancel sets the function called by stopWaiting.
+func setWaitCancel(cancel context.CancelFunc) {
+	waitMutex.Lock()
+	defer waitMutex.Unlock()
+	waitCancel = cancel
+}
+
+// stopWaiting stops waiting for services, returns false if gantry does not
+// wait.
+func stopWaiting() bool {
+	waitMutex.Lock()
+	defer waitMutex.Unlock()
+	if waitCancel == nil {
+		return false
+	}
+	waitCancel()
+	waitCancel = nil
+	return true
+}
+
 // Execute is the main entrypoint for using gantry commands.
 func Execute() {
 	if err := rootCmd.Execute(); err != nil {
@@ -1,15 +1,32 @@
 package cmd // import "github.com/ad-freiburg/gantry/cmd"
 
 import (
+	"context"
 	"log"
+	"os"
 
+	"github.com/ad-freiburg/gantry"
 	"github.com/spf13/cobra"
 )
 
 func init() {
 	rootCmd.AddCommand(startCmd)
+	rootCmd.PersistentFlags().BoolVar(&noSummary, "no-summary", false, "Do not print a summary table of all steps after execution")
+	rootCmd.PersistentFlags().BoolVar(&printDurations, "durations", false, "Print the duration of each step sorted by duration instead of the summary table")
+	rootCmd.PersistentFlags().StringVar(&eventsOutput, "events", "", "Write newline-delimited json events to this file, - for stdout")
+	rootCmd.PersistentFlags().StringVar(&reportOutput, "report", "", "Write a json report of the run to this file, - for stdout")
+	rootCmd.PersistentFlags().BoolVar(&waitForInterrupt, "wait", false, "Keep following started services until interrupted, then stop them")
 }
 
+var (
+	printDurations bool
+	noSummary      bool
+	eventsOutput   string
+	reportOutput   string
+	// waitForInterrupt keeps gantry in the foreground after all steps ran.
+	waitForInterrupt bool
+)
+
 var startCmd = &cobra.Command{
 	Use:   "start [flags] [Service/Step...]",
 	Short: "Starts containers",
@@ -17,6 +34,61 @@ var startCmd = &cobra.Command{
 		if err := pipeline.CreateNetwork(); err != nil {
 			log.Printf("Error creating network: %s", err)
 		}
-		return pipeline.ExecuteSteps()
+		if eventsOutput == "-" {
+			pipeline.Events = gantry.NewEventEmitter(os.Stdout)
+		} else if eventsOutput != "" {
+			f, err := os.Create(eventsOutput)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			pipeline.Events = gantry.NewEventEmitter(f)
+		}
+		err := pipeline.ExecuteSteps()
+		// Keep stdout free of tables if json is written to it, the durations
+		// replace the summary as they repeat its durations
+		if !noSummary && !printDurations && !gantry.Quiet && eventsOutput != "-" && reportOutput != "-" && pipeline.Result != nil {
+			if err := pipeline.Result.PrintSummary(os.Stdout, gantry.ColorEnabled(os.Stdout)); err != nil {
+				log.Printf("Error printing summary: %s", err)
+			}
+		}
+		// Durations and stats go to stderr if json is written to stdout
+		output := os.Stdout
+		if eventsOutput == "-" || reportOutput == "-" {
+			output = os.Stderr
+		}
+		if printDurations && pipeline.Result != nil {
+			if err := pipeline.Result.PrintDurations(output); err != nil {
+				log.Printf("Error printing durations: %s", err)
+			}
+		}
+		if reportOutput != "" && pipeline.Result != nil {
+			if err := writeReport(pipeline.Result, reportOutput); err != nil {
+				log.Printf("Error writing report: %s", err)
+			}
+		}
+		if err == nil && waitForInterrupt {
+			ctx, cancel := context.WithCancel(context.Background())
+			setWaitCancel(cancel)
+			defer setWaitCancel(nil)
+			err = pipeline.Wait(ctx)
+		}
+		// The run ends here, services are sampled until now
+		if err := pipeline.PrintStats(output); err != nil {
+			log.Printf("Error printing stats: %s", err)
+		}
+		return err
 	},
 }
+
+func writeReport(result *gantry.PipelineResult, path string) error {
+	if path == "-" {
+		return result.WriteJSON(os.Stdout, gantry.ProjectName)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return result.WriteJSON(f, gantry.ProjectName)
+}
@@ -0,0 +1,49 @@
+package cmd // import "github.com/ad-freiburg/gantry/cmd"
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ad-freiburg/gantry"
+	"github.com/ad-freiburg/gantry/types"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(validateCmd)
+}
+
+var validateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Checks the definition and reports all problems found without running anything",
+	Args:  cobra.NoArgs,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ignoredSteps := types.StringSet{}
+		for _, step := range stepsToIgnore {
+			ignoredSteps[step] = true
+		}
+		env := types.StringMap{}
+		for _, v := range environment {
+			parts := strings.SplitN(v, "=", 2)
+			if len(parts) == 1 {
+				env[parts[0]] = nil
+			} else {
+				env[parts[0]] = &parts[1]
+			}
+		}
+		problems := gantry.Validate(defFile, envFiles, env, ignoredSteps, types.StringSet{})
+		for _, problem := range problems {
+			fmt.Printf("✗ %s\n", problem)
+		}
+		if len(problems) > 0 {
+			// Only the problems are of interest, not the usage
+			cmd.SilenceUsage = true
+			return fmt.Errorf("%d problems found", len(problems))
+		}
+		return nil
+	},
+	PersistentPostRun: func(cmd *cobra.Command, args []string) {},
+}
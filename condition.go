@@ -0,0 +1,43 @@
+package gantry // import "github.com/ad-freiburg/gantry"
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/ad-freiburg/gantry/types"
+)
+
+// Condition is a boolean given as bool or as string, which is parsed like
+// the BOOL statement after substitution, e.g. enabled_if: ${DEPLOY}. An empty
+// value is false, a condition which is not given at all is true.
+type Condition struct {
+	defined bool
+	value   bool
+}
+
+// UnmarshalJSON sets c from a bool, a string or null.
+func (c *Condition) UnmarshalJSON(data []byte) error {
+	c.defined = true
+	if string(data) == "null" {
+		c.value = false
+		return nil
+	}
+	if err := json.Unmarshal(data, &c.value); err == nil {
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("invalid condition %s, use a bool or a string", data)
+	}
+	value, err := types.ParseBool(s)
+	if err != nil {
+		return fmt.Errorf("invalid condition: %s", err)
+	}
+	c.value = value
+	return nil
+}
+
+// IsTrue returns the value of c, true if c is not defined.
+func (c Condition) IsTrue() bool {
+	return !c.defined || c.value
+}
@@ -0,0 +1,37 @@
+package gantry_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/ad-freiburg/gantry"
+)
+
+func TestConditionUnmarshalJSON(t *testing.T) {
+	cases := []struct {
+		json   string
+		err    bool
+		result bool
+	}{
+		{`{}`, false, true},
+		{`{"enabled_if": true}`, false, true},
+		{`{"enabled_if": false}`, false, false},
+		{`{"enabled_if": null}`, false, false},
+		{`{"enabled_if": ""}`, false, false},
+		{`{"enabled_if": "Yes"}`, false, true},
+		{`{"enabled_if": "off"}`, false, false},
+		{`{"enabled_if": "maybe"}`, true, true},
+		{`{"enabled_if": [true]}`, true, true},
+	}
+	for _, c := range cases {
+		var step gantry.Step
+		err := json.Unmarshal([]byte(c.json), &step)
+		if (err != nil) != c.err {
+			t.Errorf("Incorrect error for '%s', got: %v, wanted error: %t", c.json, err, c.err)
+			continue
+		}
+		if err == nil && step.EnabledIf.IsTrue() != c.result {
+			t.Errorf("Incorrect result for '%s', got: %t, wanted: %t", c.json, step.EnabledIf.IsTrue(), c.result)
+		}
+	}
+}
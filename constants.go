@@ -3,6 +3,7 @@ package gantry // import "github.com/ad-freiburg/gantry"
 import (
 	"log"
 	"os"
+	"time"
 )
 
 // DockerCompose stores the default name of a docker compose file.
@@ -14,6 +15,34 @@ const GantryDef string = "gantry.yml"
 // GantryEnv stores the default name of a gantry environment.
 const GantryEnv string = "gantry.env.yml"
 
+// TempDirEnv stores the name of the environment variable overriding tempdir
+// of the environment files.
+const TempDirEnv string = "GANTRY_TMPDIR"
+
+// TempDirState stores the name pattern of the file inside the temporary
+// directory which persists the temporary directories of a project between
+// runs.
+const TempDirState string = ".gantry_tempdirs_%s.json"
+
+// ResumeState stores the name pattern of the file inside the temporary
+// directory which records the succeeded steps of a project.
+const ResumeState string = ".gantry_resume_%s.json"
+
+// RunLogDir stores the name of the directory inside the temporary directory
+// which keeps the combined logs of all runs.
+const RunLogDir string = ".gantry_runs"
+
+// LabelProject stores the label used to mark containers and images of a
+// project.
+const LabelProject string = "gantry.project"
+
+// LabelService stores the label used to mark containers of a step or
+// service.
+const LabelService string = "gantry.service"
+
+// LabelStep stores the label used to mark images built for a step or service.
+const LabelStep string = "gantry.step"
+
 var (
 	// Version of the program
 	Version = "no-version"
@@ -23,9 +52,62 @@ var (
 	ShowContainerCommands = false
 	// ProjectName stores the global prefix for networks and containers.
 	ProjectName = ""
+	// DockerHost selects the daemon used by all steps, passed as DOCKER_HOST.
+	// The local daemon is used if empty.
+	DockerHost = ""
+	// DockerContext selects the docker context used by all steps, passed as
+	// --context, which wharfer does not support. The current context is used
+	// if empty.
+	DockerContext = ""
+	// SecretsDir overrides secrets_dir of the environment if set.
+	SecretsDir = ""
+	// TempDirPath overrides tempdir of the environment and TempDirEnv if set.
+	TempDirPath = ""
 	// ForceWharfer is a global flag to force the usage of wharfer even
 	// if the user could use docker directly.
 	ForceWharfer = false
+	// FollowServiceLogs is a global flag to signal printing the logs of
+	// detached services while the pipeline is running.
+	FollowServiceLogs = false
+	// StrictVolumes is a global flag to signal that missing bind-mount
+	// sources are errors instead of warnings.
+	StrictVolumes = false
+	// ForceRebuild is a global flag to signal that images are built without
+	// using the build cache. It only adds --no-cache, images are always built.
+	ForceRebuild = false
+	// Quiet is a global flag to signal that the standard output of steps is
+	// only printed if the step fails.
+	Quiet = false
+	// GroupOutput is a global flag to signal that the output of each
+	// container command is printed as one block once it finished instead of
+	// interleaved with the output of other steps.
+	GroupOutput = false
+	// Progress selects how the progress of image builds and pulls is
+	// printed, one of ProgressAuto, ProgressPlain and ProgressSummary.
+	Progress = ProgressAuto
+	// MaxParallel limits the number of steps running at the same time, values
+	// less than 1 do not limit the number of steps.
+	MaxParallel = 0
+	// BailAfter is the number of failed steps after which no further steps
+	// are started, values less than 1 run all steps whose dependencies
+	// succeeded.
+	BailAfter = 1
+	// NoDeps is a global flag to signal that selected steps run without their
+	// dependencies, required services have to be running already.
+	NoDeps = false
+	// Resume is a global flag to signal that steps succeeded in the previous
+	// run are skipped if their definitions did not change.
+	Resume = false
+	// ForceRerun is a global flag to signal that all steps run again when
+	// resuming.
+	ForceRerun = false
+	// ReuseServices is a global flag to signal that running and healthy
+	// containers of services from a previous run are used instead of being
+	// replaced.
+	ReuseServices = false
+	// StatsInterval is the interval in which the resource usage of detached
+	// services is sampled, 0 disables sampling.
+	StatsInterval time.Duration
 )
 
 func init() {
@@ -0,0 +1,83 @@
+package gantry // import "github.com/ad-freiburg/gantry"
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// DependencyCondition stores what is required of a dependency before a
+// dependent step is started.
+type DependencyCondition string
+
+const (
+	// DependencyConditionStarted signals that the dependency only has to be
+	// started, this is the default.
+	DependencyConditionStarted DependencyCondition = "service_started"
+	// DependencyConditionHealthy signals that all containers of the
+	// dependency have to pass their docker healthcheck.
+	DependencyConditionHealthy DependencyCondition = "service_healthy"
+)
+
+// DependsOn stores the names of the steps a step depends on together with
+// the condition for each of them.
+type DependsOn map[string]DependencyCondition
+
+// UnmarshalJSON sets *d from a single name, a list of names or a map of names
+// to {"condition": ...} as used by docker-compose.
+func (d *DependsOn) UnmarshalJSON(data []byte) error {
+	result := DependsOn{}
+	var names []string
+	if err := json.Unmarshal(data, &names); err == nil {
+		for _, name := range names {
+			result[name] = DependencyConditionStarted
+		}
+		*d = result
+		return nil
+	}
+	var name string
+	if err := json.Unmarshal(data, &name); err == nil {
+		result[name] = DependencyConditionStarted
+		*d = result
+		return nil
+	}
+	var conditions map[string]struct {
+		Condition DependencyCondition `json:"condition"`
+	}
+	if err := json.Unmarshal(data, &conditions); err != nil {
+		return fmt.Errorf("depends_on has to be a list of names or a map of names to conditions: %s", string(data))
+	}
+	for name, c := range conditions {
+		switch c.Condition {
+		case "":
+			result[name] = DependencyConditionStarted
+		case DependencyConditionStarted, DependencyConditionHealthy:
+			result[name] = c.Condition
+		default:
+			return fmt.Errorf("unknown condition '%s' for dependency '%s', use '%s' or '%s'", c.Condition, name, DependencyConditionStarted, DependencyConditionHealthy)
+		}
+	}
+	*d = result
+	return nil
+}
+
+// checkDependencyConditions validates that the condition service_healthy is
+// only used for dependencies on services, steps have no healthcheck to wait
+// for.
+func checkDependencyConditions(steps []Step) error {
+	kinds := make(map[string]ServiceType)
+	for _, step := range steps {
+		kinds[step.Name] = step.Meta.Type
+	}
+	sort.Slice(steps, func(i, j int) bool {
+		return steps[i].Name < steps[j].Name
+	})
+	for _, step := range steps {
+		for _, name := range sortedKeys(step.Dependencies()) {
+			if step.DependsOn[name] == DependencyConditionHealthy && kinds[name] == ServiceTypeStep {
+				return fmt.Errorf("condition '%s' of '%s' requires '%s' to be a service, not a step", DependencyConditionHealthy, step.Name, name)
+			}
+		}
+	}
+	return nil
+}
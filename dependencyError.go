@@ -0,0 +1,100 @@
+package gantry // import "github.com/ad-freiburg/gantry"
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// UnknownDependencyError is returned if a step depends on a step which is not
+// defined.
+type UnknownDependencyError struct {
+	Step       string
+	Dependency string
+}
+
+// Error returns the string representation of the error.
+func (e UnknownDependencyError) Error() string {
+	return fmt.Sprintf("unknown dependency '%s' for step '%s'", e.Dependency, e.Step)
+}
+
+// StageConflictError is returned if a step depends on a step of a later
+// explicit stage.
+type StageConflictError struct {
+	Step            string
+	Stage           string
+	Dependency      string
+	DependencyStage string
+}
+
+// Error returns the string representation of the error.
+func (e StageConflictError) Error() string {
+	return fmt.Sprintf("step '%s' of stage '%s' depends on '%s' of later stage '%s'", e.Step, e.Stage, e.Dependency, e.DependencyStage)
+}
+
+// CyclicComponentError is returned if steps depend on each other. Path stores
+// one cycle through the component, starting and ending with the same step.
+type CyclicComponentError struct {
+	Steps []string
+	Path  []string
+}
+
+// Error returns the string representation of the error.
+func (e CyclicComponentError) Error() string {
+	msg := fmt.Sprintf("cyclic component found in (sub)pipeline: '%s'", strings.Join(e.Steps, ", "))
+	if len(e.Path) > 0 {
+		msg = fmt.Sprintf("%s (%s)", msg, strings.Join(e.Path, " -> "))
+	}
+	return msg
+}
+
+// newCyclicComponentError creates a CyclicComponentError for the component
+// steps, the cycle is searched starting from the step with the lowest name.
+func newCyclicComponentError(steps []Step) CyclicComponentError {
+	graph := make(map[string]Step, len(steps))
+	names := make([]string, 0, len(steps))
+	for _, step := range steps {
+		graph[step.Name] = step
+		names = append(names, step.Name)
+	}
+	sort.Strings(names)
+	result := CyclicComponentError{Steps: names}
+	if len(names) == 0 {
+		return result
+	}
+	// Breadth-first search from the start back to itself
+	start := names[0]
+	parent := make(map[string]string)
+	queue := []string{start}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		deps := make([]string, 0)
+		for dep := range graph[current].Dependencies() {
+			if _, ok := graph[dep]; ok {
+				deps = append(deps, dep)
+			}
+		}
+		sort.Strings(deps)
+		for _, dep := range deps {
+			if dep == start {
+				path := []string{start}
+				for n := current; n != start; n = parent[n] {
+					path = append(path, n)
+				}
+				path = append(path, start)
+				// Reverse to follow the dependencies from start
+				for i, j := 1, len(path)-2; i < j; i, j = i+1, j-1 {
+					path[i], path[j] = path[j], path[i]
+				}
+				result.Path = path
+				return result
+			}
+			if _, seen := parent[dep]; !seen {
+				parent[dep] = current
+				queue = append(queue, dep)
+			}
+		}
+	}
+	return result
+}
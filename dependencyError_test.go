@@ -0,0 +1,66 @@
+package gantry_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ad-freiburg/gantry"
+	"github.com/ad-freiburg/gantry/types"
+)
+
+func TestUnknownDependencyErrorError(t *testing.T) {
+	err := gantry.UnknownDependencyError{Step: "b", Dependency: "a"}
+	expected := "unknown dependency 'a' for step 'b'"
+	if r := err.Error(); r != expected {
+		t.Errorf("Incorrect result, got: '%s', wanted: '%s'", r, expected)
+	}
+}
+
+func TestStageConflictErrorError(t *testing.T) {
+	err := gantry.StageConflictError{Step: "a", Stage: "build", Dependency: "b", DependencyStage: "test"}
+	expected := "step 'a' of stage 'build' depends on 'b' of later stage 'test'"
+	if r := err.Error(); r != expected {
+		t.Errorf("Incorrect result, got: '%s', wanted: '%s'", r, expected)
+	}
+}
+
+func TestCyclicComponentError(t *testing.T) {
+	stepA := gantry.Step{Service: gantry.Service{Name: "a"}, After: types.StringSet{"c": true}}
+	stepB := gantry.Step{Service: gantry.Service{Name: "b"}, After: types.StringSet{"a": true}}
+	stepC := gantry.Step{Service: gantry.Service{Name: "c"}, After: types.StringSet{"b": true, "d": true}}
+	stepD := gantry.Step{Service: gantry.Service{Name: "d"}}
+	stepE := gantry.Step{Service: gantry.Service{Name: "e"}, After: types.StringSet{"f": true}}
+	stepF := gantry.Step{Service: gantry.Service{Name: "f"}, After: types.StringSet{"e": true}}
+
+	cases := []struct {
+		input  gantry.Pipelines
+		result gantry.CyclicComponentError
+		msg    string
+	}{
+		{
+			gantry.Pipelines{[]gantry.Step{stepC, stepB, stepA}, {stepD}},
+			gantry.CyclicComponentError{Steps: []string{"a", "b", "c"}, Path: []string{"a", "c", "b", "a"}},
+			"cyclic component found in (sub)pipeline: 'a, b, c' (a -> c -> b -> a)",
+		},
+		{
+			gantry.Pipelines{[]gantry.Step{stepF, stepE}},
+			gantry.CyclicComponentError{Steps: []string{"e", "f"}, Path: []string{"e", "f", "e"}},
+			"cyclic component found in (sub)pipeline: 'e, f' (e -> f -> e)",
+		},
+	}
+
+	for _, c := range cases {
+		err := c.input.Check()
+		r, ok := err.(gantry.CyclicComponentError)
+		if !ok {
+			t.Errorf("Incorrect error type for '%v', got: '%#v'", c.input, err)
+			continue
+		}
+		if !reflect.DeepEqual(r, c.result) {
+			t.Errorf("Incorrect result for '%v', got: '%#v', wanted '%#v'", c.input, r, c.result)
+		}
+		if r.Error() != c.msg {
+			t.Errorf("Incorrect message for '%v', got: '%s', wanted '%s'", c.input, r.Error(), c.msg)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package gantry_test
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/ad-freiburg/gantry"
+)
+
+func TestDependsOnUnmarshalJSON(t *testing.T) {
+	cases := []struct {
+		input  string
+		result gantry.DependsOn
+		err    string
+	}{
+		{`["a", "b"]`, gantry.DependsOn{"a": gantry.DependencyConditionStarted, "b": gantry.DependencyConditionStarted}, ""},
+		{`"a"`, gantry.DependsOn{"a": gantry.DependencyConditionStarted}, ""},
+		{`{"a": {"condition": "service_healthy"}, "b": {"condition": "service_started"}, "c": {}}`, gantry.DependsOn{"a": gantry.DependencyConditionHealthy, "b": gantry.DependencyConditionStarted, "c": gantry.DependencyConditionStarted}, ""},
+		{`{"a": {"condition": "service_completed"}}`, nil, "unknown condition 'service_completed' for dependency 'a', use 'service_started' or 'service_healthy'"},
+		{`1`, nil, "depends_on has to be a list of names or a map of names to conditions: 1"},
+	}
+
+	for _, c := range cases {
+		var r gantry.DependsOn
+		err := json.Unmarshal([]byte(c.input), &r)
+		if (err == nil && c.err != "") || (err != nil && err.Error() != c.err) {
+			t.Errorf("Incorrect error for '%s', got: '%v', wanted: '%s'", c.input, err, c.err)
+		}
+		if !reflect.DeepEqual(r, c.result) {
+			t.Errorf("Incorrect result for '%s', got: '%v', wanted: '%v'", c.input, r, c.result)
+		}
+	}
+}
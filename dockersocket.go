@@ -0,0 +1,70 @@
+package gantry // import "github.com/ad-freiburg/gantry"
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// containerDockerSocket is where docker_socket mounts the socket inside the
+// container.
+const containerDockerSocket = "/var/run/docker.sock"
+
+// dockerSocketCandidates returns the usual socket paths of rootful and
+// rootless docker and podman in the order they are tried.
+func dockerSocketCandidates() []string {
+	candidates := []string{"/var/run/docker.sock"}
+	runtimeDir := os.Getenv("XDG_RUNTIME_DIR")
+	if runtimeDir == "" {
+		runtimeDir = fmt.Sprintf("/run/user/%d", os.Getuid())
+	}
+	return append(candidates,
+		filepath.Join(runtimeDir, "docker.sock"),
+		filepath.Join(runtimeDir, "podman", "podman.sock"),
+		"/run/podman/podman.sock",
+	)
+}
+
+// hostDockerSocket returns the path of the socket of the daemon selected by
+// host, DOCKER_HOST if host is empty. Without a selected daemon the first
+// existing candidate is used. Daemons not reachable by a unix socket can not
+// be mounted.
+func hostDockerSocket(host string) (string, error) {
+	if host == "" {
+		host = os.Getenv("DOCKER_HOST")
+	}
+	if host != "" {
+		if !strings.HasPrefix(host, "unix://") {
+			return "", fmt.Errorf("daemon '%s' is not reachable by a unix socket", host)
+		}
+		return strings.TrimPrefix(host, "unix://"), nil
+	}
+	for _, path := range dockerSocketCandidates() {
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("no docker socket found, tried: %s", strings.Join(dockerSocketCandidates(), ", "))
+}
+
+// mountDockerSocket adds a bind mount of the socket of the daemon used by s
+// if docker_socket is set and warns about the access this grants. Steps which
+// are not run by gantry, as they are ignored, disabled by enabled_if or
+// external services, are left unchanged.
+func (s *Step) mountDockerSocket() error {
+	if !s.DockerSocket || s.Meta.Ignore || s.Meta.Disabled || s.Meta.Type == ServiceTypeExternal {
+		return nil
+	}
+	host := s.Meta.DockerHost
+	if host == "" {
+		host = DockerHost
+	}
+	path, err := hostDockerSocket(host)
+	if err != nil {
+		return fmt.Errorf("docker_socket of '%s': %s", s.Name, err)
+	}
+	pipelineLogger.Printf("Warning: '%s' can control the daemon through %s, this grants root access to the host", s.ColoredName(), path)
+	s.Volumes = append(s.Volumes, fmt.Sprintf("%s:%s", path, containerDockerSocket))
+	return nil
+}
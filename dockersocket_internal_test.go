@@ -0,0 +1,88 @@
+package gantry
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestHostDockerSocket(t *testing.T) {
+	dockerHost := os.Getenv("DOCKER_HOST")
+	defer os.Setenv("DOCKER_HOST", dockerHost)
+	os.Setenv("DOCKER_HOST", "unix:///env/docker.sock")
+
+	cases := []struct {
+		host   string
+		result string
+		err    bool
+	}{
+		{"", "/env/docker.sock", false},
+		{"unix:///run/user/1000/docker.sock", "/run/user/1000/docker.sock", false},
+		{"tcp://127.0.0.1:2375", "", true},
+	}
+	for _, c := range cases {
+		result, err := hostDockerSocket(c.host)
+		if (err != nil) != c.err {
+			t.Errorf("Incorrect error for '%s', got: %v, wanted error: %t", c.host, err, c.err)
+		}
+		if result != c.result {
+			t.Errorf("Incorrect socket for '%s', got: '%s', wanted: '%s'", c.host, result, c.result)
+		}
+	}
+
+	// Without a selected daemon the rootless socket is found
+	if _, err := os.Stat("/var/run/docker.sock"); err == nil {
+		t.Skip("rootful docker socket exists")
+	}
+	dir, err := ioutil.TempDir("", "gantry_runtime")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.MkdirAll(filepath.Join(dir, "podman"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	socket := filepath.Join(dir, "podman", "podman.sock")
+	if err := ioutil.WriteFile(socket, []byte{}, 0600); err != nil {
+		t.Fatal(err)
+	}
+	runtimeDir := os.Getenv("XDG_RUNTIME_DIR")
+	defer os.Setenv("XDG_RUNTIME_DIR", runtimeDir)
+	os.Setenv("XDG_RUNTIME_DIR", dir)
+	os.Unsetenv("DOCKER_HOST")
+	if result, err := hostDockerSocket(""); err != nil || result != socket {
+		t.Errorf("Incorrect socket, got: '%s', %v, wanted: '%s'", result, err, socket)
+	}
+}
+
+func TestStepMountDockerSocket(t *testing.T) {
+	step := Step{Service: Service{Name: "a", Volumes: []string{"data:/data"}, DockerSocket: true}}
+	step.Meta.DockerHost = "unix:///run/docker.sock"
+	if err := step.mountDockerSocket(); err != nil {
+		t.Fatalf("Got unexpected error: %#v", err)
+	}
+	wanted := []string{"data:/data", "/run/docker.sock:/var/run/docker.sock"}
+	if !reflect.DeepEqual(step.Volumes, wanted) {
+		t.Errorf("Incorrect volumes, got: %v, wanted: %v", step.Volumes, wanted)
+	}
+
+	step = Step{Service: Service{Name: "a", DockerSocket: true}}
+	step.Meta.DockerHost = "tcp://remote:2375"
+	if err := step.mountDockerSocket(); err == nil {
+		t.Errorf("Expected error for remote daemon")
+	}
+	// Steps not run by gantry do not need the socket
+	for name, meta := range map[string]ServiceMeta{
+		"ignored":  {Ignore: true},
+		"disabled": {Disabled: true},
+		"external": {Type: ServiceTypeExternal},
+	} {
+		meta.DockerHost = step.Meta.DockerHost
+		step.Meta = meta
+		if err := step.mountDockerSocket(); err != nil || len(step.Volumes) != 0 {
+			t.Errorf("Incorrect result for %s step, got: %v, %v", name, step.Volumes, err)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package gantry // import "github.com/ad-freiburg/gantry"
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Names of the checks run by Diagnose.
+const (
+	DiagnosticExecutable  = "container executable"
+	DiagnosticWharfer     = "wharfer"
+	DiagnosticDockerGroup = "docker group"
+	DiagnosticDaemon      = "daemon"
+	DiagnosticFiles       = "files"
+)
+
+// Diagnostic is the outcome of a single check of Diagnose.
+type Diagnostic struct {
+	Check   string `json:"check"`
+	OK      bool   `json:"ok"`
+	Message string `json:"message"`
+}
+
+// Diagnose checks whether the steps of p can be run on this machine: whether
+// the container executables are installed, whether the user may access the
+// docker daemon, whether the daemons are reachable and whether all referenced
+// files and build contexts exist. Ignored and external steps are not checked.
+func (p *Pipeline) Diagnose() ([]Diagnostic, error) {
+	pipelines, err := p.Definition.Pipelines()
+	if err != nil {
+		return nil, err
+	}
+	steps := []Step{}
+	for _, step := range pipelines.AllSteps() {
+		if step.Meta.Ignore || step.Meta.Type == ServiceTypeExternal {
+			continue
+		}
+		steps = append(steps, step)
+	}
+	result := []Diagnostic{}
+	result = append(result, diagnoseExecutables(steps)...)
+	result = append(result, diagnoseWharfer(), diagnoseDockerGroup())
+	result = append(result, p.diagnoseDaemons(steps)...)
+	result = append(result, diagnoseFiles(steps)...)
+	return result, nil
+}
+
+// diagnoseExecutables checks the detected container executable and all
+// executables chosen by steps.
+func diagnoseExecutables(steps []Step) []Diagnostic {
+	executables := []string{getContainerExecutable()}
+	seen := map[string]bool{executables[0]: true}
+	for _, step := range steps {
+		if step.Executable != "" && !seen[step.Executable] {
+			seen[step.Executable] = true
+			executables = append(executables, step.Executable)
+		}
+	}
+	result := []Diagnostic{}
+	for _, executable := range executables {
+		path, err := exec.LookPath(executable)
+		if err != nil {
+			result = append(result, Diagnostic{DiagnosticExecutable, false, wrapExecutableError(executable, err).Error()})
+			continue
+		}
+		result = append(result, Diagnostic{DiagnosticExecutable, true, fmt.Sprintf("%s found at %s", executable, path)})
+	}
+	return result
+}
+
+func diagnoseWharfer() Diagnostic {
+	found, err := wharferStatus()
+	if err != nil {
+		return Diagnostic{DiagnosticWharfer, false, err.Error()}
+	}
+	if !found {
+		return Diagnostic{DiagnosticWharfer, true, fmt.Sprintf("%s is not installed", wharfer)}
+	}
+	return Diagnostic{DiagnosticWharfer, true, fmt.Sprintf("%s is installed", wharfer)}
+}
+
+func diagnoseDockerGroup() Diagnostic {
+	switch {
+	case isUserRoot():
+		return Diagnostic{DiagnosticDockerGroup, true, "running as root"}
+	case isUserInDockerGroup():
+		return Diagnostic{DiagnosticDockerGroup, true, fmt.Sprintf("user is in the %s group", docker)}
+	case getContainerExecutable() == wharfer:
+		return Diagnostic{DiagnosticDockerGroup, true, fmt.Sprintf("user is not in the %s group, %s is used", docker, wharfer)}
+	}
+	return Diagnostic{DiagnosticDockerGroup, false, fmt.Sprintf("user is neither root nor in the %s group, the daemon may refuse access", docker)}
+}
+
+// diagnoseDaemons checks each distinct daemon selected by steps once.
+func (p *Pipeline) diagnoseDaemons(steps []Step) []Diagnostic {
+	result := []Diagnostic{}
+	seen := map[string]bool{}
+	for _, step := range steps {
+		executable, host, context := stepDaemon(step)
+		daemon := executable
+		if host != "" {
+			daemon = fmt.Sprintf("%s (host %s)", daemon, host)
+		}
+		if context != "" {
+			daemon = fmt.Sprintf("%s (context %s)", daemon, context)
+		}
+		if seen[daemon] {
+			continue
+		}
+		seen[daemon] = true
+		if err := p.localRunner.Copy().DaemonChecker(step)(); err != nil {
+			result = append(result, Diagnostic{DiagnosticDaemon, false, fmt.Sprintf("%s is not reachable: %s", daemon, err)})
+			continue
+		}
+		result = append(result, Diagnostic{DiagnosticDaemon, true, fmt.Sprintf("%s is reachable", daemon)})
+	}
+	return result
+}
+
+// diagnoseFiles reports each missing bind-mount source, build context,
+// Dockerfile and build secret of steps.
+func diagnoseFiles(steps []Step) []Diagnostic {
+	result := []Diagnostic{}
+	missing := func(format string, args ...interface{}) {
+		result = append(result, Diagnostic{DiagnosticFiles, false, fmt.Sprintf(format, args...)})
+	}
+	for _, step := range steps {
+		if !step.CreateHostPath {
+			for _, volume := range step.Volumes {
+				parts := strings.SplitN(volume, ":", 2)
+				if len(parts) < 2 || isNamedVolume(parts[0]) {
+					continue
+				}
+				path := step.hostPath(parts[0])
+				if !exists(path) {
+					missing("bind-mount source '%s' of step '%s' does not exist", path, step.Name)
+				}
+			}
+		}
+		if !step.IsBuildable() || isRemoteContext(step.BuildInfo.Context) {
+			continue
+		}
+		if !exists(step.BuildInfo.Context) {
+			missing("build context '%s' of step '%s' does not exist", step.BuildInfo.Context, step.Name)
+			continue
+		}
+		dockerfile := step.BuildInfo.Dockerfile
+		if dockerfile == "" {
+			dockerfile = "Dockerfile"
+		}
+		if path := filepath.Join(step.BuildInfo.Context, dockerfile); !exists(path) {
+			missing("Dockerfile '%s' of step '%s' does not exist", path, step.Name)
+		}
+		for _, secret := range step.BuildInfo.Secrets {
+			if secret.Source != "" && !exists(secret.Source) {
+				missing("source '%s' of build secret '%s' of step '%s' does not exist", secret.Source, secret.ID, step.Name)
+			}
+		}
+	}
+	if len(result) == 0 {
+		result = append(result, Diagnostic{DiagnosticFiles, true, "all referenced files exist"})
+	}
+	return result
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -0,0 +1,81 @@
+package gantry
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+func TestPipelineDiagnose(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gantry_doctor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.Mkdir(filepath.Join(dir, "context"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	def, env := setupDefAndEnv(`version: "2.0"
+steps:
+  a:
+    image: alpine
+    volumes:
+      - `+filepath.Join(dir, "missing")+`:/data
+      - named:/named
+  b:
+    build:
+      context: `+filepath.Join(dir, "context")+`
+  c:
+    build:
+      context: `+filepath.Join(dir, "absent")+`
+  d:
+    image: alpine
+    executable: docker
+`, "")
+	defer os.Remove(def)
+	defer os.Remove(env)
+	ForceWharfer = true
+	defer func() { ForceWharfer = false }()
+
+	p, err := NewPipeline(def, env, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %#v", err)
+	}
+	runner := NewNoopRunner(true)
+	p.localRunner = runner
+	diagnostics, err := p.Diagnose()
+	if err != nil {
+		t.Fatalf("Got unexpected error: %#v", err)
+	}
+	failedFiles := []string{}
+	for _, d := range diagnostics {
+		if d.Check == DiagnosticFiles && !d.OK {
+			failedFiles = append(failedFiles, d.Message)
+		}
+	}
+	wanted := []string{
+		"bind-mount source '" + filepath.Join(dir, "missing") + "' of step 'a' does not exist",
+		"Dockerfile '" + filepath.Join(dir, "context", "Dockerfile") + "' of step 'b' does not exist",
+		"build context '" + filepath.Join(dir, "absent") + "' of step 'c' does not exist",
+	}
+	sort.Strings(failedFiles)
+	sort.Strings(wanted)
+	if len(failedFiles) != len(wanted) {
+		t.Fatalf("Incorrect failed file checks, got: %#v, wanted: %#v", failedFiles, wanted)
+	}
+	for i := range wanted {
+		if failedFiles[i] != wanted[i] {
+			t.Errorf("Incorrect failed file check, got: '%s', wanted: '%s'", failedFiles[i], wanted[i])
+		}
+	}
+	// The default daemon is checked once, the one of docker separately
+	daemonChecks := 0
+	for _, name := range []string{"a", "b", "c", "d"} {
+		daemonChecks += runner.NumCalled("DaemonChecker(" + name + ")")
+	}
+	if daemonChecks != 2 || runner.NumCalled("DaemonChecker(d)") != 1 {
+		t.Errorf("Incorrect number of daemon checks, got: %d", daemonChecks)
+	}
+}
@@ -7,6 +7,8 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 
 	"github.com/ad-freiburg/gantry/types"
 	"github.com/ghodss/yaml"
@@ -17,9 +19,13 @@ type pipelineEnvironmentJSON struct {
 	Substitutions      types.StringMap `json:"substitutions"`
 	TempDirPath        string          `json:"tempdir"`
 	TempDirNoAutoClean bool            `json:"tempdir_no_autoclean"`
+	TempDirPersist     bool            `json:"tempdir_persist"`
 	Services           ServiceMetaList `json:"services"`
 	Steps              ServiceMetaList `json:"steps"`
 	ProjectName        string          `json:"project_name"`
+	DockerHost         string          `json:"docker_host"`
+	DockerContext      string          `json:"docker_context"`
+	SecretsDir         string          `json:"secrets_dir"`
 }
 
 // PipelineEnvironment stores additional data for pipelines and steps.
@@ -28,10 +34,43 @@ type PipelineEnvironment struct {
 	Substitutions      types.StringMap
 	TempDirPath        string
 	TempDirNoAutoClean bool
-	Steps              ServiceMetaList
-	ProjectName        string
-	tempFiles          []string
-	tempPaths          map[string]string
+	// TempDirPersist reuses temporary directories with the same prefix
+	// between runs, they are only removed if TempDirPurge is set.
+	TempDirPersist bool
+	TempDirPurge   bool
+	Steps          ServiceMetaList
+	ProjectName    string
+	// DockerHost and DockerContext select the daemon used by all steps
+	// which do not select one themselves.
+	DockerHost    string
+	DockerContext string
+	// SecretsDir contains files loaded as sensitive substitutions named
+	// like the files.
+	SecretsDir string
+	tempFiles  []string
+	tempPaths  map[string]string
+	// transient ignores persisted temporary directories, all temporary
+	// directories are created and removed like without TempDirPersist.
+	transient bool
+	// sources stores where each substitution was defined.
+	sources map[string]string
+}
+
+// Sources of substitutions besides environment files, which are reported by
+// their path.
+const (
+	SubstitutionSourceCommandLine = "command line"
+	SubstitutionSourceEnvironment = "os environment"
+	SubstitutionSourceDefinition  = "definition"
+	SubstitutionSourceSecrets     = "secrets directory"
+)
+
+// ResolvedSubstitution stores the effective value of a substitution and where
+// it was defined.
+type ResolvedSubstitution struct {
+	Name   string
+	Value  *string
+	Source string
 }
 
 // UnmarshalJSON loads a PipelineDefinition from json using the pipelineJSON struct.
@@ -49,7 +88,11 @@ func (e *PipelineEnvironment) UnmarshalJSON(data []byte) error {
 	result.Substitutions = parsedJSON.Substitutions
 	result.TempDirPath = parsedJSON.TempDirPath
 	result.TempDirNoAutoClean = parsedJSON.TempDirNoAutoClean
+	result.TempDirPersist = parsedJSON.TempDirPersist
 	result.ProjectName = parsedJSON.ProjectName
+	result.DockerHost = parsedJSON.DockerHost
+	result.DockerContext = parsedJSON.DockerContext
+	result.SecretsDir = parsedJSON.SecretsDir
 	if result.Substitutions == nil {
 		result.Substitutions = types.StringMap{}
 	}
@@ -57,12 +100,23 @@ func (e *PipelineEnvironment) UnmarshalJSON(data []byte) error {
 		meta.Type = ServiceTypeService
 		result.Steps[name] = meta
 	}
+	// Steps are removed after running unless keep_alive is set explicitly
+	explicitKeepAlive := struct {
+		Steps map[string]struct {
+			KeepAlive *ServiceKeepAlive `json:"keep_alive"`
+		} `json:"steps"`
+	}{}
+	if err := json.Unmarshal(data, &explicitKeepAlive); err != nil {
+		return err
+	}
 	for name, meta := range parsedJSON.Steps {
 		if _, found := result.Steps[name]; found {
 			return fmt.Errorf("duplicate step/service '%s'", name)
 		}
 		meta.Type = ServiceTypeStep
-		meta.KeepAlive = KeepAliveNo
+		if explicitKeepAlive.Steps[name].KeepAlive == nil || meta.KeepAlive == KeepAliveReplace {
+			meta.KeepAlive = KeepAliveNo
+		}
 		result.Steps[name] = meta
 	}
 	*e = result
@@ -73,43 +127,125 @@ func (e *PipelineEnvironment) UnmarshalJSON(data []byte) error {
 // environment, the environment given by path and the user provided steps to
 // ignore.
 func NewPipelineEnvironment(path string, substitutions types.StringMap, ignoredSteps types.StringSet, selectedSteps types.StringSet) (*PipelineEnvironment, error) {
-	// Set defaults
-	e := &PipelineEnvironment{
+	return NewPipelineEnvironmentFromFiles([]string{path}, substitutions, ignoredSteps, selectedSteps)
+}
+
+// NewPipelineEnvironmentFromFiles builds a new environment like
+// NewPipelineEnvironment, the environments given by paths are merged in order
+// with later files taking precedence. An empty path selects the default file.
+//
+// Substitutions are resolved in the following order, the first defined value
+// wins: the command line, the environment files, the os environment, the
+// files of the secrets directory and finally statements like SET_IF_EMPTY in
+// the definition. Only substitutions named without value, e.g. '-e FOO', are
+// looked up in the os environment.
+func NewPipelineEnvironmentFromFiles(paths []string, substitutions types.StringMap, ignoredSteps types.StringSet, selectedSteps types.StringSet) (*PipelineEnvironment, error) {
+	e, err := readPipelineEnvironments(paths)
+	if err != nil {
+		// Keep the command line settings if the files could not be read
+		e = newPipelineEnvironment()
+	}
+	// The command line supersedes the environment files
+	e.updateSubstitutions(substitutions)
+	e.setSources(substitutions, SubstitutionSourceCommandLine)
+	e.updateStepsMeta(ignoredSteps, selectedSteps)
+	if err := e.resolveTempDirPath(); err != nil {
+		return e, err
+	}
+	if SecretsDir != "" {
+		e.SecretsDir = SecretsDir
+	}
+	if e.SecretsDir != "" {
+		if err := e.loadSecrets(e.SecretsDir); err != nil {
+			return e, err
+		}
+	}
+	return e, err
+}
+
+func newPipelineEnvironment() *PipelineEnvironment {
+	return &PipelineEnvironment{
 		tempPaths:     make(map[string]string),
 		Substitutions: types.StringMap{},
 		Steps:         ServiceMetaList{},
+		sources:       make(map[string]string),
 	}
-	e.updateSubstitutions(substitutions)
-	e.updateStepsMeta(ignoredSteps, selectedSteps)
+}
 
-	// Import settings from file
+// readPipelineEnvironments reads and merges the environment files given by
+// paths.
+func readPipelineEnvironments(paths []string) (*PipelineEnvironment, error) {
+	if len(paths) == 0 {
+		paths = []string{""}
+	}
 	dir, err := os.Getwd()
 	if err != nil {
-		return e, err
-	}
-	defaultPath := filepath.Join(dir, GantryEnv)
-	if _, err := os.Stat(defaultPath); path == "" && err == nil {
-		path = defaultPath
+		return nil, err
 	}
-	file, err := os.Open(path)
-	if err != nil {
-		return e, err
+	merged := newPipelineEnvironment()
+	for _, path := range paths {
+		defaultPath := filepath.Join(dir, GantryEnv)
+		if _, err := os.Stat(defaultPath); path == "" && err == nil {
+			path = defaultPath
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		data, err := ioutil.ReadAll(file)
+		file.Close()
+		if err != nil {
+			return nil, err
+		}
+		f := &PipelineEnvironment{}
+		if err := yaml.Unmarshal(data, f); err != nil {
+			return nil, err
+		}
+		// A relative secrets_dir is relative to the file setting it
+		if f.SecretsDir != "" && !filepath.IsAbs(f.SecretsDir) {
+			f.SecretsDir = filepath.Join(filepath.Dir(path), f.SecretsDir)
+		}
+		if err := merged.merge(f); err != nil {
+			return nil, err
+		}
+		merged.setSources(f.Substitutions, path)
 	}
-	defer file.Close()
+	return merged, nil
+}
 
-	data, err := ioutil.ReadAll(file)
-	if err != nil {
-		return e, err
+// merge updates e with the settings of other. Substitutions are replaced by
+// key and steps by name, version, tempdir, project_name, docker_host,
+// docker_context and secrets_dir are replaced if set in other.
+// tempdir_no_autoclean and tempdir_persist stay set once a file sets them.
+func (e *PipelineEnvironment) merge(other *PipelineEnvironment) error {
+	if other.Version != "" {
+		e.Version = other.Version
 	}
-	e.Steps = nil
-	err = yaml.Unmarshal(data, e)
-	if err != nil {
-		return e, err
+	if other.TempDirPath != "" {
+		e.TempDirPath = other.TempDirPath
 	}
-	// Reimport defaults
-	e.updateSubstitutions(substitutions)
-	e.updateStepsMeta(ignoredSteps, selectedSteps)
-	return e, nil
+	if other.ProjectName != "" {
+		e.ProjectName = other.ProjectName
+	}
+	if other.DockerHost != "" {
+		e.DockerHost = other.DockerHost
+	}
+	if other.DockerContext != "" {
+		e.DockerContext = other.DockerContext
+	}
+	if other.SecretsDir != "" {
+		e.SecretsDir = other.SecretsDir
+	}
+	e.TempDirNoAutoClean = e.TempDirNoAutoClean || other.TempDirNoAutoClean
+	e.TempDirPersist = e.TempDirPersist || other.TempDirPersist
+	e.updateSubstitutions(other.Substitutions)
+	for name, meta := range other.Steps {
+		if current, found := e.Steps[name]; found && current.Type != meta.Type {
+			return fmt.Errorf("duplicate step/service '%s'", name)
+		}
+		e.Steps[name] = meta
+	}
+	return nil
 }
 
 func (e *PipelineEnvironment) updateSubstitutions(substitutions types.StringMap) {
@@ -118,15 +254,56 @@ func (e *PipelineEnvironment) updateSubstitutions(substitutions types.StringMap)
 	}
 }
 
+// setSources records source as the origin of all given substitutions.
+func (e *PipelineEnvironment) setSources(substitutions types.StringMap, source string) {
+	if e.sources == nil {
+		e.sources = make(map[string]string)
+	}
+	for k := range substitutions {
+		e.sources[k] = source
+	}
+}
+
 // GetSubstitution returns a string-pointer and whether or not the key is found.
+// Keys set without value are looked up in the os environment, other variables
+// of the os environment are not used.
 func (e *PipelineEnvironment) GetSubstitution(key string) (*string, bool) {
 	value, ok := e.Substitutions[key]
+	if ok && value == nil {
+		if v, found := os.LookupEnv(key); found {
+			return &v, true
+		}
+	}
 	return value, ok
 }
 
 // SetSubstitution stores/replaces the value under the given key.
 func (e *PipelineEnvironment) SetSubstitution(key string, value *string) {
 	e.Substitutions[key] = value
+	e.setSources(types.StringMap{key: value}, SubstitutionSourceDefinition)
+}
+
+// ResolvedSubstitutions returns the effective value and source of all defined
+// substitutions, sorted by name.
+func (e *PipelineEnvironment) ResolvedSubstitutions() []ResolvedSubstitution {
+	names := make([]string, 0, len(e.Substitutions))
+	for name := range e.Substitutions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	result := make([]ResolvedSubstitution, 0, len(names))
+	for _, name := range names {
+		r := ResolvedSubstitution{
+			Name:   name,
+			Source: e.sources[name],
+		}
+		r.Value, _ = e.GetSubstitution(name)
+		if e.Substitutions[name] == nil && r.Value != nil {
+			r.Source = SubstitutionSourceEnvironment
+		}
+		result = append(result, r)
+	}
+	return result
 }
 
 func (e *PipelineEnvironment) updateStepsMeta(ignoredSteps types.StringSet, selectedSteps types.StringSet) {
@@ -153,29 +330,184 @@ func (e *PipelineEnvironment) updateStepsMeta(ignoredSteps types.StringSet, sele
 }
 
 // CleanUp tries to remove all managed temporary files and directories.
+// Persisted temporary directories are only removed if TempDirPurge is set.
 func (e *PipelineEnvironment) CleanUp(signal os.Signal) error {
 	for _, file := range e.tempFiles {
 		if err := os.Remove(file); err != nil {
 			log.Print(err)
 		}
 	}
+	if e.keepTempDirs() {
+		return nil
+	}
 	for _, path := range e.tempPaths {
 		if err := os.RemoveAll(path); err != nil {
 			log.Print(err)
 		}
 	}
+	if e.persistTempDirs() {
+		if err := os.Remove(e.tempDirStatePath()); err != nil && !os.IsNotExist(err) {
+			log.Print(err)
+		}
+	}
 	return nil
 }
 
+// keepTempDirs returns true if the temporary directories have to survive the
+// current run.
+func (e *PipelineEnvironment) keepTempDirs() bool {
+	return e.persistTempDirs() && !e.TempDirPurge
+}
+
+// persistTempDirs returns true if temporary directories are shared with other
+// runs through the state file.
+func (e *PipelineEnvironment) persistTempDirs() bool {
+	return e.TempDirPersist && !e.transient
+}
+
+// isTemporary returns true if path is inside a temporary directory which is
+// removed after the current run.
+func (e *PipelineEnvironment) isTemporary(path string) bool {
+	if e.keepTempDirs() {
+		return false
+	}
+	for _, dir := range e.tempPaths {
+		if path == dir || strings.HasPrefix(path, dir+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
 // GetOrCreateTempDir returns the location of a temporary directory identified
 // by the provided prefix. This directory is created if the prefix has no
-// directory associated.
+// directory associated. If TempDirPersist is set, a directory created by a
+// previous run is reused.
 func (e *PipelineEnvironment) GetOrCreateTempDir(prefix string) (string, error) {
 	val, ok := e.tempPaths[prefix]
 	if ok {
 		return val, nil
 	}
-	return e.tempDir(prefix)
+	if !e.persistTempDirs() {
+		return e.tempDir(prefix)
+	}
+	persisted, err := e.loadTempDirState()
+	if err != nil {
+		return "", err
+	}
+	if path, ok := persisted[prefix]; ok {
+		if fi, err := os.Stat(path); err == nil && fi.IsDir() {
+			e.tempPaths[prefix] = path
+			return path, nil
+		}
+	}
+	path, err := e.tempDir(prefix)
+	if err != nil {
+		return path, err
+	}
+	persisted[prefix] = path
+	return path, e.saveTempDirState(persisted)
+}
+
+// restoreTempDirs adds all persisted temporary directories which still exist
+// to the managed directories, so they are removed by a purging clean up.
+func (e *PipelineEnvironment) restoreTempDirs() error {
+	persisted, err := e.loadTempDirState()
+	if err != nil {
+		return err
+	}
+	for prefix, path := range persisted {
+		if _, ok := e.tempPaths[prefix]; ok {
+			continue
+		}
+		if fi, err := os.Stat(path); err == nil && fi.IsDir() {
+			e.tempPaths[prefix] = path
+		}
+	}
+	return nil
+}
+
+// resolveTempDirPath selects the root of all temporary directories, the
+// first set value wins: TempDirPath, the os environment variable TempDirEnv
+// and tempdir of the environment files. The default directory of the os is
+// used if none is set. A set root has to be an existing writable directory,
+// it is made absolute.
+func (e *PipelineEnvironment) resolveTempDirPath() error {
+	source := ""
+	if e.TempDirPath != "" {
+		source = "tempdir"
+	}
+	if path := os.Getenv(TempDirEnv); path != "" {
+		e.TempDirPath = path
+		source = TempDirEnv
+	}
+	if TempDirPath != "" {
+		e.TempDirPath = TempDirPath
+		source = "--tempdir"
+	}
+	if source == "" {
+		return nil
+	}
+	if err := checkWritableDir(e.TempDirPath); err != nil {
+		return fmt.Errorf("invalid temporary directory root from %s: %s", source, err)
+	}
+	path, err := filepath.Abs(e.TempDirPath)
+	if err != nil {
+		return fmt.Errorf("invalid temporary directory root from %s: %s", source, err)
+	}
+	e.TempDirPath = path
+	return nil
+}
+
+// checkWritableDir returns an error unless path is a directory files can be
+// created in.
+func checkWritableDir(path string) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("%s is not a directory", path)
+	}
+	f, err := ioutil.TempFile(path, ".gantry_write_check")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+func (e *PipelineEnvironment) tempDirStatePath() string {
+	dir := e.TempDirPath
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, fmt.Sprintf(TempDirState, ProjectName))
+}
+
+// loadTempDirState returns the persisted mapping from prefix to directory, an
+// empty mapping if nothing was persisted yet.
+func (e *PipelineEnvironment) loadTempDirState() (map[string]string, error) {
+	result := map[string]string{}
+	data, err := ioutil.ReadFile(e.tempDirStatePath())
+	if os.IsNotExist(err) {
+		return result, nil
+	}
+	if err != nil {
+		return result, err
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return result, fmt.Errorf("invalid temporary directory state %s: %s", e.tempDirStatePath(), err)
+	}
+	return result, nil
+}
+
+func (e *PipelineEnvironment) saveTempDirState(persisted map[string]string) error {
+	data, err := json.MarshalIndent(persisted, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(e.tempDirStatePath(), data, 0644)
 }
 
 func (e *PipelineEnvironment) tempDir(prefix string) (string, error) {
@@ -2,8 +2,10 @@ package gantry
 
 import (
 	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/ad-freiburg/gantry/types"
@@ -159,3 +161,411 @@ func TestPipelineEnvironmentUpdateStepsMeta(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestPipelineEnvironmentStepKeepAlive(t *testing.T) {
+	data := []byte(`{"services": {"s": {}}, "steps": {"a": {}, "b": {"keep_alive": "yes"}, "c": {"keep_alive": "on_failure"}, "d": {"keep_alive": "replace"}}}`)
+	cases := []struct {
+		name   string
+		result ServiceKeepAlive
+	}{
+		{"s", KeepAliveYes},
+		{"a", KeepAliveNo},
+		{"b", KeepAliveYes},
+		{"c", KeepAliveOnFailure},
+		{"d", KeepAliveNo},
+	}
+
+	e := PipelineEnvironment{}
+	if err := e.UnmarshalJSON(data); err != nil {
+		t.Errorf("Got unexpected error: %#v", err)
+	}
+	for _, c := range cases {
+		if r := e.Steps[c.name].KeepAlive; r != c.result {
+			t.Errorf("Incorrect KeepAlive for '%s', got: '%d', wanted: '%d'", c.name, r, c.result)
+		}
+	}
+}
+
+func TestNewPipelineEnvironmentFromFiles(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "gantry_tmpdir_root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+	base, override := setupDefAndEnv(`version: "1"
+project_name: base
+tempdir: /nonexistent/base
+tempdir_no_autoclean: true
+docker_host: tcp://base:2375
+docker_context: base
+substitutions:
+  a: base
+  b: base
+steps:
+  x:
+    ignore: true
+  z:
+    ignore_failure: true
+    docker_host: ssh://z
+`, `tempdir: `+tempDir+`
+docker_context: override
+substitutions:
+  b: override
+  c: override
+steps:
+  x:
+    ignore_failure: true
+`)
+	defer os.Remove(base)
+	defer os.Remove(override)
+
+	cli := "cli"
+	e, err := NewPipelineEnvironmentFromFiles([]string{base, override}, types.StringMap{"c": &cli}, types.StringSet{}, types.StringSet{})
+	if err != nil {
+		t.Errorf("Got unexpected error: %#v", err)
+	}
+	if e.Version != "1" || e.ProjectName != "base" || e.TempDirPath != tempDir || !e.TempDirNoAutoClean {
+		t.Errorf("Incorrect settings, got: '%s', '%s', '%s', '%t'", e.Version, e.ProjectName, e.TempDirPath, e.TempDirNoAutoClean)
+	}
+	if e.DockerHost != "tcp://base:2375" || e.DockerContext != "override" {
+		t.Errorf("Incorrect daemon, got: '%s', '%s'", e.DockerHost, e.DockerContext)
+	}
+	substitutions := map[string]string{"a": "base", "b": "override", "c": "cli"}
+	for k, v := range substitutions {
+		if r, ok := e.GetSubstitution(k); !ok || *r != v {
+			t.Errorf("Incorrect substitution for '%s', got: '%v', wanted: '%s'", k, r, v)
+		}
+	}
+	if m := e.Steps["x"]; m.Ignore || !m.IgnoreFailure {
+		t.Errorf("Incorrect meta for 'x', got: '%#v'", m)
+	}
+	if m := e.Steps["z"]; !m.IgnoreFailure || m.DockerHost != "ssh://z" {
+		t.Errorf("Incorrect meta for 'z', got: '%#v'", m)
+	}
+
+	conflict, _ := setupDefAndEnv(`services:
+  x:
+    ignore: true
+`, "")
+	defer os.Remove(conflict)
+	if _, err := NewPipelineEnvironmentFromFiles([]string{base, conflict}, types.StringMap{}, types.StringSet{}, types.StringSet{}); err == nil {
+		t.Errorf("Expected error for step and service 'x', got: nil")
+	}
+}
+
+func TestPipelineEnvironmentPersistTempDirs(t *testing.T) {
+	base, err := ioutil.TempDir("", "gantry-persist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(base)
+
+	newEnv := func() *PipelineEnvironment {
+		return &PipelineEnvironment{
+			TempDirPath:    base,
+			TempDirPersist: true,
+			tempPaths:      map[string]string{},
+		}
+	}
+
+	first := newEnv()
+	dir, err := first.GetOrCreateTempDir("cache")
+	if err != nil {
+		t.Fatalf("Got unexpected error: %#v", err)
+	}
+	if err := first.CleanUp(nil); err != nil {
+		t.Fatalf("Got unexpected error: %#v", err)
+	}
+	if _, err := os.Stat(dir); err != nil {
+		t.Errorf("Persisted directory was removed: %s", err)
+	}
+
+	second := newEnv()
+	reused, err := second.GetOrCreateTempDir("cache")
+	if err != nil {
+		t.Fatalf("Got unexpected error: %#v", err)
+	}
+	if reused != dir {
+		t.Errorf("Incorrect directory, got: '%s', wanted: '%s'", reused, dir)
+	}
+	other, err := second.GetOrCreateTempDir("other")
+	if err != nil {
+		t.Fatalf("Got unexpected error: %#v", err)
+	}
+	if other == dir {
+		t.Errorf("Incorrect directory for 'other', got: '%s'", other)
+	}
+
+	purge := newEnv()
+	purge.TempDirPurge = true
+	if err := purge.restoreTempDirs(); err != nil {
+		t.Fatalf("Got unexpected error: %#v", err)
+	}
+	if len(purge.tempPaths) != 2 {
+		t.Errorf("Incorrect number of restored directories, got: '%d', wanted: '2'", len(purge.tempPaths))
+	}
+	if err := purge.CleanUp(nil); err != nil {
+		t.Fatalf("Got unexpected error: %#v", err)
+	}
+	for _, path := range []string{dir, other, purge.tempDirStatePath()} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("Expected '%s' to be removed, got: %v", path, err)
+		}
+	}
+}
+
+func TestPipelineEnvironmentPersistTempDirsPerProject(t *testing.T) {
+	base, err := ioutil.TempDir("", "gantry-persist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(base)
+	defer func(name string) { ProjectName = name }(ProjectName)
+
+	dirs := map[string]string{}
+	for _, project := range []string{"a", "b"} {
+		ProjectName = project
+		e := &PipelineEnvironment{
+			TempDirPath:    base,
+			TempDirPersist: true,
+			tempPaths:      map[string]string{},
+		}
+		dir, err := e.GetOrCreateTempDir("cache")
+		if err != nil {
+			t.Fatalf("Got unexpected error: %#v", err)
+		}
+		dirs[project] = dir
+		if err := e.CleanUp(nil); err != nil {
+			t.Fatalf("Got unexpected error: %#v", err)
+		}
+	}
+	if dirs["a"] == dirs["b"] {
+		t.Errorf("Projects share the directory '%s'", dirs["a"])
+	}
+	for _, project := range []string{"a", "b"} {
+		ProjectName = project
+		e := &PipelineEnvironment{TempDirPath: base, TempDirPersist: true}
+		state, err := e.loadTempDirState()
+		if err != nil {
+			t.Fatalf("Got unexpected error: %#v", err)
+		}
+		if len(state) != 1 || state["cache"] != dirs[project] {
+			t.Errorf("Incorrect state for '%s', got: %v, wanted: '%s'", project, state, dirs[project])
+		}
+	}
+}
+
+func TestPipelineEnvironmentSubstitutionPrecedence(t *testing.T) {
+	def, env := setupDefAndEnv(`#! SET_IF_EMPTY ${GANTRY_TEST_CLI} definition
+#! SET_IF_EMPTY ${GANTRY_TEST_FILE} definition
+#! SET_IF_EMPTY ${GANTRY_TEST_OS} definition
+#! SET_IF_EMPTY ${GANTRY_TEST_DEFINITION} definition
+#! SET_IF_EMPTY ${GANTRY_TEST_CLI_NIL} definition
+#! SET_IF_EMPTY ${GANTRY_TEST_UNNAMED} definition
+version: "2.0"
+steps:
+  a:
+    image: alpine
+`, `substitutions:
+  GANTRY_TEST_CLI: file
+  GANTRY_TEST_FILE: file
+  GANTRY_TEST_OS:
+`)
+	defer os.Remove(def)
+	defer os.Remove(env)
+	for _, name := range []string{"GANTRY_TEST_CLI", "GANTRY_TEST_FILE", "GANTRY_TEST_OS", "GANTRY_TEST_CLI_NIL", "GANTRY_TEST_UNNAMED"} {
+		os.Setenv(name, "os")
+		defer os.Unsetenv(name)
+	}
+
+	cli := "cli"
+	p, err := NewPipeline(def, env, types.StringMap{"GANTRY_TEST_CLI": &cli, "GANTRY_TEST_CLI_NIL": nil}, types.StringSet{}, types.StringSet{})
+	if err != nil {
+		t.Fatalf("Got unexpected error: %#v", err)
+	}
+	cases := []struct {
+		name   string
+		value  string
+		source string
+	}{
+		{"GANTRY_TEST_CLI", "cli", SubstitutionSourceCommandLine},
+		{"GANTRY_TEST_FILE", "file", env},
+		{"GANTRY_TEST_OS", "os", SubstitutionSourceEnvironment},
+		{"GANTRY_TEST_DEFINITION", "definition", SubstitutionSourceDefinition},
+		{"GANTRY_TEST_CLI_NIL", "os", SubstitutionSourceEnvironment},
+		// Variables of the os environment must be named to be used
+		{"GANTRY_TEST_UNNAMED", "definition", SubstitutionSourceDefinition},
+	}
+	resolved := map[string]ResolvedSubstitution{}
+	for _, r := range p.Environment.ResolvedSubstitutions() {
+		resolved[r.Name] = r
+	}
+	for _, c := range cases {
+		if r, ok := p.Environment.GetSubstitution(c.name); !ok || r == nil || *r != c.value {
+			t.Errorf("Incorrect value for '%s', got: '%v', wanted: '%s'", c.name, r, c.value)
+		}
+		r, ok := resolved[c.name]
+		if !ok || r.Value == nil || *r.Value != c.value || r.Source != c.source {
+			t.Errorf("Incorrect resolved substitution for '%s', got: '%#v', wanted: '%s' from '%s'", c.name, r, c.value, c.source)
+		}
+	}
+}
+
+func TestPipelineEnvironmentSecretsDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gantry_secrets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"GANTRY_TEST_SECRET":  "s3cr3t-from-dir\n",
+		"GANTRY_TEST_DEFINED": "secret\n",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "nested"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	defined := "cli"
+	e := newPipelineEnvironment()
+	e.updateSubstitutions(types.StringMap{"GANTRY_TEST_DEFINED": &defined})
+	if err := e.loadSecrets(dir); err != nil {
+		t.Fatalf("Got unexpected error: %#v", err)
+	}
+	if v, ok := e.GetSubstitution("GANTRY_TEST_SECRET"); !ok || v == nil || *v != "s3cr3t-from-dir" {
+		t.Errorf("Incorrect secret substitution, got: '%v'", v)
+	}
+	if v, ok := e.GetSubstitution("GANTRY_TEST_DEFINED"); !ok || v == nil || *v != "cli" {
+		t.Errorf("Defined substitution was replaced by secret, got: '%v'", v)
+	}
+	if _, ok := e.Substitutions["nested"]; ok {
+		t.Error("Directory was loaded as secret")
+	}
+	if e.sources["GANTRY_TEST_SECRET"] != SubstitutionSourceSecrets {
+		t.Errorf("Incorrect source, got: '%s'", e.sources["GANTRY_TEST_SECRET"])
+	}
+	if got := Redact("value: s3cr3t-from-dir"); got != "value: "+RedactedValue {
+		t.Errorf("Secret not redacted, got: '%s'", got)
+	}
+
+	if err := e.loadSecrets(filepath.Join(dir, "missing")); err != nil {
+		t.Errorf("Got unexpected error for missing directory: %#v", err)
+	}
+}
+
+func TestPipelineEnvironmentSecretsDirRelativeToFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gantry_secrets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.Mkdir(filepath.Join(dir, "secrets"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "secrets", "GANTRY_TEST_RELATIVE"), []byte("relative"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "env.yml")
+	if err := ioutil.WriteFile(path, []byte("secrets_dir: ./secrets\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	e, err := NewPipelineEnvironment(path, types.StringMap{}, types.StringSet{}, types.StringSet{})
+	if err != nil {
+		t.Fatalf("Got unexpected error: %#v", err)
+	}
+	if e.SecretsDir != filepath.Join(dir, "secrets") {
+		t.Errorf("Incorrect secrets_dir, got: '%s', wanted: '%s'", e.SecretsDir, filepath.Join(dir, "secrets"))
+	}
+	if v, ok := e.GetSubstitution("GANTRY_TEST_RELATIVE"); !ok || v == nil || *v != "relative" {
+		t.Errorf("Incorrect secret substitution, got: '%v'", v)
+	}
+}
+
+func TestPipelineEnvironmentResolveTempDirPath(t *testing.T) {
+	dirs := []string{}
+	for i := 0; i < 3; i++ {
+		dir, err := ioutil.TempDir("", "gantry_tmpdir_root")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		dirs = append(dirs, dir)
+	}
+	file, env, flag := dirs[0], dirs[1], dirs[2]
+	defer os.Unsetenv(TempDirEnv)
+	defer func() { TempDirPath = "" }()
+
+	cases := []struct {
+		env    string
+		flag   string
+		result string
+		err    bool
+	}{
+		{"", "", file, false},
+		{env, "", env, false},
+		{env, flag, flag, false},
+		{filepath.Join(env, "missing"), "", "", true},
+	}
+	for _, c := range cases {
+		os.Setenv(TempDirEnv, c.env)
+		TempDirPath = c.flag
+		e := newPipelineEnvironment()
+		e.TempDirPath = file
+		err := e.resolveTempDirPath()
+		if (err != nil) != c.err {
+			t.Errorf("Incorrect error for env '%s' and flag '%s', got: %v, wanted error: %t", c.env, c.flag, err, c.err)
+		}
+		if err == nil && e.TempDirPath != c.result {
+			t.Errorf("Incorrect path for env '%s' and flag '%s', got: '%s', wanted: '%s'", c.env, c.flag, e.TempDirPath, c.result)
+		}
+	}
+
+	// tempdir of the environment files is checked and made absolute
+	os.Unsetenv(TempDirEnv)
+	TempDirPath = ""
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(filepath.Dir(file)); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(cwd)
+	for _, c := range []struct {
+		file   string
+		result string
+		err    bool
+	}{
+		{filepath.Base(file), file, false},
+		{filepath.Join(file, "missing"), "", true},
+	} {
+		e := newPipelineEnvironment()
+		e.TempDirPath = c.file
+		err := e.resolveTempDirPath()
+		if (err != nil) != c.err {
+			t.Errorf("Incorrect error for tempdir '%s', got: %v, wanted error: %t", c.file, err, c.err)
+		}
+		if err == nil && e.TempDirPath != c.result {
+			t.Errorf("Incorrect path for tempdir '%s', got: '%s', wanted: '%s'", c.file, e.TempDirPath, c.result)
+		}
+	}
+}
+
+func TestPipelineEnvironmentIsTemporary(t *testing.T) {
+	e := &PipelineEnvironment{tempPaths: map[string]string{"a": "/tmp/a"}}
+	for path, expected := range map[string]bool{"/tmp/a": true, "/tmp/a/out": true, "/tmp/ab": false, "/data": false} {
+		if r := e.isTemporary(path); r != expected {
+			t.Errorf("Incorrect result for '%s', got: '%t', wanted: '%t'", path, r, expected)
+		}
+	}
+	// Persisted directories survive the run
+	e.TempDirPersist = true
+	if e.isTemporary("/tmp/a") {
+		t.Errorf("Incorrect result for persisted directory, got: 'true', wanted: 'false'")
+	}
+}
@@ -0,0 +1,116 @@
+package gantry // import "github.com/ad-freiburg/gantry"
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// EventType describes a lifecycle transition of a pipeline run.
+type EventType string
+
+const (
+	// EventStageStarted is emitted when an independent (sub)pipeline starts.
+	EventStageStarted EventType = "stage_started"
+	// EventStepStarted is emitted before a step is run.
+	EventStepStarted EventType = "step_started"
+	// EventStepFinished is emitted after a step was run or skipped.
+	EventStepFinished EventType = "step_finished"
+	// EventPipelineFinished is emitted after all steps are finished.
+	EventPipelineFinished EventType = "pipeline_finished"
+)
+
+// Event is a single entry of the event stream.
+type Event struct {
+	Type     EventType  `json:"type"`
+	Time     time.Time  `json:"time"`
+	Step     string     `json:"step,omitempty"`
+	Steps    []string   `json:"steps,omitempty"`
+	Status   StepStatus `json:"status,omitempty"`
+	Duration float64    `json:"duration,omitempty"`
+	Error    string     `json:"error,omitempty"`
+}
+
+// EventEmitter writes events as newline-delimited json to a writer.
+type EventEmitter struct {
+	encoder *json.Encoder
+	m       sync.Mutex
+}
+
+// NewEventEmitter returns an EventEmitter writing to w.
+func NewEventEmitter(w io.Writer) *EventEmitter {
+	return &EventEmitter{
+		encoder: json.NewEncoder(w),
+	}
+}
+
+// Emit writes event as a single line, the time is set if missing. Calling
+// Emit on a nil EventEmitter does nothing.
+func (e *EventEmitter) Emit(event Event) error {
+	if e == nil {
+		return nil
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	e.m.Lock()
+	defer e.m.Unlock()
+	return e.encoder.Encode(event)
+}
+
+// emitStepFinished emits the EventStepFinished event for result.
+func (e *EventEmitter) emitStepFinished(result StepResult) {
+	event := Event{
+		Type:     EventStepFinished,
+		Step:     result.Name,
+		Status:   result.Status,
+		Duration: result.Duration.Seconds(),
+	}
+	if result.Err != nil {
+		event.Error = result.Err.Error()
+	}
+	if err := e.Emit(event); err != nil {
+		pipelineLogger.Printf("Error emitting event: %s", err)
+	}
+}
+
+// StepTransition describes a change of the status of a step.
+type StepTransition struct {
+	Step   string
+	Status StepStatus
+	Time   time.Time
+	Err    error
+}
+
+// StepStatusFunc is called for each StepTransition of a pipeline run. Calls
+// are serialized, the function must not block for long.
+type StepStatusFunc func(StepTransition)
+
+// statusNotifier serializes calls of a StepStatusFunc, calling notify on a nil
+// statusNotifier does nothing.
+type statusNotifier struct {
+	f StepStatusFunc
+	m sync.Mutex
+}
+
+func newStatusNotifier(f StepStatusFunc) *statusNotifier {
+	if f == nil {
+		return nil
+	}
+	return &statusNotifier{f: f}
+}
+
+func (n *statusNotifier) notify(step string, status StepStatus, err error) {
+	if n == nil {
+		return
+	}
+	n.m.Lock()
+	defer n.m.Unlock()
+	n.f(StepTransition{
+		Step:   step,
+		Status: status,
+		Time:   time.Now(),
+		Err:    err,
+	})
+}
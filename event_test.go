@@ -0,0 +1,55 @@
+package gantry_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ad-freiburg/gantry"
+)
+
+func TestEventEmitterEmit(t *testing.T) {
+	buf := bytes.NewBuffer([]byte(""))
+	e := gantry.NewEventEmitter(buf)
+	now := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := e.Emit(gantry.Event{Type: gantry.EventStepStarted, Time: now, Step: "a"}); err != nil {
+		t.Errorf("Got unexpected error: %#v", err)
+	}
+	if err := e.Emit(gantry.Event{Type: gantry.EventStepFinished, Time: now, Step: "a", Status: gantry.StepStatusFailed, Duration: 1.5, Error: errors.New("boom").Error()}); err != nil {
+		t.Errorf("Got unexpected error: %#v", err)
+	}
+	expected := `{"type":"step_started","time":"2020-01-02T03:04:05Z","step":"a"}
+{"type":"step_finished","time":"2020-01-02T03:04:05Z","step":"a","status":"failed","duration":1.5,"error":"boom"}
+`
+	if result := buf.String(); result != expected {
+		t.Errorf("Incorrect output, got: '%s', wanted: '%s'", result, expected)
+	}
+}
+
+func TestEventEmitterEmitNil(t *testing.T) {
+	var e *gantry.EventEmitter
+	if err := e.Emit(gantry.Event{Type: gantry.EventStepStarted}); err != nil {
+		t.Errorf("Got unexpected error: %#v", err)
+	}
+}
+
+func TestEventEmitterEmitSetsTime(t *testing.T) {
+	buf := bytes.NewBuffer([]byte(""))
+	e := gantry.NewEventEmitter(buf)
+	if err := e.Emit(gantry.Event{Type: gantry.EventPipelineFinished}); err != nil {
+		t.Errorf("Got unexpected error: %#v", err)
+	}
+	event := gantry.Event{}
+	scanner := bufio.NewScanner(buf)
+	for scanner.Scan() {
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Errorf("Got unexpected error: %#v", err)
+		}
+	}
+	if event.Time.IsZero() {
+		t.Errorf("Expected time to be set, got zero value")
+	}
+}
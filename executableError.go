@@ -0,0 +1,40 @@
+package gantry
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+)
+
+const dockerInstallURL string = "https://docs.docker.com/get-docker/"
+const wharferInstallURL string = "https://github.com/ad-freiburg/wharfer"
+
+// ExecutableNotFoundError is returned if the container executable is not
+// installed, it explains how to install one.
+type ExecutableNotFoundError struct {
+	executable string
+	err        error
+}
+
+// Error returns the string representation of the error.
+func (e ExecutableNotFoundError) Error() string {
+	hint := fmt.Sprintf("install docker (%s) or wharfer (%s)", dockerInstallURL, wharferInstallURL)
+	if e.executable == wharfer {
+		hint = fmt.Sprintf("install wharfer (%s) or use docker", wharferInstallURL)
+	}
+	return fmt.Sprintf("container executable '%s' not found in $PATH, %s", e.executable, hint)
+}
+
+// Unwrap returns the original error.
+func (e ExecutableNotFoundError) Unwrap() error {
+	return e.err
+}
+
+// wrapExecutableError returns an ExecutableNotFoundError if err was caused by
+// a missing executable, err otherwise.
+func wrapExecutableError(executable string, err error) error {
+	if errors.Is(err, exec.ErrNotFound) {
+		return ExecutableNotFoundError{executable: executable, err: err}
+	}
+	return err
+}
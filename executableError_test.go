@@ -0,0 +1,40 @@
+package gantry
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestWrapExecutableError(t *testing.T) {
+	other := fmt.Errorf("some error")
+	if err := wrapExecutableError(docker, other); err != other {
+		t.Errorf("incorrect error, got: %v wanted: %v", err, other)
+	}
+	if err := wrapExecutableError(docker, nil); err != nil {
+		t.Errorf("incorrect error, got: %v wanted: nil", err)
+	}
+	_, lookErr := exec.LookPath("gantry-does-not-exist")
+	cases := []struct {
+		executable string
+		hint       string
+	}{
+		{docker, dockerInstallURL},
+		{wharfer, "or use docker"},
+	}
+	for _, c := range cases {
+		err := wrapExecutableError(c.executable, lookErr)
+		if _, ok := err.(ExecutableNotFoundError); !ok {
+			t.Errorf("incorrect error type for %s, got: %T wanted: ExecutableNotFoundError", c.executable, err)
+			continue
+		}
+		if !strings.Contains(err.Error(), c.hint) {
+			t.Errorf("incorrect error message for %s, got: %s wanted to contain: %s", c.executable, err.Error(), c.hint)
+		}
+		if !errors.Is(err, exec.ErrNotFound) {
+			t.Errorf("incorrect unwrapped error for %s, got: %v wanted: %v", c.executable, errors.Unwrap(err), exec.ErrNotFound)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package gantry // import "github.com/ad-freiburg/gantry"
+
+import (
+	"encoding/json"
+	"io"
+)
+
+type graphStep struct {
+	Name         string   `json:"name"`
+	Type         string   `json:"type"`
+	Image        string   `json:"image"`
+	Pipeline     int      `json:"pipeline"`
+	Dependencies []string `json:"dependencies"`
+	After        []string `json:"after"`
+	Selected     bool     `json:"selected"`
+	Ignored      bool     `json:"ignored"`
+}
+
+type graph struct {
+	Version string      `json:"version"`
+	Steps   []graphStep `json:"steps"`
+}
+
+// WriteJSON writes the resolved graph of p as json to w. Each step lists its
+// image, type (service or step), the index of its pipeline and all
+// dependencies, including those added by stages. Dependencies which only
+// order the steps are additionally listed as after.
+func (p Pipelines) WriteJSON(w io.Writer) error {
+	g := graph{
+		Version: Version,
+		Steps:   []graphStep{},
+	}
+	for i, pipeline := range p {
+		for _, step := range pipeline {
+			stepType := "step"
+			image := step.ImageName()
+			switch step.Meta.Type {
+			case ServiceTypeService:
+				stepType = "service"
+			case ServiceTypeExternal:
+				stepType = "external"
+				image = ""
+			}
+			g.Steps = append(g.Steps, graphStep{
+				Name:         step.Name,
+				Type:         stepType,
+				Image:        image,
+				Pipeline:     i,
+				Dependencies: sortedKeys(step.Dependencies()),
+				After:        sortedKeys(step.OrderingDependencies()),
+				Selected:     step.Meta.Selected,
+				Ignored:      step.Meta.Ignore,
+			})
+		}
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(g)
+}
@@ -0,0 +1,78 @@
+package gantry // import "github.com/ad-freiburg/gantry"
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// The grammar follows the reference grammar of the docker distribution
+// project, see https://github.com/distribution/reference.
+var (
+	referenceDomainRegexp    = regexp.MustCompile(`^(?:[a-zA-Z0-9]|[a-zA-Z0-9][a-zA-Z0-9-]*[a-zA-Z0-9])(?:\.(?:[a-zA-Z0-9]|[a-zA-Z0-9][a-zA-Z0-9-]*[a-zA-Z0-9]))*(?::[0-9]+)?$`)
+	referenceComponentRegexp = regexp.MustCompile(`^[a-z0-9]+(?:(?:[._]|__|[-]+)[a-z0-9]+)*$`)
+	referenceTagRegexp       = regexp.MustCompile(`^[\w][\w.-]{0,127}$`)
+	referenceDigestRegexp    = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9]*(?:[-_+.][A-Za-z][A-Za-z0-9]*)*:[0-9a-fA-F]{32,}$`)
+)
+
+// referenceNameMaxLength is the maximal length of the repository name.
+const referenceNameMaxLength int = 255
+
+// ValidateImageReference checks if ref is a valid image reference of the form
+// [domain/]name[:tag][@digest] and returns an error describing the first
+// problem found.
+func ValidateImageReference(ref string) error {
+	if ref == "" {
+		return fmt.Errorf("empty image reference")
+	}
+	name := ref
+	if i := strings.Index(name, "@"); i >= 0 {
+		digest := name[i+1:]
+		name = name[:i]
+		if digest == "" {
+			return fmt.Errorf("empty digest in image reference '%s'", ref)
+		}
+		if !referenceDigestRegexp.MatchString(digest) {
+			return fmt.Errorf("invalid digest '%s' in image reference '%s'", digest, ref)
+		}
+	}
+	// A colon after the last slash separates the tag, others belong to the
+	// port of the domain.
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		tag := name[i+1:]
+		name = name[:i]
+		if tag == "" {
+			return fmt.Errorf("empty tag in image reference '%s'", ref)
+		}
+		if !referenceTagRegexp.MatchString(tag) {
+			return fmt.Errorf("invalid tag '%s' in image reference '%s'", tag, ref)
+		}
+	}
+	if name == "" {
+		return fmt.Errorf("empty repository name in image reference '%s'", ref)
+	}
+	if len(name) > referenceNameMaxLength {
+		return fmt.Errorf("repository name of image reference '%s' is longer than %d characters", ref, referenceNameMaxLength)
+	}
+	components := strings.Split(name, "/")
+	// The first component is a domain if it contains a dot or a port or is
+	// localhost, like docker decides.
+	if len(components) > 1 && (strings.ContainsAny(components[0], ".:") || components[0] == "localhost") {
+		if !referenceDomainRegexp.MatchString(components[0]) {
+			return fmt.Errorf("invalid domain '%s' in image reference '%s'", components[0], ref)
+		}
+		components = components[1:]
+	}
+	for _, component := range components {
+		if component == "" {
+			return fmt.Errorf("empty path component in image reference '%s'", ref)
+		}
+		if strings.ToLower(component) != component {
+			return fmt.Errorf("repository name of image reference '%s' must be lowercase", ref)
+		}
+		if !referenceComponentRegexp.MatchString(component) {
+			return fmt.Errorf("invalid path component '%s' in image reference '%s'", component, ref)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package gantry_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ad-freiburg/gantry"
+)
+
+func TestValidateImageReference(t *testing.T) {
+	cases := []struct {
+		ref string
+		err string
+	}{
+		{"alpine", ""},
+		{"alpine:3.12", ""},
+		{"library/alpine:latest", ""},
+		{"docker.io/library/alpine", ""},
+		{"localhost:5000/project/image:1.0-rc_1", ""},
+		{"localhost/image", ""},
+		{"registry.example.com:443/a__b/c-d.e", ""},
+		{"alpine@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef", ""},
+		{"alpine:3@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef", ""},
+		{"", "empty image reference"},
+		{"image:", "empty tag"},
+		{"registry:5000/image:", "empty tag"},
+		{"image:-bad", "invalid tag"},
+		{"image:a/b", "invalid domain"},
+		{"a//b", "empty path component"},
+		{"/image", "empty path component"},
+		{"image/", "empty path component"},
+		{"Image", "must be lowercase"},
+		{"user/Image:latest", "must be lowercase"},
+		{"image-", "invalid path component"},
+		{"my image", "invalid path component"},
+		{":latest", "empty repository name"},
+		{"image@", "empty digest"},
+		{"image@sha256:abc", "invalid digest"},
+		{"-bad.example.com/image", "invalid domain"},
+		{strings.Repeat("a", 256), "longer than 255"},
+	}
+	for _, c := range cases {
+		err := gantry.ValidateImageReference(c.ref)
+		if c.err == "" {
+			if err != nil {
+				t.Errorf("Incorrect error for '%s', got: '%s', wanted: nil", c.ref, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("Incorrect error for '%s', got: '%v', wanted: '%s'", c.ref, err, c.err)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package gantry // import "github.com/ad-freiburg/gantry"
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/ad-freiburg/gantry/types"
+)
+
+// InheritEnv selects the substitutions passed to the container of a step as
+// environment variables. Nothing is inherited by default.
+type InheritEnv struct {
+	// All inherits all substitutions defined in the environment files or on
+	// the command line, the os environment is not passed as a whole.
+	All  bool
+	Keys []string
+}
+
+// UnmarshalJSON sets *i from a boolean to inherit all or no substitutions or
+// from the names of the substitutions to inherit.
+func (i *InheritEnv) UnmarshalJSON(data []byte) error {
+	var all bool
+	if err := json.Unmarshal(data, &all); err == nil {
+		*i = InheritEnv{All: all}
+		return nil
+	}
+	var keys types.StringOrStringSlice
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return fmt.Errorf("inherit_env has to be a boolean or a list of names: %s", string(data))
+	}
+	*i = InheritEnv{Keys: keys}
+	return nil
+}
+
+// environment returns the inherited substitutions of env. Substitutions
+// without value are looked up in the os environment, unknown names are an
+// error.
+func (i InheritEnv) environment(env *PipelineEnvironment) (types.StringMap, error) {
+	result := types.StringMap{}
+	keys := i.Keys
+	if i.All {
+		keys = env.Substitutions.Keys()
+	}
+	for _, key := range keys {
+		if _, ok := env.Substitutions[key]; !ok {
+			return nil, fmt.Errorf("unknown substitution '%s'", key)
+		}
+		if value, _ := env.GetSubstitution(key); value != nil {
+			v := *value
+			result[key] = &v
+		}
+	}
+	return result, nil
+}
@@ -5,8 +5,13 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"os"
+	"path/filepath"
+	"regexp"
 	"strings"
 	"sync"
+	"text/template"
+	"time"
 )
 
 // PrefixedWriterFormat provides formatting for to space separated strings
@@ -84,7 +89,15 @@ const AnsiForegroundColorLightCyan int = 96
 const AnsiForegroundColorWhite int = 97
 
 var (
-	friendlyColors *ColorStore
+	friendlyColors   *ColorStore
+	ansiEscapeRegexp = regexp.MustCompile("\u001b\\[[0-9;]*m")
+	// prefixTemplate replaces PrefixedWriterFormat if set.
+	prefixTemplate *template.Template
+	// outputMutex serializes the output of all prefixed writers and loggers
+	// so lines of concurrently running steps are never torn apart.
+	outputMutex sync.Mutex
+	// combinedLog receives a copy of all prefixed lines if set.
+	combinedLog io.Writer
 )
 
 func init() {
@@ -101,6 +114,122 @@ func BuildAnsiStyle(parts ...int) string {
 	return strings.Trim(strings.ReplaceAll(fmt.Sprint(parts), " ", ";"), "[]")
 }
 
+// ColorEnabled returns whether ANSI formatting should be written to w. This is
+// only the case if w is a terminal and NO_COLOR is not set, see
+// https://no-color.org.
+func ColorEnabled(w io.Writer) bool {
+	if os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// StripAnsi removes all ANSI formatting from text.
+func StripAnsi(text string) string {
+	return ansiEscapeRegexp.ReplaceAllString(text, "")
+}
+
+// plainWriter removes all ANSI formatting from complete lines before writing
+// them to w.
+type plainWriter struct {
+	w io.Writer
+}
+
+func (p plainWriter) Write(b []byte) (int, error) {
+	if _, err := io.WriteString(p.w, StripAnsi(string(b))); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// PrefixedLine provides the data available in prefix format templates.
+type PrefixedLine struct {
+	Prefix string
+	Stream string
+	Time   time.Time
+	Line   string
+}
+
+// SetPrefixFormat sets the template used by all prefixed writers and loggers
+// to format lines. The template receives a PrefixedLine, the function plain
+// removes ANSI formatting, e.g. "[{{plain .Prefix}}] {{.Line}}". An empty
+// format restores PrefixedWriterFormat.
+func SetPrefixFormat(format string) error {
+	if format == "" {
+		prefixTemplate = nil
+		return nil
+	}
+	t, err := template.New("prefix").Funcs(template.FuncMap{"plain": StripAnsi}).Parse(format)
+	if err != nil {
+		return err
+	}
+	prefixTemplate = t
+	return nil
+}
+
+// formatPrefixed formats line with prefix using the configured format. A
+// trailing newline of line is preserved.
+func formatPrefixed(prefix string, stream string, line string) string {
+	if prefixTemplate == nil {
+		return fmt.Sprintf(PrefixedWriterFormat, prefix, line)
+	}
+	newline := ""
+	if strings.HasSuffix(line, "\n") {
+		line = strings.TrimSuffix(line, "\n")
+		newline = "\n"
+	}
+	var buf bytes.Buffer
+	data := PrefixedLine{Prefix: prefix, Stream: stream, Time: time.Now(), Line: line}
+	if err := prefixTemplate.Execute(&buf, data); err != nil {
+		return fmt.Sprintf(PrefixedWriterFormat, prefix, line+newline)
+	}
+	return buf.String() + newline
+}
+
+// SetCombinedLog sets w to receive a copy of all lines of all prefixed writers
+// and loggers, regardless of their stream and target. Lines are timestamped
+// and stripped of ANSI formatting. A nil w disables the combined log.
+func SetCombinedLog(w io.Writer) {
+	outputMutex.Lock()
+	defer outputMutex.Unlock()
+	combinedLog = w
+}
+
+// CombinedLogPath returns the default path of the combined log of a run of
+// the current project started at t. Logs are stored in RunLogDir inside dir,
+// the temporary directory of the system is used if dir is empty.
+func CombinedLogPath(dir string, t time.Time) string {
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, RunLogDir, fmt.Sprintf("%s_%s.log", ProjectName, t.Format("20060102-150405")))
+}
+
+// prefixed formats line like formatPrefixed and copies the result to the
+// combined log. Callers have to hold outputMutex.
+func prefixed(prefix string, stream string, line string) string {
+	formatted := formatPrefixed(prefix, stream, line)
+	if combinedLog != nil {
+		text := StripAnsi(formatted)
+		if !strings.HasSuffix(text, "\n") {
+			text += "\n"
+		}
+		if stream != "" {
+			text = stream + " " + text
+		}
+		fmt.Fprintf(combinedLog, "%s %s", time.Now().Format(time.RFC3339), text)
+	}
+	return formatted
+}
+
 // GetNextFriendlyColor returns the next friendly color from the global
 // friendlyColors store.
 func GetNextFriendlyColor() int {
@@ -133,11 +262,14 @@ func (c *ColorStore) NextColor() int {
 	return c.colors[c.index]
 }
 
-// PrefixedWriter is a writer which prefixes all lines with given prefix.
+// PrefixedWriter is a writer which prefixes all lines with given prefix. It is
+// safe for concurrent use.
 type PrefixedWriter struct {
 	prefix string
+	stream string
 	target io.Writer
 	buf    *bytes.Buffer
+	m      sync.Mutex
 }
 
 // NewPrefixedWriter returns a PrefixWriter for given prefix and target.
@@ -149,36 +281,67 @@ func NewPrefixedWriter(prefix string, target io.Writer) *PrefixedWriter {
 	}
 }
 
-// Write writes bytes to an internal buffer and outputs the data to the internal target.
+// SetStream sets the name of the stream, e.g. stdout, available as Stream in
+// prefix format templates.
+func (p *PrefixedWriter) SetStream(stream string) {
+	p.m.Lock()
+	defer p.m.Unlock()
+	p.stream = stream
+}
+
+// Write writes bytes to an internal buffer and outputs the data to the
+// internal target. The end of an incomplete line is held back if it could be
+// the beginning of a registered secret.
 func (p *PrefixedWriter) Write(b []byte) (int, error) {
+	p.m.Lock()
+	defer p.m.Unlock()
 	n, err := p.buf.Write(b)
 	if err != nil {
 		return n, err
 	}
-	err = p.Output()
+	err = p.output(false)
 	return n, err
 }
 
 // Output generates lines from the internal buffer and prefixes them.
 func (p *PrefixedWriter) Output() error {
+	p.m.Lock()
+	defer p.m.Unlock()
+	return p.output(true)
+}
+
+func (p *PrefixedWriter) output(flush bool) error {
+	outputMutex.Lock()
+	defer outputMutex.Unlock()
 	for {
 		line, err := p.buf.ReadString('\n')
 		if err == io.EOF {
-			fmt.Fprintf(p.target, PrefixedWriterFormat, p.prefix, line)
+			tail := ""
+			if flush {
+				line = Redact(line)
+			} else {
+				line, tail = splitRedacted(line)
+			}
+			if len(line) > 0 {
+				fmt.Fprint(p.target, prefixed(p.prefix, p.stream, line))
+			}
+			p.buf.WriteString(tail)
 			break
 		}
 		if err != nil {
 			return err
 		}
-		fmt.Fprintf(p.target, PrefixedWriterFormat, p.prefix, line)
+		fmt.Fprint(p.target, prefixed(p.prefix, p.stream, Redact(line)))
 	}
 	return nil
 }
 
-// PrefixedLogger is a logger with a prefix.
+// PrefixedLogger is a logger with a prefix. It is safe for concurrent use.
 type PrefixedLogger struct {
-	prefix string
-	logger *log.Logger
+	prefix  string
+	stream  string
+	logger  *log.Logger
+	pending string
 }
 
 // NewPrefixedLogger creates a PrefixedLogger from a prefix and a logger.
@@ -189,30 +352,127 @@ func NewPrefixedLogger(prefix string, logger *log.Logger) *PrefixedLogger {
 	}
 }
 
+// SetStream sets the name of the stream, e.g. stdout, available as Stream in
+// prefix format templates.
+func (p *PrefixedLogger) SetStream(stream string) {
+	outputMutex.Lock()
+	defer outputMutex.Unlock()
+	p.stream = stream
+}
+
 // Printf format prints to the logger.
 func (p *PrefixedLogger) Printf(format string, v ...interface{}) {
-	if err := p.logger.Output(2, fmt.Sprintf(PrefixedWriterFormat, p.prefix, fmt.Sprintf(format, v...))); err != nil {
+	outputMutex.Lock()
+	defer outputMutex.Unlock()
+	if err := p.logger.Output(2, prefixed(p.prefix, p.stream, Redact(fmt.Sprintf(format, v...)))); err != nil {
 		log.Printf("Error in PrefixedLogger.Printf: %s", err)
 	}
 }
 
 // Println prints a line to the logger.
 func (p *PrefixedLogger) Println(v ...interface{}) {
-	if err := p.logger.Output(2, fmt.Sprintf(PrefixedWriterFormat, p.prefix, fmt.Sprintln(v...))); err != nil {
+	outputMutex.Lock()
+	defer outputMutex.Unlock()
+	if err := p.logger.Output(2, prefixed(p.prefix, p.stream, Redact(fmt.Sprintln(v...)))); err != nil {
 		log.Printf("Error in PrefixedLogger.Println: %s", err)
 	}
 }
 
-// Write writes given bytes to the logger.
+// Write writes given bytes to the logger. If the data does not end with a
+// newline, the end which could be the beginning of a registered secret is held
+// back until the next call of Write or Flush.
 func (p *PrefixedLogger) Write(b []byte) (int, error) {
 	n := len(b)
-	if n > 0 && b[n-1] == '\n' {
-		b = b[:n-1]
+	outputMutex.Lock()
+	defer outputMutex.Unlock()
+	data := p.pending + string(b)
+	p.pending = ""
+	if strings.HasSuffix(data, "\n") {
+		data = Redact(data)
+	} else {
+		data, p.pending = splitRedacted(data)
+		if data == "" && p.pending != "" {
+			return n, nil
+		}
 	}
-	for _, s := range strings.Split(string(b), "\n") {
-		if err := p.logger.Output(2, fmt.Sprintf(PrefixedWriterFormat, p.prefix, s)); err != nil {
+	data = strings.TrimSuffix(data, "\n")
+	for _, s := range strings.Split(data, "\n") {
+		if err := p.logger.Output(2, prefixed(p.prefix, p.stream, s)); err != nil {
 			return n, err
 		}
 	}
 	return n, nil
 }
+
+// Flush writes data held back by Write to the logger.
+func (p *PrefixedLogger) Flush() error {
+	outputMutex.Lock()
+	defer outputMutex.Unlock()
+	if p.pending == "" {
+		return nil
+	}
+	data := Redact(p.pending)
+	p.pending = ""
+	return p.logger.Output(2, prefixed(p.prefix, p.stream, data))
+}
+
+// GroupedOutput collects the output written to several targets and writes it
+// as one contiguous block on Flush. It is safe for concurrent use.
+type GroupedOutput struct {
+	chunks []groupedChunk
+	m      sync.Mutex
+}
+
+type groupedChunk struct {
+	target io.Writer
+	data   []byte
+}
+
+type groupedWriter struct {
+	output *GroupedOutput
+	target io.Writer
+}
+
+// NewGroupedOutput returns an empty GroupedOutput.
+func NewGroupedOutput() *GroupedOutput {
+	return &GroupedOutput{
+		chunks: []groupedChunk{},
+	}
+}
+
+// Writer returns a writer storing all data for target until Flush is called.
+func (g *GroupedOutput) Writer(target io.Writer) io.Writer {
+	return &groupedWriter{
+		output: g,
+		target: target,
+	}
+}
+
+func (w *groupedWriter) Write(b []byte) (int, error) {
+	w.output.m.Lock()
+	defer w.output.m.Unlock()
+	data := make([]byte, len(b))
+	copy(data, b)
+	w.output.chunks = append(w.output.chunks, groupedChunk{target: w.target, data: data})
+	return len(b), nil
+}
+
+// Flush writes all collected data to the targets in the order it was written.
+// No other prefixed output is interleaved with the block.
+func (g *GroupedOutput) Flush() error {
+	g.m.Lock()
+	defer g.m.Unlock()
+	outputMutex.Lock()
+	defer outputMutex.Unlock()
+	chunks := g.chunks
+	g.chunks = []groupedChunk{}
+	for _, chunk := range chunks {
+		if chunk.target == nil {
+			continue
+		}
+		if _, err := chunk.target.Write(chunk.data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -5,7 +5,12 @@ import (
 	"bytes"
 	"fmt"
 	"log"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/ad-freiburg/gantry"
 )
@@ -111,3 +116,191 @@ func TestPrefixedLoggerWrite(t *testing.T) {
 		t.Errorf("Incorrect buffer contents, got: '%#v', wanted: '%#v'", result, expected)
 	}
 }
+
+func TestStripAnsi(t *testing.T) {
+	cases := []struct {
+		text   string
+		result string
+	}{
+		{"plain", "plain"},
+		{gantry.ApplyAnsiStyle("bold", gantry.AnsiStyleBold), "bold"},
+		{gantry.ApplyAnsiStyle("step", gantry.AnsiForegroundColorCyan, gantry.AnsiStyleBold) + " text", "step text"},
+	}
+
+	for _, c := range cases {
+		if r := gantry.StripAnsi(c.text); r != c.result {
+			t.Errorf("Incorrect result for '%#v', got: '%#v', wanted: '%#v'", c.text, r, c.result)
+		}
+	}
+}
+
+func TestSetPrefixFormat(t *testing.T) {
+	defer gantry.SetPrefixFormat("")
+	if err := gantry.SetPrefixFormat("{{.Missing"); err == nil {
+		t.Errorf("Expected error for invalid template, got: 'nil'")
+	}
+	if err := gantry.SetPrefixFormat("[{{plain .Prefix}}] {{.Stream}}: {{.Line}}"); err != nil {
+		t.Errorf("Got unexpected errror: %#v", err)
+	}
+	prefix := gantry.ApplyAnsiStyle("step", gantry.AnsiStyleBold)
+
+	buf := bytes.NewBuffer([]byte(""))
+	pw := gantry.NewPrefixedWriter(prefix, buf)
+	pw.SetStream("stdout")
+	if _, err := pw.Write([]byte("A\nB")); err != nil {
+		t.Error(err)
+	}
+	expected := "[step] stdout: A\n[step] stdout: B"
+	if result := buf.String(); result != expected {
+		t.Errorf("Incorrect buffer contents, got: '%#v', wanted: '%#v'", result, expected)
+	}
+
+	buf.Reset()
+	logger := gantry.NewPrefixedLogger(prefix, log.New(buf, "", 0))
+	logger.SetStream("stderr")
+	logger.Printf("%s:%d", "Answer", 42)
+	if _, err := logger.Write([]byte("C\n")); err != nil {
+		t.Error(err)
+	}
+	expected = "[step] stderr: Answer:42\n[step] stderr: C\n"
+	if result := buf.String(); result != expected {
+		t.Errorf("Incorrect buffer contents, got: '%#v', wanted: '%#v'", result, expected)
+	}
+
+	if err := gantry.SetPrefixFormat(""); err != nil {
+		t.Errorf("Got unexpected errror: %#v", err)
+	}
+	buf.Reset()
+	logger.Printf("default")
+	expected = fmt.Sprintf(gantry.PrefixedWriterFormat, prefix, "default") + "\n"
+	if result := buf.String(); result != expected {
+		t.Errorf("Incorrect buffer contents, got: '%#v', wanted: '%#v'", result, expected)
+	}
+}
+
+func TestPrefixedWritersConcurrentWrite(t *testing.T) {
+	buf := bytes.NewBuffer([]byte(""))
+	numWriters := 8
+	numLines := 100
+	var wg sync.WaitGroup
+	for i := 0; i < numWriters; i++ {
+		wg.Add(2)
+		prefix := fmt.Sprintf("w%d", i)
+		go func(w *gantry.PrefixedWriter) {
+			defer wg.Done()
+			for j := 0; j < numLines; j++ {
+				if _, err := w.Write([]byte(fmt.Sprintf("line %d\n", j))); err != nil {
+					t.Error(err)
+				}
+			}
+		}(gantry.NewPrefixedWriter(prefix, buf))
+		go func(l *gantry.PrefixedLogger) {
+			defer wg.Done()
+			for j := 0; j < numLines; j++ {
+				if _, err := l.Write([]byte(fmt.Sprintf("line %d\n", j))); err != nil {
+					t.Error(err)
+				}
+			}
+		}(gantry.NewPrefixedLogger(prefix, log.New(buf, "", 0)))
+	}
+	wg.Wait()
+
+	// Each record is either a line of the writer (reset after newline) or of
+	// the logger (reset before newline).
+	record := regexp.MustCompile("\\x{1b}\\[0m line [0-9]+(\n\\x{1b}\\[0m|\\x{1b}\\[0m\n)")
+	records := record.Split(buf.String(), -1)
+	if len(records) != 2*numWriters*numLines+1 {
+		t.Errorf("Incorrect number of lines, got: '%d', wanted: '%d'", len(records)-1, 2*numWriters*numLines)
+	}
+	prefix := regexp.MustCompile("^w[0-9]+$")
+	for _, r := range records[:len(records)-1] {
+		if !prefix.MatchString(r) {
+			t.Errorf("Torn line: '%#v'", r)
+		}
+	}
+}
+
+func TestGroupedOutputFlush(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	g := gantry.NewGroupedOutput()
+	out := g.Writer(&stdout)
+	errOut := g.Writer(&stderr)
+	nowhere := g.Writer(nil)
+	fmt.Fprint(out, "a\n")
+	fmt.Fprint(errOut, "b\n")
+	fmt.Fprint(nowhere, "c\n")
+	fmt.Fprint(out, "d\n")
+	if stdout.Len() != 0 || stderr.Len() != 0 {
+		t.Errorf("Incorrect output before flush, got: '%s' and '%s', wanted: empty", stdout.String(), stderr.String())
+	}
+	if err := g.Flush(); err != nil {
+		t.Errorf("unexpected error, got: '%#v', wanted 'nil'", err)
+	}
+	if stdout.String() != "a\nd\n" {
+		t.Errorf("Incorrect stdout, got: '%s', wanted: '%s'", stdout.String(), "a\nd\n")
+	}
+	if stderr.String() != "b\n" {
+		t.Errorf("Incorrect stderr, got: '%s', wanted: '%s'", stderr.String(), "b\n")
+	}
+	// Flushed data is not written again
+	if err := g.Flush(); err != nil {
+		t.Errorf("unexpected error, got: '%#v', wanted 'nil'", err)
+	}
+	if stdout.String() != "a\nd\n" {
+		t.Errorf("Incorrect stdout after second flush, got: '%s', wanted: '%s'", stdout.String(), "a\nd\n")
+	}
+}
+
+func TestSetCombinedLog(t *testing.T) {
+	var combined, stdout, stderr bytes.Buffer
+	gantry.SetCombinedLog(&combined)
+	defer gantry.SetCombinedLog(nil)
+
+	w := gantry.NewPrefixedWriter(gantry.ApplyAnsiStyle("a", gantry.AnsiStyleBold), &stdout)
+	w.SetStream("stdout")
+	fmt.Fprint(w, "out\n")
+	l := gantry.NewPrefixedLogger("b", log.New(&stderr, "", 0))
+	l.SetStream("stderr")
+	l.Printf("err")
+	gantry.SetCombinedLog(nil)
+	l.Printf("not copied")
+
+	if stdout.Len() == 0 || stderr.Len() == 0 {
+		t.Errorf("Output is not written to the targets, got: '%s' and '%s'", stdout.String(), stderr.String())
+	}
+	lines := strings.Split(strings.TrimSuffix(combined.String(), "\n"), "\n")
+	wanted := []string{"stdout a out", "stderr b err"}
+	if len(lines) != len(wanted) {
+		t.Fatalf("Incorrect combined log, got: '%s', wanted %d lines", combined.String(), len(wanted))
+	}
+	for i, line := range lines {
+		parts := strings.SplitN(line, " ", 2)
+		if _, err := time.Parse(time.RFC3339, parts[0]); err != nil {
+			t.Errorf("Incorrect timestamp in '%s': %s", line, err)
+		}
+		if len(parts) < 2 || parts[1] != wanted[i] {
+			t.Errorf("Incorrect combined line, got: '%s', wanted: '%s'", line, wanted[i])
+		}
+	}
+}
+
+func TestCombinedLogPath(t *testing.T) {
+	defer func(name string) { gantry.ProjectName = name }(gantry.ProjectName)
+	gantry.ProjectName = "project"
+	start := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	path := gantry.CombinedLogPath("/tmp/gantry", start)
+	if wanted := "/tmp/gantry/.gantry_runs/project_20200102-030405.log"; path != wanted {
+		t.Errorf("Incorrect path, got: '%s', wanted: '%s'", path, wanted)
+	}
+}
+
+func TestColorEnabled(t *testing.T) {
+	if gantry.ColorEnabled(bytes.NewBuffer([]byte(""))) {
+		t.Errorf("Color enabled for a buffer")
+	}
+	os.Setenv("NO_COLOR", "1")
+	defer os.Unsetenv("NO_COLOR")
+	if gantry.ColorEnabled(os.Stdout) {
+		t.Errorf("Color enabled although NO_COLOR is set")
+	}
+}
@@ -0,0 +1,55 @@
+package gantry // import "github.com/ad-freiburg/gantry"
+
+import (
+	"context"
+	"io"
+	"sync"
+)
+
+// logFollowers keeps track of running log followers of detached services.
+type logFollowers struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func newLogFollowers() *logFollowers {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &logFollowers{
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+// logTargetSetter is implemented by runners which can redirect followed logs.
+type logTargetSetter interface {
+	SetLogTarget(io.Writer)
+}
+
+// Follow starts following the logs of step in the background. If target is
+// not nil, the prefixed and timestamped lines of all followed steps are merged
+// into target instead of the configured outputs of the steps. Lines are never
+// torn apart as all prefixed output is serialized. The followers work on a
+// copy of runner, which stays untouched.
+func (f *logFollowers) Follow(runner Runner, step Step, target io.Writer) {
+	runner = runner.Copy()
+	if target != nil {
+		if r, ok := runner.(logTargetSetter); ok {
+			r.SetLogTarget(target)
+		}
+	}
+	follow := runner.ContainerLogFollower(f.ctx, step)
+	f.wg.Add(1)
+	go func() {
+		defer f.wg.Done()
+		if err := follow(); err != nil {
+			pipelineLogger.Printf("Error following logs of %s: %s", step.ColoredName(), err)
+		}
+	}()
+}
+
+// Stop stops all followers and waits for them to finish.
+func (f *logFollowers) Stop() {
+	f.cancel()
+	f.wg.Wait()
+}
@@ -0,0 +1,90 @@
+package gantry // import "github.com/ad-freiburg/gantry"
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// targetRunner writes the name of each followed step to its log target.
+type targetRunner struct {
+	*NoopRunner
+	mutex  *sync.Mutex
+	target io.Writer
+}
+
+func (r *targetRunner) Copy() Runner {
+	return &targetRunner{NoopRunner: r.NoopRunner, mutex: r.mutex}
+}
+
+func (r *targetRunner) SetLogTarget(w io.Writer) {
+	r.target = w
+}
+
+func (r *targetRunner) ContainerLogFollower(ctx context.Context, step Step) func() error {
+	return func() error {
+		if r.target == nil {
+			return fmt.Errorf("no log target for %s", step.Name)
+		}
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+		_, err := fmt.Fprintln(r.target, step.Name)
+		return err
+	}
+}
+
+func TestLogFollowersTarget(t *testing.T) {
+	runner := &targetRunner{NoopRunner: NewNoopRunner(false), mutex: &sync.Mutex{}}
+	var b bytes.Buffer
+	f := newLogFollowers()
+	f.Follow(runner, Step{Service: Service{Name: "a"}}, &b)
+	f.Follow(runner, Step{Service: Service{Name: "b"}}, &b)
+	f.Stop()
+	lines := strings.Fields(b.String())
+	sort.Strings(lines)
+	if strings.Join(lines, ",") != "a,b" {
+		t.Errorf("Incorrect merged logs, got: %q, wanted: %q", b.String(), "a\nb\n")
+	}
+	if runner.target != nil {
+		t.Errorf("Incorrect log target of original runner, got: %v, wanted: nil", runner.target)
+	}
+}
+
+func TestLogFollowersCopyRunner(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gantry_follow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	executable := filepath.Join(dir, "fake")
+	script := "#!/bin/sh\ncase \"$1\" in\nps) echo id;;\nlogs) echo line;;\nesac\n"
+	if err := ioutil.WriteFile(executable, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	step := Step{
+		Service: Service{
+			Name:  "a",
+			Image: "alpine",
+			Meta:  ServiceMeta{Type: ServiceTypeService},
+		},
+		Executable: executable,
+	}
+	var stdout, logs bytes.Buffer
+	for _, target := range []io.Writer{nil, &logs} {
+		runner := NewLocalRunner("prefix", &stdout, &stdout)
+		f := newLogFollowers()
+		f.Follow(runner, step, target)
+		f.Stop()
+		if runner.executable != "" || runner.prefix != "prefix" || runner.stdout != &stdout || runner.stderr != &stdout || runner.logTarget != nil {
+			t.Errorf("Runner changed by following logs with target %v, got: %#v", target, runner)
+		}
+	}
+}
@@ -15,6 +15,9 @@ const (
 	KeepAliveNo
 	// KeepAliveReplace signals that the service is killed prior to replacement.
 	KeepAliveReplace
+	// KeepAliveOnFailure signals that the container of a step is only kept if
+	// the step fails.
+	KeepAliveOnFailure
 )
 const (
 	// LogHandlerStdout signals that the standard location (stdout or stderr) is used.
@@ -31,6 +34,9 @@ const (
 	ServiceTypeService ServiceType = iota
 	// ServiceTypeStep signals a gantry step.
 	ServiceTypeStep
+	// ServiceTypeExternal signals a service managed outside of gantry, only
+	// its readiness is awaited.
+	ServiceTypeExternal
 )
 
 // ServiceMetaList stores ServiceMeta as a map[stepname]Meta.
@@ -46,6 +52,14 @@ type ServiceMeta struct {
 	Ignore           bool `json:"ignore"`
 	IgnoreFailure    bool `json:"ignore_failure"`
 	Selected         bool
+	// Disabled is set for steps skipped by enabled_if, they are ignored as
+	// well.
+	Disabled bool `json:"-"`
+	// DockerHost and DockerContext select the daemon used for the step
+	// instead of the globally selected one, the network of the pipeline is
+	// created on each daemon used. Contexts are not supported by wharfer.
+	DockerHost    string `json:"docker_host"`
+	DockerContext string `json:"docker_context"`
 }
 
 // Open handles output initialisation by setting defaults.
@@ -75,7 +89,15 @@ type ServiceKeepAlive int
 func (d *ServiceKeepAlive) UnmarshalJSON(b []byte) error {
 	var s string
 	if err := json.Unmarshal(b, &s); err != nil {
-		return err
+		// Unquoted yes and no are booleans in yaml
+		var keep bool
+		if err := json.Unmarshal(b, &keep); err != nil {
+			return err
+		}
+		s = "no"
+		if keep {
+			s = "yes"
+		}
 	}
 	switch strings.ToLower(s) {
 	default:
@@ -84,6 +106,8 @@ func (d *ServiceKeepAlive) UnmarshalJSON(b []byte) error {
 		*d = KeepAliveNo
 	case "replace":
 		*d = KeepAliveReplace
+	case "on_failure", "on-failure":
+		*d = KeepAliveOnFailure
 	}
 	return nil
 }
@@ -19,6 +19,10 @@ func TestMetaServiceKeepAlive(t *testing.T) {
 		{`"yes"`, gantry.KeepAliveYes},
 		{`"no"`, gantry.KeepAliveNo},
 		{`"replace"`, gantry.KeepAliveReplace},
+		{`"on_failure"`, gantry.KeepAliveOnFailure},
+		{`"on-failure"`, gantry.KeepAliveOnFailure},
+		{`true`, gantry.KeepAliveYes},
+		{`false`, gantry.KeepAliveNo},
 		{`"iUseTheDefault"`, gantry.KeepAliveYes},
 	}
 
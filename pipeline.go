@@ -3,10 +3,12 @@ package gantry // import "github.com/ad-freiburg/gantry"
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -42,15 +44,43 @@ type Pipeline struct {
 	Network     Network
 	localRunner Runner
 	noopRunner  Runner
+	followers   *logFollowers
+	sampler     *statsSampler
+	detached    *detachedServices
+	// Result stores the outcome of the last call to ExecuteSteps.
+	Result *PipelineResult
+	// Events receives lifecycle events of ExecuteSteps if set.
+	Events *EventEmitter
+	// OnStepStatus is called on each status change of a step during
+	// ExecuteSteps if set.
+	OnStepStatus StepStatusFunc
+	// ServiceLogs receives the merged logs of all followed services instead
+	// of their configured outputs if set.
+	ServiceLogs io.Writer
 }
 
 // NewPipeline creates a new Pipeline from given files which ignores the
 // existence of steps with names provided in ignoreSteps.
 func NewPipeline(definitionPath, environmentPath string, environment types.StringMap, ignoredSteps types.StringSet, selectedSteps types.StringSet) (*Pipeline, error) {
+	return NewPipelineFromFiles(definitionPath, []string{environmentPath}, environment, ignoredSteps, selectedSteps)
+}
+
+// NewPipelineFromFiles creates a new Pipeline like NewPipeline merging all
+// environment files given by environmentPaths in order.
+func NewPipelineFromFiles(definitionPath string, environmentPaths []string, environment types.StringMap, ignoredSteps types.StringSet, selectedSteps types.StringSet) (*Pipeline, error) {
+	return newPipelineFromFiles(definitionPath, environmentPaths, environment, ignoredSteps, selectedSteps, false)
+}
+
+// newPipelineFromFiles is NewPipelineFromFiles, if transient is set persisted
+// temporary directories are neither used nor created while loading.
+func newPipelineFromFiles(definitionPath string, environmentPaths []string, environment types.StringMap, ignoredSteps types.StringSet, selectedSteps types.StringSet, transient bool) (*Pipeline, error) {
 	p := &Pipeline{}
 	var err error
 	// Load environment
-	p.Environment, err = NewPipelineEnvironment(environmentPath, environment, ignoredSteps, selectedSteps)
+	p.Environment, err = NewPipelineEnvironmentFromFiles(environmentPaths, environment, ignoredSteps, selectedSteps)
+	if p.Environment != nil {
+		p.Environment.transient = transient
+	}
 	if err != nil {
 		// As environment files are optional, handle if non is accessible
 		if e, ok := err.(*os.PathError); ok && e.Err == syscall.ENOENT {
@@ -63,12 +93,20 @@ func NewPipeline(definitionPath, environmentPath string, environment types.Strin
 	p.Definition, err = NewPipelineDefinition(definitionPath, p.Environment)
 	p.localRunner = NewLocalRunner("pipeline", os.Stdout, os.Stderr)
 	p.noopRunner = NewNoopRunner(false)
+	p.followers = newLogFollowers()
+	if StatsInterval > 0 {
+		p.sampler = newStatsSampler(StatsInterval)
+	}
 	return p, err
 }
 
 // CleanUp removes containers and temporary data.
 func (p *Pipeline) CleanUp(signal os.Signal) error {
 	var keepNetworkAlive bool
+	// Stop sampling before the services are stopped
+	if p.sampler != nil {
+		p.sampler.Stop()
+	}
 	// Stop all services which are not marked as keep-running
 	pipelines, err := p.Definition.Pipelines()
 	if err != nil {
@@ -83,23 +121,42 @@ func (p *Pipeline) CleanUp(signal os.Signal) error {
 				}
 				keepNetworkAlive = true
 			}
-			// Remove all steps and services marked as not to keep alive
+			// Stop all steps, remove all steps and services marked as not to
+			// keep alive
 			if step.Meta.Type == ServiceTypeStep || step.Meta.KeepAlive == KeepAliveNo {
 				runner := p.GetRunnerForMeta(step.Meta)
 				if _, err := runner.ContainerKiller(step)(); err != nil {
 					pipelineLogger.Printf("Error killing %s: %s", step.ColoredName(), err)
 				}
-				if err := runner.ContainerRemover(step)(); err != nil {
-					pipelineLogger.Printf("Error removing %s: %s", step.ColoredName(), err)
+				if step.Meta.KeepAlive == KeepAliveNo {
+					if err := runner.ContainerRemover(step)(); err != nil {
+						pipelineLogger.Printf("Error removing %s: %s", step.ColoredName(), err)
+					}
 				}
 			}
-			step.Meta.Close()
 		}
 	}
+	// Images can only be removed once their containers are gone
+	if err := p.RemoveImages(); err != nil {
+		pipelineLogger.Printf("Error removing images: %s", err)
+	}
+	// Stop following logs, all services which are kept alive continue
+	// running without their output being printed.
+	if p.followers != nil {
+		p.followers.Stop()
+	}
+	for _, step := range pipelines.AllSteps() {
+		step.Meta.Close()
+	}
 	// If we are allowed, start a cleanup container to delete all files in the
 	// temporary directories as deletion from outside will fail when
 	// user-namespaces are used.
-	if !p.Environment.TempDirNoAutoClean {
+	if p.Environment.persistTempDirs() && p.Environment.TempDirPurge {
+		if err := p.Environment.restoreTempDirs(); err != nil {
+			pipelineLogger.Printf("Error reading persisted temporary directories: %s", err)
+		}
+	}
+	if !p.Environment.TempDirNoAutoClean && !p.Environment.keepTempDirs() {
 		if err := p.RemoveTempDirData(); err != nil {
 			pipelineLogger.Printf("Error removing temporary directories: %s", err)
 		}
@@ -124,17 +181,57 @@ func (p Pipeline) Check() error {
 			return err
 		}
 	}
-	return nil
+	if err := checkContainerNames(pipelines.AllSteps()); err != nil {
+		return err
+	}
+	if err := checkDependencyConditions(pipelines.AllSteps()); err != nil {
+		return err
+	}
+	return checkPortBindings(pipelines.AllSteps())
 }
 
 // GetRunnerForMeta selects a suitable runner given a ServiceMeta instance.
+// External services are never touched.
 func (p Pipeline) GetRunnerForMeta(meta ServiceMeta) Runner {
-	if meta.Ignore {
+	if meta.Ignore || meta.Type == ServiceTypeExternal {
 		return p.noopRunner.Copy()
 	}
 	return p.localRunner.Copy()
 }
 
+// PrintStats stops sampling and writes the resource usage of all sampled
+// services to w. Nothing is written if sampling is disabled.
+func (p *Pipeline) PrintStats(w io.Writer) error {
+	if p.sampler == nil {
+		return nil
+	}
+	p.sampler.Stop()
+	return p.sampler.PrintSummary(w)
+}
+
+// SetRunnerEnvironment sets additional environment variables for all
+// container commands run on this machine, e.g. DOCKER_BUILDKIT=1.
+func (p *Pipeline) SetRunnerEnvironment(env map[string]string) {
+	if r, ok := p.localRunner.(*LocalRunner); ok {
+		r.SetEnvironment(env)
+	}
+}
+
+// SetRunnerWorkingDirectory sets the directory in which all container
+// commands run on this machine are executed, the working directory of gantry
+// if dir is empty.
+func (p *Pipeline) SetRunnerWorkingDirectory(dir string) {
+	if r, ok := p.localRunner.(*LocalRunner); ok {
+		r.SetWorkingDirectory(dir)
+	}
+}
+
+// SetTempDirNoAutoClean overrides tempdir_no_autoclean of the environment
+// files, the command line supersedes them.
+func (p *Pipeline) SetTempDirNoAutoClean(noAutoClean bool) {
+	p.Environment.TempDirNoAutoClean = noAutoClean
+}
+
 // GetAllRunners returns a list of all runners
 func (p Pipeline) GetAllRunners() []Runner {
 	res := []Runner{}
@@ -154,6 +251,20 @@ func (p Pipelines) AllSteps() []Step {
 	return result
 }
 
+// StepNames returns the names of the steps of each pipeline in execution
+// order.
+func (p Pipelines) StepNames() [][]string {
+	result := make([][]string, 0, len(p))
+	for _, pipeline := range p {
+		names := make([]string, 0, len(pipeline))
+		for _, step := range pipeline {
+			names = append(names, step.Name)
+		}
+		result = append(result, names)
+	}
+	return result
+}
+
 // Check performs checks for cyclic dependencies and requirements fulfillment.
 func (p *Pipelines) Check() error {
 	result := make(Pipelines, 0)
@@ -164,11 +275,7 @@ func (p *Pipelines) Check() error {
 	for i := len(*p) - 1; i >= 0; i-- {
 		steps := (*p)[i]
 		if len(steps) > 1 {
-			names := make([]string, len(steps))
-			for i, step := range steps {
-				names[i] = step.Name
-			}
-			return fmt.Errorf("cyclic component found in (sub)pipeline: '%s'", strings.Join(names, ", "))
+			return newCyclicComponentError(steps)
 		}
 		var step = steps[0]
 		for r := range step.Dependencies() {
@@ -188,28 +295,39 @@ func (p *Pipelines) Check() error {
 }
 
 type pipelineDefinitionJSON struct {
-	Version  string
-	Steps    StepList
-	Services ServiceList
+	Version   string
+	Steps     StepList
+	Services  ServiceList
+	External  StepList
+	Stages    []Stage
+	Templates map[string]StepTemplate
 }
 
 // PipelineDefinition stores docker-compose services and gantry steps.
 type PipelineDefinition struct {
 	Version   string
 	Steps     StepList
+	Stages    []Stage
 	pipelines *Pipelines
+	// unreachable stores steps which are neither selected nor required by a
+	// selected step.
+	unreachable []string
+	// templates maps the names of templates to the names of their steps.
+	templates map[string][]string
 }
 
 // UnmarshalJSON loads a PipelineDefinition from json using the pipelineJSON struct.
 func (p *PipelineDefinition) UnmarshalJSON(data []byte) error {
 	result := PipelineDefinition{
-		Steps: StepList{},
+		Steps:     StepList{},
+		templates: map[string][]string{},
 	}
 	parsedJSON := pipelineDefinitionJSON{}
 	if err := json.Unmarshal(data, &parsedJSON); err != nil {
 		return err
 	}
 	result.Version = parsedJSON.Version
+	result.Stages = parsedJSON.Stages
 	for name, service := range parsedJSON.Services {
 		service.Meta = ServiceMeta{
 			Type: ServiceTypeService,
@@ -226,15 +344,66 @@ func (p *PipelineDefinition) UnmarshalJSON(data []byte) error {
 		}
 		result.Steps[name] = step
 	}
+	for name, step := range parsedJSON.External {
+		if _, found := result.Steps[name]; found {
+			return fmt.Errorf("duplicate step/service '%s'", name)
+		}
+		step.Meta = ServiceMeta{
+			Type: ServiceTypeExternal,
+		}
+		result.Steps[name] = step
+	}
+	for name, template := range parsedJSON.Templates {
+		if _, found := result.Steps[name]; found {
+			return fmt.Errorf("template '%s' has the name of a step/service", name)
+		}
+		steps, err := template.instantiate(name)
+		if err != nil {
+			return err
+		}
+		result.templates[name] = template.InstanceNames(name)
+		for name, step := range steps {
+			if _, found := result.Steps[name]; found {
+				return fmt.Errorf("duplicate step/service '%s'", name)
+			}
+			step.Meta = ServiceMeta{
+				Type:      ServiceTypeStep,
+				KeepAlive: KeepAliveNo,
+			}
+			result.Steps[name] = step
+		}
+	}
+	expandTemplateReferences(result.Steps, result.Stages, parsedJSON.Templates)
 	*p = result
 	return nil
 }
 
-// NewPipelineDefinition generates a pipeline definition from a path and an environment.
-func NewPipelineDefinition(path string, env *PipelineEnvironment) (*PipelineDefinition, error) {
+// expandTemplateMetas returns metas with the meta of each template applied
+// to all of its steps, e.g. for --ignore or --select. Metas of single steps
+// take precedence.
+func (p *PipelineDefinition) expandTemplateMetas(metas ServiceMetaList) ServiceMetaList {
+	result := ServiceMetaList{}
+	for name, meta := range metas {
+		if _, ok := p.templates[name]; !ok {
+			result[name] = meta
+		}
+	}
+	for name, meta := range metas {
+		for _, instance := range p.templates[name] {
+			if _, ok := result[instance]; !ok {
+				result[instance] = meta
+			}
+		}
+	}
+	return result
+}
+
+// definitionPath returns path, or the default definition in the working
+// directory if path is empty.
+func definitionPath(path string) (string, error) {
 	dir, err := os.Getwd()
 	if err != nil {
-		return nil, err
+		return "", err
 	}
 	defaultPath := filepath.Join(dir, GantryDef)
 	if _, err := os.Stat(defaultPath); path == "" && err == nil {
@@ -244,6 +413,13 @@ func NewPipelineDefinition(path string, env *PipelineEnvironment) (*PipelineDefi
 	if _, err := os.Stat(defaultPath); path == "" && err == nil {
 		path = defaultPath
 	}
+	return path, nil
+}
+
+// renderDefinition reads the definition at path and applies the preprocessor
+// with env. In a dry run no temporary directories are created and no paths
+// are checked.
+func renderDefinition(path string, env *PipelineEnvironment, dryRun bool) ([]byte, error) {
 	file, err := os.Open(path)
 	if err != nil {
 		pipelineLogger.Println("Could not open pipeline definition.")
@@ -260,7 +436,36 @@ func NewPipelineDefinition(path string, env *PipelineEnvironment) (*PipelineDefi
 	if err != nil {
 		return nil, err
 	}
-	data, err = preproc.Process(data, env)
+	preproc.DryRun = dryRun
+	preproc.Dir = filepath.Dir(path)
+	preproc.File = path
+	return preproc.Process(data, env)
+}
+
+// RenderPipelineDefinition writes the definition at path, or the default
+// definition if path is empty, to w as it is parsed after all substitutions
+// and statements of env are applied. Nothing is run, statements creating
+// temporary directories or checking paths are only simulated.
+func RenderPipelineDefinition(w io.Writer, path string, env *PipelineEnvironment) error {
+	path, err := definitionPath(path)
+	if err != nil {
+		return err
+	}
+	data, err := renderDefinition(path, env, true)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", data)
+	return err
+}
+
+// NewPipelineDefinition generates a pipeline definition from a path and an environment.
+func NewPipelineDefinition(path string, env *PipelineEnvironment) (*PipelineDefinition, error) {
+	path, err := definitionPath(path)
+	if err != nil {
+		return nil, err
+	}
+	data, err := renderDefinition(path, env, false)
 	if err != nil {
 		return nil, err
 	}
@@ -272,14 +477,15 @@ func NewPipelineDefinition(path string, env *PipelineEnvironment) (*PipelineDefi
 		return d, err
 	}
 	// Update with specific meta if defined
-	for name, meta := range env.Steps {
+	for name, meta := range d.expandTemplateMetas(env.Steps) {
 		s, ok := d.Steps[name]
 		if ok {
+			// Only metas of the steps section can keep the container of a step
+			if s.Meta.Type == ServiceTypeStep && meta.Type != ServiceTypeStep {
+				meta.KeepAlive = KeepAliveNo
+			}
 			meta.Type = s.Meta.Type
 			s.Meta = meta
-			if meta.Type == ServiceTypeStep {
-				s.Meta.KeepAlive = KeepAliveNo
-			}
 			d.Steps[name] = s
 		} else {
 			if meta.Selected {
@@ -290,6 +496,50 @@ func NewPipelineDefinition(path string, env *PipelineEnvironment) (*PipelineDefi
 			}
 		}
 	}
+	// Steps disabled by enabled_if are not run, like ignored steps
+	for name, step := range d.Steps {
+		if !step.EnabledIf.IsTrue() {
+			step.Meta.Ignore = true
+			step.Meta.Disabled = true
+			d.Steps[name] = step
+		}
+	}
+	// Pass inherited substitutions to the containers
+	for name, step := range d.Steps {
+		inherited, err := step.InheritEnv.environment(env)
+		if err != nil {
+			return d, fmt.Errorf("inherit_env of '%s': %s", name, err)
+		}
+		for key, value := range inherited {
+			if _, ok := step.Environment[key]; ok {
+				continue
+			}
+			if step.Environment == nil {
+				step.Environment = types.StringMap{}
+			}
+			step.Environment[key] = value
+		}
+		d.Steps[name] = step
+	}
+	// Resolve build contexts, secrets and bind-mount sources relative to the
+	// definition, register sensitive values
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return d, err
+	}
+	for name, step := range d.Steps {
+		step.BuildInfo.resolveContext(filepath.Dir(abs))
+		step.BuildInfo.resolveSecrets(filepath.Dir(abs))
+		step.dir = filepath.Dir(abs)
+		step.registerSecrets()
+		if err := step.mountDockerSocket(); err != nil {
+			return d, err
+		}
+		d.Steps[name] = step
+	}
+	if err := d.mountArtifacts(env); err != nil {
+		return d, err
+	}
 	// Open output files for container logs
 	for n, step := range d.Steps {
 		if err = step.Meta.Open(); err != nil {
@@ -333,6 +583,31 @@ func (p *PipelineDefinition) checkVersion() error {
 	return nil
 }
 
+// Plan calculates and verifies the ordered pipelines of steps without running
+// anything. The steps of each stage depend on all steps of previous stages.
+// Steps are copied, the map passed in is not modified.
+func Plan(steps map[string]Step, stages []Stage) (*Pipelines, error) {
+	// Build list of active steps
+	active := make(map[string]Step, len(steps))
+	for name, step := range steps {
+		active[name] = step
+	}
+	// Add barriers between explicit stages
+	if err := applyStages(stages, active); err != nil {
+		return nil, err
+	}
+	// Calculate order and indepenence
+	pipelines, err := NewTarjan(active)
+	if err != nil {
+		return nil, err
+	}
+	// Verify pipelines
+	if err := pipelines.Check(); err != nil {
+		return nil, err
+	}
+	return pipelines, nil
+}
+
 // Pipelines calculates and verifies dependencies and ordering for steps
 // defined in the PipelineDefinition p.
 func (p *PipelineDefinition) Pipelines() (*Pipelines, error) {
@@ -346,6 +621,9 @@ func (p *PipelineDefinition) Pipelines() (*Pipelines, error) {
 			}
 			if step.Meta.Selected {
 				selectedSteps[name] = true
+				if step.Meta.Disabled {
+					return nil, fmt.Errorf("selected step '%s' is disabled by enabled_if", step.Name)
+				}
 				if step.Meta.Ignore {
 					return nil, fmt.Errorf("instructed to ignore selected step '%s'", step.Name)
 				}
@@ -353,9 +631,12 @@ func (p *PipelineDefinition) Pipelines() (*Pipelines, error) {
 		}
 
 		// If steps or services are marked es selected, expand the selection
+		// unless dependencies are not run.
 		queue := make([]string, 0)
-		for name := range selectedSteps {
-			queue = append(queue, name)
+		if !NoDeps {
+			for name := range selectedSteps {
+				queue = append(queue, name)
+			}
 		}
 		for len(queue) > 0 {
 			name := queue[0]
@@ -376,29 +657,25 @@ func (p *PipelineDefinition) Pipelines() (*Pipelines, error) {
 			}
 		}
 		if len(selectedSteps) > 0 {
-			// Ignore all not selected steps
+			// Ignore all not selected steps, without dependencies this is
+			// intended and not reported.
+			if !NoDeps {
+				p.unreachable = []string{}
+			}
 			for name, step := range p.Steps {
 				if step.Meta.Selected {
 					continue
 				}
+				if !ignoredSteps[name] && !NoDeps {
+					p.unreachable = append(p.unreachable, name)
+				}
 				step.Meta.Ignore = true
 				p.Steps[name] = step
 				ignoredSteps[name] = true
 			}
 		}
 
-		// Build list of active steps
-		steps := make(map[string]Step)
-		for name, step := range p.Steps {
-			steps[name] = step
-		}
-		// Calculate order and indepenence
-		pipelines, err := NewTarjan(steps)
-		if err != nil {
-			return nil, err
-		}
-		// Verify pipelines
-		err = pipelines.Check()
+		pipelines, err := Plan(p.Steps, p.Stages)
 		if err != nil {
 			return nil, err
 		}
@@ -407,17 +684,35 @@ func (p *PipelineDefinition) Pipelines() (*Pipelines, error) {
 	return p.pipelines, nil
 }
 
+// UnreachableSteps returns all steps which will not run as they are neither
+// selected nor a dependency of a selected step. Steps which are ignored
+// explicitly are not included. Returns nil if no step is selected or if
+// dependencies are not run.
+func (p *PipelineDefinition) UnreachableSteps() ([]string, error) {
+	if _, err := p.Pipelines(); err != nil {
+		return nil, err
+	}
+	sort.Strings(p.unreachable)
+	return p.unreachable, nil
+}
+
 type runConfig struct {
 	usePreconditions bool
 	selection        func(step Step) bool
+	skip             func(step Step) bool
 	pre              func(runner Runner, step Step) error
 	run              func(runner Runner, step Step) func() error
 	post             func(runner Runner, step Step) error
+	events           *EventEmitter
+	status           *statusNotifier
+	scheduler        *scheduler
+	failures         *failureTracker
 }
 
-func runCommandParallel(config runConfig, runner Runner, step Step, durations *sync.Map, wg *sync.WaitGroup, preconditions []chan struct{}, done chan struct{}, abort chan error) {
+func runCommandParallel(config runConfig, runner Runner, step Step, result *PipelineResult, wg *sync.WaitGroup, preconditions []chan struct{}, done chan struct{}, abort chan error, request *schedulerRequest) {
 	defer wg.Done()
 	defer close(done)
+	config.status.notify(step.Name, StepStatusPending, nil)
 	for i, c := range preconditions {
 		if Verbose {
 			pipelineLogger.Printf("%s waiting for %d precondition(s)", step.ColoredContainerName(), len(preconditions)-i)
@@ -427,9 +722,41 @@ func runCommandParallel(config runConfig, runner Runner, step Step, durations *s
 			pipelineLogger.Printf("Precondition for %s satisfied %d remaining", step.ColoredContainerName(), len(preconditions)-i-1)
 		}
 	}
-	// If an error was encountered previusly, skip the rest
-	if len(abort) > 0 {
-		pipelineLogger.Printf("- Skipping %s: an error occurred previously", step.ColoredContainerName())
+	// Wait for a free slot
+	if request == nil {
+		request = config.scheduler.request(step)
+	}
+	request.wait()
+	defer config.scheduler.release(step)
+	skip := func(format string, v ...interface{}) {
+		pipelineLogger.Printf("- Skipping %s: %s", step.ColoredContainerName(), fmt.Sprintf(format, v...))
+		skipped := StepResult{
+			Name:   step.Name,
+			Image:  step.ImageName(),
+			Status: StepStatusSkipped,
+		}
+		result.Add(skipped)
+		config.events.emitStepFinished(skipped)
+		config.status.notify(step.Name, StepStatusSkipped, nil)
+	}
+	// If enough errors were encountered previusly, skip the rest
+	if config.failures.bail(step.Name) {
+		skip("bailing after %d failed step(s)", config.failures.Count())
+		return
+	}
+	// Steps whose dependencies failed can not run
+	if dep := config.failures.failedDependency(step); config.usePreconditions && dep != "" {
+		config.failures.block(step.Name)
+		skip("dependency %s failed", ApplyAnsiStyle(dep, AnsiStyleBold))
+		return
+	}
+	// Disabled steps count as done for their dependents
+	if config.usePreconditions && step.Meta.Disabled {
+		skip("disabled by enabled_if")
+		return
+	}
+	if config.skip != nil && config.skip(step) {
+		skip("succeeded previously")
 		return
 	}
 
@@ -441,8 +768,20 @@ func runCommandParallel(config runConfig, runner Runner, step Step, durations *s
 	}
 
 	// Execute run for step
+	if err := config.events.Emit(Event{Type: EventStepStarted, Step: step.Name}); err != nil {
+		pipelineLogger.Printf("Error emitting event: %s", err)
+	}
+	config.status.notify(step.Name, StepStatusRunning, nil)
 	duration, err := executeF(config.run(runner, step))
+	stepResult := StepResult{
+		Name:     step.Name,
+		Image:    step.ImageName(),
+		Status:   StepStatusSucceeded,
+		Duration: duration,
+		Err:      err,
+	}
 	if err != nil {
+		stepResult.Status = StepStatusFailed
 		pipelineLogger.Printf("  %s: %s", step.ColoredContainerName(), err)
 		if !step.Meta.IgnoreFailure {
 			// If no previous error is stored, store the current error in the
@@ -453,11 +792,14 @@ func runCommandParallel(config runConfig, runner Runner, step Step, durations *s
 					exitCodeOverride: step.Meta.ExitCodeOverride,
 				}
 			}
+			config.failures.fail(step.Name)
 		} else {
 			pipelineLogger.Printf("  Ignoring error of: %s", step.ColoredContainerName())
 		}
 	}
-	durations.Store(step.Name, duration)
+	result.Add(stepResult)
+	config.events.emitStepFinished(stepResult)
+	config.status.notify(step.Name, stepResult.Status, stepResult.Err)
 
 	// Execute post for step if provided
 	if config.post != nil {
@@ -467,17 +809,38 @@ func runCommandParallel(config runConfig, runner Runner, step Step, durations *s
 	}
 }
 
-func (p Pipeline) runCommand(config runConfig) (int, time.Duration, time.Duration, error) {
+func (p Pipeline) runCommand(config runConfig) (*PipelineResult, error) {
+	result := NewPipelineResult()
 	pipelines, err := p.Definition.Pipelines()
 	if err != nil {
-		return 0, 0, 0, err
+		return result, err
 	}
 	var wg sync.WaitGroup
-	count := 0
-	durations := &sync.Map{}
 	abort := make(chan error, 1)
 	runChannel := make(chan struct{})
 	channels := make(map[string]chan struct{})
+	config.scheduler = newScheduler(MaxParallel)
+	config.failures = newFailureTracker(BailAfter)
+	if config.usePreconditions {
+		config.scheduler.limitDependents(pipelines.AllSteps())
+	}
+	// Steps without dependencies are queued upfront, so the scheduler can
+	// order all of them by priority.
+	initial := make([]Step, 0)
+	for _, pipeline := range *pipelines {
+		for _, step := range pipeline {
+			if config.selection != nil && !config.selection(step) {
+				continue
+			}
+			if !config.usePreconditions || len(step.Dependencies()) == 0 {
+				initial = append(initial, step)
+			}
+		}
+	}
+	requests := make(map[string]*schedulerRequest)
+	for _, step := range initial {
+		requests[step.Name] = config.scheduler.request(step)
+	}
 	for _, pipeline := range *pipelines {
 		for _, step := range pipeline {
 			// If selection is set and not applicable, skip this step
@@ -500,30 +863,45 @@ func (p Pipeline) runCommand(config runConfig) (int, time.Duration, time.Duratio
 				}
 			}
 			wg.Add(1)
-			go runCommandParallel(config, p.GetRunnerForMeta(step.Meta), step, durations, &wg, preChannels, channels[step.Name], abort)
-			count++
+			go runCommandParallel(config, p.GetRunnerForMeta(step.Meta), step, result, &wg, preChannels, channels[step.Name], abort, requests[step.Name])
 		}
 	}
 
-	start := time.Now()
+	result.Start = time.Now()
+	for _, pipeline := range *pipelines {
+		names := make([]string, 0, len(pipeline))
+		for _, step := range pipeline {
+			if _, ok := channels[step.Name]; ok {
+				names = append(names, step.Name)
+			}
+		}
+		if len(names) == 0 {
+			continue
+		}
+		if err := config.events.Emit(Event{Type: EventStageStarted, Steps: names}); err != nil {
+			pipelineLogger.Printf("Error emitting event: %s", err)
+		}
+	}
 	close(runChannel)
 	wg.Wait()
 	// Store timing information
-	elapsedTime := time.Since(start)
-	var totalElapsedTime time.Duration
-	durations.Range(func(key, value interface{}) bool {
-		duration, ok := value.(time.Duration)
-		if ok {
-			totalElapsedTime += duration
-		}
-		return ok
-	})
+	result.Elapsed = time.Since(result.Start)
+	if skipped := config.failures.Skipped(); len(skipped) > 0 {
+		pipelineLogger.Printf("Bailed after %d failed step(s), skipped: %s", config.failures.Count(), strings.Join(skipped, ", "))
+	}
 	// If an error was stored in the abort channel, return it.
 	err = nil
 	if len(abort) > 0 {
 		err = <-abort
 	}
-	return count, elapsedTime, totalElapsedTime, err
+	finished := Event{Type: EventPipelineFinished, Duration: result.Elapsed.Seconds()}
+	if err != nil {
+		finished.Error = err.Error()
+	}
+	if err := config.events.Emit(finished); err != nil {
+		pipelineLogger.Printf("Error emitting event: %s", err)
+	}
+	return result, err
 }
 
 // BuildImages builds all buildable images of Pipeline p in parallel.
@@ -531,7 +909,7 @@ func (p Pipeline) BuildImages(force bool) error {
 	if Verbose {
 		pipelineLogger.Printf("Build Images:")
 	}
-	count, elapsedTime, totalElapsedTime, err := p.runCommand(runConfig{
+	result, err := p.runCommand(runConfig{
 		selection: func(step Step) bool {
 			return step.IsBuildable()
 		},
@@ -540,8 +918,8 @@ func (p Pipeline) BuildImages(force bool) error {
 		},
 	})
 	if Verbose {
-		pipelineLogger.Printf("Build %d images in %s", count, elapsedTime)
-		pipelineLogger.Printf("Total time spent building images: %s", totalElapsedTime)
+		pipelineLogger.Printf("Build %d images in %s", result.Count(), result.Elapsed)
+		pipelineLogger.Printf("Total time spent building images: %s", result.TotalDuration())
 	}
 	return err
 }
@@ -551,7 +929,7 @@ func (p Pipeline) PullImages(force bool) error {
 	if Verbose {
 		pipelineLogger.Printf("Pull Images:")
 	}
-	count, elapsedTime, totalElapsedTime, err := p.runCommand(runConfig{
+	result, err := p.runCommand(runConfig{
 		selection: func(step Step) bool {
 			return step.IsPullable()
 		},
@@ -565,15 +943,15 @@ func (p Pipeline) PullImages(force bool) error {
 		},
 	})
 	if Verbose {
-		pipelineLogger.Printf("Pulled %d images in %s", count, elapsedTime)
-		pipelineLogger.Printf("Total time spent pulling images: %s", totalElapsedTime)
+		pipelineLogger.Printf("Pulled %d images in %s", result.Count(), result.Elapsed)
+		pipelineLogger.Printf("Total time spent pulling images: %s", result.TotalDuration())
 	}
 	return err
 }
 
 // KillContainers kills all running containers of Pipeline p.
 func (p Pipeline) KillContainers(preRun bool) error {
-	_, _, _, err := p.runCommand(runConfig{
+	_, err := p.runCommand(runConfig{
 		selection: func(step Step) bool {
 			return !preRun || step.Meta.KeepAlive != KeepAliveReplace
 		},
@@ -594,7 +972,7 @@ func (p Pipeline) KillContainers(preRun bool) error {
 
 // RemoveContainers removes all stopped containers of Pipeline p.
 func (p Pipeline) RemoveContainers(preRun bool) error {
-	_, _, _, err := p.runCommand(runConfig{
+	_, err := p.runCommand(runConfig{
 		selection: func(step Step) bool {
 			return !preRun || step.Meta.KeepAlive != KeepAliveReplace
 		},
@@ -610,14 +988,81 @@ func (p Pipeline) RemoveContainers(preRun bool) error {
 	return err
 }
 
-// CreateNetwork creates a network using the NetworkName of the Pipeline p.
+// CreateNetwork creates a network using the NetworkName of the Pipeline p on
+// each daemon used.
 func (p Pipeline) CreateNetwork() error {
-	return p.localRunner.NetworkCreator(p.Network)()
+	for _, runner := range p.networkRunners() {
+		if err := runner.NetworkCreator(p.Network)(); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-// RemoveNetwork removes the network of Pipeline p.
+// RemoveNetwork removes the network of Pipeline p from each daemon used.
 func (p Pipeline) RemoveNetwork() error {
-	return p.localRunner.NetworkRemover(p.Network)()
+	var result error
+	for _, runner := range p.networkRunners() {
+		if err := runner.NetworkRemover(p.Network)(); err != nil && result == nil {
+			result = err
+		}
+	}
+	return result
+}
+
+// daemonUser is implemented by runners which can select the daemon used.
+type daemonUser interface {
+	useDaemon(Step)
+}
+
+// networkRunners returns a runner for the default daemon, which runs the
+// clean up of temporary directories, and one for each other daemon selected
+// by the steps of p.
+func (p Pipeline) networkRunners() []Runner {
+	runners := []Runner{p.localRunner}
+	_, host, context := stepDaemon(Step{})
+	seen := map[[2]string]bool{{host, context}: true}
+	names := make([]string, 0, len(p.Definition.Steps))
+	for name := range p.Definition.Steps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		step := p.Definition.Steps[name]
+		if step.Meta.Ignore || step.Meta.Type == ServiceTypeExternal {
+			continue
+		}
+		_, host, context := stepDaemon(step)
+		if seen[[2]string{host, context}] {
+			continue
+		}
+		seen[[2]string{host, context}] = true
+		runner := p.localRunner.Copy()
+		if r, ok := runner.(daemonUser); ok {
+			r.useDaemon(step)
+		}
+		runners = append(runners, runner)
+	}
+	return runners
+}
+
+// PruneImages removes dangling images built for the project of Pipeline p.
+func (p Pipeline) PruneImages() error {
+	return p.localRunner.ImagePruner(ProjectName)()
+}
+
+// RemoveImages removes the images of all not ignored steps marked with
+// remove_image.
+func (p Pipeline) RemoveImages() error {
+	_, err := p.runCommand(runConfig{
+		selection: func(step Step) bool {
+			return step.RemoveImage && !step.Meta.Ignore
+		},
+		run: func(runner Runner, step Step) func() error {
+			return runner.ImageRemover(step)
+		},
+	})
+	return err
 }
 
 // RemoveTempDirData deletes all data stored in temporary directories.
@@ -671,13 +1116,112 @@ func (p Pipeline) RemoveTempDirData() error {
 	return err
 }
 
+// checkRequiredServices verifies that all services required by selected steps
+// but not run themselves are running already.
+func (p *Pipeline) checkRequiredServices() error {
+	pipelines, err := p.Definition.Pipelines()
+	if err != nil {
+		return err
+	}
+	checked := types.StringSet{}
+	for _, step := range pipelines.AllSteps() {
+		if step.Meta.Ignore {
+			continue
+		}
+		for name := range step.Dependencies() {
+			dep, ok := p.Definition.Steps[name]
+			if !ok || !dep.Meta.Ignore || dep.Meta.Type != ServiceTypeService || checked[name] {
+				continue
+			}
+			checked[name] = true
+			if err := p.localRunner.Copy().RunningContainerChecker(dep)(); err != nil {
+				return fmt.Errorf("%s requires service %s: %s", step.ColoredName(), dep.ColoredName(), err)
+			}
+		}
+	}
+	return nil
+}
+
+// waitForHealthyDependencies blocks until all dependencies of step with the
+// condition service_healthy pass their healthchecks. Ignored steps do not
+// wait.
+func (p *Pipeline) waitForHealthyDependencies(step Step) error {
+	if step.Meta.Ignore {
+		return nil
+	}
+	for _, name := range sortedKeys(step.Dependencies()) {
+		if step.DependsOn[name] != DependencyConditionHealthy {
+			continue
+		}
+		dep, ok := p.Definition.Steps[name]
+		if !ok {
+			continue
+		}
+		if Verbose {
+			pipelineLogger.Printf("Waiting for %s to become healthy", dep.ColoredContainerName())
+		}
+		runner := p.GetRunnerForMeta(dep.Meta).Copy()
+		if err := waitUntilHealthy(runner.HealthyContainerChecker(dep), dep); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // ExecuteSteps runs all not ignored steps/services in the order defined by
-// there dependencies. Each step/service is run as soon as possible.
-func (p Pipeline) ExecuteSteps() error {
+// there dependencies. Each step/service is run as soon as possible. The
+// outcome of each step is stored in p.Result.
+func (p *Pipeline) ExecuteSteps() error {
+	if NoDeps {
+		if err := p.checkRequiredServices(); err != nil {
+			return err
+		}
+	}
+	p.detached = &detachedServices{}
+	var resume *resumeState
+	var skip func(step Step) bool
+	if Resume {
+		var err error
+		resume, err = loadResumeState(resumeStatePath(p.Environment.TempDirPath), ForceRerun)
+		if err != nil {
+			return err
+		}
+		resume.imageID = func(step Step) string {
+			id, err := p.GetRunnerForMeta(step.Meta).ImageIdentifier(step)()
+			if err != nil {
+				return ""
+			}
+			return id
+		}
+		resume.temporary = p.Environment.isTemporary
+		skip = resume.skip
+	}
+	// Services reused from a previous run are neither replaced nor started
+	var reusedMutex sync.Mutex
+	reused := types.StringSet{}
+	isReused := func(step Step) bool {
+		reusedMutex.Lock()
+		defer reusedMutex.Unlock()
+		return reused[step.Name]
+	}
 	pipelineLogger.Printf("Execute:")
-	count, elapsedTime, totalElapsedTime, err := p.runCommand(runConfig{
+	result, err := p.runCommand(runConfig{
 		usePreconditions: true,
+		skip:             skip,
+		events:           p.Events,
+		status:           newStatusNotifier(p.OnStepStatus),
 		pre: func(runner Runner, step Step) error {
+			if ReuseServices && step.Meta.Type == ServiceTypeService && !step.Meta.Ignore {
+				err := runner.HealthyContainerChecker(step)()
+				if err == nil {
+					reusedMutex.Lock()
+					reused[step.Name] = true
+					reusedMutex.Unlock()
+					pipelineLogger.Printf("- Reusing: %s", step.ColoredContainerName())
+					return nil
+				}
+				pipelineLogger.Printf("- Not reusing %s: %s", step.ColoredContainerName(), err)
+			}
 			count, err := runner.ContainerKiller(step)()
 			if err != nil {
 				pipelineLogger.Printf("Error killing %s: %s", step.ColoredName(), err)
@@ -688,23 +1232,64 @@ func (p Pipeline) ExecuteSteps() error {
 			if err := runner.ContainerRemover(step)(); err != nil {
 				pipelineLogger.Printf("Error removing %s: %s", step.ColoredName(), err)
 			}
+			if step.Meta.Type == ServiceTypeExternal {
+				pipelineLogger.Printf("- Waiting for: %s", step.ColoredContainerName())
+				return nil
+			}
 			pipelineLogger.Printf("- Starting: %s", step.ColoredContainerName())
 			return nil
 		},
 		run: func(runner Runner, step Step) func() error {
 			return func() error {
-				return runner.ContainerRunner(step, p.Network)()
+				if err := p.waitForHealthyDependencies(step); err != nil {
+					return err
+				}
+				if !isReused(step) {
+					if err := p.runWithRetries(runner, step); err != nil {
+						return err
+					}
+				}
+				// Containers of steps kept on failure are removed after success
+				if step.Meta.Type == ServiceTypeStep && step.Meta.KeepAlive == KeepAliveOnFailure {
+					if err := runner.ContainerRemover(step)(); err != nil {
+						pipelineLogger.Printf("Error removing %s: %s", step.ColoredName(), err)
+					}
+				}
+				if !step.Meta.Ignore {
+					if err := step.WaitUntilReady(); err != nil {
+						return err
+					}
+				}
+				if FollowServiceLogs && step.Meta.Type == ServiceTypeService && p.followers != nil {
+					p.followers.Follow(runner, step, p.ServiceLogs)
+				}
+				if step.Meta.Type == ServiceTypeService && !step.Meta.Ignore {
+					p.detached.Add(step)
+				}
+				if step.Meta.Type == ServiceTypeService && p.sampler != nil {
+					p.sampler.Sample(runner, step)
+				}
+				if resume != nil {
+					resume.succeeded(step)
+				}
+				return nil
 			}
 		},
 	})
-	pipelineLogger.Printf("Executed %d steps in %s", count, elapsedTime)
-	pipelineLogger.Printf("Total time spent inside steps: %s", totalElapsedTime)
+	p.Result = result
+	if resume != nil {
+		if err := resume.save(); err != nil {
+			pipelineLogger.Printf("Error storing succeeded steps: %s", err)
+		}
+	}
+	pipelineLogger.Printf("Executed %d steps in %s", result.Count(), result.Elapsed)
+	pipelineLogger.Printf("Total time spent inside steps: %s", result.TotalDuration())
 	return err
 }
 
 // Logs retrievs the logs of all containers.
 func (p Pipeline) Logs(follow bool) error {
-	_, _, _, err := p.runCommand(runConfig{
+	_, err := p.runCommand(runConfig{
 		run: func(runner Runner, step Step) func() error {
 			return func() error {
 				return runner.ContainerLogReader(step, follow)()
@@ -1,10 +1,18 @@
 package gantry
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net"
 	"os"
+	"path/filepath"
+	"reflect"
+	"syscall"
 	"testing"
 
 	"github.com/ad-freiburg/gantry/types"
@@ -432,6 +440,55 @@ func TestPipelineRemoveNetwork(t *testing.T) {
 	}
 }
 
+func TestPipelineNetworkPerDaemon(t *testing.T) {
+	tmpDef, tmpEnv := setupDefAndEnv(`version: "2.0"
+steps:
+  a:
+    image: alpine
+  b:
+    image: alpine
+  c:
+    image: alpine
+  d:
+    image: alpine
+`, `steps:
+  b:
+    docker_host: tcp://remote:2375
+  c:
+    docker_host: tcp://remote:2375
+  d:
+    docker_host: tcp://ignored:2375
+    ignore: true
+`)
+	defer os.Remove(tmpDef)
+	defer os.Remove(tmpEnv)
+
+	p, err := NewPipeline(tmpDef, tmpEnv, types.StringMap{}, types.StringSet{}, types.StringSet{})
+	if err != nil {
+		t.Errorf("unexpected error creating pipeline: '%#v'", err)
+	}
+	p.localRunner = NewLocalRunner("pipeline", nil, nil)
+	runners := p.networkRunners()
+	if len(runners) != 2 {
+		t.Fatalf("Incorrect number of daemons, got: %d, wanted: 2", len(runners))
+	}
+	if r := runners[1].(*LocalRunner).dockerHost; r != "tcp://remote:2375" {
+		t.Errorf("Incorrect daemon, got: '%s', wanted: 'tcp://remote:2375'", r)
+	}
+
+	localRunner := NewNoopRunner(false)
+	p.localRunner = localRunner
+	p.Network = Network("test")
+	if err := p.CreateNetwork(); err != nil {
+		t.Errorf("unexpected error, got: '%#v', wanted 'nil'", err)
+	}
+	if err := p.RemoveNetwork(); err != nil {
+		t.Errorf("unexpected error, got: '%#v', wanted 'nil'", err)
+	}
+	checkCallsAndCalled(t, localRunner, "NetworkCreator(test)", 2, 2)
+	checkCallsAndCalled(t, localRunner, "NetworkRemover(test)", 2, 2)
+}
+
 func TestPipelineExecuteSteps(t *testing.T) {
 	tmpDef, tmpEnv := setupDefAndEnv(def, env)
 	defer os.Remove(tmpDef)
@@ -472,6 +529,187 @@ func TestPipelineExecuteSteps(t *testing.T) {
 	}
 }
 
+func TestPipelineExecuteStepsMaxParallel(t *testing.T) {
+	tmpDef, tmpEnv := setupDefAndEnv(`version: "2.0"
+steps:
+  a:
+    image: alpine
+  b:
+    image: alpine
+    priority: 1
+  c:
+    image: alpine
+    after:
+      - a
+      - b
+`, "")
+	defer os.Remove(tmpDef)
+	defer os.Remove(tmpEnv)
+
+	p, err := NewPipeline(tmpDef, tmpEnv, types.StringMap{}, types.StringSet{}, types.StringSet{})
+	if err != nil {
+		t.Errorf("unexpected error creating pipeline: '%#v'", err)
+	}
+	localRunner := NewNoopRunner(true)
+	p.localRunner = localRunner
+	p.Network = Network("test")
+	MaxParallel = 1
+	defer func() { MaxParallel = 0 }()
+
+	if err := p.ExecuteSteps(); err != nil {
+		t.Errorf("unexpected error, got: '%#v', wanted 'nil'", err)
+	}
+	for _, name := range []string{"a", "b", "c"} {
+		checkCallsAndCalled(t, localRunner, fmt.Sprintf("ContainerRunner(%s,test)", name), 1, 1)
+	}
+}
+
+func TestPipelineExecuteStepsKeepAlive(t *testing.T) {
+	tmpDef, tmpEnv := setupDefAndEnv(`version: "2.0"
+steps:
+  a:
+    image: alpine
+  b:
+    image: alpine
+  c:
+    image: alpine
+`, `steps:
+  a:
+    keep_alive: on_failure
+  b:
+    keep_alive: yes
+`)
+	defer os.Remove(tmpDef)
+	defer os.Remove(tmpEnv)
+
+	p, err := NewPipeline(tmpDef, tmpEnv, types.StringMap{}, types.StringSet{}, types.StringSet{})
+	if err != nil {
+		t.Errorf("unexpected error creating pipeline: '%#v'", err)
+	}
+	localRunner := NewNoopRunner(false)
+	p.localRunner = localRunner
+	p.Network = Network("test")
+
+	cases := []struct {
+		key    string
+		calls  int
+		called int
+	}{
+		{"ContainerKiller(a)", 2, 2},
+		{"ContainerRemover(a)", 2, 2},
+		{"ContainerKiller(b)", 2, 2},
+		{"ContainerRemover(b)", 1, 1},
+		{"ContainerKiller(c)", 2, 2},
+		{"ContainerRemover(c)", 2, 2},
+	}
+
+	if err := p.ExecuteSteps(); err != nil {
+		t.Errorf("unexpected error, got: '%#v', wanted 'nil'", err)
+	}
+	if err := p.CleanUp(syscall.Signal(0)); err != nil {
+		t.Errorf("unexpected error, got: '%#v', wanted 'nil'", err)
+	}
+	for _, c := range cases {
+		checkCallsAndCalled(t, localRunner, c.key, c.calls, c.called)
+	}
+}
+
+func TestPipelineExecuteStepsFollowServiceLogs(t *testing.T) {
+	tmpDef, tmpEnv := setupDefAndEnv(def, env)
+	defer os.Remove(tmpDef)
+	defer os.Remove(tmpEnv)
+
+	p, err := NewPipeline(tmpDef, tmpEnv, types.StringMap{}, types.StringSet{}, types.StringSet{})
+	if err != nil {
+		t.Errorf("unexpected error creating pipeline: '%#v'", err)
+	}
+	localRunner := NewNoopRunner(false)
+	p.localRunner = localRunner
+	noopRunner := NewNoopRunner(false)
+	p.noopRunner = noopRunner
+	p.Network = Network("test")
+
+	cases := []struct {
+		key    string
+		runner *NoopRunner
+		calls  int
+		called int
+	}{
+		{"ContainerLogFollower(a)", localRunner, 0, 0},
+		{"ContainerLogFollower(b)", noopRunner, 0, 0},
+		{"ContainerLogFollower(c)", localRunner, 1, 1},
+	}
+
+	FollowServiceLogs = true
+	defer func() { FollowServiceLogs = false }()
+	if err := p.ExecuteSteps(); err != nil {
+		t.Errorf("unexpected error, got: '%#v', wanted 'nil'", err)
+	}
+	p.followers.Stop()
+	for _, c := range cases {
+		checkCallsAndCalled(t, c.runner, c.key, c.calls, c.called)
+	}
+}
+
+func TestPipelineExecuteStepsEvents(t *testing.T) {
+	tmpDef, tmpEnv := setupDefAndEnv(def, env)
+	defer os.Remove(tmpDef)
+	defer os.Remove(tmpEnv)
+
+	p, err := NewPipeline(tmpDef, tmpEnv, types.StringMap{}, types.StringSet{}, types.StringSet{})
+	if err != nil {
+		t.Errorf("unexpected error creating pipeline: '%#v'", err)
+	}
+	p.localRunner = NewNoopRunner(true)
+	p.noopRunner = NewNoopRunner(true)
+	buf := bytes.NewBuffer([]byte(""))
+	p.Events = NewEventEmitter(buf)
+
+	if err := p.ExecuteSteps(); err != nil {
+		t.Errorf("unexpected error, got: '%#v', wanted 'nil'", err)
+	}
+	counts := map[EventType]int{}
+	scanner := bufio.NewScanner(buf)
+	for scanner.Scan() {
+		event := Event{}
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Errorf("unexpected error, got: '%#v', wanted 'nil'", err)
+		}
+		counts[event.Type]++
+	}
+	expected := map[EventType]int{
+		EventStageStarted:     1,
+		EventStepStarted:      3,
+		EventStepFinished:     3,
+		EventPipelineFinished: 1,
+	}
+	if !reflect.DeepEqual(counts, expected) {
+		t.Errorf("incorrect number of events, got: '%v', wanted '%v'", counts, expected)
+	}
+	if c := p.Result.Count(); c != 3 {
+		t.Errorf("incorrect number of results, got: '%d', wanted '%d'", c, 3)
+	}
+}
+
+func TestPipelinePruneImages(t *testing.T) {
+	tmpDef, tmpEnv := setupDefAndEnv(def, env)
+	defer os.Remove(tmpDef)
+	defer os.Remove(tmpEnv)
+
+	p, err := NewPipeline(tmpDef, tmpEnv, types.StringMap{}, types.StringSet{}, types.StringSet{})
+	if err != nil {
+		t.Errorf("unexpected error creating pipeline: '%#v'", err)
+	}
+	localRunner := NewNoopRunner(false)
+	p.localRunner = localRunner
+	ProjectName = "test"
+
+	if err := p.PruneImages(); err != nil {
+		t.Errorf("unexpected error, got: '%#v', wanted 'nil'", err)
+	}
+	checkCallsAndCalled(t, localRunner, "ImagePruner(test)", 1, 1)
+}
+
 func TestPipelineRemoveTempDirData(t *testing.T) {
 	tmpDef, tmpEnv := setupDefAndEnv(`version: "2.0"
 #! TEMP_DIR_IF_EMPTY ${TEMP_STORAGE}
@@ -512,6 +750,77 @@ steps:
 	}
 }
 
+func TestPipelineArtifacts(t *testing.T) {
+	tmpDef, tmpEnv := setupDefAndEnv(`version: "2.0"
+steps:
+  a:
+    image: alpine
+    artifacts:
+    - /out
+  b:
+    image: alpine
+    after:
+    - a
+  c:
+    image: alpine
+`, "")
+	defer os.Remove(tmpDef)
+	defer os.Remove(tmpEnv)
+
+	p, err := NewPipeline(tmpDef, tmpEnv, types.StringMap{}, types.StringSet{}, types.StringSet{})
+	if err != nil {
+		t.Errorf("unexpected error creating pipeline: '%#v'", err)
+	}
+	defer p.Environment.CleanUp(nil)
+
+	if len(p.Environment.tempPaths) != 1 {
+		t.Fatalf("Incorrect number of temporary directories, got: '%d', wanted '1'", len(p.Environment.tempPaths))
+	}
+	dir, err := p.Environment.GetOrCreateTempDir(artifactTempDirPrefix("a", 0))
+	if err != nil {
+		t.Errorf("unexpected error, got: '%#v', wanted 'nil'", err)
+	}
+	cases := []struct {
+		step    string
+		volumes []string
+	}{
+		{"a", []string{dir + ":/out"}},
+		{"b", []string{dir + ":/artifacts/a/out:ro"}},
+		{"c", nil},
+	}
+	for _, c := range cases {
+		if r := p.Definition.Steps[c.step].Volumes; !reflect.DeepEqual(r, c.volumes) {
+			t.Errorf("Incorrect volumes for '%s', got: '%v', wanted '%v'", c.step, r, c.volumes)
+		}
+	}
+}
+
+func TestPipelineBuildSecretsRelativeToDefinition(t *testing.T) {
+	tmpDef, tmpEnv := setupDefAndEnv(`version: "2.0"
+services:
+  a:
+    build:
+      context: .
+      secrets:
+      - id=relative,src=token.txt
+      - id=absolute,src=/token.txt
+`, "")
+	defer os.Remove(tmpDef)
+	defer os.Remove(tmpEnv)
+
+	p, err := NewPipeline(tmpDef, tmpEnv, types.StringMap{}, types.StringSet{}, types.StringSet{})
+	if err != nil {
+		t.Errorf("unexpected error creating pipeline: '%#v'", err)
+	}
+	result := []BuildSecret{
+		{ID: "relative", Source: filepath.Join(filepath.Dir(tmpDef), "token.txt")},
+		{ID: "absolute", Source: "/token.txt"},
+	}
+	if r := p.Definition.Steps["a"].BuildInfo.Secrets; !reflect.DeepEqual(r, result) {
+		t.Errorf("Incorrect build secrets, got: '%v', wanted '%v'", r, result)
+	}
+}
+
 func TestPipelineRemoveTempDirDataNoTempDirs(t *testing.T) {
 	tmpDef, tmpEnv := setupDefAndEnv(`version: "2.0"
 steps:
@@ -621,6 +930,72 @@ steps:
 	}
 }
 
+func TestPipelineCheckContainerNames(t *testing.T) {
+	tmpDef, tmpEnv := setupDefAndEnv(`version: "2.0"
+steps:
+  "Unit Tests":
+    image: alpine
+  unit_tests:
+    image: alpine
+`, "")
+	defer os.Remove(tmpDef)
+	defer os.Remove(tmpEnv)
+
+	p, err := NewPipeline(tmpDef, "", types.StringMap{}, types.StringSet{}, types.StringSet{})
+	if err != nil {
+		t.Errorf("unexpected error, got: '%#v', wanted 'nil'", err)
+	}
+	expected := "names 'Unit Tests' and 'unit_tests' result in the same container name 'unit_tests'"
+	if err := p.Check(); err == nil || err.Error() != expected {
+		t.Errorf("incorrect error, got: '%v', wanted '%s'", err, expected)
+	}
+}
+
+func TestPipelineCheckDependencyConditions(t *testing.T) {
+	cases := []struct {
+		definition string
+		err        string
+	}{
+		{`version: "2.0"
+services:
+  db:
+    image: postgres
+steps:
+  a:
+    image: alpine
+    depends_on:
+      db:
+        condition: service_healthy
+`, ""},
+		{`version: "2.0"
+steps:
+  b:
+    image: alpine
+  a:
+    image: alpine
+    depends_on:
+      b:
+        condition: service_healthy
+`, "condition 'service_healthy' of 'a' requires 'b' to be a service, not a step"},
+	}
+	for i, c := range cases {
+		tmpDef, tmpEnv := setupDefAndEnv(c.definition, "")
+		p, err := NewPipeline(tmpDef, "", types.StringMap{}, types.StringSet{}, types.StringSet{})
+		os.Remove(tmpDef)
+		os.Remove(tmpEnv)
+		if err != nil {
+			t.Fatalf("unexpected error in case %d, got: '%#v', wanted 'nil'", i, err)
+		}
+		err = p.Check()
+		if c.err == "" && err != nil {
+			t.Errorf("unexpected error in case %d, got: '%v', wanted 'nil'", i, err)
+		}
+		if c.err != "" && (err == nil || err.Error() != c.err) {
+			t.Errorf("incorrect error in case %d, got: '%v', wanted '%s'", i, err, c.err)
+		}
+	}
+}
+
 func TestPipelineDefinitionCheckVersion(t *testing.T) {
 	p := PipelineDefinition{}
 	cases := []struct {
@@ -688,3 +1063,671 @@ func TestPipelineDefinitionCheckVersion(t *testing.T) {
 		}
 	}
 }
+
+func TestPipelinesWriteJSON(t *testing.T) {
+	tmpDef, tmpEnv := setupDefAndEnv(`version: "2.0"
+services:
+  db:
+    image: postgres
+steps:
+  a:
+    image: alpine
+    depends_on:
+    - db
+  b:
+    build:
+      context: .
+    after:
+    - a
+`, `steps:
+  b:
+    ignore: true
+`)
+	defer os.Remove(tmpDef)
+	defer os.Remove(tmpEnv)
+
+	p, err := NewPipeline(tmpDef, tmpEnv, types.StringMap{}, types.StringSet{}, types.StringSet{"a": true})
+	if err != nil {
+		t.Fatalf("unexpected error creating pipeline: '%#v'", err)
+	}
+	pipelines, err := p.Definition.Pipelines()
+	if err != nil {
+		t.Fatalf("unexpected error: '%#v'", err)
+	}
+	buf := bytes.NewBuffer(nil)
+	if err := pipelines.WriteJSON(buf); err != nil {
+		t.Fatalf("unexpected error: '%#v'", err)
+	}
+	result := graph{}
+	if err := json.Unmarshal(buf.Bytes(), &result); err != nil {
+		t.Fatalf("unexpected error: '%#v'", err)
+	}
+	steps := map[string]graphStep{}
+	for _, step := range result.Steps {
+		steps[step.Name] = step
+		if step.Pipeline != 0 {
+			t.Errorf("Incorrect pipeline for '%s', got: '%d', wanted: '0'", step.Name, step.Pipeline)
+		}
+	}
+	cases := []graphStep{
+		{Name: "db", Type: "service", Image: "postgres", Dependencies: []string{}, After: []string{}, Selected: true},
+		{Name: "a", Type: "step", Image: "alpine", Dependencies: []string{"db"}, After: []string{}, Selected: true},
+		{Name: "b", Type: "step", Image: "b", Dependencies: []string{"a"}, After: []string{"a"}, Ignored: true},
+	}
+	for _, c := range cases {
+		if r := steps[c.Name]; !reflect.DeepEqual(r, c) {
+			t.Errorf("Incorrect graph step for '%s', got: '%#v', wanted: '%#v'", c.Name, r, c)
+		}
+	}
+}
+
+func TestPipelineDefinitionUnreachableSteps(t *testing.T) {
+	tmpDef, tmpEnv := setupDefAndEnv(`version: "2.0"
+services:
+  db:
+    image: postgres
+steps:
+  a:
+    image: alpine
+    depends_on:
+    - db
+  b:
+    image: alpine
+    after:
+    - a
+  c:
+    image: alpine
+  d:
+    image: alpine
+`, `steps:
+  d:
+    ignore: true
+`)
+	defer os.Remove(tmpDef)
+	defer os.Remove(tmpEnv)
+
+	cases := []struct {
+		selected types.StringSet
+		noDeps   bool
+		result   []string
+	}{
+		{types.StringSet{}, false, nil},
+		{types.StringSet{"a": true}, false, []string{"b", "c"}},
+		{types.StringSet{"b": true}, false, []string{"c"}},
+		{types.StringSet{"b": true, "c": true}, false, []string{}},
+		{types.StringSet{"b": true}, true, nil},
+	}
+	defer func() { NoDeps = false }()
+	for _, c := range cases {
+		NoDeps = c.noDeps
+		p, err := NewPipeline(tmpDef, tmpEnv, types.StringMap{}, types.StringSet{}, c.selected)
+		if err != nil {
+			t.Fatalf("unexpected error creating pipeline: '%#v'", err)
+		}
+		result, err := p.Definition.UnreachableSteps()
+		if err != nil {
+			t.Errorf("unexpected error for '%v': '%#v'", c.selected, err)
+		}
+		if !reflect.DeepEqual(result, c.result) {
+			t.Errorf("Incorrect result for '%v', got: '%#v', wanted: '%#v'", c.selected, result, c.result)
+		}
+	}
+}
+
+func TestPipelineExecuteStepsOnStepStatus(t *testing.T) {
+	tmpDef, tmpEnv := setupDefAndEnv(def, env)
+	defer os.Remove(tmpDef)
+	defer os.Remove(tmpEnv)
+
+	p, err := NewPipeline(tmpDef, tmpEnv, types.StringMap{}, types.StringSet{}, types.StringSet{})
+	if err != nil {
+		t.Errorf("unexpected error creating pipeline: '%#v'", err)
+	}
+	p.localRunner = NewNoopRunner(true)
+	p.noopRunner = NewNoopRunner(true)
+	transitions := map[string][]StepStatus{}
+	p.OnStepStatus = func(transition StepTransition) {
+		if transition.Time.IsZero() {
+			t.Errorf("missing time for transition of '%s'", transition.Step)
+		}
+		transitions[transition.Step] = append(transitions[transition.Step], transition.Status)
+	}
+
+	if err := p.ExecuteSteps(); err != nil {
+		t.Errorf("unexpected error, got: '%#v', wanted 'nil'", err)
+	}
+	expected := []StepStatus{StepStatusPending, StepStatusRunning, StepStatusSucceeded}
+	if len(transitions) != 3 {
+		t.Errorf("incorrect number of steps, got: '%d', wanted '%d'", len(transitions), 3)
+	}
+	for name, statuses := range transitions {
+		if !reflect.DeepEqual(statuses, expected) {
+			t.Errorf("incorrect transitions for '%s', got: '%v', wanted '%v'", name, statuses, expected)
+		}
+	}
+}
+
+func TestPipelineExecuteStepsNoDeps(t *testing.T) {
+	tmpDef, tmpEnv := setupDefAndEnv(`version: "2.0"
+services:
+  db:
+    image: postgres
+steps:
+  a:
+    image: alpine
+    depends_on:
+    - db
+  b:
+    image: alpine
+    after:
+    - a
+`, "")
+	defer os.Remove(tmpDef)
+	defer os.Remove(tmpEnv)
+
+	NoDeps = true
+	defer func() { NoDeps = false }()
+	p, err := NewPipeline(tmpDef, tmpEnv, types.StringMap{}, types.StringSet{}, types.StringSet{"b": true})
+	if err != nil {
+		t.Fatalf("unexpected error creating pipeline: '%#v'", err)
+	}
+	localRunner := NewNoopRunner(true)
+	p.localRunner = localRunner
+	noopRunner := NewNoopRunner(true)
+	p.noopRunner = noopRunner
+
+	if err := p.ExecuteSteps(); err != nil {
+		t.Errorf("unexpected error, got: '%#v', wanted 'nil'", err)
+	}
+	cases := []struct {
+		key    string
+		runner *NoopRunner
+		calls  int
+		called int
+	}{
+		{"ContainerRunner(b,)", localRunner, 1, 1},
+		{"ContainerRunner(a,)", noopRunner, 1, 1},
+		{"ContainerRunner(db,)", noopRunner, 1, 1},
+		// Only services are required to be running
+		{"RunningContainerChecker(a)", localRunner, 0, 0},
+		{"RunningContainerChecker(db)", localRunner, 0, 0},
+	}
+	for _, c := range cases {
+		checkCallsAndCalled(t, c.runner, c.key, c.calls, c.called)
+	}
+
+	p, err = NewPipeline(tmpDef, tmpEnv, types.StringMap{}, types.StringSet{}, types.StringSet{"a": true})
+	if err != nil {
+		t.Fatalf("unexpected error creating pipeline: '%#v'", err)
+	}
+	localRunner = NewNoopRunner(true)
+	p.localRunner = localRunner
+	p.noopRunner = NewNoopRunner(true)
+	if err := p.ExecuteSteps(); err != nil {
+		t.Errorf("unexpected error, got: '%#v', wanted 'nil'", err)
+	}
+	checkCallsAndCalled(t, localRunner, "RunningContainerChecker(db)", 1, 1)
+	checkCallsAndCalled(t, localRunner, "ContainerRunner(a,)", 1, 1)
+}
+
+func TestPipelineRemoveImages(t *testing.T) {
+	tmpDef, tmpEnv := setupDefAndEnv(`version: "2.0"
+steps:
+  a:
+    build:
+      context: a
+    remove_image: true
+  b:
+    image: alpine
+  c:
+    image: alpine
+    remove_image: true
+`, `steps:
+  c:
+    ignore: true
+`)
+	defer os.Remove(tmpDef)
+	defer os.Remove(tmpEnv)
+
+	p, err := NewPipeline(tmpDef, tmpEnv, types.StringMap{}, types.StringSet{}, types.StringSet{})
+	if err != nil {
+		t.Fatalf("unexpected error creating pipeline: '%#v'", err)
+	}
+	localRunner := NewNoopRunner(true)
+	p.localRunner = localRunner
+	noopRunner := NewNoopRunner(true)
+	p.noopRunner = noopRunner
+
+	if err := p.RemoveImages(); err != nil {
+		t.Errorf("unexpected error, got: '%#v', wanted 'nil'", err)
+	}
+	cases := []struct {
+		key    string
+		runner *NoopRunner
+		calls  int
+		called int
+	}{
+		{"ImageRemover(a)", localRunner, 1, 1},
+		{"ImageRemover(b)", localRunner, 0, 0},
+		{"ImageRemover(c)", localRunner, 0, 0},
+		{"ImageRemover(c)", noopRunner, 0, 0},
+	}
+	for _, c := range cases {
+		checkCallsAndCalled(t, c.runner, c.key, c.calls, c.called)
+	}
+}
+
+func TestPipelineExecuteStepsExternal(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	tmpDef, tmpEnv := setupDefAndEnv(fmt.Sprintf(`version: "2.0"
+external:
+  db:
+    wait_for:
+    - %s
+steps:
+  a:
+    image: alpine
+    depends_on:
+    - db
+`, listener.Addr().String()), "")
+	defer os.Remove(tmpDef)
+	defer os.Remove(tmpEnv)
+
+	p, err := NewPipeline(tmpDef, tmpEnv, types.StringMap{}, types.StringSet{}, types.StringSet{"a": true})
+	if err != nil {
+		t.Fatalf("unexpected error creating pipeline: '%#v'", err)
+	}
+	if err := p.Check(); err != nil {
+		t.Errorf("unexpected error checking pipeline: '%#v'", err)
+	}
+	localRunner := NewNoopRunner(true)
+	p.localRunner = localRunner
+	noopRunner := NewNoopRunner(true)
+	p.noopRunner = noopRunner
+
+	if err := p.ExecuteSteps(); err != nil {
+		t.Errorf("unexpected error, got: '%#v', wanted 'nil'", err)
+	}
+	cases := []struct {
+		key    string
+		runner *NoopRunner
+		calls  int
+		called int
+	}{
+		{"ContainerRunner(a,)", localRunner, 1, 1},
+		{"ContainerRunner(db,)", localRunner, 0, 0},
+		{"ContainerRunner(db,)", noopRunner, 1, 1},
+		{"ContainerKiller(db)", localRunner, 0, 0},
+	}
+	for _, c := range cases {
+		checkCallsAndCalled(t, c.runner, c.key, c.calls, c.called)
+	}
+}
+
+func TestPipelineWait(t *testing.T) {
+	tmpDef, tmpEnv := setupDefAndEnv(`version: "2.0"
+services:
+  db:
+    image: postgres
+steps:
+  a:
+    image: alpine
+    depends_on:
+    - db
+`, "")
+	defer os.Remove(tmpDef)
+	defer os.Remove(tmpEnv)
+
+	p, err := NewPipeline(tmpDef, tmpEnv, types.StringMap{}, types.StringSet{}, types.StringSet{})
+	if err != nil {
+		t.Fatalf("unexpected error creating pipeline: '%#v'", err)
+	}
+	localRunner := NewNoopRunner(true)
+	p.localRunner = localRunner
+	p.noopRunner = NewNoopRunner(true)
+
+	// Nothing was started yet
+	if err := p.Wait(context.Background()); err != nil {
+		t.Errorf("unexpected error, got: '%#v', wanted 'nil'", err)
+	}
+	if err := p.ExecuteSteps(); err != nil {
+		t.Errorf("unexpected error, got: '%#v', wanted 'nil'", err)
+	}
+	services := p.DetachedServices()
+	if len(services) != 1 || services[0].Name != "db" {
+		t.Errorf("Incorrect detached services, got: '%v', wanted: '[db]'", services)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := p.Wait(ctx); err != nil {
+		t.Errorf("unexpected error, got: '%#v', wanted 'nil'", err)
+	}
+	// Killed before starting and once interrupted
+	checkCallsAndCalled(t, localRunner, "ContainerKiller(db)", 2, 2)
+	checkCallsAndCalled(t, localRunner, "ContainerKiller(a)", 1, 1)
+}
+
+func TestPipelineExecuteStepsReuseServices(t *testing.T) {
+	tmpDef, tmpEnv := setupDefAndEnv(`version: "2.0"
+services:
+  db:
+    image: postgres
+steps:
+  a:
+    image: alpine
+    depends_on:
+    - db
+`, "")
+	defer os.Remove(tmpDef)
+	defer os.Remove(tmpEnv)
+
+	ReuseServices = true
+	defer func() { ReuseServices = false }()
+	p, err := NewPipeline(tmpDef, tmpEnv, types.StringMap{}, types.StringSet{}, types.StringSet{})
+	if err != nil {
+		t.Fatalf("unexpected error creating pipeline: '%#v'", err)
+	}
+	localRunner := NewNoopRunner(true)
+	p.localRunner = localRunner
+	p.noopRunner = NewNoopRunner(true)
+
+	if err := p.ExecuteSteps(); err != nil {
+		t.Errorf("unexpected error, got: '%#v', wanted 'nil'", err)
+	}
+	cases := []struct {
+		key    string
+		calls  int
+		called int
+	}{
+		// The noop runner reports all services as healthy
+		{"HealthyContainerChecker(db)", 1, 1},
+		{"ContainerKiller(db)", 0, 0},
+		{"ContainerRemover(db)", 0, 0},
+		{"ContainerRunner(db,)", 0, 0},
+		// Only services are reused
+		{"HealthyContainerChecker(a)", 0, 0},
+		{"ContainerRunner(a,)", 1, 1},
+	}
+	for _, c := range cases {
+		checkCallsAndCalled(t, localRunner, c.key, c.calls, c.called)
+	}
+	if services := p.DetachedServices(); len(services) != 1 || services[0].Name != "db" {
+		t.Errorf("Incorrect detached services, got: '%v', wanted: '[db]'", services)
+	}
+}
+
+func TestPipelineExecuteStepsHealthyDependency(t *testing.T) {
+	tmpDef, tmpEnv := setupDefAndEnv(`version: "2.0"
+services:
+  db:
+    image: postgres
+  cache:
+    image: redis
+steps:
+  a:
+    image: alpine
+    depends_on:
+      db:
+        condition: service_healthy
+      cache:
+        condition: service_started
+`, "")
+	defer os.Remove(tmpDef)
+	defer os.Remove(tmpEnv)
+
+	p, err := NewPipeline(tmpDef, tmpEnv, types.StringMap{}, types.StringSet{}, types.StringSet{})
+	if err != nil {
+		t.Fatalf("unexpected error creating pipeline: '%#v'", err)
+	}
+	localRunner := NewNoopRunner(true)
+	p.localRunner = localRunner
+	p.noopRunner = NewNoopRunner(true)
+
+	if err := p.ExecuteSteps(); err != nil {
+		t.Errorf("unexpected error, got: '%#v', wanted 'nil'", err)
+	}
+	checkCallsAndCalled(t, localRunner, "HealthyContainerChecker(db)", 1, 1)
+	checkCallsAndCalled(t, localRunner, "HealthyContainerChecker(cache)", 0, 0)
+	checkCallsAndCalled(t, localRunner, "ContainerRunner(a,)", 1, 1)
+}
+
+func TestPipelineInheritEnv(t *testing.T) {
+	os.Setenv("GANTRY_TEST_INHERITED", "os")
+	defer os.Unsetenv("GANTRY_TEST_INHERITED")
+	os.Setenv("GANTRY_TEST_NOT_INHERITED", "os")
+	defer os.Unsetenv("GANTRY_TEST_NOT_INHERITED")
+	env := `substitutions:
+  A: a
+  B: b
+  GANTRY_TEST_INHERITED:
+`
+	cases := []struct {
+		def    string
+		err    string
+		result types.StringMap
+	}{
+		{`version: "2.0"
+steps:
+  s:
+    image: alpine
+`, "", nil},
+		{`version: "2.0"
+steps:
+  s:
+    image: alpine
+    inherit_env: true
+    environment:
+      B: own
+`, "", types.StringMap{"A": strPtr("a"), "B": strPtr("own"), "GANTRY_TEST_INHERITED": strPtr("os")}},
+		{`version: "2.0"
+steps:
+  s:
+    image: alpine
+    inherit_env: [A]
+`, "", types.StringMap{"A": strPtr("a")}},
+		{`version: "2.0"
+steps:
+  s:
+    image: alpine
+    inherit_env: [GANTRY_TEST_NOT_INHERITED]
+`, "inherit_env of 's': unknown substitution 'GANTRY_TEST_NOT_INHERITED'", nil},
+	}
+
+	for _, c := range cases {
+		tmpDef, tmpEnv := setupDefAndEnv(c.def, env)
+		p, err := NewPipeline(tmpDef, tmpEnv, types.StringMap{}, types.StringSet{}, types.StringSet{})
+		os.Remove(tmpDef)
+		os.Remove(tmpEnv)
+		if (err == nil && c.err != "") || (err != nil && err.Error() != c.err) {
+			t.Errorf("Incorrect error for '%s', got: '%v', wanted: '%s'", c.def, err, c.err)
+		}
+		if err != nil {
+			continue
+		}
+		if environment := p.Definition.Steps["s"].Environment; !reflect.DeepEqual(environment, c.result) {
+			t.Errorf("Incorrect environment for '%s', got: '%v', wanted: '%v'", c.def, environment, c.result)
+		}
+	}
+}
+
+func strPtr(s string) *string {
+	return &s
+}
+
+func TestPipelineDefinitionYAMLAnchors(t *testing.T) {
+	def, env := setupDefAndEnv(`version: "2.0"
+x-environment: &environment
+  SHARED: shared
+  OVERRIDDEN: shared
+x-volumes: &volumes
+  - data:/data
+services:
+  db:
+    image: postgres
+    environment: *environment
+    volumes: *volumes
+steps:
+  a:
+    image: alpine
+    environment:
+      <<: *environment
+      OVERRIDDEN: own
+    volumes: *volumes
+    depends_on:
+    - db
+`, "")
+	defer os.Remove(def)
+	defer os.Remove(env)
+
+	p, err := NewPipeline(def, env, types.StringMap{}, types.StringSet{}, types.StringSet{})
+	if err != nil {
+		t.Fatalf("Got unexpected error: %#v", err)
+	}
+	cases := []struct {
+		name        string
+		environment types.StringMap
+	}{
+		{"db", types.StringMap{"SHARED": strPtr("shared"), "OVERRIDDEN": strPtr("shared")}},
+		{"a", types.StringMap{"SHARED": strPtr("shared"), "OVERRIDDEN": strPtr("own")}},
+	}
+	for _, c := range cases {
+		step := p.Definition.Steps[c.name]
+		if !reflect.DeepEqual(step.Environment, c.environment) {
+			t.Errorf("Incorrect environment of '%s', got: %v, wanted: %v", c.name, step.Environment, c.environment)
+		}
+		if !reflect.DeepEqual(step.Volumes, []string{"data:/data"}) {
+			t.Errorf("Incorrect volumes of '%s', got: %v", c.name, step.Volumes)
+		}
+	}
+}
+
+func TestPipelineExecuteStepsEnabledIf(t *testing.T) {
+	tmpDef, tmpEnv := setupDefAndEnv(`version: "2.0"
+services:
+  db:
+    image: postgres
+    enabled_if: ${DEPLOY}
+steps:
+  a:
+    image: alpine
+    enabled_if: ${DEPLOY}
+  b:
+    image: alpine
+    depends_on:
+    - a
+    - db
+  c:
+    image: alpine
+    enabled_if: ${RUN_C}
+`, `substitutions:
+  DEPLOY: "no"
+  RUN_C: "yes"
+`)
+	defer os.Remove(tmpDef)
+	defer os.Remove(tmpEnv)
+
+	p, err := NewPipeline(tmpDef, tmpEnv, types.StringMap{}, types.StringSet{}, types.StringSet{})
+	if err != nil {
+		t.Fatalf("unexpected error creating pipeline: '%#v'", err)
+	}
+	localRunner := NewNoopRunner(true)
+	p.localRunner = localRunner
+	noopRunner := NewNoopRunner(true)
+	p.noopRunner = noopRunner
+
+	if err := p.ExecuteSteps(); err != nil {
+		t.Errorf("unexpected error, got: '%#v', wanted 'nil'", err)
+	}
+	checkCallsAndCalled(t, localRunner, "ContainerRunner(a,)", 0, 0)
+	checkCallsAndCalled(t, noopRunner, "ContainerRunner(a,)", 0, 0)
+	checkCallsAndCalled(t, localRunner, "ContainerRunner(db,)", 0, 0)
+	checkCallsAndCalled(t, localRunner, "ContainerRunner(b,)", 1, 1)
+	checkCallsAndCalled(t, localRunner, "ContainerRunner(c,)", 1, 1)
+	status := map[string]StepStatus{}
+	for _, r := range p.Result.Steps() {
+		status[r.Name] = r.Status
+	}
+	wanted := map[string]StepStatus{
+		"db": StepStatusSkipped,
+		"a":  StepStatusSkipped,
+		"b":  StepStatusSucceeded,
+		"c":  StepStatusSucceeded,
+	}
+	if !reflect.DeepEqual(status, wanted) {
+		t.Errorf("incorrect status, got: %v, wanted: %v", status, wanted)
+	}
+
+	// Disabled steps can not be selected
+	p, err = NewPipeline(tmpDef, tmpEnv, types.StringMap{}, types.StringSet{}, types.StringSet{"a": true})
+	if err != nil {
+		t.Fatalf("unexpected error creating pipeline: '%#v'", err)
+	}
+	if _, err := p.Definition.Pipelines(); err == nil || err.Error() != "selected step 'a' is disabled by enabled_if" {
+		t.Errorf("incorrect error, got: '%v'", err)
+	}
+}
+
+func TestPipelineSetRunnerEnvironment(t *testing.T) {
+	tmpDef, tmpEnv := setupDefAndEnv(def, env)
+	defer os.Remove(tmpDef)
+	defer os.Remove(tmpEnv)
+	p, err := NewPipeline(tmpDef, tmpEnv, types.StringMap{}, types.StringSet{}, types.StringSet{})
+	if err != nil {
+		t.Fatalf("Got unexpected error: %#v", err)
+	}
+	p.SetRunnerEnvironment(map[string]string{"DOCKER_BUILDKIT": "1"})
+	runner := p.GetRunnerForMeta(ServiceMeta{Type: ServiceTypeStep}).(*LocalRunner)
+	found := false
+	for _, v := range runner.environ() {
+		if v == "DOCKER_BUILDKIT=1" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Runner environment not set, got: %v", runner.environ())
+	}
+}
+
+func TestPipelineSetRunnerWorkingDirectory(t *testing.T) {
+	tmpDef, tmpEnv := setupDefAndEnv(def, env)
+	defer os.Remove(tmpDef)
+	defer os.Remove(tmpEnv)
+	p, err := NewPipeline(tmpDef, tmpEnv, types.StringMap{}, types.StringSet{}, types.StringSet{})
+	if err != nil {
+		t.Fatalf("Got unexpected error: %#v", err)
+	}
+	p.SetRunnerWorkingDirectory("/tmp")
+	runner := p.GetRunnerForMeta(ServiceMeta{Type: ServiceTypeStep}).(*LocalRunner)
+	if runner.dir != "/tmp" {
+		t.Errorf("Incorrect working directory, got: '%s', wanted: '/tmp'", runner.dir)
+	}
+}
+
+func TestPipelineSetTempDirNoAutoClean(t *testing.T) {
+	for _, tc := range []struct {
+		env      string
+		override bool
+	}{
+		{"tempdir_no_autoclean: true\n", false},
+		{"tempdir_no_autoclean: false\n", true},
+	} {
+		tmpDef, tmpEnv := setupDefAndEnv(def, tc.env)
+		p, err := NewPipeline(tmpDef, tmpEnv, types.StringMap{}, types.StringSet{}, types.StringSet{})
+		os.Remove(tmpDef)
+		os.Remove(tmpEnv)
+		if err != nil {
+			t.Fatalf("Got unexpected error: %#v", err)
+		}
+		if p.Environment.TempDirNoAutoClean == tc.override {
+			t.Fatalf("Environment setting not read from '%s'", tc.env)
+		}
+		p.SetTempDirNoAutoClean(tc.override)
+		if p.Environment.TempDirNoAutoClean != tc.override {
+			t.Errorf("Incorrect tempdir_no_autoclean for '%s', got: '%t', wanted: '%t'", tc.env, p.Environment.TempDirNoAutoClean, tc.override)
+		}
+	}
+}
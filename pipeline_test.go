@@ -1,11 +1,13 @@
 package gantry_test
 
 import (
+	"bytes"
 	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/ad-freiburg/gantry"
@@ -149,6 +151,110 @@ func TestPipelineDefinitionPipelines(t *testing.T) {
 	}
 }
 
+func TestPipelineDefinitionPipelinesStages(t *testing.T) {
+	steps := gantry.StepList{
+		"a": gantry.Step{Service: gantry.Service{Name: "a"}},
+		"b": gantry.Step{Service: gantry.Service{Name: "b"}},
+		"c": gantry.Step{Service: gantry.Service{Name: "c"}, After: types.StringSet{"a": true}},
+		"d": gantry.Step{Service: gantry.Service{Name: "d"}},
+		"e": gantry.Step{Service: gantry.Service{Name: "e"}},
+	}
+	cases := []struct {
+		stages []gantry.Stage
+		err    string
+		result map[string]types.StringSet
+	}{
+		{
+			nil,
+			"",
+			map[string]types.StringSet{"a": {}, "b": {}, "c": {"a": true}, "d": {}, "e": {}},
+		},
+		{
+			[]gantry.Stage{{Name: "build", Steps: []string{"a", "b"}}, {Name: "test", Steps: []string{"c", "d"}}, {Name: "deploy", Steps: []string{"e"}}},
+			"",
+			map[string]types.StringSet{
+				"a": {},
+				"b": {},
+				"c": {"a": true, "b": true},
+				"d": {"a": true, "b": true},
+				"e": {"a": true, "b": true, "c": true, "d": true},
+			},
+		},
+		{
+			[]gantry.Stage{{Name: "test", Steps: []string{"b", "c"}}, {Name: "deploy", Steps: []string{"e"}}},
+			"",
+			map[string]types.StringSet{"a": {}, "b": {}, "c": {"a": true}, "d": {}, "e": {"b": true, "c": true}},
+		},
+		{
+			[]gantry.Stage{{Name: "build", Steps: []string{"x"}}},
+			"unknown step 'x' in stage 'build'",
+			nil,
+		},
+		{
+			[]gantry.Stage{{Name: "build", Steps: []string{"a"}}, {Name: "test", Steps: []string{"a"}}},
+			"step 'a' is part of stage 'build' and 'test'",
+			nil,
+		},
+		{
+			[]gantry.Stage{{Name: "build", Steps: []string{"c"}}, {Name: "test", Steps: []string{"a"}}},
+			"step 'c' of stage 'build' depends on 'a' of later stage 'test'",
+			nil,
+		},
+	}
+
+	for _, c := range cases {
+		definition := gantry.PipelineDefinition{Steps: gantry.StepList{}, Stages: c.stages}
+		for name, step := range steps {
+			definition.Steps[name] = step
+		}
+		r, err := definition.Pipelines()
+		if (err == nil && c.err != "") || (err != nil && err.Error() != c.err) {
+			t.Errorf("Incorrect error for '%v', got: '%v', wanted '%s'", c.stages, err, c.err)
+		}
+		if err != nil {
+			continue
+		}
+		for _, step := range r.AllSteps() {
+			if deps := step.Dependencies(); !reflect.DeepEqual(deps, c.result[step.Name]) {
+				t.Errorf("Incorrect dependencies of '%s' for '%v', got: '%v', wanted: '%v'", step.Name, c.stages, deps, c.result[step.Name])
+			}
+		}
+	}
+}
+
+func TestPlan(t *testing.T) {
+	steps := map[string]gantry.Step{
+		"a": gantry.Step{Service: gantry.Service{Name: "a"}},
+		"b": gantry.Step{Service: gantry.Service{Name: "b"}, After: types.StringSet{"a": true}},
+		"c": gantry.Step{Service: gantry.Service{Name: "c"}},
+	}
+	cases := []struct {
+		stages []gantry.Stage
+		err    string
+		result [][]string
+	}{
+		{nil, "", [][]string{{"c"}, {"a", "b"}}},
+		{[]gantry.Stage{{Name: "build", Steps: []string{"c"}}, {Name: "test", Steps: []string{"a"}}}, "", [][]string{{"c", "a", "b"}}},
+		{[]gantry.Stage{{Name: "build", Steps: []string{"x"}}}, "unknown step 'x' in stage 'build'", nil},
+	}
+
+	for _, c := range cases {
+		r, err := gantry.Plan(steps, c.stages)
+		if (err == nil && c.err != "") || (err != nil && err.Error() != c.err) {
+			t.Errorf("Incorrect error for '%v', got: '%v', wanted '%s'", c.stages, err, c.err)
+		}
+		if err != nil {
+			continue
+		}
+		if names := r.StepNames(); !reflect.DeepEqual(names, c.result) {
+			t.Errorf("Incorrect result for '%v', got: '%v', wanted: '%v'", c.stages, names, c.result)
+		}
+		if deps := steps["a"].Dependencies(); len(deps) != 0 {
+			t.Errorf("Plan modified the steps passed in for '%v', got dependencies: '%v'", c.stages, deps)
+		}
+	}
+}
+
 func TestPipelineIgnoreStepsFromMetaAndArgument(t *testing.T) {
 	tmpDef, err := ioutil.TempFile("", "def")
 	if err != nil {
@@ -277,3 +383,126 @@ func TestPipelineNewPipelineWithoutEnvironemntFile(t *testing.T) {
 		}
 	}
 }
+
+func TestPipelineBuildContextRelativeToDefinition(t *testing.T) {
+	dir, err := ioutil.TempDir("", "definition")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	other, err := ioutil.TempDir("", "cwd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(other)
+	definition := `version: "2.0"
+steps:
+  relative:
+    build:
+      context: ./app
+  dockerfile:
+    build:
+      dockerfile: Dockerfile.test
+  absolute:
+    build:
+      context: /srv/app
+  remote:
+    build:
+      context: https://github.com/ad-freiburg/gantry.git
+  image:
+    image: alpine
+    volumes:
+    - ./data:/data
+    - /srv/data:/srv
+`
+	defPath := filepath.Join(dir, gantry.GantryDef)
+	if err := ioutil.WriteFile(defPath, []byte(definition), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Invoke from another directory
+	if err := os.Chdir(other); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.Chdir(cwd); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	p, err := gantry.NewPipeline(defPath, "", types.StringMap{}, types.StringSet{}, types.StringSet{})
+	if err != nil {
+		t.Fatalf("unexpected error creating pipeline: '%#v'", err)
+	}
+	// Compare against the resolved directory as temporary directories may be
+	// symlinked
+	abs, err := filepath.Abs(defPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := map[string]string{
+		"relative":   filepath.Join(filepath.Dir(abs), "app"),
+		"dockerfile": filepath.Dir(abs),
+		"absolute":   "/srv/app",
+		"remote":     "https://github.com/ad-freiburg/gantry.git",
+		"image":      "",
+	}
+	for name, context := range cases {
+		if got := p.Definition.Steps[name].BuildInfo.Context; got != context {
+			t.Errorf("Incorrect context for '%s', got: '%s', wanted: '%s'", name, got, context)
+		}
+	}
+	// Bind-mount sources use the same base directory
+	args := strings.Join(p.Definition.Steps["image"].RunCommand("net"), " ")
+	for _, volume := range []string{filepath.Join(filepath.Dir(abs), "data") + ":/data", "/srv/data:/srv"} {
+		if !strings.Contains(args, "-v "+volume) {
+			t.Errorf("Missing volume '%s', got: '%s'", volume, args)
+		}
+	}
+}
+
+func TestRenderPipelineDefinition(t *testing.T) {
+	tmpDef, err := ioutil.TempFile("", "def")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.Remove(tmpDef.Name())
+	err = ioutil.WriteFile(tmpDef.Name(), []byte(`#! SET_IF_EMPTY ${IMAGE} alpine
+#! TEMP_DIR_IF_EMPTY ${DATA}
+version: "2.0"
+steps:
+  a:
+    image: ${IMAGE}
+    volumes:
+      - ${DATA}:/data
+    environment:
+      TAG: ${TAG}`), 0644)
+	if err != nil {
+		log.Fatal(err)
+	}
+	tag := "v1"
+	env, err := gantry.NewPipelineEnvironment("", types.StringMap{"TAG": &tag}, types.StringSet{}, types.StringSet{})
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+
+	var b bytes.Buffer
+	if err := gantry.RenderPipelineDefinition(&b, tmpDef.Name(), env); err != nil {
+		t.Fatalf("Got unexpected error: %#v", err)
+	}
+	// Temporary directories are not created
+	wanted := `version: "2.0"
+steps:
+  a:
+    image: alpine
+    volumes:
+      - dummy-tmp-dir:/data
+    environment:
+      TAG: v1
+`
+	if b.String() != wanted {
+		t.Errorf("Incorrect result, got: '%s', wanted: '%s'", b.String(), wanted)
+	}
+}
@@ -0,0 +1,95 @@
+package gantry // import "github.com/ad-freiburg/gantry"
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// portBinding stores the host side of a port mapping.
+type portBinding struct {
+	ip       string
+	start    int
+	end      int
+	protocol string
+}
+
+// parsePortBinding returns the host binding of a port mapping in the format
+// [[ip:]host:]container[/protocol]. If no host port is given, nil is returned
+// as docker selects a random port.
+func parsePortBinding(port string) (*portBinding, error) {
+	b := &portBinding{protocol: "tcp"}
+	if i := strings.LastIndex(port, "/"); i >= 0 {
+		b.protocol = port[i+1:]
+		port = port[:i]
+	}
+	i := strings.LastIndex(port, ":")
+	if i < 0 {
+		return nil, nil
+	}
+	host := port[:i]
+	if i = strings.LastIndex(host, ":"); i >= 0 {
+		b.ip = strings.Trim(host[:i], "[]")
+		host = host[i+1:]
+	}
+	if host == "" {
+		return nil, nil
+	}
+	parts := strings.SplitN(host, "-", 2)
+	var err error
+	if b.start, err = strconv.Atoi(parts[0]); err != nil {
+		return nil, fmt.Errorf("invalid host port '%s'", host)
+	}
+	b.end = b.start
+	if len(parts) > 1 {
+		if b.end, err = strconv.Atoi(parts[1]); err != nil || b.end < b.start {
+			return nil, fmt.Errorf("invalid host port range '%s'", host)
+		}
+	}
+	if b.ip == "0.0.0.0" {
+		b.ip = ""
+	}
+	return b, nil
+}
+
+// overlaps returns whether b and o bind at least one common host port.
+func (b portBinding) overlaps(o portBinding) bool {
+	if b.protocol != o.protocol {
+		return false
+	}
+	if b.ip != "" && o.ip != "" && b.ip != o.ip {
+		return false
+	}
+	return b.start <= o.end && o.start <= b.end
+}
+
+// checkPortBindings returns an error if host ports of steps overlap.
+func checkPortBindings(steps []Step) error {
+	type binding struct {
+		portBinding
+		port string
+		step Step
+	}
+	bindings := make([]binding, 0)
+	for _, step := range steps {
+		if step.Meta.Ignore {
+			continue
+		}
+		for _, port := range step.Ports {
+			b, err := parsePortBinding(port)
+			if err != nil {
+				return fmt.Errorf("%s for step '%s'", err, step.ColoredName())
+			}
+			if b == nil {
+				continue
+			}
+			for _, other := range bindings {
+				if other.overlaps(*b) {
+					return fmt.Errorf("host port binding '%s' of step '%s' conflicts with '%s' of step '%s'", port, step.ColoredName(), other.port, other.step.ColoredName())
+				}
+			}
+			bindings = append(bindings, binding{*b, port, step})
+		}
+	}
+	return nil
+}
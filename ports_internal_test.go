@@ -0,0 +1,63 @@
+package gantry
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParsePortBinding(t *testing.T) {
+	cases := []struct {
+		port   string
+		err    bool
+		result *portBinding
+	}{
+		{"80", false, nil},
+		{"8000-8010", false, nil},
+		{"127.0.0.1::80", false, nil},
+		{"8080:80", false, &portBinding{"", 8080, 8080, "tcp"}},
+		{"0.0.0.0:8080:80", false, &portBinding{"", 8080, 8080, "tcp"}},
+		{"127.0.0.1:8080:80/udp", false, &portBinding{"127.0.0.1", 8080, 8080, "udp"}},
+		{"[::1]:8080:80", false, &portBinding{"::1", 8080, 8080, "tcp"}},
+		{"9090-9091:8080-8081", false, &portBinding{"", 9090, 9091, "tcp"}},
+		{"http:80", true, nil},
+		{"9091-9090:80", true, nil},
+	}
+
+	for _, c := range cases {
+		r, err := parsePortBinding(c.port)
+		if (err != nil) != c.err {
+			t.Errorf("Incorrect error for '%s', got: '%v'", c.port, err)
+		}
+		if !reflect.DeepEqual(r, c.result) {
+			t.Errorf("Incorrect result for '%s', got: '%#v', wanted '%#v'", c.port, r, c.result)
+		}
+	}
+}
+
+func TestCheckPortBindings(t *testing.T) {
+	step := func(name string, ignore bool, ports ...string) Step {
+		return Step{Service: Service{Name: name, Ports: ports, Meta: ServiceMeta{Ignore: ignore}}}
+	}
+	cases := []struct {
+		steps []Step
+		err   bool
+	}{
+		{[]Step{step("a", false, "8080:80"), step("b", false, "8081:80")}, false},
+		{[]Step{step("a", false, "8080:80"), step("b", false, "8080:80")}, true},
+		{[]Step{step("a", false, "8080:80", "8080:81")}, true},
+		{[]Step{step("a", false, "8080:80"), step("b", true, "8080:80")}, false},
+		{[]Step{step("a", false, "8080:80"), step("b", false, "8080:80/udp")}, false},
+		{[]Step{step("a", false, "127.0.0.1:8080:80"), step("b", false, "127.0.0.2:8080:80")}, false},
+		{[]Step{step("a", false, "127.0.0.1:8080:80"), step("b", false, "8080:80")}, true},
+		{[]Step{step("a", false, "8000-8010:80"), step("b", false, "8005:80")}, true},
+		{[]Step{step("a", false, "8000-8010:80"), step("b", false, "8011-8020:80")}, false},
+		{[]Step{step("a", false, "80"), step("b", false, "80")}, false},
+	}
+
+	for i, c := range cases {
+		err := checkPortBindings(c.steps)
+		if (err != nil) != c.err {
+			t.Errorf("Incorrect error for case '%d', got: '%v'", i, err)
+		}
+	}
+}
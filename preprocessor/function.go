@@ -12,6 +12,8 @@ type Function struct {
 	NumArgsMin    int
 	NumArgsMax    int
 	NeedsVariable bool
+	// Variadic allows any number of arguments after NumArgsMin.
+	Variadic bool
 }
 
 // Check performs basic checks, e.g. to enforce correct number of arguments
@@ -22,7 +24,7 @@ func (f Function) Check(i Instruction) error {
 	if len(i.Arguments) < f.NumArgsMin {
 		return fmt.Errorf("missing argument(s) in %s for %s, wanted: %d, got: %d", i.Function, i.Variable, f.NumArgsMin, len(i.Arguments))
 	}
-	if len(i.Arguments) > f.NumArgsMax {
+	if !f.Variadic && len(i.Arguments) > f.NumArgsMax {
 		return fmt.Errorf("too many arguments in %s for %s, wanted: %d, got: %d", i.Function, i.Variable, f.NumArgsMax, len(i.Arguments))
 	}
 	return nil
@@ -60,6 +62,9 @@ func (f Function) Usage() string {
 		}
 		argline = fmt.Sprintf("%s ]", argline)
 	}
+	if f.Variadic {
+		argline = fmt.Sprintf("%s [ ARG%d ... ]", argline, argc)
+	}
 
 	result := ""
 	for i, name := range f.Names {
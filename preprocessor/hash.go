@@ -0,0 +1,36 @@
+package preprocessor
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io/ioutil"
+)
+
+// hashFunc returns a preprocessor function which replaces the variable by the
+// lowercase hex digest of its value, or of the contents of the file given as
+// first argument.
+func hashFunc(newHash func() hash.Hash) func(Instruction, Environment, bool) error {
+	return func(i Instruction, e Environment, dryRun bool) error {
+		data := []byte(value(i))
+		if len(i.Arguments) > 0 {
+			var err error
+			data, err = ioutil.ReadFile(i.Path(i.Arguments[0]))
+			if err != nil {
+				return fmt.Errorf("file error in %s for %s: err: '%s'", i.Function, i.Variable, err)
+			}
+		}
+		h := newHash()
+		h.Write(data)
+		result := hex.EncodeToString(h.Sum(nil))
+		e.SetSubstitution(i.Variable, &result)
+		return nil
+	}
+}
+
+var (
+	sha256Sum = hashFunc(sha256.New)
+	md5Sum    = hashFunc(md5.New)
+)
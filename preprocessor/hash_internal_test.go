@@ -0,0 +1,40 @@
+package preprocessor
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestHash(t *testing.T) {
+	checkTextFunction(t, "SHA256", sha256Sum, []textCase{
+		{str("foo"), nil, "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae", false},
+		{str(""), nil, "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", false},
+		{nil, nil, "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", false},
+	})
+	checkTextFunction(t, "MD5", md5Sum, []textCase{
+		{str("foo"), nil, "acbd18db4cc2f85cedef654fccc4a4d8", false},
+		{str(""), nil, "d41d8cd98f00b204e9800998ecf8427e", false},
+		{nil, nil, "d41d8cd98f00b204e9800998ecf8427e", false},
+	})
+}
+
+func TestHashFile(t *testing.T) {
+	f, err := ioutil.TempFile("", "gantry-hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.WriteString("foo"); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	checkTextFunction(t, "SHA256", sha256Sum, []textCase{
+		{str("ignored"), []string{f.Name()}, "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae", false},
+		{nil, []string{f.Name() + "-missing"}, "", true},
+	})
+	checkTextFunction(t, "MD5", md5Sum, []textCase{
+		{nil, []string{f.Name()}, "acbd18db4cc2f85cedef654fccc4a4d8", false},
+	})
+}
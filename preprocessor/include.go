@@ -0,0 +1,83 @@
+package preprocessor
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+func includeRaw(i Instruction, e Environment, dryRun bool) error {
+	data, err := ioutil.ReadFile(i.Path(i.Arguments[0]))
+	if err != nil {
+		return fmt.Errorf("file error in %s for %s: err: '%s'", i.Function, i.Variable, err)
+	}
+	result := string(data)
+	e.SetSubstitution(i.Variable, &result)
+	return nil
+}
+
+// IncludeUsage describes the `#! INCLUDE` statement, which is expanded before
+// any function is executed.
+const IncludeUsage = "#! INCLUDE PATH\n#! include PATH\nInserts the lines of file PATH, relative to the including file, fails on include cycles."
+
+// includeStatement returns the path of a `#! INCLUDE PATH` line.
+func includeStatement(line string) (string, bool) {
+	trimmed := strings.TrimSpace(line)
+	if !strings.HasPrefix(trimmed, "#!") {
+		return "", false
+	}
+	parts := strings.Fields(trimmed[2:])
+	if len(parts) != 2 || (parts[0] != "INCLUDE" && parts[0] != "include") {
+		return "", false
+	}
+	return parts[1], true
+}
+
+// position is the file and line number a line was read from.
+type position struct {
+	file string
+	line int
+}
+
+// expandIncludesAt replaces each `#! INCLUDE PATH` line by the lines of the
+// file at PATH, relative to dir. Includes are expanded recursively, chain
+// contains all files currently being included and is used to detect cycles.
+// The position of each resulting line is returned as well, lines are read
+// from file, included lines keep their position in the included file.
+func expandIncludesAt(lines []string, file string, dir string, chain []string) ([]string, []position, error) {
+	result := []string{}
+	positions := []position{}
+	for i, line := range lines {
+		path, ok := includeStatement(line)
+		if !ok {
+			result = append(result, line)
+			positions = append(positions, position{file, i + 1})
+			continue
+		}
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+		path = filepath.Clean(path)
+		for _, included := range chain {
+			if included == path {
+				return nil, nil, LineError{file, i + 1, fmt.Errorf("include cycle: %s", strings.Join(append(chain, path), " -> "))}
+			}
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, nil, LineError{file, i + 1, fmt.Errorf("file error in INCLUDE: err: '%s'", err)}
+		}
+		included, err := readLines(data)
+		if err != nil {
+			return nil, nil, err
+		}
+		included, includedPositions, err := expandIncludesAt(included, path, filepath.Dir(path), append(chain[:len(chain):len(chain)], path))
+		if err != nil {
+			return nil, nil, err
+		}
+		result = append(result, included...)
+		positions = append(positions, includedPositions...)
+	}
+	return result, positions, nil
+}
@@ -0,0 +1,60 @@
+package preprocessor
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeIncludeFiles(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "gantry-include")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestExpandIncludes(t *testing.T) {
+	dir := writeIncludeFiles(t, map[string]string{
+		"a.yml": "a: 1\n#! INCLUDE b.yml",
+		"b.yml": "#! SET_IF_EMPTY ${B} 2\nb: ${B}",
+	})
+	defer os.RemoveAll(dir)
+
+	lines := []string{"start", "  #! include a.yml", "#! INCLUDE_RAW ${X} a.yml", "end"}
+	result, _, err := expandIncludesAt(lines, "", dir, []string{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wanted := []string{"start", "a: 1", "#! SET_IF_EMPTY ${B} 2", "b: ${B}", "#! INCLUDE_RAW ${X} a.yml", "end"}
+	if !reflect.DeepEqual(result, wanted) {
+		t.Errorf("incorrect result, got: '%v', wanted: '%v'", result, wanted)
+	}
+}
+
+func TestExpandIncludesCycle(t *testing.T) {
+	dir := writeIncludeFiles(t, map[string]string{
+		"a.yml": "#! INCLUDE b.yml",
+		"b.yml": "#! INCLUDE a.yml",
+	})
+	defer os.RemoveAll(dir)
+
+	_, _, err := expandIncludesAt([]string{"#! INCLUDE a.yml"}, "", dir, []string{})
+	a := filepath.Join(dir, "a.yml")
+	b := filepath.Join(dir, "b.yml")
+	wanted := b + ":1: include cycle: " + a + " -> " + b + " -> " + a
+	if err == nil || err.Error() != wanted {
+		t.Errorf("incorrect error, got: '%v', wanted: '%s'", err, wanted)
+	}
+
+	if _, _, err := expandIncludesAt([]string{"#! INCLUDE missing.yml"}, "", dir, []string{}); err == nil {
+		t.Errorf("expected error for missing file")
+	}
+}
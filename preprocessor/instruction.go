@@ -2,6 +2,7 @@ package preprocessor
 
 import (
 	"fmt"
+	"path/filepath"
 	"strings"
 )
 
@@ -12,6 +13,8 @@ type Instruction struct {
 	Arguments         []string
 	CurrentValue      *string
 	CurrentValueFound bool
+	// Dir is the directory relative paths in arguments are resolved against.
+	Dir string
 }
 
 // NewInstruction parses a line and looks up the current value from the environment
@@ -38,3 +41,11 @@ func NewInstruction(line string, env Environment) (Instruction, error) {
 	result.CurrentValue, result.CurrentValueFound = env.GetSubstitution(result.Variable)
 	return result, nil
 }
+
+// Path resolves path against the directory of i if it is relative.
+func (i Instruction) Path(path string) string {
+	if filepath.IsAbs(path) || i.Dir == "" {
+		return path
+	}
+	return filepath.Join(i.Dir, path)
+}
@@ -65,11 +65,36 @@ func tempDirIfEmpty(i Instruction, e Environment, dryRun bool) error {
 	return nil
 }
 
+// LineError is returned if a statement fails, it names the file and the line
+// of the statement. Statements of included files report the included file.
+type LineError struct {
+	File string
+	Line int
+	Err  error
+}
+
+// Error returns the string representation of the error.
+func (e LineError) Error() string {
+	if e.File == "" {
+		return fmt.Sprintf("line %d: %s", e.Line, e.Err)
+	}
+	return fmt.Sprintf("%s:%d: %s", e.File, e.Line, e.Err)
+}
+
+// Unwrap returns the original error.
+func (e LineError) Unwrap() error {
+	return e.Err
+}
+
 // Preprocessor preprocesses yml files and manipulates the environment.
 type Preprocessor struct {
 	mapping   map[string]*Function
 	functions []*Function
 	DryRun    bool
+	// Dir is used to resolve relative paths, defaults to the working directory.
+	Dir string
+	// File is the name of the processed file reported in errors.
+	File string
 }
 
 // NewPreprocessor returns a new Preprocessor with basic functions preregistered.
@@ -93,6 +118,8 @@ func NewPreprocessor() (Preprocessor, error) {
 		Names: []string{
 			"SET_IF_EMPTY",
 			"set_if_empty",
+			"DEFAULT",
+			"default",
 		},
 		NeedsVariable: true,
 		NumArgsMin:    1,
@@ -113,6 +140,152 @@ func NewPreprocessor() (Preprocessor, error) {
 	}); err != nil {
 		return p, err
 	}
+	if err := p.Register(&Function{
+		Names: []string{
+			"INDENT",
+			"indent",
+		},
+		NeedsVariable: true,
+		NumArgsMin:    1,
+		NumArgsMax:    1,
+		Func:          indent,
+		Description:   "Indents each non-empty line of ${VAR} by ARG0 spaces.",
+	}); err != nil {
+		return p, err
+	}
+	if err := p.Register(&Function{
+		Names: []string{
+			"TO_YAML",
+			"to_yaml",
+		},
+		NeedsVariable: true,
+		Func:          toYaml,
+		Description:   "Converts ${VAR} to a yaml string, quoting or using a block if needed.",
+	}); err != nil {
+		return p, err
+	}
+	if err := p.Register(&Function{
+		Names: []string{
+			"TRIM",
+			"trim",
+		},
+		NeedsVariable: true,
+		NumArgsMax:    1,
+		Func:          trim,
+		Description:   "Removes leading and trailing whitespace, or all characters in ARG0, from ${VAR}.",
+	}); err != nil {
+		return p, err
+	}
+	if err := p.Register(&Function{
+		Names: []string{
+			"REPLACE",
+			"replace",
+		},
+		NeedsVariable: true,
+		NumArgsMin:    1,
+		NumArgsMax:    2,
+		Func:          replace,
+		Description:   "Replaces all occurrences of ARG0 in ${VAR} by ARG1, removes them if ARG1 is omitted.",
+	}); err != nil {
+		return p, err
+	}
+	if err := p.Register(&Function{
+		Names: []string{
+			"SPLIT",
+			"split",
+		},
+		NeedsVariable: true,
+		NumArgsMin:    1,
+		NumArgsMax:    1,
+		Func:          split,
+		Description:   "Splits ${VAR} at each ARG0 into ${VAR_0}, ${VAR_1}, ... and stores the number of parts in ${VAR_COUNT}.",
+	}); err != nil {
+		return p, err
+	}
+	if err := p.Register(&Function{
+		Names: []string{
+			"COALESCE",
+			"coalesce",
+		},
+		NeedsVariable: true,
+		NumArgsMin:    1,
+		Variadic:      true,
+		Func:          coalesce,
+		Description:   "Sets ${VAR} to the first non-empty argument, arguments like ${OTHER} are replaced by their value.",
+	}); err != nil {
+		return p, err
+	}
+	if err := p.Register(&Function{
+		Names: []string{
+			"REQUIRED",
+			"required",
+		},
+		NeedsVariable: true,
+		Variadic:      true,
+		Func:          required,
+		Description:   "Aborts with the message given as arguments if ${VAR} is empty or not set.",
+	}); err != nil {
+		return p, err
+	}
+	if err := p.Register(&Function{
+		Names: []string{
+			"SHA256",
+			"sha256",
+		},
+		NeedsVariable: true,
+		NumArgsMax:    1,
+		Func:          sha256Sum,
+		Description:   "Replaces ${VAR} by the hex encoded sha256 digest of its value or of the contents of file ARG0.",
+	}); err != nil {
+		return p, err
+	}
+	if err := p.Register(&Function{
+		Names: []string{
+			"MD5",
+			"md5",
+		},
+		NeedsVariable: true,
+		NumArgsMax:    1,
+		Func:          md5Sum,
+		Description:   "Replaces ${VAR} by the hex encoded md5 digest of its value or of the contents of file ARG0.",
+	}); err != nil {
+		return p, err
+	}
+	if err := p.Register(&Function{
+		Names: []string{
+			"INCLUDE_RAW",
+			"include_raw",
+		},
+		NeedsVariable: true,
+		NumArgsMin:    1,
+		NumArgsMax:    1,
+		Func:          includeRaw,
+		Description:   "Sets ${VAR} to the unprocessed contents of file ARG0, relative to the definition file.",
+	}); err != nil {
+		return p, err
+	}
+	if err := p.Register(&Function{
+		Names: []string{
+			"INT",
+			"int",
+		},
+		NeedsVariable: true,
+		Func:          toInt,
+		Description:   "Normalizes ${VAR} to a decimal integer, aborts execution if it is not an integer.",
+	}); err != nil {
+		return p, err
+	}
+	if err := p.Register(&Function{
+		Names: []string{
+			"BOOL",
+			"bool",
+		},
+		NeedsVariable: true,
+		Func:          toBool,
+		Description:   "Normalizes ${VAR} to true or false, accepts 1/0, true/false, yes/no, y/n, on/off and empty, aborts execution otherwise.",
+	}); err != nil {
+		return p, err
+	}
 	return p, nil
 }
 
@@ -135,29 +308,17 @@ func (p Preprocessor) Functions() []*Function {
 
 // Process processes a raw file with a given environment.
 func (p Preprocessor) Process(rawFile []byte, env Environment) ([]byte, error) {
-	// Parse bytes as lines
-	var lines []string
-	var lineBytesBuffer bytes.Buffer
-	r := bufio.NewReader(bytes.NewReader(rawFile))
-	for {
-		lineBytes, prefix, err := r.ReadLine()
-		if err != nil {
-			if err == io.EOF {
-				break
-			}
-			return []byte(""), err
-		}
-		lineBytesBuffer.Write(lineBytes)
-		// Line continues, continue reading before storing
-		if prefix {
-			continue
-		}
-		lines = append(lines, lineBytesBuffer.String())
-		lineBytesBuffer.Reset()
+	lines, err := readLines(rawFile)
+	if err != nil {
+		return []byte(""), err
+	}
+	lines, positions, err := expandIncludesAt(lines, p.File, p.Dir, []string{})
+	if err != nil {
+		return []byte(""), err
 	}
 	// Run preprocessor steps
-	preprocessor, normal := extractPreprocessorLines(lines)
-	if err := p.processPreprocessorLines(preprocessor, env); err != nil {
+	preprocessor, preprocessorPositions, normal := extractPreprocessorLinesAt(lines, positions)
+	if err := p.processPreprocessorLinesAt(preprocessor, preprocessorPositions, env); err != nil {
 		return []byte(""), err
 	}
 	lines = expandVariables(normal, env)
@@ -178,29 +339,68 @@ func (p Preprocessor) Process(rawFile []byte, env Environment) ([]byte, error) {
 	return b.Bytes(), nil
 }
 
-// processPreprocessorLines executes each `#!` line
-func (p Preprocessor) processPreprocessorLines(lines []string, env Environment) error {
-	for _, line := range lines {
-		instruction, err := NewInstruction(line, env)
+// readLines parses bytes as lines.
+func readLines(data []byte) ([]string, error) {
+	var lines []string
+	var lineBytesBuffer bytes.Buffer
+	r := bufio.NewReader(bytes.NewReader(data))
+	for {
+		lineBytes, prefix, err := r.ReadLine()
 		if err != nil {
-			return err
+			if err == io.EOF {
+				break
+			}
+			return lines, err
+		}
+		lineBytesBuffer.Write(lineBytes)
+		// Line continues, continue reading before storing
+		if prefix {
+			continue
 		}
-		if f, ok := p.mapping[instruction.Function]; ok {
-			if err := f.Execute(instruction, env, p.DryRun); err != nil {
-				return err
+		lines = append(lines, lineBytesBuffer.String())
+		lineBytesBuffer.Reset()
+	}
+	return lines, nil
+}
+
+// processPreprocessorLinesAt executes each `#!` line, errors are wrapped in a
+// LineError if the positions of the lines are given.
+func (p Preprocessor) processPreprocessorLinesAt(lines []string, positions []position, env Environment) error {
+	for i, line := range lines {
+		if err := p.processPreprocessorLine(line, env); err != nil {
+			if positions != nil {
+				return LineError{positions[i].file, positions[i].line, err}
 			}
-		} else {
-			return fmt.Errorf("unknown preprocessor directive: '%s'", instruction.Function)
+			return err
 		}
 	}
 	return nil
 }
 
-// extractPreprocessorLines splits lines in two lists, preprocessor instructions and normal lines.
-func extractPreprocessorLines(lines []string) ([]string, []string) {
+func (p Preprocessor) processPreprocessorLine(line string, env Environment) error {
+	instruction, err := NewInstruction(line, env)
+	if err != nil {
+		return err
+	}
+	instruction.Dir = p.Dir
+	f, ok := p.mapping[instruction.Function]
+	if !ok {
+		return fmt.Errorf("unknown preprocessor directive: '%s'", instruction.Function)
+	}
+	return f.Execute(instruction, env, p.DryRun)
+}
+
+// extractPreprocessorLinesAt splits lines in two lists, preprocessor
+// instructions and normal lines, and additionally returns the positions of
+// the instructions, nil if positions is nil.
+func extractPreprocessorLinesAt(lines []string, positions []position) ([]string, []position, []string) {
 	preprocessor := []string{}
+	var preprocessorPositions []position
+	if positions != nil {
+		preprocessorPositions = []position{}
+	}
 	normal := []string{}
-	for _, line := range lines {
+	for i, line := range lines {
 		trimmed := strings.TrimSpace(line)
 		if len(trimmed) < 2 || trimmed[0] != '#' {
 			normal = append(normal, line)
@@ -210,8 +410,11 @@ func extractPreprocessorLines(lines []string) ([]string, []string) {
 			continue
 		}
 		preprocessor = append(preprocessor, strings.TrimSpace(trimmed[2:]))
+		if positions != nil {
+			preprocessorPositions = append(preprocessorPositions, positions[i])
+		}
 	}
-	return preprocessor, normal
+	return preprocessor, preprocessorPositions, normal
 }
 
 // expandVariables expands variables in all lines
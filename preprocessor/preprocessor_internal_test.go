@@ -67,7 +67,7 @@ func TestExtractPreprocessorStatements(t *testing.T) {
 		"  inner2:",
 		"    value3",
 	}
-	rs, rl := extractPreprocessorLines(input)
+	rs, _, rl := extractPreprocessorLinesAt(input, nil)
 	if len(rs) != len(statements) {
 		t.Errorf("incorrect number of statements, got: %d, wanted: %d", len(rs), len(statements))
 		return
@@ -430,7 +430,7 @@ func TestProcessPreprocessorLines(t *testing.T) {
 			"EMPTY":   &empty,
 			"TEMPDIR": &tempDir,
 		}
-		err := preprocessor.processPreprocessorLines([]string{c.line}, &env)
+		err := preprocessor.processPreprocessorLinesAt([]string{c.line}, nil, &env)
 		if len(c.errorMessage) > 0 {
 			if err == nil {
 				t.Errorf("expected error @%d, got nil", i)
@@ -1,10 +1,12 @@
 package preprocessor_test
 
 import (
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/ad-freiburg/gantry"
@@ -42,6 +44,7 @@ func TestPreprocessorRegister(t *testing.T) {
 
 func TestPreprocessorProcess(t *testing.T) {
 	bar := barValue
+	block := "a: 1\nb: 2"
 	cases := []struct {
 		in            string
 		out           string
@@ -95,6 +98,14 @@ ${EMPTY}`,
   Foo: Baz`,
 			types.StringMap{"Foo": &bar},
 		},
+		{
+			`#! INDENT ${Block} 2
+config:
+${Block}`,
+			"config:\n  a: 1\n  b: 2",
+			"",
+			types.StringMap{"Block": &block},
+		},
 	}
 	preprocessor, err := preprocessor.NewPreprocessor()
 	if err != nil {
@@ -134,6 +145,40 @@ ${EMPTY}`,
 	}
 }
 
+func TestPreprocessorProcessIncludeRaw(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gantry-include")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "script.sh"), []byte("echo ${HOME} {{ .Foo }}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := preprocessor.NewPreprocessor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Dir = dir
+	e, err := gantry.NewPipelineEnvironment("", types.StringMap{}, types.StringSet{}, types.StringSet{})
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	in := "#! INCLUDE_RAW ${Script} script.sh\nscript: ${Script}"
+	out := "script: echo ${HOME} {{ .Foo }}"
+	res, err := p.Process([]byte(in), e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(res) != out {
+		t.Errorf("incorrect transformation of '%s': got: '%s', wanted: '%s'", in, res, out)
+	}
+
+	if _, err := p.Process([]byte("#! INCLUDE_RAW ${Script} missing.sh"), e); err == nil {
+		t.Errorf("expected error for missing file")
+	}
+}
+
 func TestPreprocessorProcessErrors(t *testing.T) {
 	cases := []struct {
 		in            string
@@ -145,14 +190,21 @@ func TestPreprocessorProcessErrors(t *testing.T) {
 		{
 			"#! UNKNOWN",
 			"",
-			"unknown preprocessor directive: 'UNKNOWN'",
+			"line 1: unknown preprocessor directive: 'UNKNOWN'",
 			"",
 			types.StringMap{},
 		},
 		{
 			"#! DEFECTIVE_CHECK",
 			"",
-			"missing argument(s) in DEFECTIVE_CHECK for , wanted: 1, got: 0",
+			"line 1: missing argument(s) in DEFECTIVE_CHECK for , wanted: 1, got: 0",
+			"",
+			types.StringMap{},
+		},
+		{
+			"a: 1\n# comment\n#! DEFECTIVE_CHECK",
+			"",
+			"line 3: missing argument(s) in DEFECTIVE_CHECK for , wanted: 1, got: 0",
 			"",
 			types.StringMap{},
 		},
@@ -226,3 +278,46 @@ func TestPreprocessorFunctions(t *testing.T) {
 		t.Errorf("incorrect number of functions, got: %d, wanted: %d", updatedNumFunctions, numFunctions+1)
 	}
 }
+
+func TestPreprocessorProcessLineError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gantry-line-error")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	included := filepath.Join(dir, "included.yml")
+	if err := ioutil.WriteFile(included, []byte("b: 2\n#! REQUIRED ${GANTRY_TEST_MISSING} missing\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	preproc, err := preprocessor.NewPreprocessor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	preproc.Dir = dir
+	preproc.File = "pipeline.yml"
+	e, _ := gantry.NewPipelineEnvironment("", types.StringMap{}, types.StringSet{}, types.StringSet{})
+
+	cases := []struct {
+		in   string
+		file string
+		line int
+	}{
+		{"a: 1\n#! UNKNOWN", "pipeline.yml", 2},
+		{"a: 1\n#! INCLUDE included.yml\nc: 3", included, 2},
+		{"a: 1\n\n#! INCLUDE missing.yml", "pipeline.yml", 3},
+	}
+	for _, c := range cases {
+		_, err := preproc.Process([]byte(c.in), e)
+		var lineErr preprocessor.LineError
+		if !errors.As(err, &lineErr) {
+			t.Errorf("expected LineError for '%s', got: %#v", c.in, err)
+			continue
+		}
+		if lineErr.File != c.file || lineErr.Line != c.line {
+			t.Errorf("incorrect position for '%s', got: %s:%d, wanted: %s:%d", c.in, lineErr.File, lineErr.Line, c.file, c.line)
+		}
+		if errors.Unwrap(err) == nil {
+			t.Errorf("original error missing for '%s'", c.in)
+		}
+	}
+}
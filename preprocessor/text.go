@@ -0,0 +1,132 @@
+package preprocessor
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/ad-freiburg/gantry/types"
+	"github.com/ghodss/yaml"
+)
+
+// value returns the current value of the variable of i, an empty string if
+// it is not set.
+func value(i Instruction) string {
+	if i.CurrentValueFound && i.CurrentValue != nil {
+		return *i.CurrentValue
+	}
+	return ""
+}
+
+func indent(i Instruction, e Environment, dryRun bool) error {
+	n, err := strconv.Atoi(i.Arguments[0])
+	if err != nil || n < 0 {
+		return fmt.Errorf("invalid indentation in %s for %s: '%s'", i.Function, i.Variable, i.Arguments[0])
+	}
+	padding := strings.Repeat(" ", n)
+	lines := strings.Split(value(i), "\n")
+	for j, line := range lines {
+		if len(line) > 0 {
+			lines[j] = padding + line
+		}
+	}
+	result := strings.Join(lines, "\n")
+	e.SetSubstitution(i.Variable, &result)
+	return nil
+}
+
+func toYaml(i Instruction, e Environment, dryRun bool) error {
+	data, err := yaml.Marshal(value(i))
+	if err != nil {
+		return fmt.Errorf("yaml error in %s for %s: err: '%s'", i.Function, i.Variable, err)
+	}
+	result := strings.TrimSuffix(string(data), "\n")
+	e.SetSubstitution(i.Variable, &result)
+	return nil
+}
+
+func trim(i Instruction, e Environment, dryRun bool) error {
+	result := strings.TrimSpace(value(i))
+	if len(i.Arguments) > 0 {
+		result = strings.Trim(value(i), i.Arguments[0])
+	}
+	e.SetSubstitution(i.Variable, &result)
+	return nil
+}
+
+func replace(i Instruction, e Environment, dryRun bool) error {
+	replacement := ""
+	if len(i.Arguments) > 1 {
+		replacement = i.Arguments[1]
+	}
+	result := strings.ReplaceAll(value(i), i.Arguments[0], replacement)
+	e.SetSubstitution(i.Variable, &result)
+	return nil
+}
+
+func split(i Instruction, e Environment, dryRun bool) error {
+	parts := strings.Split(value(i), i.Arguments[0])
+	for j := range parts {
+		e.SetSubstitution(fmt.Sprintf("%s_%d", i.Variable, j), &parts[j])
+	}
+	count := strconv.Itoa(len(parts))
+	e.SetSubstitution(fmt.Sprintf("%s_COUNT", i.Variable), &count)
+	return nil
+}
+
+// argument returns the value of the variable referenced by arg if it has the
+// form ${VAR}, otherwise arg itself.
+func argument(arg string, e Environment) string {
+	if !strings.HasPrefix(arg, "${") || !strings.HasSuffix(arg, "}") {
+		return arg
+	}
+	if v, ok := e.GetSubstitution(arg[2 : len(arg)-1]); ok && v != nil {
+		return *v
+	}
+	return ""
+}
+
+func coalesce(i Instruction, e Environment, dryRun bool) error {
+	result := ""
+	for _, arg := range i.Arguments {
+		if result = argument(arg, e); result != "" {
+			break
+		}
+	}
+	e.SetSubstitution(i.Variable, &result)
+	return nil
+}
+
+func required(i Instruction, e Environment, dryRun bool) error {
+	if value(i) != "" {
+		return nil
+	}
+	state := "empty"
+	if !i.CurrentValueFound {
+		state = "not set"
+	}
+	if len(i.Arguments) == 0 {
+		return fmt.Errorf("%s failed for %s: variable is %s", i.Function, i.Variable, state)
+	}
+	return fmt.Errorf("%s failed for %s: variable is %s: %s", i.Function, i.Variable, state, strings.Join(i.Arguments, " "))
+}
+
+func toInt(i Instruction, e Environment, dryRun bool) error {
+	n, err := strconv.ParseInt(strings.TrimSpace(value(i)), 0, 64)
+	if err != nil {
+		return fmt.Errorf("invalid integer in %s for %s: '%s'", i.Function, i.Variable, value(i))
+	}
+	result := strconv.FormatInt(n, 10)
+	e.SetSubstitution(i.Variable, &result)
+	return nil
+}
+
+func toBool(i Instruction, e Environment, dryRun bool) error {
+	parsed, err := types.ParseBool(value(i))
+	if err != nil {
+		return fmt.Errorf("invalid boolean in %s for %s: '%s'", i.Function, i.Variable, value(i))
+	}
+	result := strconv.FormatBool(parsed)
+	e.SetSubstitution(i.Variable, &result)
+	return nil
+}
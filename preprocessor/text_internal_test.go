@@ -0,0 +1,199 @@
+package preprocessor
+
+import (
+	"fmt"
+	"strconv"
+	"testing"
+)
+
+type textCase struct {
+	value  *string
+	args   []string
+	result string
+	err    bool
+}
+
+func checkTextFunction(t *testing.T, name string, f func(Instruction, Environment, bool) error, cases []textCase) {
+	for i, c := range cases {
+		env := testEnv{}
+		if c.value != nil {
+			env["VAR"] = c.value
+		}
+		current, found := env.GetSubstitution("VAR")
+		err := f(Instruction{
+			Function:          name,
+			Variable:          "VAR",
+			Arguments:         c.args,
+			CurrentValue:      current,
+			CurrentValueFound: found,
+		}, env, false)
+		if (err != nil) != c.err {
+			t.Errorf("incorrect error for %s @%d, got: '%v', wanted error: %t", name, i, err, c.err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if r := env["VAR"]; r == nil || *r != c.result {
+			t.Errorf("incorrect result for %s @%d, got: '%v', wanted: '%s'", name, i, r, c.result)
+		}
+	}
+}
+
+func str(s string) *string {
+	return &s
+}
+
+func TestIndent(t *testing.T) {
+	checkTextFunction(t, "INDENT", indent, []textCase{
+		{str("a"), []string{"2"}, "  a", false},
+		{str("a:\n  b: c\n\nd: e\n"), []string{"4"}, "    a:\n      b: c\n\n    d: e\n", false},
+		{str("a"), []string{"0"}, "a", false},
+		{nil, []string{"2"}, "", false},
+		{str("a"), []string{"-1"}, "", true},
+		{str("a"), []string{"two"}, "", true},
+	})
+}
+
+func TestToYaml(t *testing.T) {
+	checkTextFunction(t, "TO_YAML", toYaml, []textCase{
+		{str("plain"), nil, "plain", false},
+		{str("key: value"), nil, "'key: value'", false},
+		{str("1"), nil, "\"1\"", false},
+		{str("a\nb"), nil, "|-\n  a\n  b", false},
+		{nil, nil, "\"\"", false},
+	})
+}
+
+func TestTrim(t *testing.T) {
+	checkTextFunction(t, "TRIM", trim, []textCase{
+		{str("  a b \n"), nil, "a b", false},
+		{str("/path/"), []string{"/"}, "path", false},
+		{str("xxaxx"), []string{"x"}, "a", false},
+		{nil, nil, "", false},
+	})
+}
+
+func TestReplace(t *testing.T) {
+	checkTextFunction(t, "REPLACE", replace, []textCase{
+		{str("a-b-c"), []string{"-", "_"}, "a_b_c", false},
+		{str("a-b-c"), []string{"-"}, "abc", false},
+		{str("abc"), []string{"x", "y"}, "abc", false},
+		{nil, []string{"x", "y"}, "", false},
+	})
+}
+
+func TestSplit(t *testing.T) {
+	cases := []struct {
+		value  string
+		sep    string
+		result []string
+	}{
+		{"a,b,c", ",", []string{"a", "b", "c"}},
+		{"a", ",", []string{"a"}},
+		{"", ",", []string{""}},
+		{"a::b", "::", []string{"a", "b"}},
+	}
+
+	for _, c := range cases {
+		env := testEnv{}
+		err := split(Instruction{
+			Function:          "SPLIT",
+			Variable:          "VAR",
+			Arguments:         []string{c.sep},
+			CurrentValue:      &c.value,
+			CurrentValueFound: true,
+		}, env, false)
+		if err != nil {
+			t.Errorf("unexpected error for '%s', got: %s", c.value, err)
+		}
+		if r := env["VAR_COUNT"]; r == nil || *r != strconv.Itoa(len(c.result)) {
+			t.Errorf("incorrect count for '%s', got: '%v', wanted: '%d'", c.value, r, len(c.result))
+		}
+		for i, part := range c.result {
+			if r := env[fmt.Sprintf("VAR_%d", i)]; r == nil || *r != part {
+				t.Errorf("incorrect part @%d for '%s', got: '%v', wanted: '%s'", i, c.value, r, part)
+			}
+		}
+	}
+}
+
+func TestCoalesce(t *testing.T) {
+	empty := ""
+	foo := "foo"
+	env := testEnv{"EMPTY": &empty, "NIL": nil, "FOO": &foo}
+	cases := []struct {
+		args   []string
+		result string
+	}{
+		{[]string{"${EMPTY}", "${NIL}", "${UNDEFINED}", "${FOO}", "bar"}, "foo"},
+		{[]string{"${EMPTY}", "bar", "${FOO}"}, "bar"},
+		{[]string{"${EMPTY}", "${NIL}"}, ""},
+		{[]string{"$FOO"}, "$FOO"},
+	}
+
+	for _, c := range cases {
+		err := coalesce(Instruction{
+			Function:  "COALESCE",
+			Variable:  "VAR",
+			Arguments: c.args,
+		}, env, false)
+		if err != nil {
+			t.Errorf("unexpected error for '%v', got: %s", c.args, err)
+		}
+		if r := env["VAR"]; r == nil || *r != c.result {
+			t.Errorf("incorrect result for '%v', got: '%v', wanted: '%s'", c.args, r, c.result)
+		}
+	}
+}
+
+func TestRequired(t *testing.T) {
+	checkTextFunction(t, "REQUIRED", required, []textCase{
+		{str("foo"), nil, "foo", false},
+		{str("foo"), []string{"please", "set", "VAR"}, "foo", false},
+		{str(""), nil, "", true},
+		{nil, []string{"please", "set", "VAR"}, "", true},
+	})
+
+	err := required(Instruction{
+		Function:  "REQUIRED",
+		Variable:  "VAR",
+		Arguments: []string{"please", "set", "VAR"},
+	}, testEnv{}, false)
+	wanted := "REQUIRED failed for VAR: variable is not set: please set VAR"
+	if err == nil || err.Error() != wanted {
+		t.Errorf("incorrect error, got: '%v', wanted: '%s'", err, wanted)
+	}
+}
+
+func TestToInt(t *testing.T) {
+	checkTextFunction(t, "INT", toInt, []textCase{
+		{str("42"), nil, "42", false},
+		{str(" -7\n"), nil, "-7", false},
+		{str("0x10"), nil, "16", false},
+		{str("007"), nil, "7", false},
+		{str(""), nil, "", true},
+		{nil, nil, "", true},
+		{str("4.2"), nil, "", true},
+		{str("abc"), nil, "", true},
+	})
+}
+
+func TestToBool(t *testing.T) {
+	checkTextFunction(t, "BOOL", toBool, []textCase{
+		{str("1"), nil, "true", false},
+		{str("True"), nil, "true", false},
+		{str("yes"), nil, "true", false},
+		{str("Y"), nil, "true", false},
+		{str(" on "), nil, "true", false},
+		{str("0"), nil, "false", false},
+		{str("FALSE"), nil, "false", false},
+		{str("no"), nil, "false", false},
+		{str("n"), nil, "false", false},
+		{str("off"), nil, "false", false},
+		{str(""), nil, "false", false},
+		{nil, nil, "false", false},
+		{str("maybe"), nil, "", true},
+		{str("2"), nil, "", true},
+	})
+}
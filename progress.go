@@ -0,0 +1,128 @@
+package gantry // import "github.com/ad-freiburg/gantry"
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"time"
+)
+
+// Modes of printing the progress of builds and pulls.
+const (
+	// ProgressAuto keeps the normal output of docker.
+	ProgressAuto = "auto"
+	// ProgressPlain passes --progress=plain to builds, which also works with
+	// the classic builder.
+	ProgressPlain = "plain"
+	// ProgressSummary builds with --progress=rawjson, which requires
+	// BuildKit, and prints one line per finished build step or pulled layer.
+	ProgressSummary = "summary"
+)
+
+// pullLayerRegexp matches the status lines of single layers printed by docker
+// pull.
+var pullLayerRegexp = regexp.MustCompile(`^([0-9a-f]{12}): (.*)$`)
+
+// CheckProgress returns an error if mode is not a known progress mode.
+func CheckProgress(mode string) error {
+	switch mode {
+	case ProgressAuto, ProgressPlain, ProgressSummary:
+		return nil
+	}
+	return fmt.Errorf("invalid progress '%s', use '%s', '%s' or '%s'", mode, ProgressAuto, ProgressPlain, ProgressSummary)
+}
+
+// buildStatus is the part of a status of BuildKit's rawjson progress needed
+// for the summary.
+type buildStatus struct {
+	Vertexes []struct {
+		Digest    string     `json:"digest"`
+		Name      string     `json:"name"`
+		Started   *time.Time `json:"started"`
+		Completed *time.Time `json:"completed"`
+		Cached    bool       `json:"cached"`
+		Error     string     `json:"error"`
+	} `json:"vertexes"`
+}
+
+// progressSummary condenses progress written to it to one line per finished
+// build step or layer and writes these to target. Other lines, e.g. the
+// digest of a pulled image, are passed on.
+type progressSummary struct {
+	target   io.Writer
+	pending  []byte
+	finished map[string]bool
+}
+
+func newProgressSummary(target io.Writer) *progressSummary {
+	return &progressSummary{
+		target:   target,
+		finished: map[string]bool{},
+	}
+}
+
+// Write summarizes all complete lines of b, the rest is held back until the
+// next call of Write or Flush.
+func (p *progressSummary) Write(b []byte) (int, error) {
+	p.pending = append(p.pending, b...)
+	for {
+		i := bytes.IndexByte(p.pending, '\n')
+		if i < 0 {
+			return len(b), nil
+		}
+		line := p.pending[:i]
+		p.pending = p.pending[i+1:]
+		if err := p.summarize(line); err != nil {
+			return len(b), err
+		}
+	}
+}
+
+// Flush summarizes data held back by Write.
+func (p *progressSummary) Flush() error {
+	if len(p.pending) == 0 {
+		return nil
+	}
+	line := p.pending
+	p.pending = nil
+	return p.summarize(line)
+}
+
+func (p *progressSummary) summarize(line []byte) error {
+	status := buildStatus{}
+	if bytes.HasPrefix(line, []byte("{")) && json.Unmarshal(line, &status) == nil {
+		for _, v := range status.Vertexes {
+			if v.Completed == nil || p.finished[v.Digest] {
+				continue
+			}
+			p.finished[v.Digest] = true
+			var err error
+			switch {
+			case v.Error != "":
+				_, err = fmt.Fprintf(p.target, "%s: error: %s\n", v.Name, v.Error)
+			case v.Cached:
+				_, err = fmt.Fprintf(p.target, "%s: cached\n", v.Name)
+			case v.Started != nil:
+				_, err = fmt.Fprintf(p.target, "%s: done in %s\n", v.Name, v.Completed.Sub(*v.Started).Round(100*time.Millisecond))
+			default:
+				_, err = fmt.Fprintf(p.target, "%s: done\n", v.Name)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if m := pullLayerRegexp.FindSubmatch(line); m != nil {
+		switch string(m[2]) {
+		case "Pull complete", "Already exists":
+			_, err := fmt.Fprintf(p.target, "layer %s: %s\n", m[1], bytes.ToLower(m[2]))
+			return err
+		}
+		return nil
+	}
+	_, err := fmt.Fprintf(p.target, "%s\n", line)
+	return err
+}
@@ -0,0 +1,66 @@
+package gantry // import "github.com/ad-freiburg/gantry"
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestProgressSummary(t *testing.T) {
+	cases := []struct {
+		input  string
+		output string
+	}{
+		{
+			`{"vertexes":[{"digest":"sha256:a","name":"[1/2] FROM alpine","started":"2020-01-01T00:00:00Z"}]}
+{"vertexes":[{"digest":"sha256:a","name":"[1/2] FROM alpine","started":"2020-01-01T00:00:00Z","completed":"2020-01-01T00:00:01.5Z"}],"statuses":[{"id":"x"}]}
+{"vertexes":[{"digest":"sha256:a","name":"[1/2] FROM alpine","started":"2020-01-01T00:00:00Z","completed":"2020-01-01T00:00:01.5Z"}]}
+{"vertexes":[{"digest":"sha256:b","name":"[2/2] RUN make","cached":true,"completed":"2020-01-01T00:00:02Z"}]}
+{"vertexes":[{"digest":"sha256:c","name":"exporting","started":"2020-01-01T00:00:00Z","completed":"2020-01-01T00:00:02Z","error":"failed"}]}
+`,
+			"[1/2] FROM alpine: done in 1.5s\n[2/2] RUN make: cached\nexporting: error: failed\n",
+		},
+		{
+			`latest: Pulling from library/alpine
+4abcf2066143: Pulling fs layer
+4abcf2066143: Downloading
+0123456789ab: Already exists
+4abcf2066143: Pull complete
+Digest: sha256:0123
+Status: Downloaded newer image for alpine:latest`,
+			"latest: Pulling from library/alpine\nlayer 0123456789ab: already exists\nlayer 4abcf2066143: pull complete\nDigest: sha256:0123\nStatus: Downloaded newer image for alpine:latest\n",
+		},
+	}
+	for i, c := range cases {
+		var out bytes.Buffer
+		s := newProgressSummary(&out)
+		// Write in small chunks to split lines
+		data := []byte(c.input)
+		for len(data) > 0 {
+			n := 7
+			if n > len(data) {
+				n = len(data)
+			}
+			if _, err := s.Write(data[:n]); err != nil {
+				t.Fatal(err)
+			}
+			data = data[n:]
+		}
+		if err := s.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		if out.String() != c.output {
+			t.Errorf("%d: incorrect summary, got: %q, wanted: %q", i, out.String(), c.output)
+		}
+	}
+}
+
+func TestCheckProgress(t *testing.T) {
+	for _, mode := range []string{ProgressAuto, ProgressPlain, ProgressSummary} {
+		if err := CheckProgress(mode); err != nil {
+			t.Errorf("Unexpected error for '%s': %s", mode, err)
+		}
+	}
+	if err := CheckProgress("tty"); err == nil {
+		t.Errorf("Expected error for 'tty'")
+	}
+}
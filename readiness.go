@@ -0,0 +1,152 @@
+package gantry // import "github.com/ad-freiburg/gantry"
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/ad-freiburg/gantry/types"
+)
+
+// DefaultReadinessTimeout is used if neither startup_timeout nor
+// wait_for_timeout is configured for a step.
+const DefaultReadinessTimeout = 60 * time.Second
+
+// readinessInterval is the time between two readiness probes.
+const readinessInterval = 500 * time.Millisecond
+
+// HTTPReadinessCheck describes an http endpoint which has to respond with the
+// expected status before a step is considered ready.
+type HTTPReadinessCheck struct {
+	URL      string         `json:"url"`
+	Status   int            `json:"status"`
+	Interval types.Duration `json:"interval"`
+	Timeout  types.Duration `json:"timeout"`
+}
+
+// UnmarshalJSON sets *c from an url or an object.
+func (c *HTTPReadinessCheck) UnmarshalJSON(data []byte) error {
+	var url string
+	if err := json.Unmarshal(data, &url); err == nil {
+		*c = HTTPReadinessCheck{URL: url}
+		return nil
+	}
+	parsedJSON := struct {
+		URL      string         `json:"url"`
+		Status   int            `json:"status"`
+		Interval types.Duration `json:"interval"`
+		Timeout  types.Duration `json:"timeout"`
+	}{}
+	if err := json.Unmarshal(data, &parsedJSON); err != nil {
+		return err
+	}
+	*c = HTTPReadinessCheck(parsedJSON)
+	return nil
+}
+
+// wait blocks until the url of c responds with the expected status or the
+// timeout of c, defaultTimeout if c has none, is reached.
+func (c HTTPReadinessCheck) wait(defaultTimeout time.Duration) error {
+	status := c.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	interval := time.Duration(c.Interval)
+	if interval <= 0 {
+		interval = readinessInterval
+	}
+	timeout := time.Duration(c.Timeout)
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	client := http.Client{Timeout: interval}
+	deadline := time.Now().Add(timeout)
+	for {
+		resp, err := client.Get(c.URL)
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode == status {
+				return nil
+			}
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timeout after %s waiting for status %d from '%s'", timeout, status, c.URL)
+		}
+		time.Sleep(interval)
+	}
+}
+
+// startupTimeout returns how long to wait for s to become ready or healthy:
+// startup_timeout if set, otherwise wait_for_timeout, which only exists for
+// steps, and DefaultReadinessTimeout if neither is set. The run_timeout of a
+// step starts after this wait.
+func (s Step) startupTimeout() time.Duration {
+	if s.StartupTimeout > 0 {
+		return time.Duration(s.StartupTimeout)
+	}
+	if s.WaitForTimeout > 0 {
+		return time.Duration(s.WaitForTimeout)
+	}
+	return DefaultReadinessTimeout
+}
+
+// WaitUntilReady blocks until all readiness gates of s are satisfied or their
+// timeout is reached. Targets are probed from the host running gantry,
+// therefore ports need to be published to be reachable.
+func (s Step) WaitUntilReady() error {
+	if len(s.WaitFor) == 0 && len(s.WaitForHTTP) == 0 {
+		return nil
+	}
+	timeout := s.startupTimeout()
+	if Verbose {
+		pipelineLogger.Printf("Waiting for %s to become ready", s.ColoredContainerName())
+	}
+	if err := waitForTCP(s.WaitFor, timeout); err != nil {
+		return fmt.Errorf("%s is not ready: %s", s.ColoredName(), err)
+	}
+	for _, check := range s.WaitForHTTP {
+		if err := check.wait(timeout); err != nil {
+			return fmt.Errorf("%s is not ready: %s", s.ColoredName(), err)
+		}
+	}
+	return nil
+}
+
+// waitForTCP blocks until all targets accept tcp connections or the timeout
+// is reached.
+func waitForTCP(targets []string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for _, target := range targets {
+		for {
+			conn, err := net.DialTimeout("tcp", target, readinessInterval)
+			if err == nil {
+				conn.Close()
+				break
+			}
+			if time.Now().After(deadline) {
+				return fmt.Errorf("timeout after %s waiting for '%s'", timeout, target)
+			}
+			time.Sleep(readinessInterval)
+		}
+	}
+	return nil
+}
+
+// waitUntilHealthy blocks until check passes or the startup timeout of step
+// is reached.
+func waitUntilHealthy(check func() error, step Step) error {
+	timeout := step.startupTimeout()
+	deadline := time.Now().Add(timeout)
+	for {
+		err := check()
+		if err == nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%s is not healthy after %s: %s", step.ColoredName(), timeout, err)
+		}
+		time.Sleep(readinessInterval)
+	}
+}
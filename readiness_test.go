@@ -0,0 +1,102 @@
+package gantry_test
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ad-freiburg/gantry"
+	"github.com/ad-freiburg/gantry/types"
+)
+
+func TestStepWaitUntilReady(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	// Reserve a port which is closed afterwards
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closedAddr := closed.Addr().String()
+	closed.Close()
+
+	cases := []struct {
+		step gantry.Step
+		err  bool
+	}{
+		{gantry.Step{Service: gantry.Service{Name: "a"}}, false},
+		{gantry.Step{Service: gantry.Service{Name: "a"}, WaitFor: []string{l.Addr().String()}}, false},
+		{gantry.Step{Service: gantry.Service{Name: "a"}, WaitFor: []string{closedAddr}, WaitForTimeout: types.Duration(10 * time.Millisecond)}, true},
+		// startup_timeout takes precedence over wait_for_timeout
+		{gantry.Step{Service: gantry.Service{Name: "a", StartupTimeout: types.Duration(10 * time.Millisecond)}, WaitFor: []string{closedAddr}, WaitForTimeout: types.Duration(time.Hour)}, true},
+	}
+
+	for i, c := range cases {
+		err := c.step.WaitUntilReady()
+		if err != nil && !c.err {
+			t.Errorf("Unexpected error for case '%d', got: '%#v'", i, err)
+		}
+		if err == nil && c.err {
+			t.Errorf("Expected error for case '%d', got: 'nil'", i)
+		}
+	}
+}
+
+func TestStepWaitUntilReadyHTTP(t *testing.T) {
+	ready := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ready.Close()
+	timeout := types.Duration(10 * time.Millisecond)
+	interval := types.Duration(time.Millisecond)
+
+	cases := []struct {
+		step gantry.Step
+		err  bool
+	}{
+		{gantry.Step{Service: gantry.Service{Name: "a"}, WaitForHTTP: []gantry.HTTPReadinessCheck{{URL: ready.URL, Status: http.StatusNoContent}}}, false},
+		{gantry.Step{Service: gantry.Service{Name: "a"}, WaitForHTTP: []gantry.HTTPReadinessCheck{{URL: ready.URL, Interval: interval, Timeout: timeout}}}, true},
+		{gantry.Step{Service: gantry.Service{Name: "a"}, WaitForHTTP: []gantry.HTTPReadinessCheck{{URL: ready.URL, Status: http.StatusNoContent}, {URL: "http://127.0.0.1:0", Interval: interval, Timeout: timeout}}}, true},
+		// Checks without timeout use the startup_timeout of the step
+		{gantry.Step{Service: gantry.Service{Name: "a", StartupTimeout: timeout}, WaitForHTTP: []gantry.HTTPReadinessCheck{{URL: "http://127.0.0.1:0", Interval: interval}}}, true},
+	}
+
+	for i, c := range cases {
+		err := c.step.WaitUntilReady()
+		if err != nil && !c.err {
+			t.Errorf("Unexpected error for case '%d', got: '%#v'", i, err)
+		}
+		if err == nil && c.err {
+			t.Errorf("Expected error for case '%d', got: 'nil'", i)
+		}
+	}
+}
+
+func TestHTTPReadinessCheckUnmarshalJSON(t *testing.T) {
+	var cases = []struct {
+		json   string
+		err    string
+		result gantry.HTTPReadinessCheck
+	}{
+		{"", "unexpected end of JSON input", gantry.HTTPReadinessCheck{}},
+		{"\"http://db/health\"", "", gantry.HTTPReadinessCheck{URL: "http://db/health"}},
+		{"{\"url\": \"http://db/health\", \"status\": 204, \"interval\": \"1s\", \"timeout\": 30}", "", gantry.HTTPReadinessCheck{URL: "http://db/health", Status: 204, Interval: types.Duration(time.Second), Timeout: types.Duration(30 * time.Second)}},
+	}
+
+	for _, c := range cases {
+		r := gantry.HTTPReadinessCheck{}
+		err := r.UnmarshalJSON([]byte(c.json))
+		if (err != nil && c.err == "") || (err == nil && c.err != "") {
+			t.Errorf("Incorrect error for '%s', got '%s', wanted '%s'", c.json, err, c.err)
+		}
+		if !reflect.DeepEqual(r, c.result) {
+			t.Errorf("Incorrect result for '%s', got: '%#v', wanted '%#v'", c.json, r, c.result)
+		}
+	}
+}
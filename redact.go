@@ -0,0 +1,87 @@
+package gantry // import "github.com/ad-freiburg/gantry"
+
+import (
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// RedactedValue replaces registered secrets in all prefixed output.
+const RedactedValue = "***"
+
+var secrets = &secretStore{
+	values: map[string]bool{},
+}
+
+// secretStore stores all registered secrets, longest first.
+type secretStore struct {
+	values map[string]bool
+	sorted []string
+	m      sync.RWMutex
+}
+
+// RegisterSecret registers value to be replaced by RedactedValue in the output
+// of all prefixed writers and loggers. Secrets spanning multiple lines are
+// only redacted line by line.
+func RegisterSecret(value string) {
+	if value == "" {
+		return
+	}
+	secrets.m.Lock()
+	defer secrets.m.Unlock()
+	if secrets.values[value] {
+		return
+	}
+	secrets.values[value] = true
+	secrets.sorted = append(secrets.sorted, value)
+	sort.Slice(secrets.sorted, func(i, j int) bool {
+		return len(secrets.sorted[i]) > len(secrets.sorted[j])
+	})
+}
+
+// Redact replaces all registered secrets in text by RedactedValue.
+func Redact(text string) string {
+	secrets.m.RLock()
+	defer secrets.m.RUnlock()
+	for _, secret := range secrets.sorted {
+		text = strings.ReplaceAll(text, secret, RedactedValue)
+	}
+	return text
+}
+
+// splitRedacted redacts text and splits it into a part which is safe to print
+// and a tail which could be the beginning of a registered secret. The tail
+// has to be prepended to the following output.
+func splitRedacted(text string) (string, string) {
+	text = Redact(text)
+	secrets.m.RLock()
+	defer secrets.m.RUnlock()
+	hold := 0
+	for _, secret := range secrets.sorted {
+		for k := len(secret) - 1; k > hold; k-- {
+			if strings.HasSuffix(text, secret[:k]) {
+				hold = k
+				break
+			}
+		}
+	}
+	return text[:len(text)-hold], text[len(text)-hold:]
+}
+
+// registerSecrets registers the values of all sensitive environment variables
+// and build args of s.
+func (s Service) registerSecrets() {
+	for name := range s.Sensitive {
+		if v, ok := s.Environment[name]; ok {
+			if v == nil {
+				RegisterSecret(os.Getenv(name))
+			} else {
+				RegisterSecret(*v)
+			}
+		}
+		if v, ok := s.BuildInfo.Args[name]; ok && v != nil {
+			RegisterSecret(*v)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package gantry_test
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"testing"
+
+	"github.com/ad-freiburg/gantry"
+)
+
+func TestRedact(t *testing.T) {
+	gantry.RegisterSecret("hunter2")
+	gantry.RegisterSecret("hunter2-long")
+	gantry.RegisterSecret("")
+
+	cases := []struct {
+		input  string
+		result string
+	}{
+		{"", ""},
+		{"nothing to hide", "nothing to hide"},
+		{"password: hunter2", "password: ***"},
+		{"hunter2 and hunter2", "*** and ***"},
+		{"hunter2-long", "***"},
+		{"hunter", "hunter"},
+	}
+
+	for _, c := range cases {
+		if r := gantry.Redact(c.input); r != c.result {
+			t.Errorf("Incorrect result for '%s', got: '%s', wanted '%s'", c.input, r, c.result)
+		}
+	}
+}
+
+func TestPrefixedWriterWriteSplitSecret(t *testing.T) {
+	gantry.RegisterSecret("s3cr3t-value")
+
+	cases := []struct {
+		inputs []string
+		result string
+	}{
+		{
+			[]string{"token=s3cr", "3t-value\n"},
+			fmt.Sprintf(gantry.PrefixedWriterFormat, "prefix", "token=") + fmt.Sprintf(gantry.PrefixedWriterFormat, "prefix", "***\n"),
+		},
+		{
+			[]string{"s3cr3t-", "value"},
+			fmt.Sprintf(gantry.PrefixedWriterFormat, "prefix", "***"),
+		},
+		{
+			[]string{"s3cr", "et\n"},
+			fmt.Sprintf(gantry.PrefixedWriterFormat, "prefix", "s3cret\n"),
+		},
+	}
+
+	for _, c := range cases {
+		buf := bytes.NewBuffer([]byte(""))
+		pw := gantry.NewPrefixedWriter("prefix", buf)
+		for _, input := range c.inputs {
+			if _, err := pw.Write([]byte(input)); err != nil {
+				t.Errorf("Got unexpected error: %#v", err)
+			}
+		}
+		if err := pw.Output(); err != nil {
+			t.Errorf("Got unexpected error: %#v", err)
+		}
+		if r := buf.String(); r != c.result {
+			t.Errorf("Incorrect result for '%v', got: '%s', wanted '%s'", c.inputs, r, c.result)
+		}
+	}
+}
+
+func TestPrefixedLoggerWriteSplitSecret(t *testing.T) {
+	gantry.RegisterSecret("s3cr3t-value")
+
+	cases := []struct {
+		inputs []string
+		result string
+	}{
+		{
+			[]string{"token=s3cr", "3t-value\n"},
+			fmt.Sprintf(gantry.PrefixedWriterFormat, "prefix", "token=") + "\n" + fmt.Sprintf(gantry.PrefixedWriterFormat, "prefix", "***") + "\n",
+		},
+		{
+			[]string{"s3cr3t-", "value"},
+			fmt.Sprintf(gantry.PrefixedWriterFormat, "prefix", "***") + "\n",
+		},
+		{
+			[]string{"a\ns3cr", "et\n"},
+			fmt.Sprintf(gantry.PrefixedWriterFormat, "prefix", "a") + "\n" + fmt.Sprintf(gantry.PrefixedWriterFormat, "prefix", "s3cret") + "\n",
+		},
+	}
+
+	for _, c := range cases {
+		buf := bytes.NewBuffer([]byte(""))
+		logger := gantry.NewPrefixedLogger("prefix", log.New(buf, "", 0))
+		for _, input := range c.inputs {
+			if _, err := logger.Write([]byte(input)); err != nil {
+				t.Errorf("Got unexpected error: %#v", err)
+			}
+		}
+		if err := logger.Flush(); err != nil {
+			t.Errorf("Got unexpected error: %#v", err)
+		}
+		if r := buf.String(); r != c.result {
+			t.Errorf("Incorrect result for '%v', got: '%s', wanted '%s'", c.inputs, r, c.result)
+		}
+	}
+}
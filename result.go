@@ -0,0 +1,227 @@
+package gantry // import "github.com/ad-freiburg/gantry"
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+	"unicode/utf8"
+)
+
+// StepStatus describes the state of a step.
+type StepStatus string
+
+const (
+	// StepStatusPending signals that the step waits for its dependencies or
+	// a free slot.
+	StepStatusPending StepStatus = "pending"
+	// StepStatusRunning signals that the step is being executed.
+	StepStatusRunning StepStatus = "running"
+	// StepStatusSucceeded signals that the step finished without error.
+	StepStatusSucceeded StepStatus = "succeeded"
+	// StepStatusFailed signals that the step finished with an error.
+	StepStatusFailed StepStatus = "failed"
+	// StepStatusSkipped signals that the step was not executed.
+	StepStatusSkipped StepStatus = "skipped"
+)
+
+// StepResult stores the outcome of a single step.
+type StepResult struct {
+	Name     string
+	Image    string
+	Status   StepStatus
+	Duration time.Duration
+	Err      error
+}
+
+// PipelineResult stores the outcome of all steps executed together.
+type PipelineResult struct {
+	Start   time.Time
+	Elapsed time.Duration
+	steps   []StepResult
+	m       sync.Mutex
+}
+
+// NewPipelineResult returns an empty PipelineResult.
+func NewPipelineResult() *PipelineResult {
+	return &PipelineResult{
+		steps: []StepResult{},
+	}
+}
+
+// Add stores the result of a step.
+func (r *PipelineResult) Add(result StepResult) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.steps = append(r.steps, result)
+}
+
+// Steps returns the results of all steps in the order they were added.
+func (r *PipelineResult) Steps() []StepResult {
+	r.m.Lock()
+	defer r.m.Unlock()
+	result := make([]StepResult, len(r.steps))
+	copy(result, r.steps)
+	return result
+}
+
+// Count returns the number of stored results.
+func (r *PipelineResult) Count() int {
+	r.m.Lock()
+	defer r.m.Unlock()
+	return len(r.steps)
+}
+
+// TotalDuration returns the sum of the durations of all steps.
+func (r *PipelineResult) TotalDuration() time.Duration {
+	var total time.Duration
+	for _, step := range r.Steps() {
+		total += step.Duration
+	}
+	return total
+}
+
+// SortedByDuration returns all results, the longest running step first.
+// Steps with equal durations are ordered by name.
+func (r *PipelineResult) SortedByDuration() []StepResult {
+	result := r.Steps()
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Duration == result[j].Duration {
+			return result[i].Name < result[j].Name
+		}
+		return result[i].Duration > result[j].Duration
+	})
+	return result
+}
+
+// PrintDurations writes a table of all step durations sorted by duration to w.
+func (r *PipelineResult) PrintDurations(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	if _, err := fmt.Fprintln(tw, "STEP\tDURATION"); err != nil {
+		return err
+	}
+	for _, step := range r.SortedByDuration() {
+		if _, err := fmt.Fprintf(tw, "%s\t%s\n", step.Name, step.Duration); err != nil {
+			return err
+		}
+	}
+	if _, err := fmt.Fprintf(tw, "Total (wall-clock)\t%s\n", r.Elapsed); err != nil {
+		return err
+	}
+	return tw.Flush()
+}
+
+// PrintSummary writes a table with the name, status, duration and image of
+// each step in the order they finished to w. If color is set, the status is
+// colored.
+func (r *PipelineResult) PrintSummary(w io.Writer, color bool) error {
+	rows := [][]string{{"STEP", "STATUS", "DURATION", "IMAGE"}}
+	styles := []int{AnsiStyleBold}
+	for _, step := range r.Steps() {
+		status, style := string(step.Status), AnsiStyleNormal
+		duration := step.Duration.Round(time.Millisecond).String()
+		switch step.Status {
+		case StepStatusSucceeded:
+			status, style = "\u2713", AnsiForegroundColorGreen
+		case StepStatusFailed:
+			status, style = "\u2717", AnsiForegroundColorRed
+		case StepStatusSkipped:
+			style = AnsiForegroundColorYellow
+			duration = "-"
+		}
+		rows = append(rows, []string{step.Name, status, duration, step.Image})
+		styles = append(styles, style)
+	}
+	// Align by the visible width, ANSI formatting is added afterwards
+	widths := make([]int, len(rows[0]))
+	for _, row := range rows {
+		for i, cell := range row {
+			if n := utf8.RuneCountInString(cell); n > widths[i] {
+				widths[i] = n
+			}
+		}
+	}
+	for i, row := range rows {
+		line := ""
+		for j, cell := range row {
+			padding := ""
+			if j < len(row)-1 {
+				padding = strings.Repeat(" ", widths[j]-utf8.RuneCountInString(cell)+2)
+			}
+			if color && (j == 1 || i == 0) && styles[i] != AnsiStyleNormal {
+				cell = ApplyAnsiStyle(cell, styles[i])
+			}
+			line += cell + padding
+		}
+		if _, err := fmt.Fprintln(w, strings.TrimRight(line, " ")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ExitCode returns the exit code of the step, 0 if it did not fail.
+func (r StepResult) ExitCode() int {
+	if r.Err == nil {
+		return 0
+	}
+	switch err := r.Err.(type) {
+	case ExecutionError:
+		return err.ExitCode()
+	case *exec.ExitError:
+		return err.ExitCode()
+	}
+	return 1
+}
+
+type stepReport struct {
+	Name     string     `json:"name"`
+	Image    string     `json:"image"`
+	Status   StepStatus `json:"status"`
+	Duration float64    `json:"duration"`
+	ExitCode int        `json:"exit_code"`
+	Error    string     `json:"error,omitempty"`
+}
+
+type pipelineReport struct {
+	Project  string       `json:"project"`
+	Version  string       `json:"version"`
+	Start    time.Time    `json:"start"`
+	End      time.Time    `json:"end"`
+	Duration float64      `json:"duration"`
+	Steps    []stepReport `json:"steps"`
+}
+
+// WriteJSON writes a json report of all results of project to w. Durations
+// are given in seconds, steps are ordered as they were added.
+func (r *PipelineResult) WriteJSON(w io.Writer, project string) error {
+	report := pipelineReport{
+		Project:  project,
+		Version:  Version,
+		Start:    r.Start,
+		End:      r.Start.Add(r.Elapsed),
+		Duration: r.Elapsed.Seconds(),
+		Steps:    []stepReport{},
+	}
+	for _, step := range r.Steps() {
+		s := stepReport{
+			Name:     step.Name,
+			Image:    step.Image,
+			Status:   step.Status,
+			Duration: step.Duration.Seconds(),
+			ExitCode: step.ExitCode(),
+		}
+		if step.Err != nil {
+			s.Error = step.Err.Error()
+		}
+		report.Steps = append(report.Steps, s)
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report)
+}
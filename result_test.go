@@ -0,0 +1,135 @@
+package gantry_test
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ad-freiburg/gantry"
+)
+
+func TestPipelineResultSortedByDuration(t *testing.T) {
+	r := gantry.NewPipelineResult()
+	r.Add(gantry.StepResult{Name: "b", Duration: time.Second})
+	r.Add(gantry.StepResult{Name: "c", Duration: 3 * time.Second})
+	r.Add(gantry.StepResult{Name: "a", Duration: time.Second})
+
+	names := []string{}
+	for _, step := range r.SortedByDuration() {
+		names = append(names, step.Name)
+	}
+	expected := []string{"c", "a", "b"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("Incorrect order, got: '%v', wanted '%v'", names, expected)
+	}
+	if c := r.Count(); c != 3 {
+		t.Errorf("Incorrect count, got: '%d', wanted '%d'", c, 3)
+	}
+	if d := r.TotalDuration(); d != 5*time.Second {
+		t.Errorf("Incorrect total duration, got: '%s', wanted '%s'", d, 5*time.Second)
+	}
+}
+
+func TestPipelineResultPrintDurations(t *testing.T) {
+	r := gantry.NewPipelineResult()
+	r.Elapsed = 3 * time.Second
+	r.Add(gantry.StepResult{Name: "short", Duration: time.Second})
+	r.Add(gantry.StepResult{Name: "long_step", Duration: 2 * time.Second})
+
+	buf := bytes.NewBuffer([]byte(""))
+	if err := r.PrintDurations(buf); err != nil {
+		t.Errorf("Got unexpected error: %#v", err)
+	}
+	expected := `STEP                DURATION
+long_step           2s
+short               1s
+Total (wall-clock)  3s
+`
+	if result := buf.String(); result != expected {
+		t.Errorf("Incorrect output, got: '%s', wanted: '%s'", result, expected)
+	}
+}
+
+func TestPipelineResultPrintSummary(t *testing.T) {
+	r := gantry.NewPipelineResult()
+	r.Add(gantry.StepResult{Name: "a", Image: "img_a", Status: gantry.StepStatusSucceeded, Duration: 1234567 * time.Microsecond})
+	r.Add(gantry.StepResult{Name: "long_b", Image: "img_b", Status: gantry.StepStatusFailed, Duration: 2 * time.Second, Err: errors.New("failed")})
+	r.Add(gantry.StepResult{Name: "c", Status: gantry.StepStatusSkipped})
+
+	buf := bytes.NewBuffer([]byte(""))
+	if err := r.PrintSummary(buf, false); err != nil {
+		t.Errorf("Got unexpected error: %#v", err)
+	}
+	expected := `STEP    STATUS   DURATION  IMAGE
+a       ✓        1.235s    img_a
+long_b  ✗        2s        img_b
+c       skipped  -
+`
+	if result := buf.String(); result != expected {
+		t.Errorf("Incorrect output, got: '%s', wanted: '%s'", result, expected)
+	}
+
+	// Colors do not change the alignment
+	buf.Reset()
+	if err := r.PrintSummary(buf, true); err != nil {
+		t.Errorf("Got unexpected error: %#v", err)
+	}
+	if result := gantry.StripAnsi(buf.String()); result != expected {
+		t.Errorf("Incorrect output, got: '%s', wanted: '%s'", result, expected)
+	}
+	if !strings.Contains(buf.String(), gantry.ApplyAnsiStyle("\u2717", gantry.AnsiForegroundColorRed)) {
+		t.Errorf("Failed step is not colored, got: '%s'", buf.String())
+	}
+}
+
+func TestPipelineResultWriteJSON(t *testing.T) {
+	r := gantry.NewPipelineResult()
+	r.Start = time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	r.Elapsed = 3 * time.Second
+	r.Add(gantry.StepResult{Name: "a", Image: "img_a", Status: gantry.StepStatusSucceeded, Duration: time.Second})
+	r.Add(gantry.StepResult{Name: "b", Image: "img_b", Status: gantry.StepStatusFailed, Duration: 1500 * time.Millisecond, Err: errors.New("failed")})
+	r.Add(gantry.StepResult{Name: "c", Image: "img_c", Status: gantry.StepStatusSkipped})
+
+	buf := bytes.NewBuffer([]byte(""))
+	if err := r.WriteJSON(buf, "project"); err != nil {
+		t.Errorf("Got unexpected error: %#v", err)
+	}
+	expected := `{
+  "project": "project",
+  "version": "` + gantry.Version + `",
+  "start": "2020-01-02T03:04:05Z",
+  "end": "2020-01-02T03:04:08Z",
+  "duration": 3,
+  "steps": [
+    {
+      "name": "a",
+      "image": "img_a",
+      "status": "succeeded",
+      "duration": 1,
+      "exit_code": 0
+    },
+    {
+      "name": "b",
+      "image": "img_b",
+      "status": "failed",
+      "duration": 1.5,
+      "exit_code": 1,
+      "error": "failed"
+    },
+    {
+      "name": "c",
+      "image": "img_c",
+      "status": "skipped",
+      "duration": 0,
+      "exit_code": 0
+    }
+  ]
+}
+`
+	if result := buf.String(); result != expected {
+		t.Errorf("Incorrect output, got: '%s', wanted: '%s'", result, expected)
+	}
+}
@@ -0,0 +1,172 @@
+package gantry // import "github.com/ad-freiburg/gantry"
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/ad-freiburg/gantry/types"
+)
+
+// resumeState records which steps succeeded, so they can be skipped when a
+// failed pipeline is run again.
+type resumeState struct {
+	path string
+	// previous maps the steps succeeded in a previous run to the
+	// fingerprints of their definitions.
+	previous map[string]string
+	// current starts with the previous state, steps executed in this run are
+	// replaced by their outcome.
+	current map[string]string
+	// executed stores all steps which are not skipped in this run.
+	executed types.StringSet
+	// imageID returns the id of the image of a step, an empty string if it
+	// is unknown.
+	imageID func(Step) string
+	// temporary returns true if a path is removed after this run.
+	temporary func(string) bool
+	m         sync.Mutex
+}
+
+// resumeStatePath returns the location of the state of the current project
+// inside dir, the default temporary directory if dir is empty.
+func resumeStatePath(dir string) string {
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, fmt.Sprintf(ResumeState, ProjectName))
+}
+
+// loadResumeState reads the state stored at path. If force is set or nothing
+// was recorded yet, no step is skipped.
+func loadResumeState(path string, force bool) (*resumeState, error) {
+	s := &resumeState{
+		path:     path,
+		previous: map[string]string{},
+		current:  map[string]string{},
+		executed: types.StringSet{},
+	}
+	if force {
+		return s, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return s, err
+	}
+	if err := json.Unmarshal(data, &s.previous); err != nil {
+		return s, fmt.Errorf("invalid resume state %s: %s", path, err)
+	}
+	for name, fingerprint := range s.previous {
+		s.current[name] = fingerprint
+	}
+	return s, nil
+}
+
+// skip returns true if step succeeded previously, its definition, image and
+// mounted files are unchanged and none of the steps it depends on is executed
+// in this run. Services are never skipped as their dependents expect them to
+// be running, ignored steps are not executed anyway. Steps writing to
+// temporary directories which do not survive this run are never skipped, as
+// their outputs would be missing.
+func (s *resumeState) skip(step Step) bool {
+	if step.Meta.Type != ServiceTypeStep || step.Meta.Ignore {
+		return false
+	}
+	fingerprint := s.fingerprint(step)
+	s.m.Lock()
+	defer s.m.Unlock()
+	skip := fingerprint != "" && s.previous[step.Name] == fingerprint
+	for path, writable := range step.bindMounts() {
+		if writable && s.temporary != nil && s.temporary(path) {
+			skip = false
+		}
+	}
+	for dep := range step.Dependencies() {
+		if s.executed[dep] {
+			skip = false
+		}
+	}
+	if !skip {
+		s.executed[step.Name] = true
+		delete(s.current, step.Name)
+	}
+	return skip
+}
+
+// succeeded records that step finished without error.
+func (s *resumeState) succeeded(step Step) {
+	if step.Meta.Type != ServiceTypeStep || step.Meta.Ignore {
+		return
+	}
+	fingerprint := s.fingerprint(step)
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.current[step.Name] = fingerprint
+}
+
+// fingerprint returns the fingerprint of step using the id of its image.
+func (s *resumeState) fingerprint(step Step) string {
+	if s.imageID == nil {
+		return ""
+	}
+	return stepFingerprint(step, s.imageID(step))
+}
+
+// save stores the state for the next run.
+func (s *resumeState) save() error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	data, err := json.MarshalIndent(s.current, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(s.path, data, 0644)
+}
+
+// stepFingerprint returns a digest of the definition of step, the id of its
+// image, its build context if it is built and the contents of all bind-mount
+// sources. An empty string is returned if the image id is unknown or any of
+// the files can not be read.
+func stepFingerprint(step Step, imageID string) string {
+	if imageID == "" {
+		return ""
+	}
+	mounts := step.bindMounts()
+	// Meta contains run specific settings like outputs
+	step.Meta = ServiceMeta{}
+	data, err := json.Marshal(step)
+	if err != nil {
+		return ""
+	}
+	h := sha256.New()
+	h.Write(data)
+	fmt.Fprintf(h, "\x00%s\x00", imageID)
+	if step.IsBuildable() {
+		hash, err := step.BuildInfo.ContextHash()
+		if err != nil {
+			return ""
+		}
+		fmt.Fprintf(h, "%s\x00", hash)
+	}
+	paths := make([]string, 0, len(mounts))
+	for path := range mounts {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		fmt.Fprintf(h, "%s\x00", path)
+		if err := hashTree(h, path, nil); err != nil {
+			return ""
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,148 @@
+package gantry // import "github.com/ad-freiburg/gantry"
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ad-freiburg/gantry/types"
+)
+
+func TestResumeStateSkip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "resume")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state.json")
+	input := filepath.Join(dir, "input")
+	if err := ioutil.WriteFile(input, []byte("1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	temporary := filepath.Join(dir, "tmp")
+
+	step := func(name string, image string, deps ...string) Step {
+		s := Step{Service: Service{Name: name, Image: image, Meta: ServiceMeta{Type: ServiceTypeStep}}}
+		s.After = types.StringSet{}
+		for _, dep := range deps {
+			s.After[dep] = true
+		}
+		return s
+	}
+	a := step("a", "alpine")
+	b := step("b", "alpine", "a")
+	c := step("c", "alpine")
+	d := step("d", "alpine")
+	d.Volumes = []string{input + ":/input:ro"}
+	e := step("e", "alpine")
+	e.Volumes = []string{temporary + ":/output"}
+	imageIDs := map[string]string{"alpine": "sha256:1", "debian": "sha256:2"}
+	load := func(force bool) *resumeState {
+		s, err := loadResumeState(path, force)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.imageID = func(step Step) string {
+			return imageIDs[step.Image]
+		}
+		s.temporary = func(path string) bool {
+			return path == temporary
+		}
+		return s
+	}
+
+	s := load(false)
+	for _, st := range []Step{a, b, c, d, e} {
+		if s.skip(st) {
+			t.Errorf("Incorrect skip of '%s' without state", st.Name)
+		}
+	}
+	s.succeeded(a)
+	s.succeeded(b)
+	s.succeeded(d)
+	s.succeeded(e)
+	if err := s.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		steps    []Step
+		force    bool
+		expected []bool
+		prepare  func()
+	}{
+		{[]Step{a, b, c, d}, false, []bool{true, true, false, true}, nil},
+		{[]Step{a, b, c}, true, []bool{false, false, false}, nil},
+		// A changed definition runs the step and all steps depending on it
+		{[]Step{step("a", "debian"), b}, false, []bool{false, false}, nil},
+		{[]Step{a, step("b", "debian", "a")}, false, []bool{true, false}, nil},
+		// Steps writing to temporary directories always run
+		{[]Step{e}, false, []bool{false}, nil},
+		// A changed image or changed mounted files run the step
+		{[]Step{a, b}, false, []bool{false, false}, func() { imageIDs["alpine"] = "sha256:3" }},
+		{[]Step{d}, false, []bool{false}, func() { ioutil.WriteFile(input, []byte("2"), 0644) }},
+	}
+	for i, c := range cases {
+		if c.prepare != nil {
+			c.prepare()
+		}
+		s := load(c.force)
+		for j, st := range c.steps {
+			if result := s.skip(st); result != c.expected[j] {
+				t.Errorf("Incorrect skip of '%s' in case %d, got: %t, wanted: %t", st.Name, i, result, c.expected[j])
+			}
+		}
+	}
+}
+
+func TestPipelineExecuteStepsResume(t *testing.T) {
+	dir, err := ioutil.TempDir("", "resume")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tmpDef, tmpEnv := setupDefAndEnv(`version: "2.0"
+steps:
+  a:
+    image: alpine
+  b:
+    image: alpine
+    after:
+    - a
+`, fmt.Sprintf("tempdir: %s\n", dir))
+	defer os.Remove(tmpDef)
+	defer os.Remove(tmpEnv)
+
+	Resume = true
+	defer func() { Resume = false }()
+	cases := []struct {
+		force  bool
+		called int
+	}{
+		{false, 1},
+		{false, 0},
+		{true, 1},
+	}
+	for i, c := range cases {
+		ForceRerun = c.force
+		p, err := NewPipeline(tmpDef, tmpEnv, types.StringMap{}, types.StringSet{}, types.StringSet{})
+		if err != nil {
+			t.Fatalf("unexpected error creating pipeline: '%#v'", err)
+		}
+		localRunner := NewNoopRunner(true)
+		p.localRunner = localRunner
+		p.noopRunner = NewNoopRunner(true)
+		if err := p.ExecuteSteps(); err != nil {
+			t.Errorf("unexpected error in case %d, got: '%#v', wanted 'nil'", i, err)
+		}
+		for _, name := range []string{"a", "b"} {
+			key := fmt.Sprintf("ContainerRunner(%s,)", name)
+			if n := localRunner.NumCalled(key); n != c.called {
+				t.Errorf("Incorrect NumCalled for '%s' in case %d, got: %d, wanted: %d", key, i, n, c.called)
+			}
+		}
+	}
+	ForceRerun = false
+}
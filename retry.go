@@ -0,0 +1,62 @@
+package gantry // import "github.com/ad-freiburg/gantry"
+
+import (
+	"fmt"
+	"time"
+)
+
+// retryRemoveAttempts limits how often removing the container of a failed
+// attempt is tried, containers started with --rm may still be removed by
+// the daemon.
+const retryRemoveAttempts = 10
+
+// outputPrefix returns the prefix for the output of the container of s,
+// numbered by the attempt if s is retried.
+func (s Step) outputPrefix() string {
+	if s.attempt > 0 {
+		return fmt.Sprintf("%s#%d", s.ColoredContainerName(), s.attempt)
+	}
+	return s.ColoredContainerName()
+}
+
+// runWithRetries runs the container of step and reruns it up to
+// step.Retries times while it fails. The containers of failed attempts are
+// removed before the next one is started, the error of the last attempt is
+// returned.
+func (p *Pipeline) runWithRetries(runner Runner, step Step) error {
+	if step.Retries == 0 {
+		return runner.ContainerRunner(step, p.Network)()
+	}
+	delay := time.Duration(step.RetryDelay)
+	for attempt := 1; ; attempt++ {
+		step.attempt = attempt
+		err := runner.ContainerRunner(step, p.Network)()
+		if err == nil || attempt > step.Retries {
+			return err
+		}
+		pipelineLogger.Printf("- Retrying: %s (attempt %d of %d failed: %s)", step.ColoredContainerName(), attempt, step.Retries+1, err)
+		if err := removeFailedAttempt(runner, step); err != nil {
+			return err
+		}
+		if delay > 0 {
+			time.Sleep(delay)
+			delay *= 2
+		}
+	}
+}
+
+// removeFailedAttempt kills and removes all containers of step, so the next
+// attempt can reuse the container name.
+func removeFailedAttempt(runner Runner, step Step) error {
+	if _, err := runner.ContainerKiller(step)(); err != nil {
+		pipelineLogger.Printf("Error killing %s: %s", step.ColoredName(), err)
+	}
+	var err error
+	for i := 0; i < retryRemoveAttempts; i++ {
+		if err = runner.ContainerRemover(step)(); err == nil {
+			return nil
+		}
+		time.Sleep(waitInterval)
+	}
+	return fmt.Errorf("could not remove the container of '%s' before retrying: %s", step.ColoredName(), err)
+}
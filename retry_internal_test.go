@@ -0,0 +1,77 @@
+package gantry
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// flakyRunner fails the first runs of each container.
+type flakyRunner struct {
+	*NoopRunner
+	failures int
+	mutex    sync.Mutex
+	attempts []int
+}
+
+func (r *flakyRunner) Copy() Runner {
+	return r
+}
+
+func (r *flakyRunner) ContainerRunner(step Step, network Network) func() error {
+	return func() error {
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+		r.attempts = append(r.attempts, step.attempt)
+		if len(r.attempts) <= r.failures {
+			return fmt.Errorf("attempt %d failed", step.attempt)
+		}
+		return nil
+	}
+}
+
+func TestPipelineRunWithRetries(t *testing.T) {
+	cases := []struct {
+		retries  int
+		failures int
+		err      bool
+		attempts []int
+	}{
+		{0, 0, false, []int{0}},
+		{0, 1, true, []int{0}},
+		{2, 2, false, []int{1, 2, 3}},
+		{2, 3, true, []int{1, 2, 3}},
+	}
+	for _, c := range cases {
+		runner := &flakyRunner{NoopRunner: NewNoopRunner(true), failures: c.failures}
+		step := Step{Service: Service{Name: "a"}, Retries: c.retries}
+		p := &Pipeline{}
+		err := p.runWithRetries(runner, step)
+		if (err != nil) != c.err {
+			t.Errorf("Incorrect error for %d retries and %d failures, got: %v", c.retries, c.failures, err)
+		}
+		if !reflect.DeepEqual(runner.attempts, c.attempts) {
+			t.Errorf("Incorrect attempts for %d retries and %d failures, got: %v, wanted: %v", c.retries, c.failures, runner.attempts, c.attempts)
+		}
+		// Containers of failed attempts are removed before retrying
+		removed := len(c.attempts) - 1
+		if removed > c.failures {
+			removed = c.failures
+		}
+		if got := runner.NumCalled("ContainerRemover(a)"); got != removed {
+			t.Errorf("Incorrect number of removals for %d retries and %d failures, got: %d, wanted: %d", c.retries, c.failures, got, removed)
+		}
+	}
+}
+
+func TestStepOutputPrefix(t *testing.T) {
+	step := Step{Service: Service{Name: "a"}}
+	if prefix := step.outputPrefix(); prefix != step.ColoredContainerName() {
+		t.Errorf("Incorrect prefix, got: '%s'", prefix)
+	}
+	step.attempt = 2
+	if prefix := step.outputPrefix(); prefix != step.ColoredContainerName()+"#2" {
+		t.Errorf("Incorrect prefix for attempt 2, got: '%s'", prefix)
+	}
+}
@@ -3,13 +3,20 @@ package gantry // import "github.com/ad-freiburg/gantry"
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"os"
 	"os/exec"
 	"os/user"
+	"sort"
 	"strings"
 	"sync"
+	"time"
+
+	"github.com/ad-freiburg/gantry/types"
 )
 
 const docker string = "docker"
@@ -57,12 +64,36 @@ func isUserInDockerGroup() bool {
 	return false
 }
 
+// wharferDiagnostic ensures the reason for not using a broken wharfer is only
+// logged once.
+var wharferDiagnostic sync.Once
+
 func isWharferInstalled() bool {
-	cmd := exec.Command(wharfer, "--version")
-	if err := cmd.Run(); err != nil {
-		return false
+	found, err := wharferStatus()
+	if err != nil {
+		wharferDiagnostic.Do(func() {
+			log.Printf("Warning: falling back to %s: %s", docker, err)
+		})
 	}
-	return true
+	return found && err == nil
+}
+
+// wharferStatus returns whether wharfer is found on the PATH and an error if
+// it was found but does not work.
+func wharferStatus() (bool, error) {
+	path, err := exec.LookPath(wharfer)
+	if err != nil {
+		return false, nil
+	}
+	out, err := exec.Command(path, "--version").CombinedOutput()
+	if err != nil {
+		msg := strings.TrimSpace(string(out))
+		if msg == "" {
+			return true, fmt.Errorf("%s found at %s but '%s --version' failed: %s", wharfer, path, wharfer, err)
+		}
+		return true, fmt.Errorf("%s found at %s but '%s --version' failed: %s: %s", wharfer, path, wharfer, err, msg)
+	}
+	return true, nil
 }
 
 // Runner represents generic container runners.
@@ -72,10 +103,18 @@ type Runner interface {
 	ImageBuilder(Step, bool) func() error
 	ImagePuller(Step) func() error
 	ImageExistenceChecker(Step) func() error
+	ImageIdentifier(Step) func() (string, error)
+	ImagePruner(string) func() error
+	ImageRemover(Step) func() error
 	ContainerKiller(Step) func() (int, error)
 	ContainerRemover(Step) func() error
 	ContainerRunner(Step, Network) func() error
 	ContainerLogReader(Step, bool) func() error
+	ContainerLogFollower(context.Context, Step) func() error
+	ContainerStats(Step) func() (ContainerStats, error)
+	RunningContainerChecker(Step) func() error
+	HealthyContainerChecker(Step) func() error
+	DaemonChecker(Step) func() error
 	NetworkCreator(Network) func() error
 	NetworkRemover(Network) func() error
 }
@@ -175,6 +214,37 @@ func (r *NoopRunner) ImageExistenceChecker(step Step) func() error {
 	}
 }
 
+// ImageIdentifier returns a function which returns the image name of the
+// given step as id.
+func (r *NoopRunner) ImageIdentifier(step Step) func() (string, error) {
+	key := fmt.Sprintf("ImageIdentifier(%s)", step.Name)
+	r.incrementCalls(key)
+	return func() (string, error) {
+		r.incrementCalled(key)
+		return step.ImageName(), nil
+	}
+}
+
+// ImagePruner returns a function which removes dangling images of the given project.
+func (r *NoopRunner) ImagePruner(project string) func() error {
+	key := fmt.Sprintf("ImagePruner(%s)", project)
+	r.incrementCalls(key)
+	return func() error {
+		r.incrementCalled(key)
+		return nil
+	}
+}
+
+// ImageRemover returns a function which removes the image of the given step.
+func (r *NoopRunner) ImageRemover(step Step) func() error {
+	key := fmt.Sprintf("ImageRemover(%s)", step.Name)
+	r.incrementCalls(key)
+	return func() error {
+		r.incrementCalled(key)
+		return nil
+	}
+}
+
 // ContainerKiller returns a function to kill the container for the given step.
 func (r *NoopRunner) ContainerKiller(step Step) func() (int, error) {
 	key := fmt.Sprintf("ContainerKiller(%s)", step.Name)
@@ -218,6 +288,61 @@ func (r *NoopRunner) ContainerLogReader(step Step, follow bool) func() error {
 	}
 }
 
+// ContainerLogFollower returns a function following the logs for a given step
+// until ctx is done.
+func (r *NoopRunner) ContainerLogFollower(ctx context.Context, step Step) func() error {
+	key := fmt.Sprintf("ContainerLogFollower(%s)", step.Name)
+	r.incrementCalls(key)
+	return func() error {
+		r.incrementCalled(key)
+		return nil
+	}
+}
+
+// RunningContainerChecker returns a function checking if a container of a
+// given step is running.
+func (r *NoopRunner) RunningContainerChecker(step Step) func() error {
+	key := fmt.Sprintf("RunningContainerChecker(%s)", step.Name)
+	r.incrementCalls(key)
+	return func() error {
+		r.incrementCalled(key)
+		return nil
+	}
+}
+
+// HealthyContainerChecker returns a function checking if healthy containers
+// of a given step are running.
+func (r *NoopRunner) HealthyContainerChecker(step Step) func() error {
+	key := fmt.Sprintf("HealthyContainerChecker(%s)", step.Name)
+	r.incrementCalls(key)
+	return func() error {
+		r.incrementCalled(key)
+		return nil
+	}
+}
+
+// DaemonChecker returns a function checking if the daemon used by a given
+// step is reachable.
+func (r *NoopRunner) DaemonChecker(step Step) func() error {
+	key := fmt.Sprintf("DaemonChecker(%s)", step.Name)
+	r.incrementCalls(key)
+	return func() error {
+		r.incrementCalled(key)
+		return nil
+	}
+}
+
+// ContainerStats returns a function sampling the resource usage of a given
+// step.
+func (r *NoopRunner) ContainerStats(step Step) func() (ContainerStats, error) {
+	key := fmt.Sprintf("ContainerStats(%s)", step.Name)
+	r.incrementCalls(key)
+	return func() (ContainerStats, error) {
+		r.incrementCalled(key)
+		return ContainerStats{}, nil
+	}
+}
+
 // NetworkCreator returns a function to create the given network.
 func (r *NoopRunner) NetworkCreator(network Network) func() error {
 	key := fmt.Sprintf("NetworkCreator(%s)", network)
@@ -240,9 +365,19 @@ func (r *NoopRunner) NetworkRemover(network Network) func() error {
 
 // LocalRunner creates functions running on localhost.
 type LocalRunner struct {
-	prefix string
-	stdout io.Writer
-	stderr io.Writer
+	prefix    string
+	stdout    io.Writer
+	stderr    io.Writer
+	env       map[string]string
+	dir       string
+	sensitive types.StringSet
+	logTarget io.Writer
+	// executable overrides the detected container executable if set.
+	executable string
+	// dockerHost and dockerContext override DockerHost and DockerContext if
+	// set.
+	dockerHost    string
+	dockerContext string
 }
 
 // NewLocalRunner returns a LocalRunner using provided defaults.
@@ -256,33 +391,291 @@ func NewLocalRunner(prefix string, stdout io.Writer, stderr io.Writer) *LocalRun
 
 // Copy returns a new Instance with copied values.
 func (r *LocalRunner) Copy() Runner {
+	env := make(map[string]string, len(r.env))
+	for k, v := range r.env {
+		env[k] = v
+	}
 	return &LocalRunner{
-		prefix: r.prefix,
-		stdout: r.stdout,
-		stderr: r.stderr,
+		prefix:        r.prefix,
+		stdout:        r.stdout,
+		stderr:        r.stderr,
+		env:           env,
+		dir:           r.dir,
+		sensitive:     r.sensitive,
+		logTarget:     r.logTarget,
+		executable:    r.executable,
+		dockerHost:    r.dockerHost,
+		dockerContext: r.dockerContext,
+	}
+}
+
+// useStep makes r run the following commands for step, with its prefix,
+// container executable, daemon and outputs.
+func (r *LocalRunner) useStep(step Step) {
+	r.useDaemon(step)
+	r.prefix = step.ColoredContainerName()
+	r.stdout = step.Meta.Stdout
+	r.stderr = step.Meta.Stderr
+	r.sensitive = step.Sensitive
+}
+
+// useDaemon makes r run the following commands with the container executable
+// and daemon selected by step.
+func (r *LocalRunner) useDaemon(step Step) {
+	r.executable = step.Executable
+	r.dockerHost = step.Meta.DockerHost
+	r.dockerContext = step.Meta.DockerContext
+}
+
+// SetWorkingDirectory sets the directory in which all commands are executed.
+// If dir is empty the working directory of the current process is used.
+func (r *LocalRunner) SetWorkingDirectory(dir string) {
+	r.dir = dir
+}
+
+// SetLogTarget makes ContainerLogFollower write the logs of all steps to w
+// instead of their configured outputs, without ANSI formatting.
+func (r *LocalRunner) SetLogTarget(w io.Writer) {
+	r.logTarget = w
+}
+
+// SetEnvironment sets additional environment variables for all executed
+// commands. They are merged onto the environment of the current process.
+func (r *LocalRunner) SetEnvironment(env map[string]string) {
+	r.env = env
+}
+
+// environ returns the environment for executed commands, nil if the
+// environment of the current process is used unchanged.
+func (r *LocalRunner) environ() []string {
+	host := r.daemonHost()
+	if len(r.env) == 0 && host == "" {
+		return nil
+	}
+	keys := make([]string, 0, len(r.env))
+	for k := range r.env {
+		keys = append(keys, k)
 	}
+	sort.Strings(keys)
+	result := os.Environ()
+	for _, k := range keys {
+		result = append(result, fmt.Sprintf("%s=%s", k, r.env[k]))
+	}
+	if host != "" {
+		result = append(result, fmt.Sprintf("DOCKER_HOST=%s", host))
+	}
+	return result
+}
+
+// daemonHost returns the daemon selected by the current step, DockerHost if
+// the step does not select one.
+func (r *LocalRunner) daemonHost() string {
+	if r.dockerHost != "" {
+		return r.dockerHost
+	}
+	return DockerHost
+}
+
+// daemonArgs prepends the context selected by the current step, or
+// DockerContext if the step does not select one, to args.
+func (r *LocalRunner) daemonArgs(args []string) []string {
+	name := r.dockerContext
+	if name == "" {
+		name = DockerContext
+	}
+	if name == "" {
+		return args
+	}
+	return append([]string{"--context", name}, args...)
+}
+
+// stepDaemon returns the container executable, the host and the context used
+// for step, the global ones for all settings the step does not choose.
+func stepDaemon(step Step) (string, string, string) {
+	executable := step.Executable
+	if executable == "" {
+		executable = getContainerExecutable()
+	}
+	host := step.Meta.DockerHost
+	if host == "" {
+		host = DockerHost
+	}
+	context := step.Meta.DockerContext
+	if context == "" {
+		context = DockerContext
+	}
+	return executable, host, context
+}
+
+// redactArgs returns a copy of args in which the sources of build secrets and
+// the values of sensitive environment variables and build arguments are
+// hidden, so they can be logged.
+func redactArgs(args []string, sensitive types.StringSet) []string {
+	result := make([]string, len(args))
+	copy(result, args)
+	for i := 1; i < len(result); i++ {
+		switch result[i-1] {
+		case "--secret":
+			id := "?"
+			for _, part := range strings.Split(result[i], ",") {
+				if strings.HasPrefix(part, "id=") {
+					id = strings.TrimPrefix(part, "id=")
+				}
+			}
+			result[i] = fmt.Sprintf("id=%s,src=***", id)
+		case "-e", "--build-arg":
+			parts := strings.SplitN(result[i], "=", 2)
+			if len(parts) == 2 && sensitive[parts[0]] {
+				result[i] = fmt.Sprintf("%s=***", parts[0])
+			}
+		}
+	}
+	return result
+}
+
+// containerExecutable returns the executable chosen by the current step,
+// the globally detected one if the step does not choose one.
+func (r *LocalRunner) containerExecutable() string {
+	if r.executable != "" {
+		return r.executable
+	}
+	return getContainerExecutable()
 }
 
 // Exec executes given arguments with the containerExecutable.
 func (r *LocalRunner) Exec(args []string) error {
-	ce := getContainerExecutable()
-	if ShowContainerCommands {
-		log.Printf("Exec:   %s %s", ce, strings.Join(args, " "))
+	return r.ExecContext(context.Background(), args)
+}
+
+// ExecContext executes given arguments with the containerExecutable, the
+// process is killed when ctx is done. If GroupOutput is set, the output is
+// printed as one block after the process finished.
+func (r *LocalRunner) ExecContext(ctx context.Context, args []string) error {
+	return r.execContext(ctx, args, GroupOutput, false)
+}
+
+// execProgress executes given arguments like Exec, their output is condensed
+// to a summary if Progress is ProgressSummary.
+func (r *LocalRunner) execProgress(args []string) error {
+	return r.execContext(context.Background(), args, GroupOutput, Progress == ProgressSummary)
+}
+
+func (r *LocalRunner) execContext(ctx context.Context, args []string, group bool, summarize bool) error {
+	ce := r.containerExecutable()
+	args = r.daemonArgs(args)
+	if Verbose && r.stderr != nil {
+		NewPrefixedLogger(r.prefix, log.New(r.stderr, "", log.LstdFlags)).Printf("Exec: %s %s", ce, strings.Join(redactArgs(args, r.sensitive), " "))
+	} else if ShowContainerCommands {
+		log.Printf("Exec:   %s %s", ce, strings.Join(redactArgs(args, r.sensitive), " "))
 	}
-	cmd := exec.Command(ce, args...)
-	cmd.Stdout = NewPrefixedLogger(r.prefix, log.New(r.stdout, "", log.LstdFlags))
-	cmd.Stderr = NewPrefixedLogger(r.prefix, log.New(r.stderr, "", log.LstdFlags))
-	return cmd.Run()
+	cmd := exec.CommandContext(ctx, ce, args...)
+	cmd.Env = r.environ()
+	cmd.Dir = r.dir
+	// Grouped output is collected and printed after the process finished
+	var grouped *GroupedOutput
+	stdoutTarget := r.stdout
+	stderrTarget := r.stderr
+	if group {
+		grouped = NewGroupedOutput()
+		stdoutTarget = grouped.Writer(r.stdout)
+		stderrTarget = grouped.Writer(r.stderr)
+	}
+	// In quiet mode stdout is buffered and only printed on failure
+	var buffered *bytes.Buffer
+	if Quiet {
+		buffered = bytes.NewBuffer([]byte(""))
+		stdoutTarget = buffered
+	}
+	stdout := NewPrefixedLogger(r.prefix, log.New(stdoutTarget, "", log.LstdFlags))
+	stdout.SetStream("stdout")
+	stderr := NewPrefixedLogger(r.prefix, log.New(stderrTarget, "", log.LstdFlags))
+	stderr.SetStream("stderr")
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	summaries := []*progressSummary{}
+	if summarize {
+		summaries = append(summaries, newProgressSummary(stdout), newProgressSummary(stderr))
+		cmd.Stdout = summaries[0]
+		cmd.Stderr = summaries[1]
+	}
+	err := wrapExecutableError(ce, cmd.Run())
+	for _, s := range summaries {
+		if ferr := s.Flush(); ferr != nil {
+			log.Printf("Error writing output: %s", ferr)
+		}
+	}
+	if ferr := stdout.Flush(); ferr != nil {
+		log.Printf("Error writing output: %s", ferr)
+	}
+	if ferr := stderr.Flush(); ferr != nil {
+		log.Printf("Error writing output: %s", ferr)
+	}
+	if grouped != nil {
+		if ferr := grouped.Flush(); ferr != nil {
+			log.Printf("Error writing grouped output: %s", ferr)
+		}
+	}
+	if err != nil && buffered != nil && r.stdout != nil {
+		outputMutex.Lock()
+		defer outputMutex.Unlock()
+		if _, werr := r.stdout.Write(buffered.Bytes()); werr != nil {
+			log.Printf("Error writing buffered output: %s", werr)
+		}
+	}
+	return err
 }
 
 // Output executes given arguments with the containerExecutable and returns the output.
 func (r *LocalRunner) Output(args []string) ([]byte, error) {
-	ce := getContainerExecutable()
+	ce := r.containerExecutable()
+	args = r.daemonArgs(args)
 	if ShowContainerCommands {
-		log.Printf("Output: %s %s", ce, strings.Join(args, " "))
+		log.Printf("Output: %s %s", ce, strings.Join(redactArgs(args, r.sensitive), " "))
 	}
 	cmd := exec.Command(ce, args...)
-	return cmd.Output()
+	cmd.Env = r.environ()
+	cmd.Dir = r.dir
+	out, err := cmd.Output()
+	return out, wrapExecutableError(ce, err)
+}
+
+// StreamedOutput executes given arguments with the containerExecutable like
+// Output, additionally stderr is written to the prefixed outputs of r while
+// the command runs. The captured stdout is meant for parsing, it is only
+// written in verbose mode and never in quiet mode.
+func (r *LocalRunner) StreamedOutput(args []string) ([]byte, error) {
+	ce := r.containerExecutable()
+	args = r.daemonArgs(args)
+	if Verbose && r.stderr != nil {
+		NewPrefixedLogger(r.prefix, log.New(r.stderr, "", log.LstdFlags)).Printf("Output: %s %s", ce, strings.Join(redactArgs(args, r.sensitive), " "))
+	} else if ShowContainerCommands {
+		log.Printf("Output: %s %s", ce, strings.Join(redactArgs(args, r.sensitive), " "))
+	}
+	cmd := exec.Command(ce, args...)
+	cmd.Env = r.environ()
+	cmd.Dir = r.dir
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	loggers := []*PrefixedLogger{}
+	if r.stdout != nil && Verbose && !Quiet {
+		stdout := NewPrefixedLogger(r.prefix, log.New(r.stdout, "", log.LstdFlags))
+		stdout.SetStream("stdout")
+		cmd.Stdout = io.MultiWriter(&out, stdout)
+		loggers = append(loggers, stdout)
+	}
+	if r.stderr != nil {
+		stderr := NewPrefixedLogger(r.prefix, log.New(r.stderr, "", log.LstdFlags))
+		stderr.SetStream("stderr")
+		cmd.Stderr = stderr
+		loggers = append(loggers, stderr)
+	}
+	err := wrapExecutableError(ce, cmd.Run())
+	for _, l := range loggers {
+		if ferr := l.Flush(); ferr != nil {
+			log.Printf("Error writing output: %s", ferr)
+		}
+	}
+	return out.Bytes(), err
 }
 
 // PrintContainerExecutable returns a function printing the used container executable.
@@ -300,10 +693,8 @@ func (r *LocalRunner) ImageBuilder(step Step, pull bool) func() error {
 		if Verbose {
 			log.Printf("Build image for '%s'", step.ContainerName())
 		}
-		r.prefix = step.ColoredContainerName()
-		r.stdout = step.Meta.Stdout
-		r.stderr = step.Meta.Stderr
-		return r.Exec(step.BuildCommand(pull))
+		r.useStep(step)
+		return r.execProgress(step.BuildCommand(pull))
 	}
 }
 
@@ -313,24 +704,53 @@ func (r *LocalRunner) ImagePuller(step Step) func() error {
 		if Verbose {
 			log.Printf("Pull image for '%s'", step.ContainerName())
 		}
-		r.prefix = step.ColoredContainerName()
-		r.stdout = step.Meta.Stdout
-		r.stderr = step.Meta.Stderr
-		return r.Exec(step.PullCommand())
+		r.useStep(step)
+		if err := r.execProgress(step.PullCommand()); err != nil {
+			return err
+		}
+		if step.ImageDigest() == "" {
+			return nil
+		}
+		out, err := r.Output([]string{"inspect", "--format", "{{range .RepoDigests}}{{println .}}{{end}}", step.ImageName()})
+		if err != nil {
+			return err
+		}
+		return checkImageDigest(step, out)
 	}
 }
 
+// checkImageDigest verifies that the repo digests reported by docker inspect
+// contain the digest the image of step is pinned to.
+func checkImageDigest(step Step, repoDigests []byte) error {
+	expected := step.ImageDigest()
+	found := []string{}
+	scanner := bufio.NewScanner(bytes.NewReader(repoDigests))
+	scanner.Split(bufio.ScanWords)
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), "@", 2)
+		if len(parts) < 2 {
+			continue
+		}
+		if parts[1] == expected {
+			return nil
+		}
+		found = append(found, parts[1])
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return fmt.Errorf("digest mismatch for image '%s', got: '%s'", step.ImageName(), strings.Join(found, ", "))
+}
+
 // ImageExistenceChecker returns a function which checks if the image for the given step exists.
 func (r *LocalRunner) ImageExistenceChecker(step Step) func() error {
 	return func() error {
 		if Verbose {
 			log.Printf("Check image ('%s') existence for '%s'", step.ImageName(), step.ContainerName())
 		}
-		r.prefix = step.ColoredContainerName()
-		r.stdout = step.Meta.Stdout
-		r.stderr = step.Meta.Stderr
+		r.useStep(step)
 		// Search for image
-		out, err := r.Output([]string{"images", "--format", "{{.ID}};{{.Repository}}", step.ImageName()})
+		out, err := r.StreamedOutput([]string{"images", "--format", "{{.ID}};{{.Repository}}", step.ImageName()})
 		if err != nil {
 			return err
 		}
@@ -352,6 +772,52 @@ func (r *LocalRunner) ImageExistenceChecker(step Step) func() error {
 	}
 }
 
+// ImageIdentifier returns a function which returns the id of the image for
+// the given step.
+func (r *LocalRunner) ImageIdentifier(step Step) func() (string, error) {
+	return func() (string, error) {
+		r.useStep(step)
+		out, err := r.Output([]string{"image", "inspect", "--format", "{{.Id}}", step.ImageName()})
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(string(out)), nil
+	}
+}
+
+// ImagePruner returns a function which removes dangling images of the given
+// project. Only images labeled by gantry at build time are removed.
+func (r *LocalRunner) ImagePruner(project string) func() error {
+	return func() error {
+		if Verbose {
+			log.Printf("Prune dangling images of project '%s'", project)
+		}
+		return r.Exec([]string{"image", "prune", "-f", "--filter", fmt.Sprintf("label=%s=%s", LabelProject, project)})
+	}
+}
+
+// ImageRemover returns a function which removes the image of the given step.
+// A missing image is not an error.
+func (r *LocalRunner) ImageRemover(step Step) func() error {
+	return func() error {
+		if Verbose {
+			log.Printf("Remove image '%s'", step.ImageName())
+		}
+		r.useStep(step)
+		out, err := r.StreamedOutput([]string{"images", "-q", step.ImageName()})
+		if err != nil {
+			return err
+		}
+		if len(bytes.TrimSpace(out)) == 0 {
+			if Verbose {
+				log.Printf("Image '%s' not found, nothing to remove", step.ImageName())
+			}
+			return nil
+		}
+		return r.Exec([]string{"rmi", step.ImageName()})
+	}
+}
+
 // ContainerKiller returns a function to kill the container for the given step.
 func (r *LocalRunner) ContainerKiller(step Step) func() (int, error) {
 	return func() (int, error) {
@@ -359,9 +825,7 @@ func (r *LocalRunner) ContainerKiller(step Step) func() (int, error) {
 		if Verbose {
 			log.Printf("Kill container '%s'", step.ContainerName())
 		}
-		r.prefix = step.ColoredContainerName()
-		r.stdout = step.Meta.Stdout
-		r.stderr = step.Meta.Stderr
+		r.useStep(step)
 		// Get id(s) of container with name of step to kill
 		ids, err := r.getContainerIds(step, false)
 		if err != nil {
@@ -384,9 +848,7 @@ func (r *LocalRunner) ContainerRemover(step Step) func() error {
 		if Verbose {
 			log.Printf("Remove container '%s'", step.ContainerName())
 		}
-		r.prefix = step.ColoredContainerName()
-		r.stdout = step.Meta.Stdout
-		r.stderr = step.Meta.Stderr
+		r.useStep(step)
 		// Get id(s) of container with name of step to remove
 		ids, err := r.getContainerIds(step, true)
 		if err != nil {
@@ -408,20 +870,56 @@ func (r *LocalRunner) ContainerRunner(step Step, network Network) func() error {
 		if Verbose {
 			log.Printf("Run container '%s'", step.ContainerName())
 		}
-		r.prefix = step.ColoredContainerName()
-		r.stdout = step.Meta.Stdout
-		r.stderr = step.Meta.Stderr
-		return r.Exec(step.RunCommand(network))
+		r.useStep(step)
+		r.prefix = step.outputPrefix()
+		if step.IPv4Address != "" && network == "" {
+			return fmt.Errorf("ipv4_address of '%s' requires a network", step.ContainerName())
+		}
+		if step.RunTimeout > 0 {
+			return r.runWithTimeout(step, network, time.Duration(step.RunTimeout))
+		}
+		for _, replica := range step.Replicas() {
+			if err := r.Exec(replica.RunCommand(network)); err != nil {
+				return err
+			}
+		}
+		return nil
 	}
 }
 
+// runWithTimeout runs the container of step and kills it if it is still
+// running after timeout. Killing the client alone would leave the container
+// running.
+func (r *LocalRunner) runWithTimeout(step Step, network Network, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	err := r.ExecContext(ctx, step.RunCommand(network))
+	if ctx.Err() != context.DeadlineExceeded {
+		return err
+	}
+	ids, kerr := r.getContainerIds(step, false)
+	if kerr != nil {
+		log.Printf("Error killing %s: %s", step.ColoredName(), kerr)
+	}
+	for _, id := range ids {
+		if kerr := r.Exec([]string{"kill", id}); kerr != nil {
+			log.Printf("Error killing %s: %s", step.ColoredName(), kerr)
+		}
+	}
+	return fmt.Errorf("%s exceeded its run_timeout of %s", step.ColoredName(), timeout)
+}
+
 // ContainerLogReader returns a function retrieving all logs for a given step.
 func (r *LocalRunner) ContainerLogReader(step Step, follow bool) func() error {
 	return func() error {
 		if Verbose {
 			log.Printf("Opening logs for container '%s'", step.ContainerName())
 		}
-		r.prefix = step.ColoredContainerName()
+		// Logs are printed to the outputs of the runner, not to the ones
+		// configured for the step
+		stdout, stderr := r.stdout, r.stderr
+		r.useStep(step)
+		r.stdout, r.stderr = stdout, stderr
 		args := []string{"logs"}
 		if follow {
 			args = append(args, "-f")
@@ -441,6 +939,141 @@ func (r *LocalRunner) ContainerLogReader(step Step, follow bool) func() error {
 	}
 }
 
+// ContainerLogFollower returns a function following the logs for a given step
+// until ctx is done.
+func (r *LocalRunner) ContainerLogFollower(ctx context.Context, step Step) func() error {
+	return func() error {
+		if Verbose {
+			log.Printf("Following logs for container '%s'", step.ContainerName())
+		}
+		r.useStep(step)
+		if r.logTarget != nil {
+			// Merged logs are kept without ANSI formatting
+			target := plainWriter{r.logTarget}
+			r.stdout = target
+			r.stderr = target
+		}
+		ids, err := r.getContainerIds(step, false)
+		if err != nil {
+			return err
+		}
+		if len(ids) < 1 {
+			return fmt.Errorf("no running instance for '%s' found", step.ColoredContainerName())
+		}
+		// Followed logs are printed live as the process runs until ctx is
+		// done, each replica with its own prefix
+		replicas := step.Replicas()
+		errs := make([]error, len(replicas))
+		var wg sync.WaitGroup
+		for i, replica := range replicas {
+			follower := r.Copy().(*LocalRunner)
+			follower.prefix = replica.ColoredContainerName()
+			wg.Add(1)
+			go func(i int, name string) {
+				defer wg.Done()
+				errs[i] = follower.execContext(ctx, []string{"logs", "-f", name}, false, false)
+			}(i, replica.ContainerName())
+		}
+		wg.Wait()
+		if ctx.Err() != nil {
+			return nil
+		}
+		for _, err := range errs {
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// RunningContainerChecker returns a function which fails if no container of
+// the given step is running.
+func (r *LocalRunner) RunningContainerChecker(step Step) func() error {
+	return func() error {
+		r.useStep(step)
+		ids, err := r.getContainerIds(step, false)
+		if err != nil {
+			return err
+		}
+		if len(ids) < 1 {
+			return fmt.Errorf("no running instance for '%s' found", step.ColoredContainerName())
+		}
+		return nil
+	}
+}
+
+// HealthyContainerChecker returns a function which fails unless all replicas
+// of the given step are running in containers labeled with the current
+// project and none of them is unhealthy or still starting. Containers are
+// matched by their labels only, changes of the definition are not detected.
+func (r *LocalRunner) HealthyContainerChecker(step Step) func() error {
+	return func() error {
+		r.useStep(step)
+		args := []string{
+			"ps", "-q",
+			"--filter", fmt.Sprintf("label=%s=%s", LabelProject, ProjectName),
+			"--filter", fmt.Sprintf("label=%s=%s", LabelService, step.RawContainerName()),
+		}
+		out, err := r.Output(args)
+		if err != nil {
+			return err
+		}
+		running := len(strings.Fields(string(out)))
+		if replicas := len(step.Replicas()); running < replicas {
+			return fmt.Errorf("%d of %d instances of '%s' running", running, replicas, step.ColoredContainerName())
+		}
+		// Containers without healthcheck are considered healthy
+		for _, health := range []string{"unhealthy", "starting"} {
+			out, err = r.Output(append(args, "--filter", "health="+health))
+			if err != nil {
+				return err
+			}
+			if len(strings.Fields(string(out))) > 0 {
+				return fmt.Errorf("%s instance of '%s' found", health, step.ColoredContainerName())
+			}
+		}
+		return nil
+	}
+}
+
+// DaemonChecker returns a function which fails if the daemon selected by the
+// given step does not answer.
+func (r *LocalRunner) DaemonChecker(step Step) func() error {
+	return func() error {
+		r.useStep(step)
+		_, err := r.Output([]string{"version", "--format", "{{.Server.Version}}"})
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			if msg := strings.TrimSpace(string(exitErr.Stderr)); msg != "" {
+				return fmt.Errorf("%s: %s", err, msg)
+			}
+		}
+		return err
+	}
+}
+
+// ContainerStats returns a function sampling the resource usage of all
+// running containers of a given step.
+func (r *LocalRunner) ContainerStats(step Step) func() (ContainerStats, error) {
+	return func() (ContainerStats, error) {
+		r.useStep(step)
+		ids, err := r.getContainerIds(step, false)
+		if err != nil {
+			return ContainerStats{}, err
+		}
+		if len(ids) < 1 {
+			return ContainerStats{}, fmt.Errorf("no running instance for '%s' found", step.ColoredContainerName())
+		}
+		args := append([]string{"stats", "--no-stream", "--format", "{{.CPUPerc}} {{.MemUsage}}"}, ids...)
+		out, err := r.Output(args)
+		if err != nil {
+			return ContainerStats{}, err
+		}
+		return parseContainerStats(out)
+	}
+}
+
 // NetworkCreator returns a function to create the given network.
 func (r *LocalRunner) NetworkCreator(network Network) func() error {
 	return func() error {
@@ -482,14 +1115,19 @@ func (r *LocalRunner) NetworkRemover(network Network) func() error {
 }
 
 // getContainerIds retrieves a list of ids for the step, if the all flag is set
-// stopped containers are returned aswell.
+// stopped containers are returned aswell. Containers are found by the project
+// and service labels set when they are run, which includes all replicas.
 func (r *LocalRunner) getContainerIds(step Step, all bool) ([]string, error) {
 	ids := []string{}
-	args := []string{"ps", "-q", "--filter", fmt.Sprintf("name=%s$", step.ContainerName())}
+	args := []string{
+		"ps", "-q",
+		"--filter", fmt.Sprintf("label=%s=%s", LabelProject, ProjectName),
+		"--filter", fmt.Sprintf("label=%s=%s", LabelService, step.RawContainerName()),
+	}
 	if all {
 		args = append(args, "-a")
 	}
-	out, err := r.Output(args)
+	out, err := r.StreamedOutput(args)
 	if err != nil {
 		return ids, err
 	}
@@ -1,7 +1,17 @@
 package gantry
 
 import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/ad-freiburg/gantry/types"
 )
 
 func TestGetContainerExecutable(t *testing.T) {
@@ -15,6 +25,52 @@ func TestGetContainerExecutable(t *testing.T) {
 	}
 }
 
+func TestWharferStatus(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wharfer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := os.Getenv("PATH")
+	defer os.Setenv("PATH", path)
+	os.Setenv("PATH", dir)
+
+	cases := []struct {
+		script string
+		found  bool
+		err    bool
+	}{
+		{"", false, false},
+		{"#!/bin/sh\necho 'wharfer 1.0'\n", true, false},
+		{"#!/bin/sh\necho 'broken config' >&2\nexit 1\n", true, true},
+	}
+	for i, c := range cases {
+		if c.script != "" {
+			if err := ioutil.WriteFile(filepath.Join(dir, wharfer), []byte(c.script), 0755); err != nil {
+				t.Fatal(err)
+			}
+		}
+		found, err := wharferStatus()
+		if found != c.found {
+			t.Errorf("incorrect found in case %d, got: %t, wanted: %t", i, found, c.found)
+		}
+		if (err != nil) != c.err {
+			t.Errorf("incorrect error in case %d, got: %v, wanted error: %t", i, err, c.err)
+		}
+	}
+}
+
+func TestLocalRunnerContainerExecutable(t *testing.T) {
+	r := NewLocalRunner("prefix", os.Stdout, os.Stderr)
+	if e := r.containerExecutable(); e != getContainerExecutable() {
+		t.Errorf("incorrect executable without override, got: %s, wanted: %s", e, getContainerExecutable())
+	}
+	r.executable = wharfer
+	if e := r.containerExecutable(); e != wharfer {
+		t.Errorf("incorrect executable with override, got: %s, wanted: %s", e, wharfer)
+	}
+}
+
 func TestNoopRunnerCopy(t *testing.T) {
 	s := NewNoopRunner(true)
 	c, ok := s.Copy().(*NoopRunner)
@@ -36,3 +92,287 @@ func TestNoopRunnerCopy(t *testing.T) {
 		t.Errorf("incorrect value in copy, got: %T, wanted: %T", c.silent, s.silent)
 	}
 }
+
+func TestLocalRunnerCopy(t *testing.T) {
+	s := NewLocalRunner("prefix", os.Stdout, os.Stderr)
+	s.SetEnvironment(map[string]string{"FOO": "bar"})
+	s.SetWorkingDirectory("/tmp")
+	s.SetLogTarget(os.Stdout)
+	s.executable = wharfer
+	s.dockerHost = "tcp://remote:2375"
+	c, ok := s.Copy().(*LocalRunner)
+	if !ok {
+		t.Errorf("incorrect return type")
+		return
+	}
+	if !reflect.DeepEqual(s, c) {
+		t.Errorf("incorrect copy, got: %#v, wanted: %#v", c, s)
+	}
+	c.env["FOO"] = "baz"
+	if s.env["FOO"] != "bar" {
+		t.Errorf("environment of copy is shared with original")
+	}
+}
+
+func TestLocalRunnerEnviron(t *testing.T) {
+	r := NewLocalRunner("prefix", os.Stdout, os.Stderr)
+	if env := r.environ(); env != nil {
+		t.Errorf("incorrect environment without additional values, got: %v, wanted: nil", env)
+	}
+	r.SetEnvironment(map[string]string{"B": "2", "A": "1"})
+	env := r.environ()
+	expected := append(os.Environ(), "A=1", "B=2")
+	if !reflect.DeepEqual(env, expected) {
+		t.Errorf("incorrect environment, got: %v, wanted: %v", env, expected)
+	}
+}
+
+func TestLocalRunnerDaemon(t *testing.T) {
+	defer func() {
+		DockerHost = ""
+		DockerContext = ""
+	}()
+	r := NewLocalRunner("prefix", os.Stdout, os.Stderr)
+	args := []string{"ps", "-q"}
+	if result := r.daemonArgs(args); !reflect.DeepEqual(result, args) {
+		t.Errorf("incorrect args without context, got: %v, wanted: %v", result, args)
+	}
+	if env := r.environ(); env != nil {
+		t.Errorf("incorrect environment without host, got: %v, wanted: nil", env)
+	}
+
+	DockerHost = "ssh://global"
+	DockerContext = "global"
+	expected := []string{"--context", "global", "ps", "-q"}
+	if result := r.daemonArgs(args); !reflect.DeepEqual(result, expected) {
+		t.Errorf("incorrect args with global context, got: %v, wanted: %v", result, expected)
+	}
+	env := r.environ()
+	if len(env) == 0 || env[len(env)-1] != "DOCKER_HOST=ssh://global" {
+		t.Errorf("incorrect environment with global host, got: %v", env)
+	}
+
+	// Steps override the global daemon
+	r.dockerHost = "tcp://step:2375"
+	r.dockerContext = "step"
+	expected = []string{"--context", "step", "ps", "-q"}
+	if result := r.daemonArgs(args); !reflect.DeepEqual(result, expected) {
+		t.Errorf("incorrect args with context of step, got: %v, wanted: %v", result, expected)
+	}
+	env = r.environ()
+	if len(env) == 0 || env[len(env)-1] != "DOCKER_HOST=tcp://step:2375" {
+		t.Errorf("incorrect environment with host of step, got: %v", env)
+	}
+}
+
+func TestCheckImageDigest(t *testing.T) {
+	step := Step{Service: Service{Image: "alpine@sha256:abc"}}
+	cases := []struct {
+		out string
+		err bool
+	}{
+		{"alpine@sha256:abc\n", false},
+		{"mirror/alpine@sha256:def\nalpine@sha256:abc\n", false},
+		{"alpine@sha256:def\n", true},
+		{"", true},
+	}
+
+	for _, c := range cases {
+		err := checkImageDigest(step, []byte(c.out))
+		if (err != nil) != c.err {
+			t.Errorf("incorrect error for '%s', got: '%v'", c.out, err)
+		}
+	}
+}
+
+func TestRedactArgs(t *testing.T) {
+	sensitive := types.StringSet{"TOKEN": true}
+	cases := []struct {
+		args   []string
+		result []string
+	}{
+		{[]string{"build", "--tag", "img", "."}, []string{"build", "--tag", "img", "."}},
+		{[]string{"build", "--secret", "id=token,src=/home/user/token.txt", "."}, []string{"build", "--secret", "id=token,src=***", "."}},
+		{[]string{"build", "--secret", "src=/token.txt", "."}, []string{"build", "--secret", "id=?,src=***", "."}},
+		{[]string{"build", "--build-arg", "TOKEN=abc", "--build-arg", "USER=me", "."}, []string{"build", "--build-arg", "TOKEN=***", "--build-arg", "USER=me", "."}},
+		{[]string{"run", "-e", "TOKEN=abc", "-e", "TOKEN_ID=1", "img"}, []string{"run", "-e", "TOKEN=***", "-e", "TOKEN_ID=1", "img"}},
+	}
+
+	for _, c := range cases {
+		r := redactArgs(c.args, sensitive)
+		if !reflect.DeepEqual(r, c.result) {
+			t.Errorf("incorrect result for '%v', got: '%v', wanted: '%v'", c.args, r, c.result)
+		}
+	}
+}
+
+func TestLocalRunnerStreamedOutput(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gantry_streamed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	executable := filepath.Join(dir, "fake")
+	script := "#!/bin/sh\necho \"out $1\"\necho 'progress' >&2\n"
+	if err := ioutil.WriteFile(executable, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	var stdout, stderr bytes.Buffer
+	r := NewLocalRunner("prefix", &stdout, &stderr)
+	r.executable = executable
+	out, err := r.StreamedOutput([]string{"images"})
+	if err != nil {
+		t.Fatalf("Got unexpected error: %#v", err)
+	}
+	if string(out) != "out images\n" {
+		t.Errorf("Incorrect output, got: '%s', wanted: 'out images\\n'", out)
+	}
+	if stdout.Len() != 0 {
+		t.Errorf("Stdout streamed without verbose mode, got: '%s'", stdout.String())
+	}
+	if !strings.Contains(stderr.String(), "prefix") || !strings.Contains(stderr.String(), "progress") {
+		t.Errorf("Stderr not streamed, got: '%s'", stderr.String())
+	}
+
+	// Verbose mode also streams stdout
+	Verbose = true
+	defer func() { Verbose = false }()
+	if _, err := r.StreamedOutput([]string{"images"}); err != nil {
+		t.Fatalf("Got unexpected error: %#v", err)
+	}
+	if !strings.Contains(stdout.String(), "prefix") || !strings.Contains(stdout.String(), "out images") {
+		t.Errorf("Stdout not streamed in verbose mode, got: '%s'", stdout.String())
+	}
+
+	// Quiet only keeps stdout from the console
+	Quiet = true
+	defer func() { Quiet = false }()
+	stdout.Reset()
+	if out, err := r.StreamedOutput([]string{"ps"}); err != nil || string(out) != "out ps\n" {
+		t.Errorf("Incorrect quiet output, got: '%s', %v", out, err)
+	}
+	if stdout.Len() != 0 {
+		t.Errorf("Stdout streamed in quiet mode, got: '%s'", stdout.String())
+	}
+}
+
+func TestLocalRunnerContainerRunnerRunTimeout(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gantry_timeout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	calls := filepath.Join(dir, "calls")
+	executable := filepath.Join(dir, "fake")
+	script := "#!/bin/sh\necho \"$1\" >> " + calls + "\ncase \"$1\" in\nrun) exec sleep 10;;\nps) echo id;;\nesac\n"
+	if err := ioutil.WriteFile(executable, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	step := Step{
+		Service: Service{
+			Name:  "a",
+			Image: "alpine",
+			Meta:  ServiceMeta{Type: ServiceTypeStep},
+		},
+		Executable: executable,
+		RunTimeout: types.Duration(100 * time.Millisecond),
+	}
+	if err := step.Meta.Open(); err != nil {
+		t.Fatal(err)
+	}
+	r := NewLocalRunner("prefix", nil, nil)
+	start := time.Now()
+	err = r.ContainerRunner(step, "")()
+	if err == nil || !strings.Contains(err.Error(), "run_timeout") {
+		t.Errorf("Expected run_timeout error, got: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Container not killed after run_timeout, took: %s", elapsed)
+	}
+	data, err := ioutil.ReadFile(calls)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(string(data), "kill\n") {
+		t.Errorf("Container not killed, calls: %q", data)
+	}
+}
+
+func TestLocalRunnerGetContainerIds(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gantry_ps")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	calls := filepath.Join(dir, "calls")
+	executable := filepath.Join(dir, "fake")
+	script := "#!/bin/sh\necho \"$@\" >> " + calls + "\necho id1\necho id2\n"
+	if err := ioutil.WriteFile(executable, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	defer func(name string) { ProjectName = name }(ProjectName)
+	ProjectName = "p"
+	step := Step{
+		Service: Service{
+			Name:  "a",
+			Image: "alpine",
+			Scale: 2,
+			Meta:  ServiceMeta{Type: ServiceTypeService},
+		},
+		Executable: executable,
+	}
+	r := NewLocalRunner("prefix", nil, nil)
+	r.useStep(step)
+	ids, err := r.getContainerIds(step, true)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %#v", err)
+	}
+	if !reflect.DeepEqual(ids, []string{"id1", "id2"}) {
+		t.Errorf("Incorrect ids, got: '%v', wanted: '[id1 id2]'", ids)
+	}
+	data, err := ioutil.ReadFile(calls)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wanted := "ps -q --filter label=gantry.project=p --filter label=gantry.service=a -a\n"; string(data) != wanted {
+		t.Errorf("Incorrect queries, got: %q, wanted: %q", data, wanted)
+	}
+}
+
+func TestLocalRunnerContainerLogFollowerReplicas(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gantry_follow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	executable := filepath.Join(dir, "fake")
+	script := "#!/bin/sh\ncase \"$1\" in\nps) echo id;;\nlogs) echo \"line of $3\";;\nesac\n"
+	if err := ioutil.WriteFile(executable, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	defer func(name string) { ProjectName = name }(ProjectName)
+	ProjectName = "p"
+	step := Step{
+		Service: Service{
+			Name:  "a",
+			Image: "alpine",
+			Scale: 2,
+			Meta:  ServiceMeta{Type: ServiceTypeService},
+		},
+		Executable: executable,
+	}
+	var logs bytes.Buffer
+	r := NewLocalRunner("prefix", nil, nil)
+	r.SetLogTarget(&logs)
+	if err := r.ContainerLogFollower(context.Background(), step)(); err != nil {
+		t.Fatalf("Got unexpected error: %#v", err)
+	}
+	for _, name := range []string{"p_a_1", "p_a_2"} {
+		if !strings.Contains(logs.String(), name+" line of "+name+"\n") {
+			t.Errorf("Missing logs of replica '%s' with its prefix, got: %q", name, logs.String())
+		}
+	}
+	if strings.Contains(logs.String(), "\x1b") {
+		t.Errorf("Unexpected ANSI formatting in merged logs, got: %q", logs.String())
+	}
+}
@@ -1,6 +1,7 @@
 package gantry_test
 
 import (
+	"context"
 	"fmt"
 	"testing"
 
@@ -151,6 +152,36 @@ func TestNoopRunnerContainerRunner(t *testing.T) {
 	checkCallsAndCalled(t, runner, key, 1, 1)
 }
 
+func TestNoopRunnerContainerLogFollower(t *testing.T) {
+	runner := gantry.NewNoopRunner(true)
+	step := gantry.Step{}
+	step.Name = stepName
+	key := fmt.Sprintf("ContainerLogFollower(%s)", step.Name)
+	checkCallsAndCalled(t, runner, key, 0, 0)
+
+	f := runner.ContainerLogFollower(context.Background(), step)
+	checkCallsAndCalled(t, runner, key, 1, 0)
+
+	if err := f(); err != nil {
+		t.Errorf("unexpected error, got: '%#v', wanted 'nil'", err)
+	}
+	checkCallsAndCalled(t, runner, key, 1, 1)
+}
+
+func TestNoopRunnerImagePruner(t *testing.T) {
+	runner := gantry.NewNoopRunner(true)
+	key := "ImagePruner(project)"
+	checkCallsAndCalled(t, runner, key, 0, 0)
+
+	f := runner.ImagePruner("project")
+	checkCallsAndCalled(t, runner, key, 1, 0)
+
+	if err := f(); err != nil {
+		t.Errorf("unexpected error, got: '%#v', wanted 'nil'", err)
+	}
+	checkCallsAndCalled(t, runner, key, 1, 1)
+}
+
 func TestNoopRunnerNetworkCreator(t *testing.T) {
 	runner := gantry.NewNoopRunner(true)
 	network := gantry.Network(networkName)
@@ -180,3 +211,51 @@ func TestNoopRunnerNetworkRemover(t *testing.T) {
 	}
 	checkCallsAndCalled(t, runner, key, 1, 1)
 }
+
+func TestNoopRunnerRunningContainerChecker(t *testing.T) {
+	runner := gantry.NewNoopRunner(true)
+	step := gantry.Step{Service: gantry.Service{Name: "foo"}}
+
+	key := "RunningContainerChecker(foo)"
+	checkCallsAndCalled(t, runner, key, 0, 0)
+
+	f := runner.RunningContainerChecker(step)
+	checkCallsAndCalled(t, runner, key, 1, 0)
+
+	if err := f(); err != nil {
+		t.Errorf("unexpected error, got: '%#v', wanted 'nil'", err)
+	}
+	checkCallsAndCalled(t, runner, key, 1, 1)
+}
+
+func TestNoopRunnerImageRemover(t *testing.T) {
+	runner := gantry.NewNoopRunner(true)
+	step := gantry.Step{Service: gantry.Service{Name: "foo"}}
+
+	key := "ImageRemover(foo)"
+	checkCallsAndCalled(t, runner, key, 0, 0)
+
+	f := runner.ImageRemover(step)
+	checkCallsAndCalled(t, runner, key, 1, 0)
+
+	if err := f(); err != nil {
+		t.Errorf("unexpected error, got: '%#v', wanted 'nil'", err)
+	}
+	checkCallsAndCalled(t, runner, key, 1, 1)
+}
+
+func TestNoopRunnerHealthyContainerChecker(t *testing.T) {
+	runner := gantry.NewNoopRunner(true)
+	step := gantry.Step{Service: gantry.Service{Name: "foo"}}
+
+	key := "HealthyContainerChecker(foo)"
+	checkCallsAndCalled(t, runner, key, 0, 0)
+
+	f := runner.HealthyContainerChecker(step)
+	checkCallsAndCalled(t, runner, key, 1, 0)
+
+	if err := f(); err != nil {
+		t.Errorf("unexpected error, got: '%#v', wanted 'nil'", err)
+	}
+	checkCallsAndCalled(t, runner, key, 1, 1)
+}
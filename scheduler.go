@@ -0,0 +1,117 @@
+package gantry // import "github.com/ad-freiburg/gantry"
+
+import (
+	"sort"
+	"sync"
+)
+
+// scheduler limits the number of concurrently running steps. Waiting steps
+// with a higher priority are started first, ties are broken by name.
+type scheduler struct {
+	limit   int
+	running int
+	waiting []*schedulerRequest
+	// dependentsLimit stores the maximum number of concurrently running
+	// direct dependents per step, dependents the number currently running.
+	dependentsLimit map[string]int
+	dependents      map[string]int
+	m               sync.Mutex
+}
+
+// schedulerRequest represents a step waiting for a slot of a scheduler.
+type schedulerRequest struct {
+	step  Step
+	ready chan struct{}
+}
+
+// newScheduler returns a scheduler running at most limit steps at once. A
+// limit less than 1 does not restrict the number of running steps.
+func newScheduler(limit int) *scheduler {
+	return &scheduler{
+		limit:           limit,
+		waiting:         make([]*schedulerRequest, 0),
+		dependentsLimit: make(map[string]int),
+		dependents:      make(map[string]int),
+	}
+}
+
+// limitDependents restricts the number of concurrently running direct
+// dependents of all steps setting max_parallel_dependents.
+func (s *scheduler) limitDependents(steps []Step) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	for _, step := range steps {
+		if step.MaxParallelDependents > 0 {
+			s.dependentsLimit[step.Name] = step.MaxParallelDependents
+		}
+	}
+}
+
+// request queues step for execution, the returned request is ready as soon
+// as a slot is assigned to it.
+func (s *scheduler) request(step Step) *schedulerRequest {
+	r := &schedulerRequest{
+		step:  step,
+		ready: make(chan struct{}),
+	}
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.waiting = append(s.waiting, r)
+	s.dispatch()
+	return r
+}
+
+// release frees the slot of the finished step.
+func (s *scheduler) release(step Step) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.running--
+	for dep := range step.Dependencies() {
+		if _, ok := s.dependentsLimit[dep]; ok {
+			s.dependents[dep]--
+		}
+	}
+	s.dispatch()
+}
+
+// canStart checks whether starting step exceeds the dependents limit of one
+// of its dependencies, s.m must be held.
+func (s *scheduler) canStart(step Step) bool {
+	for dep := range step.Dependencies() {
+		if limit, ok := s.dependentsLimit[dep]; ok && s.dependents[dep] >= limit {
+			return false
+		}
+	}
+	return true
+}
+
+// dispatch assigns free slots to waiting requests, s.m must be held.
+func (s *scheduler) dispatch() {
+	sort.SliceStable(s.waiting, func(i, j int) bool {
+		a, b := s.waiting[i].step, s.waiting[j].step
+		if a.Priority == b.Priority {
+			return a.Name < b.Name
+		}
+		return a.Priority > b.Priority
+	})
+	waiting := make([]*schedulerRequest, 0, len(s.waiting))
+	for _, r := range s.waiting {
+		if (s.limit > 0 && s.running >= s.limit) || !s.canStart(r.step) {
+			waiting = append(waiting, r)
+			continue
+		}
+		for dep := range r.step.Dependencies() {
+			if _, ok := s.dependentsLimit[dep]; ok {
+				s.dependents[dep]++
+			}
+		}
+		s.running++
+		close(r.ready)
+	}
+	s.waiting = waiting
+}
+
+// wait blocks until r is assigned a slot.
+func (r *schedulerRequest) wait() {
+	<-r.ready
+}
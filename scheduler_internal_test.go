@@ -0,0 +1,98 @@
+package gantry
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSchedulerPriority(t *testing.T) {
+	s := newScheduler(1)
+	blocker := s.request(Step{Service: Service{Name: "blocker"}})
+	blocker.wait()
+
+	steps := []Step{
+		{Service: Service{Name: "b"}},
+		{Service: Service{Name: "a"}},
+		{Service: Service{Name: "c", Priority: 5}},
+		{Service: Service{Name: "d", Priority: -1}},
+	}
+	requests := make(map[string]*schedulerRequest)
+	for _, step := range steps {
+		requests[step.Name] = s.request(step)
+	}
+
+	order := []string{}
+	for range steps {
+		s.release(Step{})
+		for name, r := range requests {
+			select {
+			case <-r.ready:
+				order = append(order, name)
+				delete(requests, name)
+			default:
+			}
+		}
+	}
+	expected := []string{"c", "a", "b", "d"}
+	if !reflect.DeepEqual(order, expected) {
+		t.Errorf("Incorrect order, got: '%v', wanted: '%v'", order, expected)
+	}
+}
+
+func TestSchedulerLimit(t *testing.T) {
+	cases := []struct {
+		limit int
+		ready int
+	}{
+		{0, 4},
+		{1, 1},
+		{3, 3},
+	}
+
+	for _, c := range cases {
+		s := newScheduler(c.limit)
+		ready := 0
+		for _, name := range []string{"a", "b", "c", "d"} {
+			r := s.request(Step{Service: Service{Name: name}})
+			select {
+			case <-r.ready:
+				ready++
+			default:
+			}
+		}
+		if ready != c.ready {
+			t.Errorf("Incorrect number of ready requests for limit '%d', got: '%d', wanted: '%d'", c.limit, ready, c.ready)
+		}
+	}
+}
+
+func TestSchedulerMaxParallelDependents(t *testing.T) {
+	s := newScheduler(0)
+	s.limitDependents([]Step{
+		{Service: Service{Name: "db"}, MaxParallelDependents: 2},
+	})
+	dependent := func(name string) Step {
+		return Step{Service: Service{Name: name, DependsOn: DependsOn{"db": DependencyConditionStarted}}}
+	}
+	requests := map[string]*schedulerRequest{}
+	for _, step := range []Step{dependent("a"), dependent("b"), dependent("c"), {Service: Service{Name: "d"}}} {
+		requests[step.Name] = s.request(step)
+	}
+	isReady := func(name string) bool {
+		select {
+		case <-requests[name].ready:
+			return true
+		default:
+			return false
+		}
+	}
+	for name, ready := range map[string]bool{"a": true, "b": true, "c": false, "d": true} {
+		if r := isReady(name); r != ready {
+			t.Errorf("Incorrect state for '%s', got: '%t', wanted: '%t'", name, r, ready)
+		}
+	}
+	s.release(dependent("a"))
+	if !isReady("c") {
+		t.Errorf("Expected 'c' to be ready after 'a' finished")
+	}
+}
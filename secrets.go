@@ -0,0 +1,56 @@
+package gantry // import "github.com/ad-freiburg/gantry"
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ad-freiburg/gantry/types"
+)
+
+// DefaultSecretsDir is the directory docker and podman mount secrets to.
+const DefaultSecretsDir = "/run/secrets"
+
+// readSecretsDir returns the trimmed contents of all regular files in dir
+// keyed by their names. A missing directory contains no secrets.
+func readSecretsDir(dir string) (types.StringMap, error) {
+	result := types.StringMap{}
+	files, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return result, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		if !f.Mode().IsRegular() {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(dir, f.Name()))
+		if err != nil {
+			return nil, err
+		}
+		value := strings.TrimSpace(string(data))
+		result[f.Name()] = &value
+	}
+	return result, nil
+}
+
+// loadSecrets adds all secrets of dir as substitutions, unless a value is
+// defined already, and registers them to be redacted.
+func (e *PipelineEnvironment) loadSecrets(dir string) error {
+	secrets, err := readSecretsDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, name := range secrets.Keys() {
+		if value, ok := e.GetSubstitution(name); ok && value != nil {
+			continue
+		}
+		RegisterSecret(*secrets[name])
+		e.Substitutions[name] = secrets[name]
+		e.setSources(types.StringMap{name: secrets[name]}, SubstitutionSourceSecrets)
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package gantry // import "github.com/ad-freiburg/gantry"
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// DefaultShell is used to run commands of steps with shell set to true.
+const DefaultShell = "/bin/sh"
+
+// Shell stores the shell used to run the command of a step, commands are run
+// without a shell if empty.
+type Shell string
+
+// UnmarshalJSON sets *s from true for DefaultShell, false for no shell or the
+// path of a shell.
+func (s *Shell) UnmarshalJSON(data []byte) error {
+	var enabled bool
+	if err := json.Unmarshal(data, &enabled); err == nil {
+		*s = ""
+		if enabled {
+			*s = DefaultShell
+		}
+		return nil
+	}
+	var path string
+	if err := json.Unmarshal(data, &path); err != nil {
+		return fmt.Errorf("shell has to be a boolean or the path of a shell: %s", string(data))
+	}
+	*s = Shell(path)
+	return nil
+}
+
+// shellSafeRegexp matches words which need no quoting in a shell.
+var shellSafeRegexp = regexp.MustCompile(`^[A-Za-z0-9_@%+=:,./-]+$`)
+
+// shellCommand returns the script run by a shell for command. A single string
+// is the script itself, the elements of a command in exec form are quoted so
+// the shell passes them unchanged.
+func shellCommand(command []string) string {
+	if len(command) == 1 {
+		return command[0]
+	}
+	quoted := make([]string, len(command))
+	for i, arg := range command {
+		quoted[i] = shellQuote(arg)
+	}
+	return strings.Join(quoted, " ")
+}
+
+// shellQuote quotes arg for a POSIX shell if needed.
+func shellQuote(arg string) string {
+	if shellSafeRegexp.MatchString(arg) {
+		return arg
+	}
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+}
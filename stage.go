@@ -0,0 +1,81 @@
+package gantry // import "github.com/ad-freiburg/gantry"
+
+import (
+	"fmt"
+
+	"github.com/ad-freiburg/gantry/types"
+)
+
+// Stage stores an explicitly declared group of steps. All steps of a stage
+// have to finish before any step of a later stage is started.
+type Stage struct {
+	Name  string   `json:"name"`
+	Steps []string `json:"steps"`
+}
+
+// applyStages adds a dependency on every step of all previous stages to each
+// step of a stage. Steps which are not part of any stage are not affected.
+func applyStages(stages []Stage, steps map[string]Step) error {
+	if len(stages) == 0 {
+		return nil
+	}
+	stageOf := make(map[string]int)
+	for i, stage := range stages {
+		for _, name := range stage.Steps {
+			if _, ok := steps[name]; !ok {
+				return fmt.Errorf("unknown step '%s' in stage '%s'", name, stage.Name)
+			}
+			if j, ok := stageOf[name]; ok {
+				return fmt.Errorf("step '%s' is part of stage '%s' and '%s'", name, stages[j].Name, stage.Name)
+			}
+			stageOf[name] = i
+		}
+	}
+	// Real dependencies must not point to a step of a later stage
+	for name, i := range stageOf {
+		for _, dep := range sortedKeys(transitiveDependencies(name, steps)) {
+			if j, ok := stageOf[dep]; ok && j > i {
+				return StageConflictError{
+					Step:            name,
+					Stage:           stages[i].Name,
+					Dependency:      dep,
+					DependencyStage: stages[j].Name,
+				}
+			}
+		}
+	}
+	previous := types.StringSet{}
+	for _, stage := range stages {
+		for _, name := range stage.Steps {
+			step := steps[name]
+			step.stageDependencies = types.StringSet{}
+			for dep := range previous {
+				step.stageDependencies[dep] = true
+			}
+			steps[name] = step
+		}
+		for _, name := range stage.Steps {
+			previous[name] = true
+		}
+	}
+	return nil
+}
+
+// transitiveDependencies returns all steps which name depends on directly or
+// indirectly.
+func transitiveDependencies(name string, steps map[string]Step) types.StringSet {
+	result := types.StringSet{}
+	queue := []string{name}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		for dep := range steps[current].Dependencies() {
+			if result[dep] {
+				continue
+			}
+			result[dep] = true
+			queue = append(queue, dep)
+		}
+	}
+	return result
+}
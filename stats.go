@@ -0,0 +1,199 @@
+package gantry // import "github.com/ad-freiburg/gantry"
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ContainerStats stores a single resource usage sample of all containers of a
+// step.
+type ContainerStats struct {
+	CPUPercent  float64
+	MemoryBytes uint64
+}
+
+// ServiceStats summarizes all samples of a service.
+type ServiceStats struct {
+	Name       string
+	Samples    int
+	PeakMemory uint64
+	AverageCPU float64
+}
+
+// statsSampler periodically samples the resource usage of detached services.
+type statsSampler struct {
+	interval time.Duration
+	ctx      context.Context
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+	samples  map[string][]ContainerStats
+	m        sync.Mutex
+}
+
+func newStatsSampler(interval time.Duration) *statsSampler {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &statsSampler{
+		interval: interval,
+		ctx:      ctx,
+		cancel:   cancel,
+		samples:  make(map[string][]ContainerStats),
+	}
+}
+
+// Sample starts sampling the resource usage of step in the background. A copy
+// of runner is used, as runner may be used by others meanwhile.
+func (s *statsSampler) Sample(runner Runner, step Step) {
+	stats := runner.Copy().ContainerStats(step)
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+		for {
+			sample, err := stats()
+			if err != nil {
+				if s.ctx.Err() == nil {
+					pipelineLogger.Printf("Error sampling stats of %s: %s", step.ColoredName(), err)
+				}
+				return
+			}
+			s.add(step.Name, sample)
+			select {
+			case <-s.ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (s *statsSampler) add(name string, sample ContainerStats) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.samples[name] = append(s.samples[name], sample)
+}
+
+// Stop stops all samplers and waits for them to finish.
+func (s *statsSampler) Stop() {
+	s.cancel()
+	s.wg.Wait()
+}
+
+// Summary returns the summary of all sampled services ordered by name.
+func (s *statsSampler) Summary() []ServiceStats {
+	s.m.Lock()
+	defer s.m.Unlock()
+	result := make([]ServiceStats, 0, len(s.samples))
+	for name, samples := range s.samples {
+		summary := ServiceStats{
+			Name:    name,
+			Samples: len(samples),
+		}
+		for _, sample := range samples {
+			if sample.MemoryBytes > summary.PeakMemory {
+				summary.PeakMemory = sample.MemoryBytes
+			}
+			summary.AverageCPU += sample.CPUPercent
+		}
+		if len(samples) > 0 {
+			summary.AverageCPU /= float64(len(samples))
+		}
+		result = append(result, summary)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+// PrintSummary writes one line per sampled service to w.
+func (s *statsSampler) PrintSummary(w io.Writer) error {
+	for _, summary := range s.Summary() {
+		if _, err := fmt.Fprintf(w, "%s: peak memory %s, average cpu %.2f%% (%d samples)\n", summary.Name, formatBytes(summary.PeakMemory), summary.AverageCPU, summary.Samples); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseContainerStats parses the output of `docker stats --no-stream --format
+// "{{.CPUPerc}} {{.MemUsage}}"`, the usage of all lines is summed up.
+func parseContainerStats(out []byte) (ContainerStats, error) {
+	result := ContainerStats{}
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			return result, fmt.Errorf("invalid stats line: '%s'", line)
+		}
+		cpu, err := strconv.ParseFloat(strings.TrimSuffix(fields[0], "%"), 64)
+		if err != nil {
+			return result, fmt.Errorf("invalid cpu usage '%s': %s", fields[0], err)
+		}
+		memory, err := parseByteSize(fields[1])
+		if err != nil {
+			return result, err
+		}
+		result.CPUPercent += cpu
+		result.MemoryBytes += memory
+	}
+	return result, scanner.Err()
+}
+
+var byteUnits = map[string]float64{
+	"b":   1,
+	"kb":  1e3,
+	"mb":  1e6,
+	"gb":  1e9,
+	"tb":  1e12,
+	"kib": 1 << 10,
+	"mib": 1 << 20,
+	"gib": 1 << 30,
+	"tib": 1 << 40,
+}
+
+// parseByteSize parses sizes like 12.5MiB or 3kB as printed by docker.
+func parseByteSize(s string) (uint64, error) {
+	i := strings.IndexFunc(s, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if i <= 0 {
+		return 0, fmt.Errorf("invalid size '%s'", s)
+	}
+	value, err := strconv.ParseFloat(s[:i], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size '%s': %s", s, err)
+	}
+	unit, ok := byteUnits[strings.ToLower(s[i:])]
+	if !ok {
+		return 0, fmt.Errorf("invalid unit in size '%s'", s)
+	}
+	return uint64(value * unit), nil
+}
+
+// formatBytes formats b using binary units.
+func formatBytes(b uint64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+	value := float64(b)
+	i := 0
+	for value >= 1024 && i < len(units)-1 {
+		value /= 1024
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%d%s", b, units[i])
+	}
+	return fmt.Sprintf("%.1f%s", value, units[i])
+}
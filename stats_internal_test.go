@@ -0,0 +1,126 @@
+package gantry
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseContainerStats(t *testing.T) {
+	cases := []struct {
+		input  string
+		result ContainerStats
+		err    bool
+	}{
+		{"", ContainerStats{}, false},
+		{"12.50% 1.5MiB / 1.944GiB\n", ContainerStats{12.5, 1572864}, false},
+		{"1.00% 512KiB / 2GiB\n2.50% 1kB / 2GiB\n", ContainerStats{3.5, 525288}, false},
+		{"0.00% 0B / 0B", ContainerStats{0, 0}, false},
+		{"--", ContainerStats{}, true},
+		{"abc% 1MiB / 2GiB", ContainerStats{}, true},
+		{"1% 1XB / 2GiB", ContainerStats{}, true},
+	}
+	for _, c := range cases {
+		r, err := parseContainerStats([]byte(c.input))
+		if (err != nil) != c.err {
+			t.Errorf("Incorrect error for '%s', got: '%v', wanted error: '%t'", c.input, err, c.err)
+			continue
+		}
+		if err == nil && r != c.result {
+			t.Errorf("Incorrect result for '%s', got: '%#v', wanted: '%#v'", c.input, r, c.result)
+		}
+	}
+}
+
+func TestStatsSamplerSummary(t *testing.T) {
+	s := newStatsSampler(time.Second)
+	s.add("web", ContainerStats{10, 100})
+	s.add("web", ContainerStats{30, 300})
+	s.add("db", ContainerStats{5, 2 << 20})
+
+	expected := []ServiceStats{
+		{Name: "db", Samples: 1, PeakMemory: 2 << 20, AverageCPU: 5},
+		{Name: "web", Samples: 2, PeakMemory: 300, AverageCPU: 20},
+	}
+	if r := s.Summary(); !reflect.DeepEqual(r, expected) {
+		t.Errorf("Incorrect summary, got: '%#v', wanted: '%#v'", r, expected)
+	}
+	buf := bytes.NewBuffer(nil)
+	if err := s.PrintSummary(buf); err != nil {
+		t.Errorf("Got unexpected error: %#v", err)
+	}
+	wanted := "db: peak memory 2.0MiB, average cpu 5.00% (1 samples)\nweb: peak memory 300B, average cpu 20.00% (2 samples)\n"
+	if r := buf.String(); r != wanted {
+		t.Errorf("Incorrect output, got: '%s', wanted: '%s'", r, wanted)
+	}
+}
+
+func TestStatsSamplerSample(t *testing.T) {
+	runner := NewNoopRunner(true)
+	s := newStatsSampler(time.Millisecond)
+	s.Sample(runner, Step{Service: Service{Name: "web"}})
+	time.Sleep(10 * time.Millisecond)
+	s.Stop()
+	if n := runner.NumCalled("ContainerStats(web)"); n < 1 {
+		t.Errorf("Incorrect number of samples, got: '%d', wanted at least 1", n)
+	}
+	if r := s.Summary(); len(r) != 1 || r[0].Samples != runner.NumCalled("ContainerStats(web)") {
+		t.Errorf("Incorrect summary, got: '%#v'", r)
+	}
+}
+
+func TestStatsSamplerSampleLocalRunner(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gantry_stats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	executable := filepath.Join(dir, "fake")
+	script := "#!/bin/sh\ncase \"$1\" in\nps) echo id;;\nstats) echo '1.00% 1MiB / 2GiB';;\nesac\n"
+	if err := ioutil.WriteFile(executable, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	step := Step{
+		Service: Service{
+			Name:  "web",
+			Image: "alpine",
+			Meta:  ServiceMeta{Type: ServiceTypeService},
+		},
+		Executable: executable,
+	}
+	if err := step.Meta.Open(); err != nil {
+		t.Fatal(err)
+	}
+	runner := NewLocalRunner("prefix", nil, nil)
+	s := newStatsSampler(time.Millisecond)
+	s.Sample(runner, step)
+	time.Sleep(50 * time.Millisecond)
+	s.Stop()
+	if runner.executable != "" || runner.prefix != "prefix" {
+		t.Errorf("Runner changed by sampling, got: '%s', '%s'", runner.executable, runner.prefix)
+	}
+	if r := s.Summary(); len(r) != 1 || r[0].PeakMemory != 1<<20 {
+		t.Errorf("Incorrect summary, got: '%#v'", r)
+	}
+}
+
+func TestPipelinePrintStats(t *testing.T) {
+	p := &Pipeline{}
+	buf := bytes.NewBuffer(nil)
+	if err := p.PrintStats(buf); err != nil || buf.Len() > 0 {
+		t.Errorf("Unexpected output without sampling, got: '%s', '%v'", buf.String(), err)
+	}
+	p.sampler = newStatsSampler(time.Second)
+	p.sampler.add("web", ContainerStats{10, 100})
+	if err := p.PrintStats(buf); err != nil {
+		t.Errorf("Got unexpected error: %#v", err)
+	}
+	wanted := "web: peak memory 100B, average cpu 10.00% (1 samples)\n"
+	if r := buf.String(); r != wanted {
+		t.Errorf("Incorrect output, got: '%s', wanted: '%s'", r, wanted)
+	}
+}
@@ -2,43 +2,151 @@ package gantry // import "github.com/ad-freiburg/gantry"
 
 import (
 	"fmt"
+	"net"
+	"net/url"
 	"os"
 	"path/filepath"
+	"regexp"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/ad-freiburg/gantry/types"
 	"github.com/google/shlex"
 )
 
+var (
+	sysctlRegexp          = regexp.MustCompile(`^[a-zA-Z0-9_-]+(\.[a-zA-Z0-9_-]+)+$`)
+	invalidNameCharRegexp = regexp.MustCompile(`[^a-z0-9_.-]+`)
+)
+
 // Service provides a service definition from docker-compose.
 type Service struct {
-	BuildInfo   BuildInfo                 `json:"build"`
-	Command     types.StringOrStringSlice `json:"command"`
-	Entrypoint  types.StringOrStringSlice `json:"entrypoint"`
-	Image       string                    `json:"image"`
-	Ports       []string                  `json:"ports"`
-	Volumes     []string                  `json:"volumes"`
-	Environment types.StringMap           `json:"environment"`
-	DependsOn   types.StringSet           `json:"depends_on"`
-	Restart     string                    `json:"restart"`
-	Name        string
-	Meta        ServiceMeta
-	color       int
+	BuildInfo    BuildInfo                 `json:"build"`
+	Command      types.StringOrStringSlice `json:"command"`    // Replaces CMD of the image, a single string is split like a shell would.
+	Entrypoint   types.StringOrStringSlice `json:"entrypoint"` // Replaces ENTRYPOINT of the image, the command is passed as its arguments.
+	Image        string                    `json:"image"`
+	Ports        []string                  `json:"ports"`
+	Volumes      []string                  `json:"volumes"`
+	Environment  types.StringMap           `json:"environment"`
+	DependsOn    DependsOn                 `json:"depends_on"` // A list or a map with conditions like docker-compose.
+	Restart      string                    `json:"restart"`
+	GPUs         string                    `json:"gpus"` // Requires the NVIDIA container runtime.
+	Ulimits      Ulimits                   `json:"ulimits"`
+	LogDriver    string                    `json:"log_driver"`
+	LogOpts      types.StringMap           `json:"log_opt"`
+	Scale        int                       `json:"scale"`
+	SecurityOpt  []string                  `json:"security_opt"`
+	ShmSize      types.ByteSize            `json:"shm_size"`
+	Sysctls      types.StringMap           `json:"sysctls"`
+	ReadOnly     bool                      `json:"read_only"`
+	Tmpfs        types.StringOrStringSlice `json:"tmpfs"`
+	ForceRebuild bool                      `json:"force_rebuild"` // Build without the build cache (--no-cache), images are always built.
+	Sensitive    types.StringSet           `json:"sensitive"`     // Names of environment variables and build args hidden in logs.
+	Priority     int                       `json:"priority"`      // Higher priorities are started first if the number of parallel steps is limited.
+	IPv4Address  string                    `json:"ipv4_address"`
+	Init         bool                      `json:"init"`          // Runs an init process reaping zombie processes.
+	PullPolicy   string                    `json:"pull_policy"`   // Lets docker run pull the image: always, missing or never.
+	EnabledIf    Condition                 `json:"enabled_if"`    // Skips the step if false, dependents run as if it succeeded.
+	DockerSocket bool                      `json:"docker_socket"` // Mounts the socket of the daemon, grants root access to the host.
+	// StartupTimeout limits waiting for the readiness gates and the health of
+	// the container. It defaults to wait_for_timeout of steps, otherwise to
+	// DefaultReadinessTimeout. For a step with RunTimeout both limits add up,
+	// the wait is not counted against the run.
+	StartupTimeout types.Duration `json:"startup_timeout"`
+	Name           string
+	Meta           ServiceMeta
+	color          int
+	replica        int
+	// dir is the directory of the definition, relative bind-mount sources
+	// are resolved against it.
+	dir string
 }
 
 // Step provides an extended service.
 type Step struct {
 	Service
-	After types.StringSet `json:"after"`
+	// After only orders s after the given steps, unlike depends_on it does
+	// not express that s uses them. Both take part in cycle detection.
+	After          types.StringSet      `json:"after"`
+	WaitFor        []string             `json:"wait_for"`
+	WaitForTimeout types.Duration       `json:"wait_for_timeout"`
+	WaitForHTTP    []HTTPReadinessCheck `json:"wait_for_http"`
+	Artifacts      []string             `json:"artifacts"`
+	CreateHostPath bool                 `json:"create_host_path"` // Allows missing bind-mount sources.
+	// MaxParallelDependents limits how many direct dependents of the step may
+	// run at the same time, 0 for no limit.
+	MaxParallelDependents int `json:"max_parallel_dependents"`
+	// RemoveImage removes the image of the step when the pipeline is cleaned
+	// up.
+	RemoveImage bool `json:"remove_image"`
+	// Executable runs all container commands of the step with docker or
+	// wharfer instead of the globally detected executable.
+	Executable string `json:"executable"`
+	// Shell runs the command with the given shell as entrypoint, passing it
+	// as single argument of -c so pipes and && can be used. The elements of
+	// commands in exec form are quoted and joined with spaces.
+	Shell Shell `json:"shell"`
+	// InheritEnv passes substitutions to the container as environment
+	// variables, variables set by environment take precedence.
+	InheritEnv InheritEnv `json:"inherit_env"`
+	// ExtraArgs are passed verbatim to docker run just before the image. They
+	// are not validated, options gantry models should be preferred.
+	ExtraArgs []string `json:"extra_args"`
+	// Retries reruns the container of the step up to the given number of
+	// times if it fails, waiting RetryDelay before the first retry and twice
+	// as long before each further one.
+	Retries    int            `json:"retries"`
+	RetryDelay types.Duration `json:"retry_delay"`
+	// RunTimeout kills the container of the step if it runs longer, 0 for no
+	// limit. Each retry gets the full timeout. Waiting for dependencies and
+	// readiness gates is not included, it is limited by StartupTimeout.
+	// Services run detached until the pipeline stops, they only support
+	// StartupTimeout.
+	RunTimeout types.Duration `json:"run_timeout"`
+	// attempt numbers the output of retried steps, 0 if not retried.
+	attempt int
+	// stageDependencies stores the steps of all previous explicit stages.
+	stageDependencies types.StringSet
+}
+
+// checkExternal validates an external service, it only has readiness checks
+// as gantry neither starts nor orders it.
+func (s Step) checkExternal() error {
+	if s.Image != "" || s.IsBuildable() || len(s.Command) > 0 || len(s.Entrypoint) > 0 {
+		return fmt.Errorf("external '%s' can not define a container", s.ColoredName())
+	}
+	if len(s.Dependencies()) > 0 {
+		return fmt.Errorf("external '%s' can not have dependencies", s.ColoredName())
+	}
+	if len(s.WaitFor) == 0 && len(s.WaitForHTTP) == 0 {
+		return fmt.Errorf("external '%s' needs wait_for or wait_for_http", s.ColoredName())
+	}
+	for _, check := range s.WaitForHTTP {
+		if u, err := url.Parse(check.URL); err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("invalid wait_for_http url '%s' for external '%s'", check.URL, s.ColoredName())
+		}
+	}
+	return nil
 }
 
 // Dependencies returns all steps needed for running s.
 func (s Step) Dependencies() types.StringSet {
+	r := s.OrderingDependencies()
+	for dep := range s.DependsOn {
+		r[dep] = true
+	}
+	return r
+}
+
+// OrderingDependencies returns all steps s has to run after without depending
+// on them, defined by after and explicit stages.
+func (s Step) OrderingDependencies() types.StringSet {
 	r := types.StringSet{}
 	for dep := range s.After {
 		r[dep] = true
 	}
-	for dep := range s.DependsOn {
+	for dep := range s.stageDependencies {
 		r[dep] = true
 	}
 	return r
@@ -46,15 +154,214 @@ func (s Step) Dependencies() types.StringSet {
 
 // Check validates Step s, returns nil if ok, otherwise returns found error.
 func (s Step) Check() error {
+	if s.Meta.Type == ServiceTypeExternal {
+		return s.checkExternal()
+	}
 	if s.Image == "" && s.BuildInfo.Context == "" && s.BuildInfo.Dockerfile == "" {
 		return fmt.Errorf("no container information for '%s'", s.ColoredName())
 	}
+	if s.Image != "" {
+		if err := ValidateImageReference(s.Image); err != nil {
+			return fmt.Errorf("invalid image for '%s': %s", s.ColoredName(), err)
+		}
+	}
 	if len(s.Restart) > 0 && s.Restart != "no" && s.Meta.Type == ServiceTypeStep {
 		return fmt.Errorf("invalid restart value '%s' for step '%s'", s.Restart, s.ColoredName())
 	}
+	if s.Scale < 0 {
+		return fmt.Errorf("invalid scale %d for step '%s'", s.Scale, s.ColoredName())
+	}
+	if s.Retries < 0 {
+		return fmt.Errorf("invalid retries %d for step '%s'", s.Retries, s.ColoredName())
+	}
+	if s.RetryDelay < 0 {
+		return fmt.Errorf("invalid retry_delay %s for step '%s'", time.Duration(s.RetryDelay), s.ColoredName())
+	}
+	if s.StartupTimeout < 0 {
+		return fmt.Errorf("invalid startup_timeout %s for step '%s'", time.Duration(s.StartupTimeout), s.ColoredName())
+	}
+	if s.RunTimeout < 0 {
+		return fmt.Errorf("invalid run_timeout %s for step '%s'", time.Duration(s.RunTimeout), s.ColoredName())
+	}
+	if s.RunTimeout > 0 && s.Meta.Type == ServiceTypeService {
+		return fmt.Errorf("run_timeout is only supported for steps, not for service '%s', use startup_timeout", s.ColoredName())
+	}
+	if s.MaxParallelDependents < 0 {
+		return fmt.Errorf("invalid max_parallel_dependents %d for step '%s'", s.MaxParallelDependents, s.ColoredName())
+	}
+	if s.Scale > 1 {
+		if s.Meta.Type == ServiceTypeStep {
+			return fmt.Errorf("scale is only supported for services, not for step '%s'", s.ColoredName())
+		}
+		for _, port := range s.Ports {
+			if hasFixedHostPort(port) {
+				return fmt.Errorf("fixed host port '%s' conflicts between replicas of '%s', use a range or a random port", port, s.ColoredName())
+			}
+		}
+	}
+	switch s.Executable {
+	case "", docker, wharfer:
+	default:
+		return fmt.Errorf("invalid executable '%s' for step '%s', use '%s' or '%s'", s.Executable, s.ColoredName(), docker, wharfer)
+	}
+	if s.Meta.DockerContext != "" || DockerContext != "" {
+		if executable, _, context := stepDaemon(s); executable == wharfer {
+			return fmt.Errorf("docker context '%s' of step '%s' is not supported by %s", context, s.ColoredName(), wharfer)
+		}
+	}
+	if err := s.checkCommand(); err != nil {
+		return err
+	}
+	if s.Shell != "" {
+		if s.Entrypoint != nil {
+			return fmt.Errorf("shell and entrypoint of step '%s' are mutually exclusive", s.ColoredName())
+		}
+		if len(s.Command) == 0 {
+			return fmt.Errorf("shell of step '%s' requires a command", s.ColoredName())
+		}
+	}
+	switch s.PullPolicy {
+	case "", "always", "missing", "never":
+	default:
+		return fmt.Errorf("invalid pull_policy '%s' for step '%s'", s.PullPolicy, s.ColoredName())
+	}
+	if s.IPv4Address != "" {
+		if ip := net.ParseIP(s.IPv4Address); ip == nil || ip.To4() == nil {
+			return fmt.Errorf("invalid ipv4_address '%s' for step '%s'", s.IPv4Address, s.ColoredName())
+		}
+		if s.Scale > 1 {
+			return fmt.Errorf("ipv4_address '%s' conflicts between replicas of '%s'", s.IPv4Address, s.ColoredName())
+		}
+	}
+	for _, secret := range s.BuildInfo.Secrets {
+		if err := secret.Check(); err != nil {
+			return fmt.Errorf("%s for step '%s'", err, s.ColoredName())
+		}
+	}
+	if err := s.ShmSize.Check(); err != nil {
+		return fmt.Errorf("%s for step '%s'", err, s.ColoredName())
+	}
+	for _, k := range s.Sysctls.Keys() {
+		if !sysctlRegexp.MatchString(k) || s.Sysctls[k] == nil || *s.Sysctls[k] == "" {
+			return fmt.Errorf("invalid sysctl '%s' for step '%s'", k, s.ColoredName())
+		}
+	}
+	if err := s.Ulimits.Check(); err != nil {
+		return fmt.Errorf("%s for step '%s'", err, s.ColoredName())
+	}
+	for _, target := range s.WaitFor {
+		if _, _, err := net.SplitHostPort(target); err != nil {
+			return fmt.Errorf("invalid wait_for target '%s' for step '%s': %s", target, s.ColoredName(), err)
+		}
+	}
+	for _, path := range s.Artifacts {
+		if !filepath.IsAbs(path) {
+			return fmt.Errorf("artifact path '%s' for step '%s' is not absolute", path, s.ColoredName())
+		}
+	}
+	if err := s.checkVolumes(); err != nil {
+		return err
+	}
+	for _, check := range s.WaitForHTTP {
+		if u, err := url.Parse(check.URL); err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("invalid wait_for_http url '%s' for step '%s'", check.URL, s.ColoredName())
+		}
+	}
+	return nil
+}
+
+// checkCommand validates that a command given as single string yields at
+// least one token and that command and entrypoint can be split like a shell
+// would. Commands in exec form are passed as they are.
+func (s Step) checkCommand() error {
+	if len(s.Command) == 1 {
+		if strings.TrimSpace(s.Command[0]) == "" {
+			return fmt.Errorf("empty command for step '%s'", s.ColoredName())
+		}
+		if s.Shell == "" {
+			if _, err := shlex.Split(s.Command[0]); err != nil {
+				return fmt.Errorf("invalid command '%s' for step '%s': %s", s.Command[0], s.ColoredName(), err)
+			}
+		}
+	}
+	if len(s.Entrypoint) == 1 {
+		if _, err := shlex.Split(s.Entrypoint[0]); err != nil {
+			return fmt.Errorf("invalid entrypoint '%s' for step '%s': %s", s.Entrypoint[0], s.ColoredName(), err)
+		}
+	}
+	return nil
+}
+
+// hasFixedHostPort returns whether port binds a single host port. Port mappings
+// without a host port or with a host port range are not fixed.
+func hasFixedHostPort(port string) bool {
+	b, err := parsePortBinding(port)
+	return err == nil && b != nil && b.start == b.end
+}
+
+// checkVolumes validates that all bind-mount sources of s exist. Missing
+// sources are only reported unless StrictVolumes is set.
+func (s Step) checkVolumes() error {
+	if s.CreateHostPath || s.Meta.Ignore {
+		return nil
+	}
+	for _, volume := range s.Volumes {
+		parts := strings.SplitN(volume, ":", 2)
+		if len(parts) < 2 || isNamedVolume(parts[0]) {
+			continue
+		}
+		path := s.hostPath(parts[0])
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			if StrictVolumes {
+				return fmt.Errorf("bind-mount source '%s' for step '%s' does not exist", path, s.ColoredName())
+			}
+			pipelineLogger.Printf("Warning: bind-mount source '%s' for step '%s' does not exist", path, s.ColoredName())
+		}
+	}
 	return nil
 }
 
+// hostPath returns the absolute path of the bind-mount source source.
+// Relative sources are resolved against the directory of the definition, or
+// the working directory if s was not loaded from a definition.
+func (s Service) hostPath(source string) string {
+	if s.dir != "" && !filepath.IsAbs(source) {
+		return filepath.Join(s.dir, source)
+	}
+	path, _ := filepath.Abs(source)
+	return path
+}
+
+// bindMounts returns the host paths of all bind-mount sources of s and
+// whether any mount of them is writable.
+func (s Service) bindMounts() map[string]bool {
+	result := make(map[string]bool)
+	for _, volume := range s.Volumes {
+		parts := strings.SplitN(volume, ":", 3)
+		if len(parts) < 2 || isNamedVolume(parts[0]) {
+			continue
+		}
+		writable := true
+		if len(parts) == 3 {
+			for _, option := range strings.Split(parts[2], ",") {
+				if option == "ro" {
+					writable = false
+				}
+			}
+		}
+		path := s.hostPath(parts[0])
+		result[path] = result[path] || writable
+	}
+	return result
+}
+
+// isNamedVolume returns whether source looks like a named volume instead of
+// a host path. Such sources are not checked for existence, they are still
+// passed to docker as host paths, see hostPath.
+func isNamedVolume(source string) bool {
+	return source != "" && !strings.ContainsRune(source, '/') && !strings.HasPrefix(source, ".")
+}
+
 // InitColor initializes the color of s.
 func (s *Service) InitColor() {
 	s.color = GetNextFriendlyColor()
@@ -70,24 +377,80 @@ func (s Service) ColoredContainerName() string {
 	return ApplyAnsiStyle(s.ContainerName(), s.color)
 }
 
+// SanitizeName converts name to a name accepted by docker for containers,
+// images, networks and volumes. It is lowercased, sequences of invalid
+// characters are replaced by _ and separators at the start and the end are
+// removed.
+func SanitizeName(name string) string {
+	name = invalidNameCharRegexp.ReplaceAllString(strings.ToLower(name), "_")
+	return strings.Trim(name, "_.-")
+}
+
 // ImageName returns the name of the image of s.
 // The name of the step is used if non is specified.
 func (s Service) ImageName() string {
 	if s.Image != "" {
 		return s.Image
 	}
-	return strings.ReplaceAll(strings.ToLower(s.Name), " ", "_")
+	return SanitizeName(s.Name)
+}
+
+// ImageDigest returns the digest the image of s is pinned to, or an empty
+// string if the image is referenced by tag.
+func (s Service) ImageDigest() string {
+	if i := strings.LastIndex(s.Image, "@"); i >= 0 {
+		return s.Image[i+1:]
+	}
+	return ""
 }
 
 // RawContainerName returns the name for a container of s.
 func (s Service) RawContainerName() string {
-	return strings.ReplaceAll(strings.ToLower(s.Name), " ", "_")
+	return SanitizeName(s.Name)
 }
 
 // ContainerName returns the name for a container of s prefixed with the
-// current project name.
+// current project name. Replicas are suffixed with their number.
 func (s Service) ContainerName() string {
-	return fmt.Sprintf("%s_%s", ProjectName, strings.ReplaceAll(strings.ToLower(s.Name), " ", "_"))
+	name := fmt.Sprintf("%s_%s", ProjectName, s.RawContainerName())
+	if s.replica > 0 {
+		return fmt.Sprintf("%s_%d", name, s.replica)
+	}
+	return name
+}
+
+// checkContainerNames validates that the names of all steps result in
+// distinct container names.
+func checkContainerNames(steps []Step) error {
+	sort.Slice(steps, func(i, j int) bool {
+		return steps[i].Name < steps[j].Name
+	})
+	names := make(map[string]string)
+	for _, step := range steps {
+		name := step.RawContainerName()
+		if name == "" {
+			return fmt.Errorf("invalid name '%s', no valid characters for a container name", step.Name)
+		}
+		if other, ok := names[name]; ok {
+			return fmt.Errorf("names '%s' and '%s' result in the same container name '%s'", other, step.Name, name)
+		}
+		names[name] = step.Name
+	}
+	return nil
+}
+
+// Replicas returns a step for each container of s. If s is not scaled, s
+// itself is returned.
+func (s Step) Replicas() []Step {
+	if s.Scale <= 1 {
+		return []Step{s}
+	}
+	result := make([]Step, s.Scale)
+	for i := range result {
+		result[i] = s
+		result[i].replica = i + 1
+	}
+	return result
 }
 
 // IsBuildable returns whether or not the step can be build.
@@ -97,16 +460,39 @@ func (s Step) IsBuildable() bool {
 
 // BuildCommand returns the command to build a new image for s.
 func (s Step) BuildCommand(pull bool) []string {
-	args := []string{"build", "--tag", s.ImageName()}
+	args := []string{
+		"build",
+		"--tag", s.ImageName(),
+		"--label", fmt.Sprintf("%s=%s", LabelProject, ProjectName),
+		"--label", fmt.Sprintf("%s=%s", LabelStep, s.Name),
+	}
 	if s.BuildInfo.Dockerfile != "" {
 		args = append(args, "--file", filepath.Join(s.BuildInfo.Context, s.BuildInfo.Dockerfile))
 	}
 	if s.BuildInfo.Context == "" {
 		s.BuildInfo.Context = "."
 	}
-	if pull {
+	if pull || s.BuildInfo.PullBase {
 		args = append(args, "--pull")
 	}
+	if s.ForceRebuild || ForceRebuild {
+		args = append(args, "--no-cache")
+	}
+	for _, image := range s.BuildInfo.CacheFrom {
+		args = append(args, "--cache-from", image)
+	}
+	for _, secret := range s.BuildInfo.Secrets {
+		args = append(args, "--secret", secret.String())
+	}
+	for _, ssh := range s.BuildInfo.SSH {
+		args = append(args, "--ssh", ssh)
+	}
+	switch Progress {
+	case ProgressPlain:
+		args = append(args, "--progress", "plain")
+	case ProgressSummary:
+		args = append(args, "--progress", "rawjson")
+	}
 	for k, v := range s.BuildInfo.Args {
 		if v == nil {
 			t := os.Getenv(k)
@@ -114,6 +500,7 @@ func (s Step) BuildCommand(pull bool) []string {
 		}
 		args = append(args, "--build-arg", fmt.Sprintf("%s=%s", k, *v))
 	}
+	args = append(args, s.BuildInfo.ExtraArgs...)
 	args = append(args, s.BuildInfo.Context)
 	return args
 }
@@ -127,22 +514,67 @@ func (s Step) RunCommand(network Network) []string {
 		"--network-alias", s.RawContainerName(),
 		"--network-alias", s.ContainerName(),
 	}
+	if s.IPv4Address != "" && network != "" {
+		args = append(args, "--ip", s.IPv4Address)
+	}
+	args = append(args,
+		"--label", fmt.Sprintf("%s=%s", LabelProject, ProjectName),
+		"--label", fmt.Sprintf("%s=%s", LabelService, s.RawContainerName()),
+	)
+	// Services run detached, steps are removed unless they are kept alive
 	if s.Meta.Type == ServiceTypeService {
 		args = append(args, "-d")
-	} else {
+	} else if s.Meta.KeepAlive == KeepAliveNo {
 		args = append(args, "--rm")
 	}
 	if s.Restart != "" {
 		args = append(args, "--restart")
 		args = append(args, s.Restart)
 	}
+	if s.Init {
+		args = append(args, "--init")
+	}
+	if s.PullPolicy != "" {
+		args = append(args, "--pull", s.PullPolicy)
+	}
+	if s.GPUs != "" {
+		args = append(args, "--gpus", s.GPUs)
+	}
+	for _, name := range s.Ulimits.Names() {
+		args = append(args, "--ulimit", fmt.Sprintf("%s=%s", name, s.Ulimits[name]))
+	}
+	if s.LogDriver != "" {
+		args = append(args, "--log-driver", s.LogDriver)
+	}
+	for _, k := range s.LogOpts.Keys() {
+		v := ""
+		if s.LogOpts[k] != nil {
+			v = *s.LogOpts[k]
+		}
+		args = append(args, "--log-opt", fmt.Sprintf("%s=%s", k, v))
+	}
+	if s.ReadOnly {
+		args = append(args, "--read-only")
+	}
+	for _, path := range s.Tmpfs {
+		args = append(args, "--tmpfs", path)
+	}
+	for _, k := range s.Sysctls.Keys() {
+		args = append(args, "--sysctl", fmt.Sprintf("%s=%s", k, *s.Sysctls[k]))
+	}
+	if s.ShmSize != "" {
+		args = append(args, "--shm-size", string(s.ShmSize))
+	}
+	for _, opt := range s.SecurityOpt {
+		args = append(args, "--security-opt", opt)
+	}
 	for _, port := range s.Ports {
 		args = append(args, "-p", port)
 	}
 	for _, volume := range s.Volumes {
 		// Resolve relative paths
 		parts := strings.SplitN(volume, ":", 2)
-		parts[0], _ = filepath.Abs(parts[0])
+		parts[0] = s.hostPath(parts[0])
 		args = append(args, "-v", strings.Join(parts, ":"))
 	}
 	for k, v := range s.Environment {
@@ -154,18 +586,26 @@ func (s Step) RunCommand(network Network) []string {
 	}
 	// Determine entrypoint and arguments
 	callerArgs := make([]string, 0)
-	if len(s.Entrypoint) > 0 {
-		if len(s.Entrypoint) > 1 {
-			args = append(args, "--entrypoint", s.Entrypoint[0])
-			callerArgs = append(callerArgs, s.Entrypoint[1:]...)
+	if s.Shell != "" {
+		// The whole command is a single script for the shell
+		args = append(args, "--entrypoint", string(s.Shell))
+		callerArgs = append(callerArgs, "-c", shellCommand(s.Command))
+	} else if s.Entrypoint != nil {
+		entrypoint := []string(s.Entrypoint)
+		if len(entrypoint) == 1 {
+			entrypoint, _ = shlex.Split(entrypoint[0])
+		}
+		if len(entrypoint) > 0 {
+			args = append(args, "--entrypoint", entrypoint[0])
+			callerArgs = append(callerArgs, entrypoint[1:]...)
 		} else {
-			tokens, _ := shlex.Split(s.Entrypoint[0])
-			args = append(args, "--entrypoint", tokens[0])
-			callerArgs = append(callerArgs, tokens[1:]...)
+			// An explicitly empty entrypoint, [] or "", clears the one of
+			// the image
+			args = append(args, "--entrypoint", "")
 		}
 	}
 	// Add command
-	if len(s.Command) > 0 {
+	if s.Shell == "" && len(s.Command) > 0 {
 		if len(s.Command) > 1 {
 			callerArgs = append(callerArgs, s.Command...)
 		} else {
@@ -173,6 +613,7 @@ func (s Step) RunCommand(network Network) []string {
 			callerArgs = append(callerArgs, tokens...)
 		}
 	}
+	args = append(args, s.ExtraArgs...)
 	args = append(args, s.ImageName())
 	if len(callerArgs) > 0 {
 		args = append(args, callerArgs...)
@@ -180,9 +621,10 @@ func (s Step) RunCommand(network Network) []string {
 	return args
 }
 
-// IsPullable returns whether or not a image is pulled for this step.
+// IsPullable returns whether or not a image is pulled for this step. Images
+// of steps with a pull policy are pulled by docker run itself.
 func (s Step) IsPullable() bool {
-	return !s.IsBuildable()
+	return !s.IsBuildable() && s.PullPolicy == ""
 }
 
 // PullCommand returns the command to pull the image for step s.
@@ -1,16 +1,20 @@
 package gantry_test
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/ad-freiburg/gantry"
 	"github.com/ad-freiburg/gantry/types"
 )
 
 func TestStepCheck(t *testing.T) {
+	value := "1024"
 	cases := []struct {
 		step gantry.Step
 		err  bool
@@ -20,6 +24,62 @@ func TestStepCheck(t *testing.T) {
 		{gantry.Step{Service: gantry.Service{Name: "a", Image: "alpine", Meta: gantry.ServiceMeta{Type: gantry.ServiceTypeStep}}}, false},
 		{gantry.Step{Service: gantry.Service{Name: "a", Image: "alpine", Restart: "always", Meta: gantry.ServiceMeta{Type: gantry.ServiceTypeStep}}}, true},
 		{gantry.Step{Service: gantry.Service{Name: "a", Image: "alpine", Restart: "always", Meta: gantry.ServiceMeta{Type: gantry.ServiceTypeService}}}, false},
+		{gantry.Step{Service: gantry.Service{Name: "a", Image: "alpine", Ulimits: gantry.Ulimits{"nofile": {Soft: 1024, Hard: 2048}}}}, false},
+		{gantry.Step{Service: gantry.Service{Name: "a", Image: "alpine", Command: types.StringOrStringSlice{"ls | wc -l"}}, Shell: gantry.DefaultShell}, false},
+		{gantry.Step{Service: gantry.Service{Name: "a", Image: "alpine"}, Shell: gantry.DefaultShell}, true},
+		{gantry.Step{Service: gantry.Service{Name: "a", Image: "alpine", Command: types.StringOrStringSlice{""}}}, true},
+		{gantry.Step{Service: gantry.Service{Name: "a", Image: "alpine", Command: types.StringOrStringSlice{" \t "}}}, true},
+		{gantry.Step{Service: gantry.Service{Name: "a", Image: "alpine", Command: types.StringOrStringSlice{"echo 'unterminated"}}}, true},
+		{gantry.Step{Service: gantry.Service{Name: "a", Image: "alpine", Command: types.StringOrStringSlice{"echo", ""}}}, false},
+		{gantry.Step{Service: gantry.Service{Name: "a", Image: "alpine", Command: types.StringOrStringSlice{" "}}, Shell: gantry.DefaultShell}, true},
+		{gantry.Step{Service: gantry.Service{Name: "a", Image: "alpine", Entrypoint: types.StringOrStringSlice{" "}}}, false},
+		{gantry.Step{Service: gantry.Service{Name: "a", Image: "alpine", Entrypoint: types.StringOrStringSlice{"sh \"-c"}}}, true},
+		{gantry.Step{Service: gantry.Service{Name: "a", Image: "alpine", Command: types.StringOrStringSlice{"ls"}, Entrypoint: types.StringOrStringSlice{"/bin/sh"}}, Shell: gantry.DefaultShell}, true},
+		{gantry.Step{Service: gantry.Service{Name: "a", Image: "alpine", Ulimits: gantry.Ulimits{"nofiles": {Soft: 1024}}}}, true},
+		{gantry.Step{Service: gantry.Service{Name: "a", Image: "alpine", Ulimits: gantry.Ulimits{"nofile": {Soft: 2048, Hard: 1024}}}}, true},
+		{gantry.Step{Service: gantry.Service{Name: "a", Image: "alpine", Scale: 3, Ports: []string{"80", "8000-8002:80", "127.0.0.1::80"}, Meta: gantry.ServiceMeta{Type: gantry.ServiceTypeService}}}, false},
+		{gantry.Step{Service: gantry.Service{Name: "a", Image: "alpine", Scale: 3, Ports: []string{"8080:80"}, Meta: gantry.ServiceMeta{Type: gantry.ServiceTypeService}}}, true},
+		{gantry.Step{Service: gantry.Service{Name: "a", Image: "alpine", Scale: 3, Ports: []string{"127.0.0.1:8080:80"}, Meta: gantry.ServiceMeta{Type: gantry.ServiceTypeService}}}, true},
+		{gantry.Step{Service: gantry.Service{Name: "a", Image: "alpine", Scale: 2, Meta: gantry.ServiceMeta{Type: gantry.ServiceTypeStep}}}, true},
+		{gantry.Step{Service: gantry.Service{Name: "a", Image: "alpine", Scale: -1}}, true},
+		{gantry.Step{Service: gantry.Service{Name: "a", Image: "alpine", ShmSize: "2gb"}}, false},
+		{gantry.Step{Service: gantry.Service{Name: "a", Image: "alpine", ShmSize: "big"}}, true},
+		{gantry.Step{Service: gantry.Service{Name: "a", Image: "alpine", Sysctls: types.StringMap{"net.core.somaxconn": &value}}}, false},
+		{gantry.Step{Service: gantry.Service{Name: "a", Image: "alpine", Sysctls: types.StringMap{"somaxconn": &value}}}, true},
+		{gantry.Step{Service: gantry.Service{Name: "a", Image: "alpine", Sysctls: types.StringMap{"net.core.somaxconn": nil}}}, true},
+		{gantry.Step{Service: gantry.Service{Name: "a", BuildInfo: gantry.BuildInfo{Context: ".", Secrets: []gantry.BuildSecret{{ID: "token", Source: "/token.txt"}}}}}, false},
+		{gantry.Step{Service: gantry.Service{Name: "a", BuildInfo: gantry.BuildInfo{Context: ".", Secrets: []gantry.BuildSecret{{ID: "token"}}}}}, true},
+		{gantry.Step{Service: gantry.Service{Name: "a", Image: "alpine"}, Artifacts: []string{"/out"}}, false},
+		{gantry.Step{Service: gantry.Service{Name: "a", Image: "alpine"}, Artifacts: []string{"out"}}, true},
+		{gantry.Step{Service: gantry.Service{Name: "a", Image: "alpine"}, WaitFor: []string{"db:5432"}}, false},
+		{gantry.Step{Service: gantry.Service{Name: "a", Image: "alpine"}, WaitFor: []string{"db"}}, true},
+		{gantry.Step{Service: gantry.Service{Name: "a", Image: "alpine", PullPolicy: "missing"}}, false},
+		{gantry.Step{Service: gantry.Service{Name: "a", Image: "alpine", PullPolicy: "sometimes"}}, true},
+		{gantry.Step{Service: gantry.Service{Name: "a", Image: "alpine", IPv4Address: "172.20.0.10"}}, false},
+		{gantry.Step{Service: gantry.Service{Name: "a", Image: "alpine", IPv4Address: "172.20.0.300"}}, true},
+		{gantry.Step{Service: gantry.Service{Name: "a", Image: "alpine", IPv4Address: "::1"}}, true},
+		{gantry.Step{Service: gantry.Service{Name: "a", Image: "alpine", IPv4Address: "172.20.0.10", Scale: 2, Meta: gantry.ServiceMeta{Type: gantry.ServiceTypeService}}}, true},
+		{gantry.Step{Service: gantry.Service{Name: "a", Image: "alpine"}, WaitForHTTP: []gantry.HTTPReadinessCheck{{URL: "http://localhost:8080/health"}}}, false},
+		{gantry.Step{Service: gantry.Service{Name: "a", Image: "alpine"}, WaitForHTTP: []gantry.HTTPReadinessCheck{{URL: "localhost:8080"}}}, true},
+		{gantry.Step{Service: gantry.Service{Name: "a", Image: "alpine"}, MaxParallelDependents: 2}, false},
+		{gantry.Step{Service: gantry.Service{Name: "a", Image: "alpine"}, MaxParallelDependents: -1}, true},
+		{gantry.Step{Service: gantry.Service{Name: "a", Image: "alpine"}, Retries: -1}, true},
+		{gantry.Step{Service: gantry.Service{Name: "a", Image: "alpine"}, Retries: 2, RetryDelay: types.Duration(time.Second)}, false},
+		{gantry.Step{Service: gantry.Service{Name: "a", Image: "alpine", StartupTimeout: types.Duration(-time.Second)}}, true},
+		{gantry.Step{Service: gantry.Service{Name: "a", Image: "alpine"}, RunTimeout: types.Duration(-time.Second)}, true},
+		{gantry.Step{Service: gantry.Service{Name: "a", Image: "alpine", StartupTimeout: types.Duration(time.Second), Meta: gantry.ServiceMeta{Type: gantry.ServiceTypeStep}}, RunTimeout: types.Duration(time.Minute)}, false},
+		{gantry.Step{Service: gantry.Service{Name: "a", Image: "alpine", Meta: gantry.ServiceMeta{Type: gantry.ServiceTypeService}}, RunTimeout: types.Duration(time.Minute)}, true},
+		{gantry.Step{Service: gantry.Service{Name: "a", Image: "alpine", StartupTimeout: types.Duration(time.Second), Meta: gantry.ServiceMeta{Type: gantry.ServiceTypeService}}}, false},
+		{gantry.Step{Service: gantry.Service{Name: "a", Image: "alpine"}, Executable: "wharfer"}, false},
+		{gantry.Step{Service: gantry.Service{Name: "a", Image: "alpine", Meta: gantry.ServiceMeta{DockerContext: "remote"}}, Executable: "wharfer"}, true},
+		{gantry.Step{Service: gantry.Service{Name: "a", Image: "alpine", Meta: gantry.ServiceMeta{DockerContext: "remote"}}, Executable: "docker"}, false},
+		{gantry.Step{Service: gantry.Service{Name: "a", Image: "alpine"}, Executable: "podman"}, true},
+		{gantry.Step{Service: gantry.Service{Name: "a", Image: "alpine:"}}, true},
+		{gantry.Step{Service: gantry.Service{Name: "a", Meta: gantry.ServiceMeta{Type: gantry.ServiceTypeExternal}}, WaitFor: []string{"db:5432"}}, false},
+		{gantry.Step{Service: gantry.Service{Name: "a", Meta: gantry.ServiceMeta{Type: gantry.ServiceTypeExternal}}}, true},
+		{gantry.Step{Service: gantry.Service{Name: "a", Image: "alpine", Meta: gantry.ServiceMeta{Type: gantry.ServiceTypeExternal}}, WaitFor: []string{"db:5432"}}, true},
+		{gantry.Step{Service: gantry.Service{Name: "a", Meta: gantry.ServiceMeta{Type: gantry.ServiceTypeExternal}}, WaitFor: []string{"db:5432"}, After: types.StringSet{"b": true}}, true},
+		{gantry.Step{Service: gantry.Service{Name: "a", Image: "registry//alpine"}}, true},
 	}
 
 	for i, c := range cases {
@@ -33,6 +93,34 @@ func TestStepCheck(t *testing.T) {
 	}
 }
 
+func TestStepCheckVolumes(t *testing.T) {
+	cases := []struct {
+		step   gantry.Step
+		strict bool
+		err    bool
+	}{
+		{gantry.Step{Service: gantry.Service{Name: "a", Image: "alpine", Volumes: []string{"/tmp:/tmp"}}}, true, false},
+		{gantry.Step{Service: gantry.Service{Name: "a", Image: "alpine", Volumes: []string{"data:/data", "/anonymous"}}}, true, false},
+		{gantry.Step{Service: gantry.Service{Name: "a", Image: "alpine", Volumes: []string{"/does/not/exist:/data"}}}, false, false},
+		{gantry.Step{Service: gantry.Service{Name: "a", Image: "alpine", Volumes: []string{"/does/not/exist:/data"}}}, true, true},
+		{gantry.Step{Service: gantry.Service{Name: "a", Image: "alpine", Volumes: []string{"./does/not/exist:/data"}}}, true, true},
+		{gantry.Step{Service: gantry.Service{Name: "a", Image: "alpine", Volumes: []string{"/does/not/exist:/data"}}, CreateHostPath: true}, true, false},
+		{gantry.Step{Service: gantry.Service{Name: "a", Image: "alpine", Volumes: []string{"/does/not/exist:/data"}, Meta: gantry.ServiceMeta{Ignore: true}}}, true, false},
+	}
+
+	defer func() { gantry.StrictVolumes = false }()
+	for i, c := range cases {
+		gantry.StrictVolumes = c.strict
+		err := c.step.Check()
+		if err != nil && !c.err {
+			t.Errorf("Unexpected error for case '%d', got: '%#v'", i, err)
+		}
+		if err == nil && c.err {
+			t.Errorf("Expected error for case '%d', got: 'nil'", i)
+		}
+	}
+}
+
 func TestStepDependencies(t *testing.T) {
 	cases := []struct {
 		step   gantry.Step
@@ -47,11 +135,11 @@ func TestStepDependencies(t *testing.T) {
 			types.StringSet{"a": true},
 		},
 		{
-			gantry.Step{Service: gantry.Service{Name: "b", DependsOn: map[string]bool{"a": true}}},
+			gantry.Step{Service: gantry.Service{Name: "b", DependsOn: gantry.DependsOn{"a": gantry.DependencyConditionStarted}}},
 			types.StringSet{"a": true},
 		},
 		{
-			gantry.Step{Service: gantry.Service{Name: "d", DependsOn: map[string]bool{"c": true}}, After: map[string]bool{"b": true}},
+			gantry.Step{Service: gantry.Service{Name: "d", DependsOn: gantry.DependsOn{"c": gantry.DependencyConditionStarted}}, After: map[string]bool{"b": true}},
 			types.StringSet{"b": true, "c": true},
 		},
 	}
@@ -64,6 +152,60 @@ func TestStepDependencies(t *testing.T) {
 	}
 }
 
+func TestStepOrderingDependencies(t *testing.T) {
+	cases := []struct {
+		step   gantry.Step
+		result types.StringSet
+	}{
+		{
+			gantry.Step{Service: gantry.Service{Name: "a"}},
+			types.StringSet{},
+		},
+		{
+			gantry.Step{Service: gantry.Service{Name: "b"}, After: map[string]bool{"a": true}},
+			types.StringSet{"a": true},
+		},
+		{
+			gantry.Step{Service: gantry.Service{Name: "b", DependsOn: gantry.DependsOn{"a": gantry.DependencyConditionStarted}}},
+			types.StringSet{},
+		},
+		{
+			gantry.Step{Service: gantry.Service{Name: "d", DependsOn: gantry.DependsOn{"c": gantry.DependencyConditionStarted}}, After: map[string]bool{"b": true}},
+			types.StringSet{"b": true},
+		},
+	}
+
+	for i, c := range cases {
+		r := c.step.OrderingDependencies()
+		if !reflect.DeepEqual(r, c.result) {
+			t.Errorf("Incorrect result for case '%d': '%v', got: '%#v', wanted '%#v'", i, c.step, r, c.result)
+		}
+	}
+}
+
+func TestSanitizeName(t *testing.T) {
+	cases := []struct {
+		name   string
+		result string
+	}{
+		{"", ""},
+		{"step", "step"},
+		{"a Step", "a_step"},
+		{"Build/Test: Go", "build_test_go"},
+		{"  padded  ", "padded"},
+		{"_-.x.-_", "x"},
+		{"web.api-1", "web.api-1"},
+		{"über", "ber"},
+		{"!!!", ""},
+	}
+
+	for _, c := range cases {
+		if r := gantry.SanitizeName(c.name); r != c.result {
+			t.Errorf("Incorrect result for '%s', got: '%s', wanted '%s'", c.name, r, c.result)
+		}
+	}
+}
+
 func TestStepImageName(t *testing.T) {
 	cases := []struct {
 		step   gantry.Step
@@ -81,6 +223,10 @@ func TestStepImageName(t *testing.T) {
 			gantry.Step{Service: gantry.Service{Name: "c Step", Image: "c"}},
 			"c",
 		},
+		{
+			gantry.Step{Service: gantry.Service{Name: "My/Step"}},
+			"my_step",
+		},
 	}
 
 	for _, c := range cases {
@@ -91,6 +237,25 @@ func TestStepImageName(t *testing.T) {
 	}
 }
 
+func TestStepImageDigest(t *testing.T) {
+	cases := []struct {
+		step   gantry.Step
+		result string
+	}{
+		{gantry.Step{Service: gantry.Service{Name: "a"}}, ""},
+		{gantry.Step{Service: gantry.Service{Image: "alpine:3.12"}}, ""},
+		{gantry.Step{Service: gantry.Service{Image: "alpine@sha256:abc"}}, "sha256:abc"},
+		{gantry.Step{Service: gantry.Service{Image: "localhost:5000/alpine@sha256:abc"}}, "sha256:abc"},
+	}
+
+	for _, c := range cases {
+		r := c.step.ImageDigest()
+		if r != c.result {
+			t.Errorf("Incorrect result for '%v', got: '%s', wanted '%s'", c.step, r, c.result)
+		}
+	}
+}
+
 func TestStepRawContainerName(t *testing.T) {
 	cases := []struct {
 		step   gantry.Step
@@ -108,6 +273,14 @@ func TestStepRawContainerName(t *testing.T) {
 			gantry.Step{Service: gantry.Service{Name: "c Step", Image: "c"}},
 			"c_step",
 		},
+		{
+			gantry.Step{Service: gantry.Service{Name: " Build/Test: Go "}},
+			"build_test_go",
+		},
+		{
+			gantry.Step{Service: gantry.Service{Name: "-web.api-"}},
+			"web.api",
+		},
 	}
 
 	for _, c := range cases {
@@ -147,6 +320,28 @@ func TestStepContainerName(t *testing.T) {
 	}
 }
 
+func TestStepReplicas(t *testing.T) {
+	cases := []struct {
+		step   gantry.Step
+		result []string
+	}{
+		{gantry.Step{Service: gantry.Service{Name: "a"}}, []string{"P_a"}},
+		{gantry.Step{Service: gantry.Service{Name: "a", Scale: 1}}, []string{"P_a"}},
+		{gantry.Step{Service: gantry.Service{Name: "a", Scale: 3}}, []string{"P_a_1", "P_a_2", "P_a_3"}},
+	}
+
+	gantry.ProjectName = "P"
+	for i, c := range cases {
+		r := []string{}
+		for _, replica := range c.step.Replicas() {
+			r = append(r, replica.ContainerName())
+		}
+		if !reflect.DeepEqual(r, c.result) {
+			t.Errorf("Incorrect result for test '%d', got: '%v', wanted '%v'", i, r, c.result)
+		}
+	}
+}
+
 func TestStepBuildCommand(t *testing.T) {
 	bar := "Bar"
 	cases := []struct {
@@ -155,111 +350,279 @@ func TestStepBuildCommand(t *testing.T) {
 		result []string
 	}{
 		{
-			gantry.Step{Service: gantry.Service{Image: "img"}},
+			gantry.Step{Service: gantry.Service{Name: "name", Image: "img"}},
 			false,
-			[]string{"build", "--tag", "img", "."},
+			[]string{"build", "--tag", "img", "--label", "gantry.project=T", "--label", "gantry.step=name", "."},
 		},
 		{
-			gantry.Step{Service: gantry.Service{Image: "img"}},
+			gantry.Step{Service: gantry.Service{Name: "name", Image: "img"}},
 			true,
-			[]string{"build", "--tag", "img", "--pull", "."},
+			[]string{"build", "--tag", "img", "--label", "gantry.project=T", "--label", "gantry.step=name", "--pull", "."},
+		},
+		{
+			gantry.Step{Service: gantry.Service{Name: "name", Image: "img", BuildInfo: gantry.BuildInfo{Dockerfile: "file"}}},
+			false,
+			[]string{"build", "--tag", "img", "--label", "gantry.project=T", "--label", "gantry.step=name", "--file", "file", "."},
+		},
+		{
+			gantry.Step{Service: gantry.Service{Name: "name", Image: "img", BuildInfo: gantry.BuildInfo{Context: "./context"}}},
+			false,
+			[]string{"build", "--tag", "img", "--label", "gantry.project=T", "--label", "gantry.step=name", "./context"},
+		},
+		{
+			gantry.Step{Service: gantry.Service{Name: "name", Image: "img", BuildInfo: gantry.BuildInfo{Args: map[string]*string{"Foo": &bar}}}},
+			false,
+			[]string{"build", "--tag", "img", "--label", "gantry.project=T", "--label", "gantry.step=name", "--build-arg", "Foo=Bar", "."},
+		},
+		{
+			gantry.Step{Service: gantry.Service{Name: "name", Image: "img", BuildInfo: gantry.BuildInfo{Args: map[string]*string{"USER": nil}}}},
+			false,
+			[]string{"build", "--tag", "img", "--label", "gantry.project=T", "--label", "gantry.step=name", "--build-arg", fmt.Sprintf("USER=%s", os.Getenv("USER")), "."},
 		},
 		{
-			gantry.Step{Service: gantry.Service{Image: "img", BuildInfo: gantry.BuildInfo{Dockerfile: "file"}}},
+			gantry.Step{Service: gantry.Service{Name: "name", Image: "img", BuildInfo: gantry.BuildInfo{PullBase: true}}},
 			false,
-			[]string{"build", "--tag", "img", "--file", "file", "."},
+			[]string{"build", "--tag", "img", "--label", "gantry.project=T", "--label", "gantry.step=name", "--pull", "."},
+		},
+		{
+			gantry.Step{Service: gantry.Service{Name: "name", Image: "img", BuildInfo: gantry.BuildInfo{PullBase: true}}},
+			true,
+			[]string{"build", "--tag", "img", "--label", "gantry.project=T", "--label", "gantry.step=name", "--pull", "."},
 		},
 		{
-			gantry.Step{Service: gantry.Service{Image: "img", BuildInfo: gantry.BuildInfo{Context: "./context"}}},
+			gantry.Step{Service: gantry.Service{Name: "name", Image: "img", BuildInfo: gantry.BuildInfo{CacheFrom: []string{"registry/img:latest", "registry/img:cache"}}}},
 			false,
-			[]string{"build", "--tag", "img", "./context"},
+			[]string{"build", "--tag", "img", "--label", "gantry.project=T", "--label", "gantry.step=name", "--cache-from", "registry/img:latest", "--cache-from", "registry/img:cache", "."},
 		},
 		{
-			gantry.Step{Service: gantry.Service{Image: "img", BuildInfo: gantry.BuildInfo{Args: map[string]*string{"Foo": &bar}}}},
+			gantry.Step{Service: gantry.Service{Name: "name", Image: "img", BuildInfo: gantry.BuildInfo{Secrets: []gantry.BuildSecret{{ID: "token", Source: "/token.txt"}}}}},
 			false,
-			[]string{"build", "--tag", "img", "--build-arg", "Foo=Bar", "."},
+			[]string{"build", "--tag", "img", "--label", "gantry.project=T", "--label", "gantry.step=name", "--secret", "id=token,src=/token.txt", "."},
 		},
 		{
-			gantry.Step{Service: gantry.Service{Image: "img", BuildInfo: gantry.BuildInfo{Args: map[string]*string{"USER": nil}}}},
+			gantry.Step{Service: gantry.Service{Name: "name", Image: "img", BuildInfo: gantry.BuildInfo{SSH: types.StringOrStringSlice{"default", "github=/run/ssh.sock"}}}},
 			false,
-			[]string{"build", "--tag", "img", "--build-arg", fmt.Sprintf("USER=%s", os.Getenv("USER")), "."},
+			[]string{"build", "--tag", "img", "--label", "gantry.project=T", "--label", "gantry.step=name", "--ssh", "default", "--ssh", "github=/run/ssh.sock", "."},
+		},
+		{
+			gantry.Step{Service: gantry.Service{Name: "name", Image: "img", ForceRebuild: true}},
+			true,
+			[]string{"build", "--tag", "img", "--label", "gantry.project=T", "--label", "gantry.step=name", "--pull", "--no-cache", "."},
+		},
+		{
+			gantry.Step{Service: gantry.Service{Name: "name", Image: "img", BuildInfo: gantry.BuildInfo{Context: "ctx", ExtraArgs: []string{"--squash", "--network", "host"}}}},
+			false,
+			[]string{"build", "--tag", "img", "--label", "gantry.project=T", "--label", "gantry.step=name", "--squash", "--network", "host", "ctx"},
 		},
 	}
 
+	gantry.ProjectName = "T"
 	for _, c := range cases {
 		r := c.step.BuildCommand(c.pull)
 		if !reflect.DeepEqual(r, c.result) {
 			t.Errorf("Incorrect result for '%v',pull:%t , got: '%v', wanted '%v'", c.step, c.pull, r, c.result)
 		}
 	}
+
+	gantry.ForceRebuild = true
+	defer func() { gantry.ForceRebuild = false }()
+	step := gantry.Step{Service: gantry.Service{Name: "name", Image: "img"}}
+	result := []string{"build", "--tag", "img", "--label", "gantry.project=T", "--label", "gantry.step=name", "--no-cache", "."}
+	if r := step.BuildCommand(false); !reflect.DeepEqual(r, result) {
+		t.Errorf("Incorrect result for '%v' with global force rebuild, got: '%v', wanted '%v'", step, r, result)
+	}
+	gantry.ForceRebuild = false
+
+	defer func() { gantry.Progress = gantry.ProgressAuto }()
+	for progress, flag := range map[string]string{gantry.ProgressPlain: "plain", gantry.ProgressSummary: "rawjson"} {
+		gantry.Progress = progress
+		result := []string{"build", "--tag", "img", "--label", "gantry.project=T", "--label", "gantry.step=name", "--progress", flag, "."}
+		if r := step.BuildCommand(false); !reflect.DeepEqual(r, result) {
+			t.Errorf("Incorrect result for '%v' with progress %s, got: '%v', wanted '%v'", step, progress, r, result)
+		}
+	}
 }
 
 func TestStepRunCommand(t *testing.T) {
 	bar := "Bar"
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
 	cases := []struct {
 		step    gantry.Step
 		network gantry.Network
 		result  []string
 	}{
 		{
-			gantry.Step{Service: gantry.Service{Image: "img", Name: "name", Meta: gantry.ServiceMeta{Type: gantry.ServiceTypeStep}}},
+			gantry.Step{Service: gantry.Service{Image: "img", Name: "name", Meta: gantry.ServiceMeta{Type: gantry.ServiceTypeStep, KeepAlive: gantry.KeepAliveNo}}},
 			gantry.Network("dummy"),
-			[]string{"run", "--name", "T_name", "--network", "dummy", "--network-alias", "name", "--network-alias", "T_name", "--rm", "img"},
+			[]string{"run", "--name", "T_name", "--network", "dummy", "--network-alias", "name", "--network-alias", "T_name", "--label", "gantry.project=T", "--label", "gantry.service=name", "--rm", "img"},
 		},
 		{
 			gantry.Step{Service: gantry.Service{Image: "i", Name: "n", Meta: gantry.ServiceMeta{Type: gantry.ServiceTypeService}}},
 			gantry.Network("dummy"),
-			[]string{"run", "--name", "T_n", "--network", "dummy", "--network-alias", "n", "--network-alias", "T_n", "-d", "i"},
+			[]string{"run", "--name", "T_n", "--network", "dummy", "--network-alias", "n", "--network-alias", "T_n", "--label", "gantry.project=T", "--label", "gantry.service=n", "-d", "i"},
 		},
 		{
-			gantry.Step{Service: gantry.Service{Image: "img", Name: "name", Ports: []string{"8080:5000"}, Meta: gantry.ServiceMeta{Type: gantry.ServiceTypeStep}}},
+			gantry.Step{Service: gantry.Service{Image: "img", Name: "name", Ports: []string{"8080:5000"}, Meta: gantry.ServiceMeta{Type: gantry.ServiceTypeStep, KeepAlive: gantry.KeepAliveNo}}},
 			gantry.Network("dummy"),
-			[]string{"run", "--name", "T_name", "--network", "dummy", "--network-alias", "name", "--network-alias", "T_name", "--rm", "-p", "8080:5000", "img"},
+			[]string{"run", "--name", "T_name", "--network", "dummy", "--network-alias", "name", "--network-alias", "T_name", "--label", "gantry.project=T", "--label", "gantry.service=name", "--rm", "-p", "8080:5000", "img"},
 		},
 		{
-			gantry.Step{Service: gantry.Service{Image: "img", Name: "name", Environment: map[string]*string{"Foo": &bar}, Meta: gantry.ServiceMeta{Type: gantry.ServiceTypeStep}}},
+			gantry.Step{Service: gantry.Service{Image: "img", Name: "name", Environment: map[string]*string{"Foo": &bar}, Meta: gantry.ServiceMeta{Type: gantry.ServiceTypeStep, KeepAlive: gantry.KeepAliveNo}}},
 			gantry.Network("dummy"),
-			[]string{"run", "--name", "T_name", "--network", "dummy", "--network-alias", "name", "--network-alias", "T_name", "--rm", "-e", "Foo=Bar", "img"},
+			[]string{"run", "--name", "T_name", "--network", "dummy", "--network-alias", "name", "--network-alias", "T_name", "--label", "gantry.project=T", "--label", "gantry.service=name", "--rm", "-e", "Foo=Bar", "img"},
 		},
 		{
-			gantry.Step{Service: gantry.Service{Image: "img", Name: "name", Environment: map[string]*string{"USER": nil}, Meta: gantry.ServiceMeta{Type: gantry.ServiceTypeStep}}},
+			gantry.Step{Service: gantry.Service{Image: "img", Name: "name", Environment: map[string]*string{"USER": nil}, Meta: gantry.ServiceMeta{Type: gantry.ServiceTypeStep, KeepAlive: gantry.KeepAliveNo}}},
 			gantry.Network("dummy"),
-			[]string{"run", "--name", "T_name", "--network", "dummy", "--network-alias", "name", "--network-alias", "T_name", "--rm", "-e", fmt.Sprintf("USER=%s", os.Getenv("USER")), "img"},
+			[]string{"run", "--name", "T_name", "--network", "dummy", "--network-alias", "name", "--network-alias", "T_name", "--label", "gantry.project=T", "--label", "gantry.service=name", "--rm", "-e", fmt.Sprintf("USER=%s", os.Getenv("USER")), "img"},
 		},
 		{
-			gantry.Step{Service: gantry.Service{Image: "img", Name: "name", Volumes: []string{"/tmp:/tmp"}, Meta: gantry.ServiceMeta{Type: gantry.ServiceTypeStep}}},
+			gantry.Step{Service: gantry.Service{Image: "img", Name: "name", Volumes: []string{"/tmp:/tmp"}, Meta: gantry.ServiceMeta{Type: gantry.ServiceTypeStep, KeepAlive: gantry.KeepAliveNo}}},
 			gantry.Network("dummy"),
-			[]string{"run", "--name", "T_name", "--network", "dummy", "--network-alias", "name", "--network-alias", "T_name", "--rm", "-v", "/tmp:/tmp", "img"},
+			[]string{"run", "--name", "T_name", "--network", "dummy", "--network-alias", "name", "--network-alias", "T_name", "--label", "gantry.project=T", "--label", "gantry.service=name", "--rm", "-v", "/tmp:/tmp", "img"},
 		},
 		{
-			gantry.Step{Service: gantry.Service{Image: "img", Name: "name", Command: types.StringOrStringSlice{"Do", "nothing"}, Meta: gantry.ServiceMeta{Type: gantry.ServiceTypeStep}}},
+			gantry.Step{Service: gantry.Service{Image: "img", Name: "name", Volumes: []string{"data:/data"}, Meta: gantry.ServiceMeta{Type: gantry.ServiceTypeStep, KeepAlive: gantry.KeepAliveNo}}},
 			gantry.Network("dummy"),
-			[]string{"run", "--name", "T_name", "--network", "dummy", "--network-alias", "name", "--network-alias", "T_name", "--rm", "img", "Do", "nothing"},
+			[]string{"run", "--name", "T_name", "--network", "dummy", "--network-alias", "name", "--network-alias", "T_name", "--label", "gantry.project=T", "--label", "gantry.service=name", "--rm", "-v", filepath.Join(cwd, "data") + ":/data", "img"},
 		},
 		{
-			gantry.Step{Service: gantry.Service{Image: "img", Name: "name", Command: types.StringOrStringSlice{"Do nothing"}, Meta: gantry.ServiceMeta{Type: gantry.ServiceTypeStep}}},
+			gantry.Step{Service: gantry.Service{Image: "img", Name: "name", Command: types.StringOrStringSlice{"Do", "nothing"}, Meta: gantry.ServiceMeta{Type: gantry.ServiceTypeStep, KeepAlive: gantry.KeepAliveNo}}},
 			gantry.Network("dummy"),
-			[]string{"run", "--name", "T_name", "--network", "dummy", "--network-alias", "name", "--network-alias", "T_name", "--rm", "img", "Do", "nothing"},
+			[]string{"run", "--name", "T_name", "--network", "dummy", "--network-alias", "name", "--network-alias", "T_name", "--label", "gantry.project=T", "--label", "gantry.service=name", "--rm", "img", "Do", "nothing"},
 		},
 		{
-			gantry.Step{Service: gantry.Service{Image: "img", Name: "name", Entrypoint: types.StringOrStringSlice{"Do", "nothing"}, Meta: gantry.ServiceMeta{Type: gantry.ServiceTypeStep}}},
+			gantry.Step{Service: gantry.Service{Image: "img", Name: "name", Command: types.StringOrStringSlice{"Do nothing"}, Meta: gantry.ServiceMeta{Type: gantry.ServiceTypeStep, KeepAlive: gantry.KeepAliveNo}}},
 			gantry.Network("dummy"),
-			[]string{"run", "--name", "T_name", "--network", "dummy", "--network-alias", "name", "--network-alias", "T_name", "--rm", "--entrypoint", "Do", "img", "nothing"},
+			[]string{"run", "--name", "T_name", "--network", "dummy", "--network-alias", "name", "--network-alias", "T_name", "--label", "gantry.project=T", "--label", "gantry.service=name", "--rm", "img", "Do", "nothing"},
 		},
 		{
-			gantry.Step{Service: gantry.Service{Image: "img", Name: "name", Entrypoint: types.StringOrStringSlice{"Do nothing"}, Meta: gantry.ServiceMeta{Type: gantry.ServiceTypeStep}}},
+			gantry.Step{Service: gantry.Service{Image: "img", Name: "name", Command: types.StringOrStringSlice{`sh -c "echo a && echo b"`}, Meta: gantry.ServiceMeta{Type: gantry.ServiceTypeStep, KeepAlive: gantry.KeepAliveNo}}},
 			gantry.Network("dummy"),
-			[]string{"run", "--name", "T_name", "--network", "dummy", "--network-alias", "name", "--network-alias", "T_name", "--rm", "--entrypoint", "Do", "img", "nothing"},
+			[]string{"run", "--name", "T_name", "--network", "dummy", "--network-alias", "name", "--network-alias", "T_name", "--label", "gantry.project=T", "--label", "gantry.service=name", "--rm", "img", "sh", "-c", "echo a && echo b"},
 		},
 		{
-			gantry.Step{Service: gantry.Service{Image: "img", Name: "name", Restart: "never", Meta: gantry.ServiceMeta{Type: gantry.ServiceTypeStep}}},
+			gantry.Step{Service: gantry.Service{Image: "img", Name: "name", Entrypoint: types.StringOrStringSlice{"/bin/sh", "-c"}, Command: types.StringOrStringSlice{"echo a && echo b"}, Meta: gantry.ServiceMeta{Type: gantry.ServiceTypeStep, KeepAlive: gantry.KeepAliveNo}}},
 			gantry.Network("dummy"),
-			[]string{"run", "--name", "T_name", "--network", "dummy", "--network-alias", "name", "--network-alias", "T_name", "--rm", "--restart", "never", "img"},
+			[]string{"run", "--name", "T_name", "--network", "dummy", "--network-alias", "name", "--network-alias", "T_name", "--label", "gantry.project=T", "--label", "gantry.service=name", "--rm", "--entrypoint", "/bin/sh", "img", "-c", "echo", "a", "&&", "echo", "b"},
+		},
+		{
+			gantry.Step{Service: gantry.Service{Image: "img", Name: "name", Entrypoint: types.StringOrStringSlice{"/bin/sh", "-c"}, Command: types.StringOrStringSlice{"echo a && echo b", "sh"}, Meta: gantry.ServiceMeta{Type: gantry.ServiceTypeStep, KeepAlive: gantry.KeepAliveNo}}},
+			gantry.Network("dummy"),
+			[]string{"run", "--name", "T_name", "--network", "dummy", "--network-alias", "name", "--network-alias", "T_name", "--label", "gantry.project=T", "--label", "gantry.service=name", "--rm", "--entrypoint", "/bin/sh", "img", "-c", "echo a && echo b", "sh"},
+		},
+		{
+			gantry.Step{Service: gantry.Service{Image: "img", Name: "name", Entrypoint: types.StringOrStringSlice{"Do", "nothing"}, Meta: gantry.ServiceMeta{Type: gantry.ServiceTypeStep, KeepAlive: gantry.KeepAliveNo}}},
+			gantry.Network("dummy"),
+			[]string{"run", "--name", "T_name", "--network", "dummy", "--network-alias", "name", "--network-alias", "T_name", "--label", "gantry.project=T", "--label", "gantry.service=name", "--rm", "--entrypoint", "Do", "img", "nothing"},
+		},
+		{
+			gantry.Step{Service: gantry.Service{Image: "img", Name: "name", Entrypoint: types.StringOrStringSlice{"Do nothing"}, Meta: gantry.ServiceMeta{Type: gantry.ServiceTypeStep, KeepAlive: gantry.KeepAliveNo}}},
+			gantry.Network("dummy"),
+			[]string{"run", "--name", "T_name", "--network", "dummy", "--network-alias", "name", "--network-alias", "T_name", "--label", "gantry.project=T", "--label", "gantry.service=name", "--rm", "--entrypoint", "Do", "img", "nothing"},
+		},
+		{
+			gantry.Step{Service: gantry.Service{Image: "img", Name: "name", Restart: "never", Meta: gantry.ServiceMeta{Type: gantry.ServiceTypeStep, KeepAlive: gantry.KeepAliveNo}}},
+			gantry.Network("dummy"),
+			[]string{"run", "--name", "T_name", "--network", "dummy", "--network-alias", "name", "--network-alias", "T_name", "--label", "gantry.project=T", "--label", "gantry.service=name", "--rm", "--restart", "never", "img"},
 		},
 		{
 			gantry.Step{Service: gantry.Service{Image: "img", Name: "name", Restart: "unless-stopped", Meta: gantry.ServiceMeta{Type: gantry.ServiceTypeService}}},
 			gantry.Network("dummy"),
-			[]string{"run", "--name", "T_name", "--network", "dummy", "--network-alias", "name", "--network-alias", "T_name", "-d", "--restart", "unless-stopped", "img"},
+			[]string{"run", "--name", "T_name", "--network", "dummy", "--network-alias", "name", "--network-alias", "T_name", "--label", "gantry.project=T", "--label", "gantry.service=name", "-d", "--restart", "unless-stopped", "img"},
+		},
+		{
+			gantry.Step{Service: gantry.Service{Image: "img", Name: "name", GPUs: "all", Meta: gantry.ServiceMeta{Type: gantry.ServiceTypeStep, KeepAlive: gantry.KeepAliveNo}}},
+			gantry.Network("dummy"),
+			[]string{"run", "--name", "T_name", "--network", "dummy", "--network-alias", "name", "--network-alias", "T_name", "--label", "gantry.project=T", "--label", "gantry.service=name", "--rm", "--gpus", "all", "img"},
+		},
+		{
+			gantry.Step{Service: gantry.Service{Image: "img", Name: "name", Ulimits: gantry.Ulimits{"nproc": {Soft: 65535}, "memlock": {Soft: -1, Hard: -1}, "nofile": {Soft: 20000, Hard: 40000}}, Meta: gantry.ServiceMeta{Type: gantry.ServiceTypeStep, KeepAlive: gantry.KeepAliveNo}}},
+			gantry.Network("dummy"),
+			[]string{"run", "--name", "T_name", "--network", "dummy", "--network-alias", "name", "--network-alias", "T_name", "--label", "gantry.project=T", "--label", "gantry.service=name", "--rm", "--ulimit", "memlock=-1:-1", "--ulimit", "nofile=20000:40000", "--ulimit", "nproc=65535", "img"},
+		},
+		{
+			gantry.Step{Service: gantry.Service{Image: "img", Name: "name", LogDriver: "json-file", LogOpts: map[string]*string{"max-size": &bar, "labels": nil}, Meta: gantry.ServiceMeta{Type: gantry.ServiceTypeService}}},
+			gantry.Network("dummy"),
+			[]string{"run", "--name", "T_name", "--network", "dummy", "--network-alias", "name", "--network-alias", "T_name", "--label", "gantry.project=T", "--label", "gantry.service=name", "-d", "--log-driver", "json-file", "--log-opt", "labels=", "--log-opt", "max-size=Bar", "img"},
+		},
+		{
+			gantry.Step{Service: gantry.Service{Image: "img", Name: "name", SecurityOpt: []string{"seccomp=unconfined", "apparmor=unconfined"}, Meta: gantry.ServiceMeta{Type: gantry.ServiceTypeStep, KeepAlive: gantry.KeepAliveNo}}},
+			gantry.Network("dummy"),
+			[]string{"run", "--name", "T_name", "--network", "dummy", "--network-alias", "name", "--network-alias", "T_name", "--label", "gantry.project=T", "--label", "gantry.service=name", "--rm", "--security-opt", "seccomp=unconfined", "--security-opt", "apparmor=unconfined", "img"},
+		},
+		{
+			gantry.Step{Service: gantry.Service{Image: "img", Name: "name", ShmSize: "2gb", Meta: gantry.ServiceMeta{Type: gantry.ServiceTypeStep, KeepAlive: gantry.KeepAliveNo}}},
+			gantry.Network("dummy"),
+			[]string{"run", "--name", "T_name", "--network", "dummy", "--network-alias", "name", "--network-alias", "T_name", "--label", "gantry.project=T", "--label", "gantry.service=name", "--rm", "--shm-size", "2gb", "img"},
+		},
+		{
+			gantry.Step{Service: gantry.Service{Image: "img", Name: "name", Sysctls: types.StringMap{"net.ipv4.ip_forward": &bar, "net.core.somaxconn": &bar}, Meta: gantry.ServiceMeta{Type: gantry.ServiceTypeService}}},
+			gantry.Network("dummy"),
+			[]string{"run", "--name", "T_name", "--network", "dummy", "--network-alias", "name", "--network-alias", "T_name", "--label", "gantry.project=T", "--label", "gantry.service=name", "-d", "--sysctl", "net.core.somaxconn=Bar", "--sysctl", "net.ipv4.ip_forward=Bar", "img"},
+		},
+		{
+			gantry.Step{Service: gantry.Service{Image: "img", Name: "name", ReadOnly: true, Tmpfs: types.StringOrStringSlice{"/tmp", "/run:size=64m"}, Meta: gantry.ServiceMeta{Type: gantry.ServiceTypeStep, KeepAlive: gantry.KeepAliveNo}}},
+			gantry.Network("dummy"),
+			[]string{"run", "--name", "T_name", "--network", "dummy", "--network-alias", "name", "--network-alias", "T_name", "--label", "gantry.project=T", "--label", "gantry.service=name", "--rm", "--read-only", "--tmpfs", "/tmp", "--tmpfs", "/run:size=64m", "img"},
+		},
+		{
+			gantry.Step{Service: gantry.Service{Image: "img", Name: "name", IPv4Address: "172.20.0.10", Meta: gantry.ServiceMeta{Type: gantry.ServiceTypeStep, KeepAlive: gantry.KeepAliveNo}}},
+			gantry.Network("dummy"),
+			[]string{"run", "--name", "T_name", "--network", "dummy", "--network-alias", "name", "--network-alias", "T_name", "--ip", "172.20.0.10", "--label", "gantry.project=T", "--label", "gantry.service=name", "--rm", "img"},
+		},
+		{
+			gantry.Step{Service: gantry.Service{Image: "img", Name: "name", IPv4Address: "172.20.0.10", Meta: gantry.ServiceMeta{Type: gantry.ServiceTypeStep, KeepAlive: gantry.KeepAliveNo}}},
+			gantry.Network(""),
+			[]string{"run", "--name", "T_name", "--network", "", "--network-alias", "name", "--network-alias", "T_name", "--label", "gantry.project=T", "--label", "gantry.service=name", "--rm", "img"},
+		},
+		{
+			gantry.Step{Service: gantry.Service{Image: "img", Name: "name", Init: true, Meta: gantry.ServiceMeta{Type: gantry.ServiceTypeStep, KeepAlive: gantry.KeepAliveNo}}},
+			gantry.Network("dummy"),
+			[]string{"run", "--name", "T_name", "--network", "dummy", "--network-alias", "name", "--network-alias", "T_name", "--label", "gantry.project=T", "--label", "gantry.service=name", "--rm", "--init", "img"},
+		},
+		{
+			gantry.Step{Service: gantry.Service{Image: "img", Name: "name", Entrypoint: types.StringOrStringSlice{}, Meta: gantry.ServiceMeta{Type: gantry.ServiceTypeStep, KeepAlive: gantry.KeepAliveNo}}},
+			gantry.Network("dummy"),
+			[]string{"run", "--name", "T_name", "--network", "dummy", "--network-alias", "name", "--network-alias", "T_name", "--label", "gantry.project=T", "--label", "gantry.service=name", "--rm", "--entrypoint", "", "img"},
+		},
+		{
+			gantry.Step{Service: gantry.Service{Image: "img", Name: "name", Entrypoint: types.StringOrStringSlice{""}, Command: types.StringOrStringSlice{"ls -l"}, Meta: gantry.ServiceMeta{Type: gantry.ServiceTypeStep, KeepAlive: gantry.KeepAliveNo}}},
+			gantry.Network("dummy"),
+			[]string{"run", "--name", "T_name", "--network", "dummy", "--network-alias", "name", "--network-alias", "T_name", "--label", "gantry.project=T", "--label", "gantry.service=name", "--rm", "--entrypoint", "", "img", "ls", "-l"},
+		},
+		{
+			gantry.Step{Service: gantry.Service{Image: "img", Name: "name", PullPolicy: "always", Meta: gantry.ServiceMeta{Type: gantry.ServiceTypeStep, KeepAlive: gantry.KeepAliveNo}}},
+			gantry.Network("dummy"),
+			[]string{"run", "--name", "T_name", "--network", "dummy", "--network-alias", "name", "--network-alias", "T_name", "--label", "gantry.project=T", "--label", "gantry.service=name", "--rm", "--pull", "always", "img"},
+		},
+		{
+			gantry.Step{Service: gantry.Service{Image: "img", Name: "name", Meta: gantry.ServiceMeta{Type: gantry.ServiceTypeStep, KeepAlive: gantry.KeepAliveOnFailure}}},
+			gantry.Network("dummy"),
+			[]string{"run", "--name", "T_name", "--network", "dummy", "--network-alias", "name", "--network-alias", "T_name", "--label", "gantry.project=T", "--label", "gantry.service=name", "img"},
+		},
+		{
+			gantry.Step{Service: gantry.Service{Image: "img", Name: "name", Volumes: []string{"My Data:/data"}, Meta: gantry.ServiceMeta{Type: gantry.ServiceTypeStep, KeepAlive: gantry.KeepAliveNo}}},
+			gantry.Network("dummy"),
+			[]string{"run", "--name", "T_name", "--network", "dummy", "--network-alias", "name", "--network-alias", "T_name", "--label", "gantry.project=T", "--label", "gantry.service=name", "--rm", "-v", filepath.Join(cwd, "My Data") + ":/data", "img"},
+		},
+		{
+			gantry.Step{Service: gantry.Service{Image: "img", Name: "name", Entrypoint: types.StringOrStringSlice{"/bin/sh"}, Command: types.StringOrStringSlice{"ls"}, Meta: gantry.ServiceMeta{Type: gantry.ServiceTypeStep, KeepAlive: gantry.KeepAliveNo}}, ExtraArgs: []string{"--cap-add", "SYS_PTRACE", "--device=/dev/fuse"}},
+			gantry.Network("dummy"),
+			[]string{"run", "--name", "T_name", "--network", "dummy", "--network-alias", "name", "--network-alias", "T_name", "--label", "gantry.project=T", "--label", "gantry.service=name", "--rm", "--entrypoint", "/bin/sh", "--cap-add", "SYS_PTRACE", "--device=/dev/fuse", "img", "ls"},
+		},
+		{
+			gantry.Step{Service: gantry.Service{Image: "img", Name: "name", Command: types.StringOrStringSlice{"make && make test | tee log"}, Meta: gantry.ServiceMeta{Type: gantry.ServiceTypeStep, KeepAlive: gantry.KeepAliveNo}}, Shell: gantry.DefaultShell},
+			gantry.Network("dummy"),
+			[]string{"run", "--name", "T_name", "--network", "dummy", "--network-alias", "name", "--network-alias", "T_name", "--label", "gantry.project=T", "--label", "gantry.service=name", "--rm", "--entrypoint", "/bin/sh", "img", "-c", "make && make test | tee log"},
+		},
+		{
+			gantry.Step{Service: gantry.Service{Image: "img", Name: "name", Command: types.StringOrStringSlice{"echo", "$HOME", "it's", ""}, Meta: gantry.ServiceMeta{Type: gantry.ServiceTypeStep, KeepAlive: gantry.KeepAliveNo}}, Shell: "/bin/bash"},
+			gantry.Network("dummy"),
+			[]string{"run", "--name", "T_name", "--network", "dummy", "--network-alias", "name", "--network-alias", "T_name", "--label", "gantry.project=T", "--label", "gantry.service=name", "--rm", "--entrypoint", "/bin/bash", "img", "-c", `echo '$HOME' 'it'\''s' ''`},
 		},
 	}
 
@@ -272,6 +635,23 @@ func TestStepRunCommand(t *testing.T) {
 	}
 }
 
+func TestStepIsPullable(t *testing.T) {
+	cases := []struct {
+		step   gantry.Step
+		result bool
+	}{
+		{gantry.Step{Service: gantry.Service{Image: "img"}}, true},
+		{gantry.Step{Service: gantry.Service{Image: "img", BuildInfo: gantry.BuildInfo{Context: "."}}}, false},
+		{gantry.Step{Service: gantry.Service{Image: "img", PullPolicy: "always"}}, false},
+	}
+
+	for _, c := range cases {
+		if r := c.step.IsPullable(); r != c.result {
+			t.Errorf("Incorrect result for '%#v', got: '%t', wanted: '%t'", c.step, r, c.result)
+		}
+	}
+}
+
 func TestStepPullCommand(t *testing.T) {
 	cases := []struct {
 		step   gantry.Step
@@ -287,3 +667,27 @@ func TestStepPullCommand(t *testing.T) {
 		}
 	}
 }
+
+func TestShellUnmarshalJSON(t *testing.T) {
+	cases := []struct {
+		input  string
+		result gantry.Shell
+		err    bool
+	}{
+		{"true", gantry.DefaultShell, false},
+		{"false", "", false},
+		{`"/bin/bash"`, "/bin/bash", false},
+		{"1", "", true},
+	}
+
+	for _, c := range cases {
+		var r gantry.Shell
+		err := json.Unmarshal([]byte(c.input), &r)
+		if (err != nil) != c.err {
+			t.Errorf("Incorrect error for '%s', got: '%v', wanted error: %t", c.input, err, c.err)
+		}
+		if r != c.result {
+			t.Errorf("Incorrect result for '%s', got: '%s', wanted: '%s'", c.input, r, c.result)
+		}
+	}
+}
@@ -1,7 +1,7 @@
 package gantry // import "github.com/ad-freiburg/gantry"
 // Adapted version of https://github.com/looplab/tarjan/blob/master/tarjan.go
 import (
-	"fmt"
+	"sort"
 )
 
 type tarjanData struct {
@@ -24,9 +24,9 @@ func (td *tarjanData) strongConnect(v string) (*tarjanNode, error) {
 	td.nodes = append(td.nodes, tarjanNode{lowlink: index, stacked: true})
 	node := &td.nodes[index]
 
-	for w := range td.graph[v].Dependencies() {
+	for _, w := range sortedKeys(td.graph[v].Dependencies()) {
 		if _, ok := td.graph[w]; !ok {
-			return nil, fmt.Errorf("unknown dependency '%s' for step '%s'", w, v)
+			return nil, UnknownDependencyError{Step: v, Dependency: w}
 		}
 		i, seen := td.index[w]
 		if !seen {
@@ -71,7 +71,13 @@ func NewTarjan(steps map[string]Step) (*Pipelines, error) {
 		index: make(map[string]int, len(steps)),
 	}
 	t.graph = steps
+	// Visit steps in sorted order to get a reproducible result
+	names := make([]string, 0, len(steps))
 	for v := range t.graph {
+		names = append(names, v)
+	}
+	sort.Strings(names)
+	for _, v := range names {
 		if _, ok := t.index[v]; !ok {
 			_, err := t.strongConnect(v)
 			if err != nil {
@@ -81,3 +87,13 @@ func NewTarjan(steps map[string]Step) (*Pipelines, error) {
 	}
 	return &t.output, nil
 }
+
+// sortedKeys returns all keys of set in sorted order.
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -1,6 +1,7 @@
 package gantry_test
 
 import (
+	"reflect"
 	"testing"
 
 	"github.com/ad-freiburg/gantry"
@@ -210,4 +211,51 @@ func TestNewTarjanMissingDependency(t *testing.T) {
 	if err == nil {
 		t.Errorf("Got no error for: '%#v'", input)
 	}
+	expected := gantry.UnknownDependencyError{Step: "b", Dependency: "a"}
+	if e, ok := err.(gantry.UnknownDependencyError); !ok || e != expected {
+		t.Errorf("Incorrect error for: '%#v', got: '%#v', wanted: '%#v'", input, err, expected)
+	}
+}
+
+func TestNewTarjanDeterministic(t *testing.T) {
+	input := map[string]gantry.Step{
+		"f": {Service: gantry.Service{Name: "f"}},
+		"e": {Service: gantry.Service{Name: "e"}, After: map[string]bool{"f": true}},
+		"d": {Service: gantry.Service{Name: "d"}, After: map[string]bool{"c": true, "b": true}},
+		"c": {Service: gantry.Service{Name: "c"}, After: map[string]bool{"a": true}},
+		"b": {Service: gantry.Service{Name: "b"}, After: map[string]bool{"a": true}},
+		"a": {Service: gantry.Service{Name: "a"}},
+	}
+	expected := []string{"a", "b", "c", "d", "f", "e"}
+
+	for i := 0; i < 50; i++ {
+		r, err := gantry.NewTarjan(input)
+		if err != nil {
+			t.Fatalf("Got error: %v", err)
+		}
+		names := make([]string, 0)
+		for _, step := range r.AllSteps() {
+			names = append(names, step.Name)
+		}
+		if !reflect.DeepEqual(names, expected) {
+			t.Fatalf("Incorrect order in run '%d', got: '%v', wanted: '%v'", i, names, expected)
+		}
+	}
+}
+
+func TestPipelinesCheckMixedCycle(t *testing.T) {
+	// a only orders itself after c, the cycle must still be detected
+	input := map[string]gantry.Step{
+		"a": {Service: gantry.Service{Name: "a"}, After: map[string]bool{"c": true}},
+		"b": {Service: gantry.Service{Name: "b", DependsOn: gantry.DependsOn{"a": gantry.DependencyConditionStarted}}},
+		"c": {Service: gantry.Service{Name: "c", DependsOn: gantry.DependsOn{"b": gantry.DependencyConditionStarted}}},
+	}
+	pipelines, err := gantry.NewTarjan(input)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %#v", err)
+	}
+	err = pipelines.Check()
+	if _, ok := err.(gantry.CyclicComponentError); !ok {
+		t.Errorf("Incorrect error, got: '%#v', wanted: CyclicComponentError", err)
+	}
 }
@@ -0,0 +1,143 @@
+package gantry // import "github.com/ad-freiburg/gantry"
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+
+	"github.com/ad-freiburg/gantry/types"
+)
+
+// templateParameterRegexp matches the ((NAME)) placeholders of step
+// templates, which are not touched by the preprocessor.
+var templateParameterRegexp = regexp.MustCompile(`\(\(\s*([a-zA-Z_][a-zA-Z0-9_]*)\s*\)\)`)
+
+// StepTemplate is a step which is instantiated once per instance. Each
+// ((NAME)) in the strings of the step is replaced by the parameter NAME of
+// the instance.
+type StepTemplate struct {
+	// Instances maps the name of each instance to its parameters.
+	Instances map[string]map[string]string
+	step      []byte
+}
+
+// UnmarshalJSON stores the step of the template and parses its instances.
+func (t *StepTemplate) UnmarshalJSON(data []byte) error {
+	parsedJSON := struct {
+		Instances map[string]map[string]interface{} `json:"instances"`
+	}{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&parsedJSON); err != nil {
+		return err
+	}
+	if len(parsedJSON.Instances) == 0 {
+		return fmt.Errorf("template without instances")
+	}
+	t.Instances = make(map[string]map[string]string, len(parsedJSON.Instances))
+	for name, parameters := range parsedJSON.Instances {
+		t.Instances[name] = make(map[string]string, len(parameters))
+		for key, value := range parameters {
+			if value == nil {
+				value = ""
+			}
+			t.Instances[name][key] = fmt.Sprint(value)
+		}
+	}
+	t.step = data
+	return nil
+}
+
+// TemplateInstanceName returns the name of the step generated for instance
+// of template.
+func TemplateInstanceName(template string, instance string) string {
+	return fmt.Sprintf("%s.%s", template, instance)
+}
+
+// InstanceNames returns the names of all steps generated from template t
+// named name in sorted order.
+func (t StepTemplate) InstanceNames(name string) []string {
+	result := make([]string, 0, len(t.Instances))
+	for instance := range t.Instances {
+		result = append(result, TemplateInstanceName(name, instance))
+	}
+	sort.Strings(result)
+	return result
+}
+
+// instantiate returns the steps generated from template t named name.
+func (t StepTemplate) instantiate(name string) (StepList, error) {
+	result := StepList{}
+	for instance, parameters := range t.Instances {
+		var missing string
+		data := templateParameterRegexp.ReplaceAllFunc(t.step, func(placeholder []byte) []byte {
+			key := string(templateParameterRegexp.FindSubmatch(placeholder)[1])
+			value, ok := parameters[key]
+			if !ok {
+				missing = key
+				return placeholder
+			}
+			// Parameters are only replaced inside strings
+			encoded, _ := json.Marshal(value)
+			return encoded[1 : len(encoded)-1]
+		})
+		if missing != "" {
+			return nil, fmt.Errorf("template '%s': instance '%s' misses parameter '%s'", name, instance, missing)
+		}
+		step := Step{}
+		if err := json.Unmarshal(data, &step); err != nil {
+			return nil, fmt.Errorf("template '%s': instance '%s': %s", name, instance, err)
+		}
+		step.Name = TemplateInstanceName(name, instance)
+		step.InitColor()
+		result[step.Name] = step
+	}
+	return result, nil
+}
+
+// expandTemplateReferences replaces references to templates in depends_on,
+// after and stages by references to all instances of the template.
+func expandTemplateReferences(steps StepList, stages []Stage, templates map[string]StepTemplate) {
+	for name, step := range steps {
+		if len(step.DependsOn) > 0 {
+			dependsOn := DependsOn{}
+			for dep, condition := range step.DependsOn {
+				if template, ok := templates[dep]; ok {
+					for _, instance := range template.InstanceNames(dep) {
+						dependsOn[instance] = condition
+					}
+					continue
+				}
+				dependsOn[dep] = condition
+			}
+			step.DependsOn = dependsOn
+		}
+		if len(step.After) > 0 {
+			after := types.StringSet{}
+			for dep := range step.After {
+				if template, ok := templates[dep]; ok {
+					for _, instance := range template.InstanceNames(dep) {
+						after[instance] = true
+					}
+					continue
+				}
+				after[dep] = true
+			}
+			step.After = after
+		}
+		steps[name] = step
+	}
+	for i, stage := range stages {
+		names := []string{}
+		for _, name := range stage.Steps {
+			if template, ok := templates[name]; ok {
+				names = append(names, template.InstanceNames(name)...)
+				continue
+			}
+			names = append(names, name)
+		}
+		stages[i].Steps = names
+	}
+}
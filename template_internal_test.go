@@ -0,0 +1,198 @@
+package gantry // import "github.com/ad-freiburg/gantry"
+
+import (
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/ad-freiburg/gantry/types"
+)
+
+func TestPipelineDefinitionTemplates(t *testing.T) {
+	def, env := setupDefAndEnv(`version: "2.0"
+templates:
+  test:
+    image: alpine
+    command: ["test", "((SUITE))", "--shard", "((SHARD))"]
+    environment:
+      SUITE: ((SUITE))
+      RETRIES: "2"
+    depends_on:
+    - build
+    instances:
+      unit:
+        SUITE: unit tests
+        SHARD: 1
+      e2e:
+        SUITE: "quoted \"e2e\""
+        SHARD: 2
+steps:
+  build:
+    image: alpine
+  report:
+    image: alpine
+    depends_on:
+    - test
+  cleanup:
+    image: alpine
+    after:
+    - test
+stages:
+- name: test
+  steps:
+  - test
+`, "")
+	defer os.Remove(def)
+	defer os.Remove(env)
+
+	p, err := NewPipeline(def, env, types.StringMap{}, types.StringSet{}, types.StringSet{})
+	if err != nil {
+		t.Fatalf("Got unexpected error: %#v", err)
+	}
+	cases := []struct {
+		name        string
+		command     types.StringOrStringSlice
+		environment types.StringMap
+	}{
+		{"test.unit", types.StringOrStringSlice{"test", "unit tests", "--shard", "1"}, types.StringMap{"SUITE": strPtr("unit tests"), "RETRIES": strPtr("2")}},
+		{"test.e2e", types.StringOrStringSlice{"test", `quoted "e2e"`, "--shard", "2"}, types.StringMap{"SUITE": strPtr(`quoted "e2e"`), "RETRIES": strPtr("2")}},
+	}
+	for _, c := range cases {
+		step, ok := p.Definition.Steps[c.name]
+		if !ok {
+			t.Errorf("Missing instance '%s'", c.name)
+			continue
+		}
+		if step.Name != c.name || step.Meta.Type != ServiceTypeStep {
+			t.Errorf("Incorrect instance '%s', got name: '%s', type: %v", c.name, step.Name, step.Meta.Type)
+		}
+		if !reflect.DeepEqual(step.Command, c.command) {
+			t.Errorf("Incorrect command of '%s', got: %v, wanted: %v", c.name, step.Command, c.command)
+		}
+		if !reflect.DeepEqual(step.Environment, c.environment) {
+			t.Errorf("Incorrect environment of '%s', got: %v, wanted: %v", c.name, step.Environment, c.environment)
+		}
+		if _, ok := step.DependsOn["build"]; !ok {
+			t.Errorf("Missing dependency of '%s' on 'build', got: %v", c.name, step.DependsOn)
+		}
+	}
+	if _, ok := p.Definition.Steps["test"]; ok {
+		t.Errorf("Template 'test' must not be a step")
+	}
+	instances := []string{"test.e2e", "test.unit"}
+	if got := sortedKeys(p.Definition.Steps["report"].Dependencies()); !reflect.DeepEqual(got, instances) {
+		t.Errorf("Incorrect dependencies of 'report', got: %v, wanted: %v", got, instances)
+	}
+	if got := sortedKeys(p.Definition.Steps["cleanup"].OrderingDependencies()); !reflect.DeepEqual(got, instances) {
+		t.Errorf("Incorrect ordering dependencies of 'cleanup', got: %v, wanted: %v", got, instances)
+	}
+	if got := p.Definition.Stages[0].Steps; !reflect.DeepEqual(got, instances) {
+		t.Errorf("Incorrect steps of stage, got: %v, wanted: %v", got, instances)
+	}
+	pipelines, err := p.Definition.Pipelines()
+	if err != nil {
+		t.Fatalf("Got unexpected error: %#v", err)
+	}
+	if got := len(pipelines.AllSteps()); got != 5 {
+		t.Errorf("Incorrect number of steps, got: %d, wanted: 5", got)
+	}
+}
+
+func TestPipelineDefinitionTemplatesSelection(t *testing.T) {
+	def, env := setupDefAndEnv(`version: "2.0"
+templates:
+  test:
+    image: alpine
+    instances:
+      a: {}
+      b: {}
+steps:
+  build:
+    image: alpine
+`, "")
+	defer os.Remove(def)
+	defer os.Remove(env)
+
+	cases := []struct {
+		ignored  types.StringSet
+		selected types.StringSet
+		active   []string
+	}{
+		{types.StringSet{"test": true}, types.StringSet{}, []string{"build"}},
+		{types.StringSet{}, types.StringSet{"test": true}, []string{"test.a", "test.b"}},
+		// Steps of a template can still be chosen by their own name
+		{types.StringSet{"test": true, "test.b": false}, types.StringSet{}, []string{"build", "test.b"}},
+		{types.StringSet{}, types.StringSet{"test.a": true}, []string{"test.a"}},
+	}
+	for i, c := range cases {
+		p, err := NewPipeline(def, env, types.StringMap{}, c.ignored, c.selected)
+		if err != nil {
+			t.Fatalf("Got unexpected error in case %d: %#v", i, err)
+		}
+		pipelines, err := p.Definition.Pipelines()
+		if err != nil {
+			t.Fatalf("Got unexpected error in case %d: %#v", i, err)
+		}
+		active := []string{}
+		for _, step := range pipelines.AllSteps() {
+			if !step.Meta.Ignore {
+				active = append(active, step.Name)
+			}
+		}
+		sort.Strings(active)
+		if !reflect.DeepEqual(active, c.active) {
+			t.Errorf("Incorrect active steps in case %d, got: %v, wanted: %v", i, active, c.active)
+		}
+	}
+}
+
+func TestPipelineDefinitionTemplatesErrors(t *testing.T) {
+	cases := []struct {
+		def string
+		err string
+	}{
+		{`version: "2.0"
+templates:
+  a:
+    image: ((IMAGE))
+    instances:
+      x: {}
+`, "template 'a': instance 'x' misses parameter 'IMAGE'"},
+		{`version: "2.0"
+templates:
+  a:
+    image: alpine
+`, "template without instances"},
+		{`version: "2.0"
+steps:
+  a:
+    image: alpine
+templates:
+  a:
+    image: alpine
+    instances:
+      x: {}
+`, "template 'a' has the name of a step/service"},
+		{`version: "2.0"
+steps:
+  a.x:
+    image: alpine
+templates:
+  a:
+    image: alpine
+    instances:
+      x: {}
+`, "duplicate step/service 'a.x'"},
+	}
+	for _, c := range cases {
+		def, env := setupDefAndEnv(c.def, "")
+		_, err := NewPipeline(def, env, types.StringMap{}, types.StringSet{}, types.StringSet{})
+		os.Remove(def)
+		os.Remove(env)
+		if err == nil || !strings.HasSuffix(err.Error(), c.err) {
+			t.Errorf("Incorrect error, got: %v, wanted: %s", err, c.err)
+		}
+	}
+}
@@ -0,0 +1,18 @@
+package types // import "github.com/ad-freiburg/gantry/types"
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ParseBool parses 1/0, true/false, yes/no, y/n and on/off ignoring case and
+// surrounding whitespace. An empty string is false.
+func ParseBool(s string) (bool, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "1", "true", "yes", "y", "on":
+		return true, nil
+	case "0", "false", "no", "n", "off", "":
+		return false, nil
+	}
+	return false, fmt.Errorf("invalid boolean '%s'", s)
+}
@@ -0,0 +1,33 @@
+package types_test
+
+import (
+	"testing"
+
+	"github.com/ad-freiburg/gantry/types"
+)
+
+func TestParseBool(t *testing.T) {
+	var cases = []struct {
+		in     string
+		err    bool
+		result bool
+	}{
+		{"", false, false},
+		{" Yes ", false, true},
+		{"on", false, true},
+		{"1", false, true},
+		{"OFF", false, false},
+		{"n", false, false},
+		{"maybe", true, false},
+	}
+
+	for _, c := range cases {
+		result, err := types.ParseBool(c.in)
+		if (err != nil) != c.err {
+			t.Errorf("Incorrect error for '%s', got '%v', wanted error: %t", c.in, err, c.err)
+		}
+		if result != c.result {
+			t.Errorf("Incorrect result for '%s', got: %t, wanted %t", c.in, result, c.result)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package types // import "github.com/ad-freiburg/gantry/types"
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+var byteSizeRegexp = regexp.MustCompile(`^\d+(\.\d+)?\s?([kKmMgGtTpP][iI]?)?[bB]?$`)
+
+// ByteSize stores a size given as a number of bytes or as a string with a
+// unit like "64m" or "2gb" as accepted by docker.
+type ByteSize string
+
+// UnmarshalJSON sets *s to a copy of data.
+func (s *ByteSize) UnmarshalJSON(data []byte) error {
+	var bytes uint64
+	if err := json.Unmarshal(data, &bytes); err == nil {
+		*s = ByteSize(strconv.FormatUint(bytes, 10))
+		return nil
+	}
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	result := ByteSize(value)
+	if err := result.Check(); err != nil {
+		return err
+	}
+	*s = result
+	return nil
+}
+
+// Check validates the format of s. An empty size is valid.
+func (s ByteSize) Check() error {
+	if s != "" && !byteSizeRegexp.MatchString(string(s)) {
+		return fmt.Errorf("invalid size '%s'", string(s))
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package types_test
+
+import (
+	"testing"
+
+	"github.com/ad-freiburg/gantry/types"
+)
+
+func TestByteSizeUnmarshalJSON(t *testing.T) {
+	var cases = []struct {
+		json   string
+		err    string
+		result types.ByteSize
+	}{
+		{"", "unexpected end of JSON input", types.ByteSize("")},
+		{"1024", "", types.ByteSize("1024")},
+		{"\"64m\"", "", types.ByteSize("64m")},
+		{"\"2gb\"", "", types.ByteSize("2gb")},
+		{"\"1.5GiB\"", "", types.ByteSize("1.5GiB")},
+		{"\"-1m\"", "invalid size '-1m'", types.ByteSize("")},
+		{"\"2 lots\"", "invalid size '2 lots'", types.ByteSize("")},
+	}
+
+	for _, c := range cases {
+		var s types.ByteSize
+		err := s.UnmarshalJSON([]byte(c.json))
+		if (err != nil && c.err == "") || (err == nil && c.err != "") {
+			t.Errorf("Incorrect error for '%s', got '%s', wanted '%s'", c.json, err, c.err)
+		}
+		if s != c.result {
+			t.Errorf("Incorrect result for '%s', got: '%#v', wanted '%#v'", c.json, s, c.result)
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package types // import "github.com/ad-freiburg/gantry/types"
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Duration stores a duration given as a string like "1m30s" or as a number
+// of seconds.
+type Duration time.Duration
+
+// UnmarshalJSON sets *d to a copy of data.
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var seconds float64
+	if err := json.Unmarshal(data, &seconds); err == nil {
+		*d = Duration(seconds * float64(time.Second))
+		return nil
+	}
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
@@ -0,0 +1,33 @@
+package types_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ad-freiburg/gantry/types"
+)
+
+func TestDurationUnmarshalJSON(t *testing.T) {
+	var cases = []struct {
+		json   string
+		err    string
+		result types.Duration
+	}{
+		{"", "unexpected end of JSON input", types.Duration(0)},
+		{"30", "", types.Duration(30 * time.Second)},
+		{"1.5", "", types.Duration(1500 * time.Millisecond)},
+		{"\"1m30s\"", "", types.Duration(90 * time.Second)},
+		{"\"foo\"", "time: invalid duration foo", types.Duration(0)},
+	}
+
+	for _, c := range cases {
+		var d types.Duration
+		err := d.UnmarshalJSON([]byte(c.json))
+		if (err != nil && c.err == "") || (err == nil && c.err != "") {
+			t.Errorf("Incorrect error for '%s', got '%s', wanted '%s'", c.json, err, c.err)
+		}
+		if d != c.result {
+			t.Errorf("Incorrect result for '%s', got: '%#v', wanted '%#v'", c.json, d, c.result)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package types
 
 import (
 	"encoding/json"
+	"sort"
 	"strings"
 )
 
@@ -31,3 +32,13 @@ func (r *StringMap) UnmarshalJSON(data []byte) error {
 	*r = result
 	return nil
 }
+
+// Keys returns all keys of r in sorted order.
+func (r StringMap) Keys() []string {
+	keys := make([]string, 0, len(r))
+	for k := range r {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -31,3 +31,21 @@ func TestStringMapUnmarshalJSON(t *testing.T) {
 		}
 	}
 }
+
+func TestStringMapKeys(t *testing.T) {
+	bar := "Bar"
+	var cases = []struct {
+		m      types.StringMap
+		result []string
+	}{
+		{types.StringMap{}, []string{}},
+		{types.StringMap{"b": &bar, "a": nil, "c": &bar}, []string{"a", "b", "c"}},
+	}
+
+	for _, c := range cases {
+		r := c.m.Keys()
+		if !reflect.DeepEqual(r, c.result) {
+			t.Errorf("Incorrect result for '%#v', got: '%#v', wanted '%#v'", c.m, r, c.result)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package gantry // import "github.com/ad-freiburg/gantry"
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// knownUlimits stores all ulimit names accepted by docker.
+var knownUlimits = map[string]bool{
+	"as":         true,
+	"core":       true,
+	"cpu":        true,
+	"data":       true,
+	"fsize":      true,
+	"locks":      true,
+	"memlock":    true,
+	"msgqueue":   true,
+	"nice":       true,
+	"nofile":     true,
+	"nproc":      true,
+	"rss":        true,
+	"rtprio":     true,
+	"rttime":     true,
+	"sigpending": true,
+	"stack":      true,
+}
+
+// Ulimit represents a single soft and hard limit. A Hard value of 0 signals
+// that the hard limit equals the soft limit, -1 is used for unlimited.
+type Ulimit struct {
+	Soft int64 `json:"soft"`
+	Hard int64 `json:"hard"`
+}
+
+// UnmarshalJSON sets *u from a single number, a "soft:hard" string or a
+// {"soft": x, "hard": y} object as used by docker-compose.
+func (u *Ulimit) UnmarshalJSON(data []byte) error {
+	var single int64
+	if err := json.Unmarshal(data, &single); err == nil {
+		*u = Ulimit{Soft: single}
+		return nil
+	}
+	var value string
+	if err := json.Unmarshal(data, &value); err == nil {
+		return u.parse(value)
+	}
+	parsedJSON := struct {
+		Soft int64 `json:"soft"`
+		Hard int64 `json:"hard"`
+	}{}
+	if err := json.Unmarshal(data, &parsedJSON); err != nil {
+		return err
+	}
+	*u = Ulimit{Soft: parsedJSON.Soft, Hard: parsedJSON.Hard}
+	return nil
+}
+
+func (u *Ulimit) parse(value string) error {
+	parts := strings.SplitN(value, ":", 2)
+	soft, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid ulimit value '%s'", value)
+	}
+	var hard int64
+	if len(parts) > 1 {
+		hard, err = strconv.ParseInt(parts[1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid ulimit value '%s'", value)
+		}
+	}
+	*u = Ulimit{Soft: soft, Hard: hard}
+	return nil
+}
+
+// String returns the value of u in the form soft[:hard].
+func (u Ulimit) String() string {
+	if u.Hard == 0 {
+		return strconv.FormatInt(u.Soft, 10)
+	}
+	return fmt.Sprintf("%d:%d", u.Soft, u.Hard)
+}
+
+// Ulimits stores ulimits by their name.
+type Ulimits map[string]Ulimit
+
+// Check validates names and values of u.
+func (u Ulimits) Check() error {
+	for _, name := range u.Names() {
+		if !knownUlimits[name] {
+			return fmt.Errorf("unknown ulimit '%s'", name)
+		}
+		limit := u[name]
+		if limit.Hard > 0 && (limit.Soft < 0 || limit.Hard < limit.Soft) {
+			return fmt.Errorf("soft limit exceeds hard limit for ulimit '%s'", name)
+		}
+	}
+	return nil
+}
+
+// Names returns all names of u in sorted order.
+func (u Ulimits) Names() []string {
+	names := make([]string, 0, len(u))
+	for name := range u {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,51 @@
+package gantry_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/ad-freiburg/gantry"
+)
+
+func TestUlimitUnmarshalJSON(t *testing.T) {
+	var cases = []struct {
+		json   string
+		err    string
+		result gantry.Ulimit
+	}{
+		{"", "unexpected end of JSON input", gantry.Ulimit{}},
+		{"65535", "", gantry.Ulimit{Soft: 65535}},
+		{"\"1024\"", "", gantry.Ulimit{Soft: 1024}},
+		{"\"1024:2048\"", "", gantry.Ulimit{Soft: 1024, Hard: 2048}},
+		{"\"1024:foo\"", "invalid ulimit value '1024:foo'", gantry.Ulimit{}},
+		{"{\"soft\": 20000, \"hard\": 40000}", "", gantry.Ulimit{Soft: 20000, Hard: 40000}},
+	}
+
+	for _, c := range cases {
+		u := gantry.Ulimit{}
+		err := u.UnmarshalJSON([]byte(c.json))
+		if (err != nil && c.err == "") || (err == nil && c.err != "") {
+			t.Errorf("Incorrect error for '%s', got '%s', wanted '%s'", c.json, err, c.err)
+		}
+		if !reflect.DeepEqual(u, c.result) {
+			t.Errorf("Incorrect result for '%s', got: '%#v', wanted '%#v'", c.json, u, c.result)
+		}
+	}
+}
+
+func TestUlimitString(t *testing.T) {
+	var cases = []struct {
+		ulimit gantry.Ulimit
+		result string
+	}{
+		{gantry.Ulimit{Soft: 1024}, "1024"},
+		{gantry.Ulimit{Soft: 1024, Hard: 2048}, "1024:2048"},
+		{gantry.Ulimit{Soft: -1, Hard: -1}, "-1:-1"},
+	}
+
+	for _, c := range cases {
+		if r := c.ulimit.String(); r != c.result {
+			t.Errorf("Incorrect result for '%#v', got: '%s', wanted '%s'", c.ulimit, r, c.result)
+		}
+	}
+}
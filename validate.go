@@ -0,0 +1,88 @@
+package gantry // import "github.com/ad-freiburg/gantry"
+
+import (
+	"errors"
+	"log"
+	"sort"
+	"syscall"
+
+	"github.com/ad-freiburg/gantry/types"
+)
+
+// Validate loads the environment files and the definition like
+// NewPipelineFromFiles and returns all problems found by Pipeline.Validate.
+// Nothing is run and the container runtime is not contacted. Persisted
+// temporary directories and their state file are left untouched, temporary
+// directories created while loading are removed again.
+func Validate(definitionPath string, environmentPaths []string, environment types.StringMap, ignoredSteps types.StringSet, selectedSteps types.StringSet) []error {
+	p, err := newPipelineFromFiles(definitionPath, environmentPaths, environment, ignoredSteps, selectedSteps, true)
+	if p != nil && p.Environment != nil {
+		defer func() {
+			if err := p.Environment.CleanUp(syscall.Signal(0)); err != nil {
+				log.Print(err)
+			}
+		}()
+	}
+	if err != nil {
+		return []error{err}
+	}
+	return p.Validate()
+}
+
+// Validate checks the whole pipeline p for unknown dependencies, cycles,
+// invalid steps and images, conflicting container names and port bindings
+// and missing files. Unlike Check it does not stop at the first problem but
+// returns all problems found, an empty list if p is valid.
+func (p *Pipeline) Validate() []error {
+	problems := []error{}
+	names := make([]string, 0, len(p.Definition.Steps))
+	for name := range p.Definition.Steps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	unknown := false
+	for _, name := range names {
+		for _, dep := range sortedKeys(p.Definition.Steps[name].Dependencies()) {
+			if _, ok := p.Definition.Steps[dep]; !ok {
+				problems = append(problems, UnknownDependencyError{Step: name, Dependency: dep})
+				unknown = true
+			}
+		}
+	}
+	// The graph stops at the first unknown dependency, which is reported
+	// already
+	if !unknown {
+		if _, err := p.Definition.Pipelines(); err != nil {
+			problems = append(problems, err)
+		}
+	}
+	steps := make([]Step, 0, len(names))
+	for _, name := range names {
+		step := p.Definition.Steps[name]
+		if err := step.Check(); err != nil {
+			problems = append(problems, err)
+		}
+		steps = append(steps, step)
+	}
+	if err := checkContainerNames(steps); err != nil {
+		problems = append(problems, err)
+	}
+	if err := checkDependencyConditions(steps); err != nil {
+		problems = append(problems, err)
+	}
+	if err := checkPortBindings(steps); err != nil {
+		problems = append(problems, err)
+	}
+	active := []Step{}
+	for _, step := range steps {
+		if !step.Meta.Ignore && step.Meta.Type != ServiceTypeExternal {
+			active = append(active, step)
+		}
+	}
+	for _, d := range diagnoseFiles(active) {
+		if !d.OK {
+			problems = append(problems, errors.New(d.Message))
+		}
+	}
+	return problems
+}
@@ -0,0 +1,108 @@
+package gantry // import "github.com/ad-freiburg/gantry"
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/ad-freiburg/gantry/types"
+)
+
+func TestValidate(t *testing.T) {
+	cases := []struct {
+		def      string
+		problems []string
+	}{
+		{`version: "2.0"
+steps:
+  a:
+    image: alpine
+  b:
+    image: alpine
+    depends_on:
+    - a
+`, []string{}},
+		{`version: "2.0"
+steps:
+  a:
+    image: Alpine
+    depends_on:
+    - missing
+  b:
+    build:
+      context: ./does-not-exist
+    ports:
+    - "8080:80"
+  c:
+    image: alpine
+    ports:
+    - "8080:80"
+`, []string{
+			"unknown dependency 'missing' for step 'a'",
+			"invalid image for",
+			"host port binding '8080:80'",
+			"build context",
+		}},
+		{`version: "2.0"
+steps:
+  a:
+    image: alpine
+    after:
+    - b
+  b:
+    image: alpine
+    depends_on:
+    - a
+  c:
+    image: "alpine:"
+`, []string{
+			"cyclic",
+			"invalid image for",
+		}},
+	}
+	for i, c := range cases {
+		def, env := setupDefAndEnv(c.def, "")
+		problems := Validate(def, []string{env}, types.StringMap{}, types.StringSet{}, types.StringSet{})
+		os.Remove(def)
+		os.Remove(env)
+		if len(problems) != len(c.problems) {
+			t.Errorf("%d: incorrect number of problems, got: %v, wanted: %v", i, problems, c.problems)
+			continue
+		}
+		for j, problem := range problems {
+			if !strings.Contains(problem.Error(), c.problems[j]) {
+				t.Errorf("%d: incorrect problem %d, got: '%s', wanted it to contain: '%s'", i, j, problem, c.problems[j])
+			}
+		}
+	}
+}
+
+func TestValidateKeepsPersistedTempDirs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "validate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	def, env := setupDefAndEnv(`version: "2.0"
+#! TEMP_DIR_IF_EMPTY ${TEMP_STORAGE}
+steps:
+  a:
+    image: alpine
+    volumes:
+    - ${TEMP_STORAGE}:/input
+`, "tempdir: "+dir+"\ntempdir_persist: true\n")
+	defer os.Remove(def)
+	defer os.Remove(env)
+
+	if problems := Validate(def, []string{env}, types.StringMap{}, types.StringSet{}, types.StringSet{}); len(problems) != 0 {
+		t.Errorf("unexpected problems, got: %v, wanted: []", problems)
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		t.Errorf("unexpected entry in temporary directory, got: '%s'", entry.Name())
+	}
+}
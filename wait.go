@@ -0,0 +1,87 @@
+package gantry // import "github.com/ad-freiburg/gantry"
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+// waitInterval is the time between two checks whether detached services are
+// still running.
+const waitInterval = time.Second
+
+// detachedServices keeps track of services started by ExecuteSteps which
+// keep running in the background.
+type detachedServices struct {
+	mutex sync.Mutex
+	steps []Step
+}
+
+// Add records step as started.
+func (d *detachedServices) Add(step Step) {
+	if d == nil {
+		return
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.steps = append(d.steps, step)
+}
+
+// Steps returns all recorded services in the order they were started.
+func (d *detachedServices) Steps() []Step {
+	if d == nil {
+		return nil
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return append([]Step{}, d.steps...)
+}
+
+// DetachedServices returns all services started by the last call to
+// ExecuteSteps in the order they were started.
+func (p *Pipeline) DetachedServices() []Step {
+	return p.detached.Steps()
+}
+
+// Wait blocks until ctx is done or all services started by ExecuteSteps
+// exited, similar to sync.WaitGroup. Services still running when ctx is done
+// are stopped, regardless of keep_alive. Returns immediately if no service
+// was started.
+func (p *Pipeline) Wait(ctx context.Context) error {
+	services := p.DetachedServices()
+	if len(services) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(services))
+	for _, step := range services {
+		names = append(names, step.ColoredName())
+	}
+	pipelineLogger.Printf("Waiting for %s, interrupt to stop", strings.Join(names, ", "))
+	ticker := time.NewTicker(waitInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			pipelineLogger.Printf("Stopping services")
+			for _, step := range services {
+				if _, err := p.GetRunnerForMeta(step.Meta).ContainerKiller(step)(); err != nil {
+					pipelineLogger.Printf("Error killing %s: %s", step.ColoredName(), err)
+				}
+			}
+			return nil
+		case <-ticker.C:
+			running := false
+			for _, step := range services {
+				if err := p.GetRunnerForMeta(step.Meta).RunningContainerChecker(step)(); err == nil {
+					running = true
+					break
+				}
+			}
+			if !running {
+				pipelineLogger.Printf("All services exited")
+				return nil
+			}
+		}
+	}
+}